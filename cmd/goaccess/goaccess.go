@@ -2,9 +2,15 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/StevenRojas/goaccess/pkg/server"
 	"github.com/StevenRojas/goaccess/pkg/service"
 )
 
@@ -18,13 +24,90 @@ func main() {
 	logger.Debug("creating services...")
 	factory := service.NewServiceFactory(ctx, serviceConfig)
 	factory.Setup()
-	authenticationService := factory.CreateAuthenticationService()
-	accessService := factory.CreateAccessService()
-	authorizationService := factory.CreateAuthorizationService()
-	initService := factory.CreateInitializationService()
+	services := server.NewServices(factory)
 	logger.Debug("services ready")
-	fmt.Printf("%T --- %T ---%T ---%T ---\n\n", authenticationService, accessService, authorizationService, initService)
 
-	initService.Init(ctx, false)
+	err = services.Initialization.Init(ctx, false)
+	if err != nil {
+		logger.Error("unable to initialize modules", err.Error())
+	}
 
+	if hours := serviceConfig.Security.JWTKeyRotationHours; hours > 0 {
+		go rotateSigningKeys(ctx, services.Authentication, time.Duration(hours)*time.Hour, logger)
+	}
+
+	httpServer, err := server.NewHTTPServer(serviceConfig.Server, services, logger)
+	if err != nil {
+		panic(err)
+	}
+	grpcServer, err := server.NewGRPCServer(serviceConfig.Server, services, logger)
+	if err != nil {
+		panic(err)
+	}
+	errs := make(chan error, 2)
+	go func() {
+		logger.Info("HTTP server listening", serviceConfig.Server.HTTP)
+		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			errs <- err
+		}
+	}()
+	go func() {
+		listener, err := net.Listen("tcp", serviceConfig.Server.GRPC)
+		if err != nil {
+			errs <- err
+			return
+		}
+		logger.Info("gRPC server listening", serviceConfig.Server.GRPC)
+		if err := grpcServer.Serve(listener); err != nil {
+			errs <- err
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err = <-errs:
+		logger.Error("server failed", err.Error())
+	case sig := <-quit:
+		logger.Info("shutting down", sig.String())
+	}
+
+	timeout := time.Duration(serviceConfig.Server.ShutdownTimeout) * time.Second
+	shutdownCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err = httpServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("HTTP server shutdown failed", err.Error())
+	}
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-shutdownCtx.Done():
+		grpcServer.Stop()
+	}
+	logger.Info("server stopped")
+}
+
+// rotateSigningKeys check the signing key age periodically and rotate it once it is older than maxAge
+func rotateSigningKeys(ctx context.Context, auth service.AuthenticationService, maxAge time.Duration, logger configuration.LoggerWrapper) {
+	interval := maxAge / 10
+	if interval > time.Hour {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		kid, err := auth.RotateSigningKey(ctx, maxAge)
+		if err != nil {
+			// Another instance holding the lock is rotating the key already
+			if err != service.ErrKeyRotationInProgress {
+				logger.Error("unable to rotate signing key", err.Error())
+			}
+			continue
+		}
+		logger.Debug("active signing key", kid)
+	}
 }
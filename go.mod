@@ -7,9 +7,14 @@ require (
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/go-kit/kit v0.10.0
 	github.com/go-redis/redis/v8 v8.0.0-beta.10
+	github.com/golang/protobuf v1.4.2
+	github.com/gorilla/mux v1.7.3
 	github.com/mitchellh/mapstructure v1.1.2
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
 	github.com/rs/xid v1.2.1
 	github.com/stretchr/testify v1.7.0
 	github.com/thedevsaddam/govalidator v1.9.10
+	golang.org/x/crypto v0.0.0-20200820211705-5c72a883971a
+	google.golang.org/grpc v1.31.0
+	google.golang.org/protobuf v1.25.0
 )
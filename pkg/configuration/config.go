@@ -8,26 +8,127 @@ import (
 
 // ServiceConfig service configuration
 type ServiceConfig struct {
-	Server   ServerConfig
-	Security SecurityConfig
-	Redis    RedisConfig
+	Server         ServerConfig
+	Security       SecurityConfig
+	PasswordPolicy PasswordPolicyConfig
+	MFA            MFAConfig
+	LoginThrottle  LoginThrottleConfig
+	EmailTokens    EmailTokensConfig
+	Mail           MailConfig
+	OIDC           OIDCConfig
+	Federation     FederationConfig
+	Redis          RedisConfig
 }
 
 // ServerConfig server configuration
 type ServerConfig struct {
-	HTTP     string          `env:"HTTP_ADDR" envDefault:":8077"`
-	GRPC     string          `env:"GRPC_ADDR" envDefault:":8088"`
-	LogLevel syslog.Priority `env:"LOG_LEVEL" envDefault:"7"` // LOG_DEBUG // LOG_ERR = 3
+	HTTP            string          `env:"HTTP_ADDR" envDefault:":8077"`
+	GRPC            string          `env:"GRPC_ADDR" envDefault:":8088"`
+	GRPCCertFile    string          `env:"GRPC_TLS_CERT_FILE"` // PEM certificate, the gRPC server uses TLS when it is set
+	GRPCKeyFile     string          `env:"GRPC_TLS_KEY_FILE"`
+	LogLevel        syslog.Priority `env:"LOG_LEVEL" envDefault:"7"` // LOG_DEBUG // LOG_ERR = 3
+	ShutdownTimeout int             `env:"SHUTDOWN_TIMEOUT_SECONDS" envDefault:"10"`
+	TrustedProxies  []string        `env:"TRUSTED_PROXIES" envSeparator:","` // IPs or CIDRs allowed to set X-Forwarded-For
 }
 
 // SecurityConfig security configuration
 type SecurityConfig struct {
 	JWTSecret                    string `env:"JWT_SECRET_KEY"`
+	JWTAlgorithm                 string `env:"JWT_ALGORITHM" envDefault:"HS256"` // HS256, RS256, ES256 or EdDSA
+	JWTPrivateKeyFile            string `env:"JWT_PRIVATE_KEY_FILE"`             // PEM private key for RS256, ES256 and EdDSA
 	JWTTokenExpiration           int    `env:"JWT_EXPIRE_HOURS" envDefault:"10"`
 	JWTRefreshExpiration         int    `env:"JWT_REFRESH_HOURS" envDefault:"20"`
+	JWTImpersonationExpiration   int    `env:"JWT_IMPERSONATION_EXPIRE_MINUTES" envDefault:"30"`
+	JWTClaims                    string `env:"JWT_CLAIMS"`                            // email, name, is_admin, roles and permissions
+	JWTKeyRotationHours          int    `env:"JWT_KEY_ROTATION_HOURS" envDefault:"0"` // 0 to disable the scheduled rotation
+	JWTKeyReloadSeconds          int    `env:"JWT_KEY_RELOAD_SECONDS" envDefault:"60"`
+	RoutesReloadSeconds          int    `env:"ROUTES_RELOAD_SECONDS" envDefault:"60"` // 0 to compile the routes only on first use and init
+	JWTKeyEncryptionKey          string `env:"JWT_KEY_ENCRYPTION_KEY"`                // encrypts the signing keys stored in Redis
+	JWTStateless                 bool   `env:"JWT_STATELESS" envDefault:"false"`      // verify access tokens without the store
+	JWTRevocationSyncSeconds     int    `env:"JWT_REVOCATION_SYNC_SECONDS" envDefault:"5"`
 	JWTInternalSecret            string `env:"JWT_INTERNAL_SECRET_KEY"`
 	JWTInternalTokenExpiration   int    `env:"JWT_INTERNAL_EXPIRE_HOURS" envDefault:"2"`
 	JWTInternalRefreshExpiration int    `env:"JWT_INTERNAL_REFRESH_HOURS" envDefault:"5"`
+	PasswordHash                 string `env:"PASSWORD_HASH" envDefault:"argon2id"` // argon2id or bcrypt
+	BcryptCost                   int    `env:"BCRYPT_COST" envDefault:"12"`
+	Argon2Memory                 int    `env:"ARGON2_MEMORY_KB" envDefault:"65536"`
+	Argon2Iterations             int    `env:"ARGON2_ITERATIONS" envDefault:"3"`
+	Argon2Parallelism            int    `env:"ARGON2_PARALLELISM" envDefault:"2"`
+}
+
+// PasswordPolicyConfig password policy configuration
+type PasswordPolicyConfig struct {
+	MinLength     int    `env:"PASSWORD_MIN_LENGTH" envDefault:"8"`
+	RequireUpper  bool   `env:"PASSWORD_REQUIRE_UPPER" envDefault:"true"`
+	RequireLower  bool   `env:"PASSWORD_REQUIRE_LOWER" envDefault:"true"`
+	RequireDigit  bool   `env:"PASSWORD_REQUIRE_DIGIT" envDefault:"true"`
+	RequireSymbol bool   `env:"PASSWORD_REQUIRE_SYMBOL" envDefault:"false"`
+	DenyListFile  string `env:"PASSWORD_DENY_LIST_FILE"`              // one password per line
+	History       int    `env:"PASSWORD_HISTORY" envDefault:"5"`      // 0 to allow reusing passwords
+	MaxAgeDays    int    `env:"PASSWORD_MAX_AGE_DAYS" envDefault:"0"` // 0 to never expire
+}
+
+// MFAConfig multi-factor authentication configuration
+type MFAConfig struct {
+	Issuer              string `env:"MFA_ISSUER" envDefault:"goaccess"`
+	TOTPSkew            int    `env:"MFA_TOTP_SKEW" envDefault:"1"` // accepted time steps before and after the current one
+	ChallengeExpiration int    `env:"MFA_CHALLENGE_EXPIRE_MINUTES" envDefault:"5"`
+	RecoveryCodes       int    `env:"MFA_RECOVERY_CODES" envDefault:"10"`
+	RequiredForAdmins   bool   `env:"MFA_REQUIRED_FOR_ADMINS" envDefault:"false"` // admin users can't log in without MFA
+}
+
+// LoginThrottleConfig brute-force protection configuration, a max of 0 failures disables that limit
+type LoginThrottleConfig struct {
+	WindowMinutes      int `env:"LOGIN_FAILURE_WINDOW_MINUTES" envDefault:"15"` // sliding window of the failures
+	MaxAccountFailures int `env:"LOGIN_MAX_ACCOUNT_FAILURES" envDefault:"5"`
+	MaxIPFailures      int `env:"LOGIN_MAX_IP_FAILURES" envDefault:"20"`
+	LockoutMinutes     int `env:"LOGIN_LOCKOUT_MINUTES" envDefault:"5"` // doubled on each consecutive lockout
+	MaxLockoutMinutes  int `env:"LOGIN_MAX_LOCKOUT_MINUTES" envDefault:"1440"`
+}
+
+// EmailTokensConfig password reset, email verification and passwordless login tokens configuration, the token
+// is appended to the URLs
+type EmailTokensConfig struct {
+	PasswordResetExpiration     int    `env:"PASSWORD_RESET_EXPIRE_MINUTES" envDefault:"30"`
+	PasswordResetURL            string `env:"PASSWORD_RESET_URL"` // e.g. https://app.example.com/reset?token=
+	EmailVerificationExpiration int    `env:"EMAIL_VERIFICATION_EXPIRE_HOURS" envDefault:"24"`
+	EmailVerificationURL        string `env:"EMAIL_VERIFICATION_URL"` // e.g. https://app.example.com/verify?token=
+	PasswordlessExpiration      int    `env:"PASSWORDLESS_EXPIRE_MINUTES" envDefault:"10"`
+	PasswordlessURL             string `env:"PASSWORDLESS_LOGIN_URL"`                      // e.g. https://app.example.com/login?token=
+	PasswordlessMaxRequests     int    `env:"PASSWORDLESS_MAX_REQUESTS" envDefault:"3"`    // per account within the window, 0 for no limit
+	PasswordlessWindow          int    `env:"PASSWORDLESS_WINDOW_MINUTES" envDefault:"15"` // sliding window of the requests
+}
+
+// MailConfig mailer configuration
+type MailConfig struct {
+	Mailer   string `env:"MAILER" envDefault:"log"` // smtp or log
+	From     string `env:"MAIL_FROM" envDefault:"goaccess@localhost"`
+	File     string `env:"MAIL_FILE"` // the log mailer appends the mails to this file instead of logging them
+	SMTPAddr string `env:"SMTP_ADDR" envDefault:"localhost:25"`
+	SMTPUser string `env:"SMTP_USER"` // no authentication when empty
+	SMTPPass string `env:"SMTP_PASS"`
+}
+
+// OIDCConfig OpenID Connect provider configuration, the provider is disabled when the issuer is empty
+type OIDCConfig struct {
+	Issuer            string `env:"OIDC_ISSUER"` // public URL of goaccess, e.g. https://auth.example.com
+	CodeExpiration    int    `env:"OIDC_CODE_EXPIRE_SECONDS" envDefault:"60"`
+	IDTokenExpiration int    `env:"OIDC_ID_TOKEN_EXPIRE_MINUTES" envDefault:"60"`
+}
+
+// FederationConfig external OpenID Connect identity provider configuration, federated login is disabled when the
+// issuer is empty
+type FederationConfig struct {
+	Issuer               string `env:"FEDERATION_ISSUER"` // e.g. https://login.example.com
+	ClientID             string `env:"FEDERATION_CLIENT_ID"`
+	ClientSecret         string `env:"FEDERATION_CLIENT_SECRET"` // empty for public clients
+	RedirectURL          string `env:"FEDERATION_REDIRECT_URL"`  // e.g. https://auth.example.com/federation/callback
+	Scopes               string `env:"FEDERATION_SCOPES" envDefault:"openid email profile"`
+	Provision            bool   `env:"FEDERATION_PROVISION" envDefault:"false"` // create the unknown users on their first login
+	RequireVerifiedEmail bool   `env:"FEDERATION_REQUIRE_VERIFIED_EMAIL" envDefault:"true"`
+	LinkAdmins           bool   `env:"FEDERATION_LINK_ADMINS" envDefault:"false"` // link admin users to an identity on their first login
+	StateExpiration      int    `env:"FEDERATION_STATE_EXPIRE_MINUTES" envDefault:"10"`
+	KeysCacheMinutes     int    `env:"FEDERATION_KEYS_CACHE_MINUTES" envDefault:"60"` // the keys are fetched again sooner for unknown key IDs
 }
 
 // RedisConfig redis configuration
@@ -47,6 +148,27 @@ func Read() (*ServiceConfig, error) {
 	if err := env.Parse(&config.Security); err != nil {
 		return nil, err
 	}
+	if err := env.Parse(&config.PasswordPolicy); err != nil {
+		return nil, err
+	}
+	if err := env.Parse(&config.MFA); err != nil {
+		return nil, err
+	}
+	if err := env.Parse(&config.LoginThrottle); err != nil {
+		return nil, err
+	}
+	if err := env.Parse(&config.EmailTokens); err != nil {
+		return nil, err
+	}
+	if err := env.Parse(&config.Mail); err != nil {
+		return nil, err
+	}
+	if err := env.Parse(&config.OIDC); err != nil {
+		return nil, err
+	}
+	if err := env.Parse(&config.Federation); err != nil {
+		return nil, err
+	}
 	if err := env.Parse(&config.Redis); err != nil {
 		return nil, err
 	}
@@ -1,17 +1,69 @@
 package entities
 
+import "time"
+
 const (
 	EventTypeAccess = "EventTypeAccess"
 	EventTypeAction = "EventTypeAction"
 )
 
+// ClientSubjectPrefix prefix of the ID used to assign roles and check the permissions of a service client,
+// roles are assigned to client:<clientID> like they are assigned to a user ID
+const ClientSubjectPrefix = "client:"
+
+// APIKeySubjectPrefix prefix of the ID used to check the permissions of an API key created for a set of roles,
+// API keys created for a user are checked with the user ID
+const APIKeySubjectPrefix = "apikey:"
+
+// Security event types
+const (
+	// SecurityEventRefreshTokenReuse a rotated refresh token was used again, its token family was revoked
+	SecurityEventRefreshTokenReuse = "refresh_token_reuse"
+	// SecurityEventSessionsRevoked all the sessions of a user were revoked
+	SecurityEventSessionsRevoked = "sessions_revoked"
+	// SecurityEventUserOffboarded a user was unregistered with its tokens, sessions and permissions
+	SecurityEventUserOffboarded = "user_offboarded"
+	// SecurityEventStatusChanged the account status of a user was changed by an admin
+	SecurityEventStatusChanged = "account_status_changed"
+	// SecurityEventPasswordReset the password of a user was reset with a reset token
+	SecurityEventPasswordReset = "password_reset"
+	// SecurityEventEmailVerified a user verified its email address
+	SecurityEventEmailVerified = "email_verified"
+	// SecurityEventImpersonation an admin got a token to act as another user
+	SecurityEventImpersonation = "impersonation"
+	// SecurityEventLoginFailed a login failed because of an unknown email or a wrong password
+	SecurityEventLoginFailed = "login_failed"
+	// SecurityEventLoginLockout an account or IP was locked out after too many failed logins
+	SecurityEventLoginLockout = "login_lockout"
+	// SecurityEventLoginUnlocked an admin cleared the lockout of an account or IP
+	SecurityEventLoginUnlocked = "login_unlocked"
+)
+
+// Account status values, users without a stored status are active
+const (
+	UserStatusActive   = "active"
+	UserStatusDisabled = "disabled"
+	UserStatusLocked   = "locked"
+	UserStatusPending  = "pending"
+)
+
 // User struct
 type User struct {
-	ID      string   `json:"id"`
-	Email   string   `json:"email"`
-	Name    string   `json:"name"`
-	IsAdmin bool     `json:"is_admin"`
-	Roles   []string `json:"roles"`
+	ID            string   `json:"id"`
+	Email         string   `json:"email"`
+	Name          string   `json:"name"`
+	IsAdmin       bool     `json:"is_admin"`
+	Status        string   `json:"status,omitempty"`
+	EmailVerified bool     `json:"email_verified,omitempty"`
+	Roles         []string `json:"roles"`
+	TokenVersion  int64    `json:"-"` // changes with the roles of the user, the ver claim of its tokens
+}
+
+// AccountStatus status of a user account with the reason and time of the last change
+type AccountStatus struct {
+	Status    string    `json:"status"`
+	Reason    string    `json:"reason,omitempty"`
+	ChangedAt time.Time `json:"changed_at,omitempty"`
 }
 
 // Token struct
@@ -20,10 +72,131 @@ type Token struct {
 	Refresh string `json:"refresh_token"`
 }
 
-// LoggedUser logged user struct
+// LoggedUser logged user struct, only MFAChallenge is set when a second factor is required
 type LoggedUser struct {
-	User  *User
-	Token *Token
+	User         *User
+	Token        *Token
+	MFAChallenge string
+}
+
+// Session login of a user from a device, it lasts while its refresh token family is alive. The ID is the family ID
+type Session struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+}
+
+// Impersonation audit record of an admin acting as another user, the ID is the access token UUID
+type Impersonation struct {
+	ID        string    `json:"id"`
+	AdminID   string    `json:"admin_id"`
+	UserID    string    `json:"user_id"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+}
+
+// ImpersonationToken access token issued to an admin to act as another user, it can't be refreshed
+type ImpersonationToken struct {
+	Access    string    `json:"access_token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	AdminID   string    `json:"admin_id"`
+	User      *User     `json:"user"`
+}
+
+// TOTPEnrolment TOTP secret and the provisioning URI for authenticator apps
+type TOTPEnrolment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// JWK public JSON Web Key (RFC 7517) used to verify the access tokens
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// SigningKey JWT signing key of the key ring, Key is the HMAC secret or the PEM private key
+type SigningKey struct {
+	ID        string    `json:"kid"`
+	Algorithm string    `json:"alg"`
+	Key       string    `json:"key"`
+	CreatedAt time.Time `json:"created_at"`
+	RetireAt  time.Time `json:"retire_at,omitempty"` // zero for the active key
+}
+
+// ServiceClient machine identity allowed to get internal tokens for the granted scopes, its roles are
+// checked by CheckPermission like the roles of a user
+type ServiceClient struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	Roles     []string  `json:"roles"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// InternalIdentity service client authenticated by an internal token and the scopes it was granted,
+// Subject is the ID used to check its permissions
+type InternalIdentity struct {
+	ClientID string   `json:"client_id"`
+	Subject  string   `json:"subject"`
+	Scopes   []string `json:"scopes"`
+}
+
+// APIKey long-lived key that authenticates as a user or as a set of roles, only its hash is stored
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	UserID     string     `json:"user_id,omitempty"`
+	Roles      []string   `json:"roles,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// OAuthToken OAuth2 access token response (RFC 6749 section 5.1)
+type OAuthToken struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Scope        string `json:"scope,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"` // OpenID Connect authorization code grant only
+}
+
+// Token types of the introspection responses
+const (
+	TokenTypeAccess  = "access_token"
+	TokenTypeRefresh = "refresh_token"
+	TokenTypeAPIKey  = "api_key"
+)
+
+// TokenIntrospection state of a token (RFC 7662 section 2.2), only active is set for inactive tokens
+type TokenIntrospection struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	Sub       string   `json:"sub,omitempty"`
+	Jti       string   `json:"jti,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
 }
 
 type Role struct {
@@ -73,6 +246,21 @@ type ActionModule struct {
 	SubModules []ActionSubModule `json:"submodules"`
 }
 
+// RouteMatch template action matched for a request
+type RouteMatch struct {
+	Action    string `json:"action"`
+	Module    string `json:"module"`
+	SubModule string `json:"submodule"`
+}
+
+// SecurityEvent security relevant event, e.g. a refresh token reuse
+type SecurityEvent struct {
+	Type    string            `json:"type"`
+	UserID  string            `json:"user_id"`
+	Time    time.Time         `json:"time"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
 type RoleEvent struct {
 	RoleID    string
 	UserID    string
@@ -102,3 +290,76 @@ type ActionList struct {
 	SubModule string
 	Actions   []string `json:"actions"`
 }
+
+// OIDCClient relying party of the OpenID Connect provider, e.g. a SPA. It is a public client without secret, the
+// authorization codes are bound to a PKCE challenge and to the registered redirect URIs
+type OIDCClient struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// AuthorizationRequest OpenID Connect authorization code request with a PKCE challenge (RFC 7636)
+type AuthorizationRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// Authorization result of the login of an authorization request, the URL the user agent is redirected to with
+// the code or an MFA challenge to complete the login first
+type Authorization struct {
+	RedirectTo   string `json:"redirect_to,omitempty"`
+	MFAChallenge string `json:"mfa_challenge,omitempty"`
+}
+
+// AuthorizationCode authorization code waiting to be exchanged for the tokens of the login
+type AuthorizationCode struct {
+	ClientID      string
+	RedirectURI   string
+	Scope         string
+	Nonce         string
+	CodeChallenge string
+	UserID        string
+	AuthTime      int64
+	Access        string
+	Refresh       string
+}
+
+// FederationState pending federated login, the nonce and PKCE verifier of the login sent to the external identity
+// provider with the state
+type FederationState struct {
+	Nonce        string
+	CodeVerifier string
+}
+
+// OIDCConfiguration OpenID Connect discovery document
+type OIDCConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// UserInfo standard claims of a user returned by the OpenID Connect userinfo endpoint
+type UserInfo struct {
+	Sub           string `json:"sub"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name,omitempty"`
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"sync"
+	"unicode"
 
 	"github.com/thedevsaddam/govalidator"
 )
@@ -24,7 +26,45 @@ var roleRules = govalidator.MapData{
 	"name": []string{"required"},
 }
 
+var initValidator sync.Once
+
+// PasswordRequest password to be validated against the password policy
+type PasswordRequest struct {
+	Password string `json:"password"`
+}
+
+// passwordCharacterRules custom rules for the character classes a password must contain
+var passwordCharacterRules = map[string]struct {
+	class   string
+	matches func(r rune) bool
+}{
+	"password_upper":  {"an uppercase letter", unicode.IsUpper},
+	"password_lower":  {"a lowercase letter", unicode.IsLower},
+	"password_digit":  {"a digit", unicode.IsDigit},
+	"password_symbol": {"a symbol", func(r rune) bool { return unicode.IsPunct(r) || unicode.IsSymbol(r) }},
+}
+
+// InitValidator register the custom rules, it is safe to call it more than once
 func InitValidator() {
+	initValidator.Do(initCustomRules)
+}
+
+func initCustomRules() {
+	for name, rule := range passwordCharacterRules {
+		rule := rule
+		govalidator.AddCustomRule(name, func(field string, _ string, message string, value interface{}) error {
+			password, _ := value.(string)
+			for _, r := range password {
+				if rule.matches(r) {
+					return nil
+				}
+			}
+			if message != "" {
+				return errors.New(message)
+			}
+			return fmt.Errorf("The %s field must contain %s", field, rule.class)
+		})
+	}
 	govalidator.AddCustomRule("is_admin", func(field string, rule string, message string, value interface{}) error {
 		_, ok := value.(bool)
 		if !ok {
@@ -37,10 +77,10 @@ func InitValidator() {
 	})
 }
 
-func InitUserValidator(r *http.Request, userRequest User) *govalidator.Validator {
+func InitUserValidator(r *http.Request, userRequest *User) *govalidator.Validator {
 	opts := govalidator.Options{
 		Request:  r,
-		Data:     &userRequest,
+		Data:     userRequest,
 		Rules:    userRules,
 		Messages: userRuleMessages,
 	}
@@ -55,3 +95,16 @@ func InitRoleValidator(r *http.Request, roleRequest *Role) *govalidator.Validato
 	}
 	return govalidator.New(opts)
 }
+
+// InitPasswordValidator validator for a password with the given policy rules
+func InitPasswordValidator(passwordRequest *PasswordRequest, rules []string) *govalidator.Validator {
+	InitValidator()
+	opts := govalidator.Options{
+		Data: passwordRequest,
+		Rules: govalidator.MapData{
+			"password": rules,
+		},
+		TagIdentifier: "json",
+	}
+	return govalidator.New(opts)
+}
@@ -0,0 +1,72 @@
+package events
+
+import (
+	"sync"
+
+	"github.com/StevenRojas/goaccess/pkg/entities"
+)
+
+// SecurityFeed interface to publish security events, every subscriber receives all the events
+type SecurityFeed interface {
+	Subscribe(l chan *entities.SecurityEvent) Subscription
+	Send(event *entities.SecurityEvent)
+}
+
+type securityFeed struct {
+	lock      sync.Mutex
+	listeners map[chan *entities.SecurityEvent]bool
+}
+
+type securitySub struct {
+	feed    *securityFeed
+	channel chan *entities.SecurityEvent
+	once    sync.Once
+	err     chan error
+}
+
+// NewSecurityFeed return a new security events feed
+func NewSecurityFeed() SecurityFeed {
+	return &securityFeed{
+		listeners: make(map[chan *entities.SecurityEvent]bool),
+	}
+}
+
+// Subscribe method to subscribe listeners
+func (f *securityFeed) Subscribe(l chan *entities.SecurityEvent) Subscription {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.listeners[l] = true
+	return &securitySub{
+		feed:    f,
+		channel: l,
+		err:     make(chan error, 1),
+	}
+}
+
+// Send method to send an event to all the listeners
+func (f *securityFeed) Send(event *entities.SecurityEvent) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for l := range f.listeners {
+		l <- event
+	}
+}
+
+func (f *securityFeed) remove(l chan *entities.SecurityEvent) {
+	f.lock.Lock()
+	delete(f.listeners, l)
+	f.lock.Unlock()
+}
+
+// Unsubscribe method to unsubscribe from the feed, the event type is ignored since all the events are received
+func (s *securitySub) Unsubscribe(eventType string) {
+	s.once.Do(func() {
+		s.feed.remove(s.channel)
+		close(s.err)
+	})
+}
+
+// Err method which returns the error channel
+func (s *securitySub) Err() <-chan error {
+	return s.err
+}
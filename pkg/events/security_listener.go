@@ -0,0 +1,40 @@
+package events
+
+import (
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/StevenRojas/goaccess/pkg/entities"
+)
+
+// SecurityListener interface of the listener that logs the security events
+type SecurityListener interface {
+	RegisterSecurityListener() error
+}
+
+type security struct {
+	sf     SecurityFeed
+	ch     chan *entities.SecurityEvent
+	logger configuration.LoggerWrapper
+}
+
+// NewSecurityListener return a new listener that logs the security events
+func NewSecurityListener(logger configuration.LoggerWrapper, sf SecurityFeed) SecurityListener {
+	return &security{
+		logger: logger,
+		sf:     sf,
+	}
+}
+
+// RegisterSecurityListener subscribe to the security feed and log the events until unsubscribed
+func (l *security) RegisterSecurityListener() error {
+	l.ch = make(chan *entities.SecurityEvent)
+	sub := l.sf.Subscribe(l.ch)
+	defer sub.Unsubscribe("")
+	for {
+		select {
+		case event := <-l.ch:
+			l.logger.Info("security event", event.Type, "user_id", event.UserID, "details", event.Details)
+		case err := <-sub.Err():
+			return err
+		}
+	}
+}
@@ -0,0 +1,4 @@
+// Package pb contains the protobuf definitions and gRPC stubs of the goaccess API
+package pb
+
+//go:generate protoc --go_out=plugins=grpc,paths=source_relative:. goaccess.proto
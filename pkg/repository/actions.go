@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"sort"
+	"strings"
 
 	"github.com/StevenRojas/goaccess/pkg/entities"
 	"github.com/go-redis/redis/v8"
@@ -28,6 +29,8 @@ type ActionsRepository interface {
 	RemoveActionsByUser(ctx context.Context, userID string) error
 	// UpdateActionList update the list of actions to quick access while checking permissions
 	UpdateActionList(ctx context.Context, roleID string) error
+	// ActionTemplates get all module templates with their actions
+	ActionTemplates(ctx context.Context) ([]entities.Module, error)
 }
 
 type actionsRepo struct {
@@ -185,6 +188,23 @@ func (r *actionsRepo) UpdateActionList(ctx context.Context, roleID string) error
 	return nil
 }
 
+// ActionTemplates get all module templates with their actions
+func (r *actionsRepo) ActionTemplates(ctx context.Context) ([]entities.Module, error) {
+	keys, err := r.c.Keys(ctx, accessTemplateKey+":*").Result()
+	if err != nil {
+		return nil, err
+	}
+	modules := []entities.Module{}
+	for _, k := range keys {
+		module, err := r.moduleStructure(ctx, strings.Replace(k, accessTemplateKey+":", "", 1))
+		if err != nil {
+			return nil, err
+		}
+		modules = append(modules, *module)
+	}
+	return modules, nil
+}
+
 // moduleStructure returns the modules, submodules and sections structure for a given module
 func (r *actionsRepo) moduleStructure(ctx context.Context, name string) (*entities.Module, error) {
 	key := accessTemplateKey + ":" + name
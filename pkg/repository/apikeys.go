@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/go-redis/redis/v8"
+)
+
+// APIKeysRepository interface to store the API keys, only the hash of their secret is stored
+type APIKeysRepository interface {
+	// AddAPIKey store an API key with the hash of its secret
+	AddAPIKey(context.Context, *entities.APIKey, string) error
+	// GetAPIKey get an API key and the hash of its secret by ID, nil if it doesn't exist
+	GetAPIKey(context.Context, string) (*entities.APIKey, string, error)
+	// GetAPIKeys get a list of all API keys
+	GetAPIKeys(context.Context) ([]entities.APIKey, error)
+	// DeleteAPIKey delete an API key
+	DeleteAPIKey(context.Context, string) error
+	// SetAPIKeyExpiration set the time when an API key expires
+	SetAPIKeyExpiration(context.Context, string, time.Time) error
+	// TouchAPIKey set the last time an API key was used
+	TouchAPIKey(context.Context, string, time.Time) error
+}
+
+type apiKeysRepo struct {
+	c *redis.Client
+}
+
+// NewAPIKeysRepository creates a new repository instance
+func NewAPIKeysRepository(ctx context.Context, client *redis.Client) (APIKeysRepository, error) {
+	_, err := client.Ping(context.TODO()).Result()
+	if err != nil {
+		return nil, err
+	}
+	return &apiKeysRepo{
+		c: client,
+	}, nil
+}
+
+// AddAPIKey store an API key with the hash of its secret
+func (r *apiKeysRepo) AddAPIKey(ctx context.Context, key *entities.APIKey, secretHash string) error {
+	var expiresAt int64
+	if key.ExpiresAt != nil {
+		expiresAt = key.ExpiresAt.Unix()
+	}
+	pipe := r.c.TxPipeline()
+	pipe.HSet(ctx, fmt.Sprintf(apiKeyKey, key.ID),
+		"id", key.ID,
+		"name", key.Name,
+		"user_id", key.UserID,
+		"hash", secretHash,
+		"created_at", key.CreatedAt.Unix(),
+		"expires_at", expiresAt,
+		"last_used", 0,
+	)
+	pipe.SAdd(ctx, apiKeysKey, key.ID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetAPIKey get an API key and the hash of its secret by ID, nil if it doesn't exist
+func (r *apiKeysRepo) GetAPIKey(ctx context.Context, id string) (*entities.APIKey, string, error) {
+	result, err := r.c.HGetAll(ctx, fmt.Sprintf(apiKeyKey, id)).Result()
+	if err != nil {
+		return nil, "", err
+	}
+	if len(result) == 0 {
+		return nil, "", nil
+	}
+	createdAt, _ := strconv.ParseInt(result["created_at"], 10, 64)
+	key := &entities.APIKey{
+		ID:         result["id"],
+		Name:       result["name"],
+		UserID:     result["user_id"],
+		CreatedAt:  time.Unix(createdAt, 0),
+		ExpiresAt:  unixTime(result["expires_at"]),
+		LastUsedAt: unixTime(result["last_used"]),
+	}
+	return key, result["hash"], nil
+}
+
+// GetAPIKeys get a list of all API keys
+func (r *apiKeysRepo) GetAPIKeys(ctx context.Context) ([]entities.APIKey, error) {
+	ids, err := r.c.SMembers(ctx, apiKeysKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	keys := []entities.APIKey{}
+	for _, id := range ids {
+		key, _, err := r.GetAPIKey(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if key != nil {
+			keys = append(keys, *key)
+		}
+	}
+	return keys, nil
+}
+
+// DeleteAPIKey delete an API key
+func (r *apiKeysRepo) DeleteAPIKey(ctx context.Context, id string) error {
+	pipe := r.c.TxPipeline()
+	pipe.Del(ctx, fmt.Sprintf(apiKeyKey, id))
+	pipe.SRem(ctx, apiKeysKey, id)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// SetAPIKeyExpiration set the time when an API key expires
+func (r *apiKeysRepo) SetAPIKeyExpiration(ctx context.Context, id string, expiresAt time.Time) error {
+	return r.c.HSet(ctx, fmt.Sprintf(apiKeyKey, id), "expires_at", expiresAt.Unix()).Err()
+}
+
+// TouchAPIKey set the last time an API key was used
+func (r *apiKeysRepo) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	return r.c.HSet(ctx, fmt.Sprintf(apiKeyKey, id), "last_used", usedAt.Unix()).Err()
+}
+
+// unixTime parse a stored unix time, nil when it is not set
+func unixTime(value string) *time.Time {
+	sec, _ := strconv.ParseInt(value, 10, 64)
+	if sec == 0 {
+		return nil
+	}
+	t := time.Unix(sec, 0)
+	return &t
+}
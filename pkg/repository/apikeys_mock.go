@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/stretchr/testify/mock"
+)
+
+// APIKeysRepoMock API keys repo mock
+type APIKeysRepoMock struct {
+	M mock.Mock
+}
+
+// AddAPIKey store an API key with the hash of its secret
+func (r *APIKeysRepoMock) AddAPIKey(ctx context.Context, key *entities.APIKey, secretHash string) error {
+	args := r.M.Called(key, secretHash)
+	return args.Error(0)
+}
+
+// GetAPIKey get an API key and the hash of its secret by ID
+func (r *APIKeysRepoMock) GetAPIKey(ctx context.Context, id string) (*entities.APIKey, string, error) {
+	args := r.M.Called(id)
+	key := args.Get(0)
+	if key != nil {
+		return key.(*entities.APIKey), args.String(1), args.Error(2)
+	}
+	return nil, args.String(1), args.Error(2)
+}
+
+// GetAPIKeys get a list of all API keys
+func (r *APIKeysRepoMock) GetAPIKeys(ctx context.Context) ([]entities.APIKey, error) {
+	args := r.M.Called()
+	return args.Get(0).([]entities.APIKey), args.Error(1)
+}
+
+// DeleteAPIKey delete an API key
+func (r *APIKeysRepoMock) DeleteAPIKey(ctx context.Context, id string) error {
+	args := r.M.Called(id)
+	return args.Error(0)
+}
+
+// SetAPIKeyExpiration set the time when an API key expires
+func (r *APIKeysRepoMock) SetAPIKeyExpiration(ctx context.Context, id string, expiresAt time.Time) error {
+	args := r.M.Called(id, expiresAt)
+	return args.Error(0)
+}
+
+// TouchAPIKey set the last time an API key was used
+func (r *APIKeysRepoMock) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	args := r.M.Called(id)
+	return args.Error(0)
+}
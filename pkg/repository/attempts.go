@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// AttemptsRepository interface to store the failed login attempts shared by all the instances
+type AttemptsRepository interface {
+	// AddFailure record a failure of a key and return the number of failures within the window
+	AddFailure(ctx context.Context, key string, at time.Time, window time.Duration) (int, error)
+	// IncrementLockouts increment the consecutive lockouts of a key, they are forgotten after ttl
+	IncrementLockouts(ctx context.Context, key string, ttl time.Duration) (int, error)
+	// SetLock lock a key out for a duration
+	SetLock(ctx context.Context, key string, duration time.Duration) error
+	// GetLock get the remaining lockout of a key, 0 when it is not locked
+	GetLock(ctx context.Context, key string) (time.Duration, error)
+	// ClearFailures delete the failures of a key
+	ClearFailures(ctx context.Context, key string) error
+	// Clear delete the failures, lockouts and lock of a key
+	Clear(ctx context.Context, key string) error
+}
+
+type attemptsRepo struct {
+	c *redis.Client
+}
+
+// NewAttemptsRepository creates a new repository instance
+func NewAttemptsRepository(ctx context.Context, client *redis.Client) (AttemptsRepository, error) {
+	_, err := client.Ping(context.TODO()).Result()
+	if err != nil {
+		return nil, err
+	}
+	return &attemptsRepo{
+		c: client,
+	}, nil
+}
+
+// AddFailure record a failure of a key and return the number of failures within the window
+func (r *attemptsRepo) AddFailure(ctx context.Context, key string, at time.Time, window time.Duration) (int, error) {
+	failuresKey := fmt.Sprintf(loginFailuresKey, key)
+	now := at.UnixNano()
+	pipe := r.c.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, failuresKey, "-inf", strconv.FormatInt(now-window.Nanoseconds(), 10))
+	pipe.ZAdd(ctx, failuresKey, &redis.Z{Score: float64(now), Member: now})
+	count := pipe.ZCard(ctx, failuresKey)
+	pipe.Expire(ctx, failuresKey, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return int(count.Val()), nil
+}
+
+// IncrementLockouts increment the consecutive lockouts of a key, they are forgotten after ttl
+func (r *attemptsRepo) IncrementLockouts(ctx context.Context, key string, ttl time.Duration) (int, error) {
+	lockoutsKey := fmt.Sprintf(loginLockoutsKey, key)
+	pipe := r.c.TxPipeline()
+	count := pipe.Incr(ctx, lockoutsKey)
+	pipe.Expire(ctx, lockoutsKey, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return int(count.Val()), nil
+}
+
+// SetLock lock a key out for a duration
+func (r *attemptsRepo) SetLock(ctx context.Context, key string, duration time.Duration) error {
+	return r.c.Set(ctx, fmt.Sprintf(loginLockKey, key), 1, duration).Err()
+}
+
+// GetLock get the remaining lockout of a key, 0 when it is not locked
+func (r *attemptsRepo) GetLock(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := r.c.PTTL(ctx, fmt.Sprintf(loginLockKey, key)).Result()
+	if err != nil {
+		return 0, err
+	}
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// ClearFailures delete the failures of a key
+func (r *attemptsRepo) ClearFailures(ctx context.Context, key string) error {
+	return r.c.Del(ctx, fmt.Sprintf(loginFailuresKey, key)).Err()
+}
+
+// Clear delete the failures, lockouts and lock of a key
+func (r *attemptsRepo) Clear(ctx context.Context, key string) error {
+	return r.c.Del(ctx,
+		fmt.Sprintf(loginFailuresKey, key),
+		fmt.Sprintf(loginLockKey, key),
+		fmt.Sprintf(loginLockoutsKey, key),
+	).Err()
+}
@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/go-redis/redis/v8"
+)
+
+// ClientsRepository interface to store the service clients and their internal refresh tokens
+type ClientsRepository interface {
+	// AddClient store a service client with its secret hash
+	AddClient(context.Context, *entities.ServiceClient, string) error
+	// GetClient get a service client by ID, nil if it doesn't exist
+	GetClient(context.Context, string) (*entities.ServiceClient, error)
+	// GetClientSecretHash get the secret hash of a service client, empty if it doesn't exist
+	GetClientSecretHash(context.Context, string) (string, error)
+	// GetClients get a list of all service clients
+	GetClients(context.Context) ([]entities.ServiceClient, error)
+	// DeleteClient delete a service client
+	DeleteClient(context.Context, string) error
+	// StoreInternalToken store an internal refresh token UUID for a client ID with an expiration period
+	StoreInternalToken(context.Context, string, string, time.Duration) error
+	// ConsumeInternalToken delete an internal refresh token UUID and return its client ID, empty if not found
+	ConsumeInternalToken(context.Context, string) (string, error)
+	// GetInternalToken get the client ID of an internal refresh token UUID, empty if not found
+	GetInternalToken(context.Context, string) (string, error)
+}
+
+type clientsRepo struct {
+	c *redis.Client
+}
+
+// NewClientsRepository creates a new repository instance
+func NewClientsRepository(ctx context.Context, client *redis.Client) (ClientsRepository, error) {
+	_, err := client.Ping(context.TODO()).Result()
+	if err != nil {
+		return nil, err
+	}
+	return &clientsRepo{
+		c: client,
+	}, nil
+}
+
+// AddClient store a service client with its secret hash
+func (r *clientsRepo) AddClient(ctx context.Context, client *entities.ServiceClient, secretHash string) error {
+	pipe := r.c.TxPipeline()
+	pipe.HSet(ctx, fmt.Sprintf(clientKey, client.ID),
+		"id", client.ID,
+		"name", client.Name,
+		"secret", secretHash,
+		"scopes", strings.Join(client.Scopes, " "),
+		"created_at", client.CreatedAt.Unix(),
+	)
+	pipe.SAdd(ctx, clientsKey, client.ID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetClient get a service client by ID, nil if it doesn't exist
+func (r *clientsRepo) GetClient(ctx context.Context, id string) (*entities.ServiceClient, error) {
+	result, err := r.c.HGetAll(ctx, fmt.Sprintf(clientKey, id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+	createdAt, _ := strconv.ParseInt(result["created_at"], 10, 64)
+	return &entities.ServiceClient{
+		ID:        result["id"],
+		Name:      result["name"],
+		Scopes:    strings.Fields(result["scopes"]),
+		CreatedAt: time.Unix(createdAt, 0),
+	}, nil
+}
+
+// GetClientSecretHash get the secret hash of a service client, empty if it doesn't exist
+func (r *clientsRepo) GetClientSecretHash(ctx context.Context, id string) (string, error) {
+	hash, err := r.c.HGet(ctx, fmt.Sprintf(clientKey, id), "secret").Result()
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// GetClients get a list of all service clients
+func (r *clientsRepo) GetClients(ctx context.Context) ([]entities.ServiceClient, error) {
+	ids, err := r.c.SMembers(ctx, clientsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	clients := []entities.ServiceClient{}
+	for _, id := range ids {
+		client, err := r.GetClient(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if client != nil {
+			clients = append(clients, *client)
+		}
+	}
+	return clients, nil
+}
+
+// DeleteClient delete a service client
+func (r *clientsRepo) DeleteClient(ctx context.Context, id string) error {
+	pipe := r.c.TxPipeline()
+	pipe.Del(ctx, fmt.Sprintf(clientKey, id))
+	pipe.SRem(ctx, clientsKey, id)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// StoreInternalToken store an internal refresh token UUID for a client ID with an expiration period
+func (r *clientsRepo) StoreInternalToken(ctx context.Context, refreshUUID string, clientID string, ttl time.Duration) error {
+	_, err := r.c.Set(ctx, fmt.Sprintf(internalRefreshKey, refreshUUID), clientID, ttl).Result()
+	return err
+}
+
+// ConsumeInternalToken delete an internal refresh token UUID and return its client ID, empty if not found
+func (r *clientsRepo) ConsumeInternalToken(ctx context.Context, refreshUUID string) (string, error) {
+	key := fmt.Sprintf(internalRefreshKey, refreshUUID)
+	pipe := r.c.TxPipeline()
+	get := pipe.Get(ctx, key)
+	pipe.Del(ctx, key)
+	_, err := pipe.Exec(ctx)
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+	return get.Val(), nil
+}
+
+// GetInternalToken get the client ID of an internal refresh token UUID, empty if not found
+func (r *clientsRepo) GetInternalToken(ctx context.Context, refreshUUID string) (string, error) {
+	clientID, err := r.c.Get(ctx, fmt.Sprintf(internalRefreshKey, refreshUUID)).Result()
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+	return clientID, nil
+}
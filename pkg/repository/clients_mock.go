@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/stretchr/testify/mock"
+)
+
+// ClientsRepoMock clients repo mock
+type ClientsRepoMock struct {
+	M mock.Mock
+}
+
+// AddClient store a service client with its secret hash
+func (r *ClientsRepoMock) AddClient(ctx context.Context, client *entities.ServiceClient, secretHash string) error {
+	args := r.M.Called(client, secretHash)
+	return args.Error(0)
+}
+
+// GetClient get a service client by ID
+func (r *ClientsRepoMock) GetClient(ctx context.Context, id string) (*entities.ServiceClient, error) {
+	args := r.M.Called(id)
+	client := args.Get(0)
+	if client != nil {
+		return client.(*entities.ServiceClient), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// GetClientSecretHash get the secret hash of a service client
+func (r *ClientsRepoMock) GetClientSecretHash(ctx context.Context, id string) (string, error) {
+	args := r.M.Called(id)
+	return args.String(0), args.Error(1)
+}
+
+// GetClients get a list of all service clients
+func (r *ClientsRepoMock) GetClients(ctx context.Context) ([]entities.ServiceClient, error) {
+	args := r.M.Called()
+	return args.Get(0).([]entities.ServiceClient), args.Error(1)
+}
+
+// DeleteClient delete a service client
+func (r *ClientsRepoMock) DeleteClient(ctx context.Context, id string) error {
+	args := r.M.Called(id)
+	return args.Error(0)
+}
+
+// StoreInternalToken store an internal refresh token UUID
+func (r *ClientsRepoMock) StoreInternalToken(ctx context.Context, refreshUUID string, clientID string, ttl time.Duration) error {
+	args := r.M.Called(refreshUUID, clientID)
+	return args.Error(0)
+}
+
+// ConsumeInternalToken delete an internal refresh token UUID and return its client ID
+func (r *ClientsRepoMock) ConsumeInternalToken(ctx context.Context, refreshUUID string) (string, error) {
+	args := r.M.Called(refreshUUID)
+	return args.String(0), args.Error(1)
+}
+
+// GetInternalToken get the client ID of an internal refresh token UUID
+func (r *ClientsRepoMock) GetInternalToken(ctx context.Context, refreshUUID string) (string, error) {
+	args := r.M.Called(refreshUUID)
+	return args.String(0), args.Error(1)
+}
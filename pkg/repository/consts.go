@@ -10,6 +10,56 @@ const usersKey string = "users"          // users
 const roleUserKey string = "roleuser:%s" // roleuser:roleID
 const userRoleKey string = "userrole:%s" // userrole:userID
 
+const offboardRetries int = 3 // attempts of the offboarding transaction when the watched keys change
+
+const statusField string = "status"                // account status field at user:userID
+const statusReasonField string = "status_reason"   // reason of the last status change field at user:userID
+const statusChangedField string = "status_changed" // unix time of the last status change field at user:userID
+const emailVerifiedField string = "email_verified" // unix time of the email verification field at user:userID
+const tokenVersionField string = "token_version"   // token version field at user:userID
+
+const passwordField string = "password"                // password hash field at user:userID
+const passwordChangedField string = "password_changed" // password change unix time field at user:userID
+const passwordHistoryKey string = "passwords:%s"       // passwords:userID
+
+const mfaSecretField string = "mfa_secret"       // TOTP secret field at user:userID
+const mfaEnabledField string = "mfa_enabled"     // TOTP confirmed field at user:userID
+const mfaStepField string = "mfa_step"           // last accepted TOTP time step field at user:userID
+const mfaRecoveryKey string = "mfarecovery:%s"   // mfarecovery:userID
+const mfaChallengeKey string = "mfachallenge:%s" // mfachallenge:challenge
+
+const tokenFamilyKey string = "family:%s"          // family:familyID
+const refreshFamilyKey string = "refreshfamily:%s" // refreshfamily:refreshUUID
+const oneTimeTokenKey string = "tokens:%s:%s"      // tokens:purpose:tokenHash
+const sessionKey string = "session:%s"             // session:familyID
+const userSessionsKey string = "sessions:%s"       // sessions:userID
+
+const impersonationsKey string = "audit:impersonations" // impersonations audit log, newest first
+
+const revokedTokensKey string = "revoked" // revoked access token UUIDs scored by their expiration
+
+const signingKeysKey string = "jwtkeys"                  // jwtkeys kid -> signing key JSON
+const configuredSigningKey string = "jwtkeys:configured" // kid of the last configured key
+const signingLockKey string = "jwtkeys:lock"             // key rotation lock
+
+const clientKey string = "client:%s"                   // client:clientID
+const clientsKey string = "clients"                    // set of client IDs
+const internalRefreshKey string = "internalrefresh:%s" // internalrefresh:refreshUUID
+
+const oidcClientKey string = "oidcclient:%s"          // oidcclient:clientID
+const oidcClientsKey string = "oidcclients"           // set of OpenID Connect client IDs
+const authorizationCodeKey string = "authcode:%s"     // authcode:codeHash
+const federationStateKey string = "fedstate:%s"       // fedstate:stateHash
+const federationSubjectsKey string = "fedsubjects:%s" // fedsubjects:issuer, hash of subject -> user ID
+const federationUsersKey string = "fedusers:%s"       // fedusers:issuer, hash of user ID -> subject
+
+const loginFailuresKey string = "loginfailures:%s" // loginfailures:account:<email> or loginfailures:ip:<address>
+const loginLockKey string = "loginlock:%s"         // loginlock:account:<email> or loginlock:ip:<address>
+const loginLockoutsKey string = "loginlockouts:%s" // loginlockouts:account:<email> or loginlockouts:ip:<address>
+
+const apiKeyKey string = "apikey:%s" // apikey:keyID
+const apiKeysKey string = "apikeys"  // set of API key IDs
+
 const roleIDKey string = "roleId"
 const rolesKey string = "roles"
 
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/go-redis/redis/v8"
+)
+
+// KeysRepository interface to store the JWT signing keys shared by all the instances
+type KeysRepository interface {
+	// GetSigningKeys get all the stored signing keys
+	GetSigningKeys(ctx context.Context) ([]entities.SigningKey, error)
+	// SaveSigningKeys store or replace the given keys and delete the removed key IDs
+	SaveSigningKeys(ctx context.Context, keys []entities.SigningKey, removed []string) error
+	// GetConfiguredKeyID get the ID of the last key loaded from the configuration
+	GetConfiguredKeyID(ctx context.Context) (string, error)
+	// SetConfiguredKeyID set the ID of the last key loaded from the configuration
+	SetConfiguredKeyID(ctx context.Context, kid string) error
+	// LockRotation get a lock to rotate the keys for an owner token, false if another instance holds it
+	LockRotation(ctx context.Context, owner string, ttl time.Duration) (bool, error)
+	// UnlockRotation release the rotation lock if it is still held by the owner token
+	UnlockRotation(ctx context.Context, owner string) error
+}
+
+type keysRepo struct {
+	c *redis.Client
+}
+
+// NewKeysRepository creates a new repository instance
+func NewKeysRepository(ctx context.Context, client *redis.Client) (KeysRepository, error) {
+	_, err := client.Ping(context.TODO()).Result()
+	if err != nil {
+		return nil, err
+	}
+	return &keysRepo{
+		c: client,
+	}, nil
+}
+
+// GetSigningKeys get all the stored signing keys
+func (r *keysRepo) GetSigningKeys(ctx context.Context) ([]entities.SigningKey, error) {
+	values, err := r.c.HGetAll(ctx, signingKeysKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	keys := []entities.SigningKey{}
+	for _, value := range values {
+		var key entities.SigningKey
+		if err = json.Unmarshal([]byte(value), &key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// SaveSigningKeys store or replace the given keys and delete the removed key IDs
+func (r *keysRepo) SaveSigningKeys(ctx context.Context, keys []entities.SigningKey, removed []string) error {
+	pipe := r.c.TxPipeline()
+	for _, key := range keys {
+		value, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		pipe.HSet(ctx, signingKeysKey, key.ID, value)
+	}
+	if len(removed) > 0 {
+		pipe.HDel(ctx, signingKeysKey, removed...)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetConfiguredKeyID get the ID of the last key loaded from the configuration
+func (r *keysRepo) GetConfiguredKeyID(ctx context.Context) (string, error) {
+	kid, err := r.c.Get(ctx, configuredSigningKey).Result()
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+	return kid, nil
+}
+
+// SetConfiguredKeyID set the ID of the last key loaded from the configuration
+func (r *keysRepo) SetConfiguredKeyID(ctx context.Context, kid string) error {
+	_, err := r.c.Set(ctx, configuredSigningKey, kid, 0).Result()
+	return err
+}
+
+// LockRotation get a lock to rotate the keys for an owner token, false if another instance holds it
+func (r *keysRepo) LockRotation(ctx context.Context, owner string, ttl time.Duration) (bool, error) {
+	return r.c.SetNX(ctx, signingLockKey, owner, ttl).Result()
+}
+
+// unlockRotationScript delete the lock only if it holds the owner token, once the lock expired another instance
+// may hold it
+var unlockRotationScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// UnlockRotation release the rotation lock if it is still held by the owner token
+func (r *keysRepo) UnlockRotation(ctx context.Context, owner string) error {
+	return unlockRotationScript.Run(ctx, r.c, []string{signingLockKey}, owner).Err()
+}
@@ -0,0 +1,220 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/go-redis/redis/v8"
+)
+
+// OIDCRepository interface to store the OpenID Connect clients, the pending authorization codes and the pending
+// federated logins
+type OIDCRepository interface {
+	// AddClient store an OpenID Connect client
+	AddClient(context.Context, *entities.OIDCClient) error
+	// GetClient get an OpenID Connect client by ID, nil if it doesn't exist
+	GetClient(context.Context, string) (*entities.OIDCClient, error)
+	// GetClients get a list of all OpenID Connect clients
+	GetClients(context.Context) ([]entities.OIDCClient, error)
+	// DeleteClient delete an OpenID Connect client
+	DeleteClient(context.Context, string) error
+	// StoreAuthorizationCode store an authorization code by its hash with an expiration period
+	StoreAuthorizationCode(context.Context, string, *entities.AuthorizationCode, time.Duration) error
+	// TakeAuthorizationCode delete an authorization code by its hash and return it, nil if not found
+	TakeAuthorizationCode(context.Context, string) (*entities.AuthorizationCode, error)
+	// StoreFederationState store a federated login by its state hash with an expiration period
+	StoreFederationState(context.Context, string, *entities.FederationState, time.Duration) error
+	// TakeFederationState delete a federated login by its state hash and return it, nil if not found
+	TakeFederationState(context.Context, string) (*entities.FederationState, error)
+	// GetFederatedUser get the ID of the user linked to the subject of an issuer, empty if there is none
+	GetFederatedUser(ctx context.Context, issuer string, subject string) (string, error)
+	// GetFederatedSubject get the subject of an issuer linked to a user, empty if there is none
+	GetFederatedSubject(ctx context.Context, issuer string, userID string) (string, error)
+	// LinkFederatedUser link the subject of an issuer to a user
+	LinkFederatedUser(ctx context.Context, issuer string, subject string, userID string) error
+}
+
+type oidcRepo struct {
+	c *redis.Client
+}
+
+// NewOIDCRepository creates a new repository instance
+func NewOIDCRepository(ctx context.Context, client *redis.Client) (OIDCRepository, error) {
+	_, err := client.Ping(context.TODO()).Result()
+	if err != nil {
+		return nil, err
+	}
+	return &oidcRepo{
+		c: client,
+	}, nil
+}
+
+// AddClient store an OpenID Connect client
+func (r *oidcRepo) AddClient(ctx context.Context, client *entities.OIDCClient) error {
+	pipe := r.c.TxPipeline()
+	pipe.HSet(ctx, fmt.Sprintf(oidcClientKey, client.ID),
+		"id", client.ID,
+		"name", client.Name,
+		"redirect_uris", strings.Join(client.RedirectURIs, " "),
+		"created_at", client.CreatedAt.Unix(),
+	)
+	pipe.SAdd(ctx, oidcClientsKey, client.ID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetClient get an OpenID Connect client by ID, nil if it doesn't exist
+func (r *oidcRepo) GetClient(ctx context.Context, id string) (*entities.OIDCClient, error) {
+	result, err := r.c.HGetAll(ctx, fmt.Sprintf(oidcClientKey, id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+	createdAt, _ := strconv.ParseInt(result["created_at"], 10, 64)
+	return &entities.OIDCClient{
+		ID:           result["id"],
+		Name:         result["name"],
+		RedirectURIs: strings.Fields(result["redirect_uris"]),
+		CreatedAt:    time.Unix(createdAt, 0),
+	}, nil
+}
+
+// GetClients get a list of all OpenID Connect clients
+func (r *oidcRepo) GetClients(ctx context.Context) ([]entities.OIDCClient, error) {
+	ids, err := r.c.SMembers(ctx, oidcClientsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	clients := []entities.OIDCClient{}
+	for _, id := range ids {
+		client, err := r.GetClient(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if client != nil {
+			clients = append(clients, *client)
+		}
+	}
+	return clients, nil
+}
+
+// DeleteClient delete an OpenID Connect client
+func (r *oidcRepo) DeleteClient(ctx context.Context, id string) error {
+	pipe := r.c.TxPipeline()
+	pipe.Del(ctx, fmt.Sprintf(oidcClientKey, id))
+	pipe.SRem(ctx, oidcClientsKey, id)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// StoreAuthorizationCode store an authorization code by its hash with an expiration period
+func (r *oidcRepo) StoreAuthorizationCode(ctx context.Context, codeHash string, code *entities.AuthorizationCode, ttl time.Duration) error {
+	key := fmt.Sprintf(authorizationCodeKey, codeHash)
+	pipe := r.c.TxPipeline()
+	pipe.HSet(ctx, key,
+		"client_id", code.ClientID,
+		"redirect_uri", code.RedirectURI,
+		"scope", code.Scope,
+		"nonce", code.Nonce,
+		"code_challenge", code.CodeChallenge,
+		"user_id", code.UserID,
+		"auth_time", code.AuthTime,
+		"access", code.Access,
+		"refresh", code.Refresh,
+	)
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// TakeAuthorizationCode delete an authorization code by its hash and return it, nil if not found
+func (r *oidcRepo) TakeAuthorizationCode(ctx context.Context, codeHash string) (*entities.AuthorizationCode, error) {
+	key := fmt.Sprintf(authorizationCodeKey, codeHash)
+	pipe := r.c.TxPipeline()
+	get := pipe.HGetAll(ctx, key)
+	pipe.Del(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	result := get.Val()
+	if len(result) == 0 {
+		return nil, nil
+	}
+	authTime, _ := strconv.ParseInt(result["auth_time"], 10, 64)
+	return &entities.AuthorizationCode{
+		ClientID:      result["client_id"],
+		RedirectURI:   result["redirect_uri"],
+		Scope:         result["scope"],
+		Nonce:         result["nonce"],
+		CodeChallenge: result["code_challenge"],
+		UserID:        result["user_id"],
+		AuthTime:      authTime,
+		Access:        result["access"],
+		Refresh:       result["refresh"],
+	}, nil
+}
+
+// StoreFederationState store a federated login by its state hash with an expiration period
+func (r *oidcRepo) StoreFederationState(ctx context.Context, stateHash string, state *entities.FederationState, ttl time.Duration) error {
+	key := fmt.Sprintf(federationStateKey, stateHash)
+	pipe := r.c.TxPipeline()
+	pipe.HSet(ctx, key,
+		"nonce", state.Nonce,
+		"code_verifier", state.CodeVerifier,
+	)
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// TakeFederationState delete a federated login by its state hash and return it, nil if not found
+func (r *oidcRepo) TakeFederationState(ctx context.Context, stateHash string) (*entities.FederationState, error) {
+	key := fmt.Sprintf(federationStateKey, stateHash)
+	pipe := r.c.TxPipeline()
+	get := pipe.HGetAll(ctx, key)
+	pipe.Del(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	result := get.Val()
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return &entities.FederationState{
+		Nonce:        result["nonce"],
+		CodeVerifier: result["code_verifier"],
+	}, nil
+}
+
+// GetFederatedUser get the ID of the user linked to the subject of an issuer, empty if there is none
+func (r *oidcRepo) GetFederatedUser(ctx context.Context, issuer string, subject string) (string, error) {
+	userID, err := r.c.HGet(ctx, fmt.Sprintf(federationSubjectsKey, issuer), subject).Result()
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+// GetFederatedSubject get the subject of an issuer linked to a user, empty if there is none
+func (r *oidcRepo) GetFederatedSubject(ctx context.Context, issuer string, userID string) (string, error) {
+	subject, err := r.c.HGet(ctx, fmt.Sprintf(federationUsersKey, issuer), userID).Result()
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+	return subject, nil
+}
+
+// LinkFederatedUser link the subject of an issuer to a user
+func (r *oidcRepo) LinkFederatedUser(ctx context.Context, issuer string, subject string, userID string) error {
+	pipe := r.c.TxPipeline()
+	pipe.HSet(ctx, fmt.Sprintf(federationSubjectsKey, issuer), subject, userID)
+	pipe.HSet(ctx, fmt.Sprintf(federationUsersKey, issuer), userID, subject)
+	_, err := pipe.Exec(ctx)
+	return err
+}
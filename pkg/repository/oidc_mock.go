@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/stretchr/testify/mock"
+)
+
+// OIDCRepoMock OpenID Connect repo mock
+type OIDCRepoMock struct {
+	M mock.Mock
+}
+
+// AddClient store an OpenID Connect client
+func (r *OIDCRepoMock) AddClient(ctx context.Context, client *entities.OIDCClient) error {
+	args := r.M.Called(client)
+	return args.Error(0)
+}
+
+// GetClient get an OpenID Connect client by ID
+func (r *OIDCRepoMock) GetClient(ctx context.Context, id string) (*entities.OIDCClient, error) {
+	args := r.M.Called(id)
+	client := args.Get(0)
+	if client != nil {
+		return client.(*entities.OIDCClient), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// GetClients get a list of all OpenID Connect clients
+func (r *OIDCRepoMock) GetClients(ctx context.Context) ([]entities.OIDCClient, error) {
+	args := r.M.Called()
+	return args.Get(0).([]entities.OIDCClient), args.Error(1)
+}
+
+// DeleteClient delete an OpenID Connect client
+func (r *OIDCRepoMock) DeleteClient(ctx context.Context, id string) error {
+	args := r.M.Called(id)
+	return args.Error(0)
+}
+
+// StoreAuthorizationCode store an authorization code by its hash
+func (r *OIDCRepoMock) StoreAuthorizationCode(ctx context.Context, codeHash string, code *entities.AuthorizationCode, ttl time.Duration) error {
+	args := r.M.Called(codeHash, code)
+	return args.Error(0)
+}
+
+// TakeAuthorizationCode delete an authorization code by its hash and return it
+func (r *OIDCRepoMock) TakeAuthorizationCode(ctx context.Context, codeHash string) (*entities.AuthorizationCode, error) {
+	args := r.M.Called(codeHash)
+	code := args.Get(0)
+	if code != nil {
+		return code.(*entities.AuthorizationCode), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// StoreFederationState store a federated login by its state hash
+func (r *OIDCRepoMock) StoreFederationState(ctx context.Context, stateHash string, state *entities.FederationState, ttl time.Duration) error {
+	args := r.M.Called(stateHash, state)
+	return args.Error(0)
+}
+
+// TakeFederationState delete a federated login by its state hash and return it
+func (r *OIDCRepoMock) TakeFederationState(ctx context.Context, stateHash string) (*entities.FederationState, error) {
+	args := r.M.Called(stateHash)
+	state := args.Get(0)
+	if state != nil {
+		return state.(*entities.FederationState), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// GetFederatedUser get the ID of the user linked to the subject of an issuer
+func (r *OIDCRepoMock) GetFederatedUser(ctx context.Context, issuer string, subject string) (string, error) {
+	args := r.M.Called(issuer, subject)
+	return args.String(0), args.Error(1)
+}
+
+// GetFederatedSubject get the subject of an issuer linked to a user
+func (r *OIDCRepoMock) GetFederatedSubject(ctx context.Context, issuer string, userID string) (string, error) {
+	args := r.M.Called(issuer, userID)
+	return args.String(0), args.Error(1)
+}
+
+// LinkFederatedUser link the subject of an issuer to a user
+func (r *OIDCRepoMock) LinkFederatedUser(ctx context.Context, issuer string, subject string, userID string) error {
+	args := r.M.Called(issuer, subject, userID)
+	return args.Error(0)
+}
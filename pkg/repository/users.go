@@ -2,8 +2,11 @@ package repository
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/StevenRojas/goaccess/pkg/entities"
@@ -20,8 +23,11 @@ type UsersRepository interface {
 	GetUsersByRole(context.Context, string) ([]entities.User, error)
 	// Register a user
 	Register(context.Context, *entities.User) error
-	// Unregister a user
+	// Unregister a user, same as Offboard
 	Unregister(context.Context, *entities.User) error
+	// Offboard remove a user with its tokens, sessions, API keys, role memberships and permission lists in a
+	// single transaction
+	Offboard(context.Context, string) error
 	// GetUserByID get a user by ID
 	GetUserByID(context.Context, string) (*entities.User, error)
 	// GetUserByEmail get a user by email
@@ -32,8 +38,70 @@ type UsersRepository interface {
 	StoreTokens(context.Context, *utils.StoredToken) error
 	// DeleteToken delete token key
 	DeleteToken(context.Context, string) error
+	// ConsumeToken delete a token key and return its user ID, empty if the token was already used or expired
+	ConsumeToken(context.Context, string) (string, error)
+	// GetTokenFamily get the family of a refresh token UUID, it is kept after rotation to detect reuse
+	GetTokenFamily(context.Context, string) (string, error)
+	// RevokeTokenFamily delete all the tokens issued in a family and its session
+	RevokeTokenFamily(context.Context, string) error
+	// GetRevokedTokens get the UUIDs of the revoked access tokens that are not expired yet with their expiration
+	GetRevokedTokens(context.Context) (map[string]time.Time, error)
+	// StoreSession store a session in the index of its user, it expires with the session
+	StoreSession(context.Context, *entities.Session) error
+	// ExtendSession set the new expiration of a session after its refresh token was rotated
+	ExtendSession(context.Context, string, time.Time) error
+	// GetSessions get the live sessions of a user ID, revoked and expired ones are removed from the index
+	GetSessions(context.Context, string) ([]entities.Session, error)
+	// AddImpersonation append an impersonation to the audit log
+	AddImpersonation(context.Context, *entities.Impersonation) error
+	// GetImpersonations get the impersonations audit log, newest first
+	GetImpersonations(context.Context) ([]entities.Impersonation, error)
 	// IsValidUser check if a user exist
 	IsValidUser(context.Context, string) (bool, error)
+	// SetStatus store the account status of a user with the reason and time of the change
+	SetStatus(context.Context, string, *entities.AccountStatus) error
+	// GetStatus get the account status of a user, active when it was never set
+	GetStatus(context.Context, string) (*entities.AccountStatus, error)
+	// SetEmailVerified store the time the email of a user was verified, a zero time removes the verification
+	SetEmailVerified(context.Context, string, time.Time) error
+	// GetTokenVersion get the token version of a user, 0 when its roles never changed
+	GetTokenVersion(context.Context, string) (int64, error)
+	// IncrementTokenVersion increment the token version of a user so the tokens issued before are stale
+	IncrementTokenVersion(context.Context, string) (int64, error)
+	// GetUserRoles get the role IDs of a user
+	GetUserRoles(context.Context, string) ([]string, error)
+	// GetUserActions get the actions a user can perform
+	GetUserActions(context.Context, string) ([]string, error)
+	// SetPasswordHash store the password hash for a given user ID keeping the given number of previous hashes
+	SetPasswordHash(context.Context, string, string, int) error
+	// GetPasswordHash get the password hash for a given user ID, empty if not set
+	GetPasswordHash(context.Context, string) (string, error)
+	// GetPasswordHistory get the previous password hashes for a given user ID, newest first
+	GetPasswordHistory(context.Context, string) ([]string, error)
+	// GetPasswordChangedAt get the last time the password was set for a given user ID
+	GetPasswordChangedAt(context.Context, string) (time.Time, error)
+	// SetMFASecret store a pending TOTP secret for a given user ID, MFA stays disabled until it is enabled
+	SetMFASecret(context.Context, string, string) error
+	// GetMFASecret get the TOTP secret for a given user ID and whether MFA is enabled
+	GetMFASecret(context.Context, string) (string, bool, error)
+	// EnableMFA enable MFA for a given user ID replacing the recovery code hashes
+	EnableMFA(context.Context, string, []string) error
+	// DisableMFA remove the TOTP secret and recovery codes for a given user ID
+	DisableMFA(context.Context, string) error
+	// UseMFAStep store the last accepted TOTP time step, false if the step was already used
+	UseMFAStep(context.Context, string, int64) (bool, error)
+	// UseMFARecoveryCode remove a recovery code hash for a given user ID, false if it doesn't exist
+	UseMFARecoveryCode(context.Context, string, string) (bool, error)
+	// StoreMFAChallenge store a login challenge for a user ID with an expiration period
+	StoreMFAChallenge(context.Context, string, string, time.Duration) error
+	// TakeMFAChallenge get the user ID of a login challenge and delete it, empty if not found
+	TakeMFAChallenge(context.Context, string) (string, error)
+	// StoreOneTimeToken store the hash of a single use token of a purpose for a user ID with an expiration period
+	StoreOneTimeToken(ctx context.Context, purpose string, tokenHash string, ID string, expiration time.Duration) error
+	// GetOneTimeToken get the user ID of a single use token hash without using it, empty if not found
+	GetOneTimeToken(ctx context.Context, purpose string, tokenHash string) (string, error)
+	// TakeOneTimeToken get the user ID of a single use token hash and delete it, empty if not found
+	TakeOneTimeToken(ctx context.Context, purpose string, tokenHash string) (string, error)
 }
 
 type repo struct {
@@ -70,17 +138,112 @@ func (r *repo) Register(ctx context.Context, user *entities.User) error {
 	return nil
 }
 
-// Unregister a user
+// Unregister a user, same as Offboard
 func (r *repo) Unregister(ctx context.Context, user *entities.User) error {
-	pipe := r.c.Pipeline()
-	key := fmt.Sprintf(userKey, user.ID)
-	pipe.Del(ctx, key)
-	pipe.HDel(ctx, usersKey, user.Email)
-	_, err := pipe.Exec(ctx)
-	if err != nil {
+	return r.Offboard(ctx, user.ID)
+}
+
+// Offboard remove a user with its tokens, sessions, API keys, role memberships and permission lists in a
+// single transaction, it is retried when the user roles or sessions change meanwhile
+func (r *repo) Offboard(ctx context.Context, userID string) error {
+	userHash := fmt.Sprintf(userKey, userID)
+	rolesSet := fmt.Sprintf(userRoleKey, userID)
+	sessionsSet := fmt.Sprintf(userSessionsKey, userID)
+	offboard := func(tx *redis.Tx) error {
+		email, err := tx.HGet(ctx, userHash, "email").Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		roles, err := tx.SMembers(ctx, rolesSet).Result()
+		if err != nil {
+			return err
+		}
+		keys := []string{
+			userHash,
+			rolesSet,
+			sessionsSet,
+			fmt.Sprintf(passwordHistoryKey, userID),
+			fmt.Sprintf(mfaRecoveryKey, userID),
+			fmt.Sprintf(accessKey, userID),
+			fmt.Sprintf(actionsKey, userID),
+			fmt.Sprintf(hasPesmissionKey, userID),
+		}
+		sessions, err := tx.SMembers(ctx, sessionsSet).Result()
+		if err != nil {
+			return err
+		}
+		tokens := []string{}
+		for _, family := range sessions {
+			familyKey := fmt.Sprintf(tokenFamilyKey, family)
+			uuids, err := tx.SMembers(ctx, familyKey).Result()
+			if err != nil {
+				return err
+			}
+			keys = append(keys, familyKey, fmt.Sprintf(sessionKey, family))
+			for _, uuid := range uuids {
+				keys = append(keys, "tokens:"+uuid)
+			}
+			tokens = append(tokens, uuids...)
+		}
+		if err = r.revokeTokens(ctx, tokens); err != nil {
+			return err
+		}
+		iter := tx.Scan(ctx, 0, fmt.Sprintf(actionsByModuleKey, userID, "*"), 0).Iterator()
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+		}
+		if err = iter.Err(); err != nil {
+			return err
+		}
+		apiKeys, err := r.userAPIKeys(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		for _, id := range apiKeys {
+			keys = append(keys, fmt.Sprintf(apiKeyKey, id))
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, keys...)
+			if email != "" {
+				pipe.HDel(ctx, usersKey, email)
+			}
+			for _, roleID := range roles {
+				pipe.SRem(ctx, fmt.Sprintf(roleUserKey, roleID), userID)
+			}
+			if len(apiKeys) > 0 {
+				pipe.SRem(ctx, apiKeysKey, apiKeys)
+			}
+			return nil
+		})
 		return err
 	}
-	return nil
+	var err error
+	for i := 0; i < offboardRetries; i++ {
+		err = r.c.Watch(ctx, offboard, userHash, rolesSet, sessionsSet, apiKeysKey)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return err
+}
+
+// userAPIKeys get the IDs of the API keys created for a user
+func (r *repo) userAPIKeys(ctx context.Context, tx *redis.Tx, userID string) ([]string, error) {
+	ids, err := tx.SMembers(ctx, apiKeysKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	userKeys := []string{}
+	for _, id := range ids {
+		owner, err := tx.HGet(ctx, fmt.Sprintf(apiKeyKey, id), "user_id").Result()
+		if err != nil && err != redis.Nil {
+			return nil, err
+		}
+		if owner == userID {
+			userKeys = append(userKeys, id)
+		}
+	}
+	return userKeys, nil
 }
 
 // GetUserByID get a user by ID
@@ -94,10 +257,18 @@ func (r *repo) GetUserByID(ctx context.Context, id string) (*entities.User, erro
 		return nil, errors.New("Not found")
 	}
 	user := &entities.User{
-		ID:      result["id"],
-		Email:   result["email"],
-		Name:    result["name"],
-		IsAdmin: (result["admin"] == "1"),
+		ID:            result["id"],
+		Email:         result["email"],
+		Name:          result["name"],
+		IsAdmin:       (result["admin"] == "1"),
+		Status:        result[statusField],
+		EmailVerified: result[emailVerifiedField] != "",
+	}
+	if version, ok := result[tokenVersionField]; ok {
+		user.TokenVersion, _ = strconv.ParseInt(version, 10, 64)
+	}
+	if user.ID != "" && user.Status == "" {
+		user.Status = entities.UserStatusActive
 	}
 	return user, nil
 }
@@ -109,7 +280,7 @@ func (r *repo) GetUserByEmail(ctx context.Context, email string) (*entities.User
 		return nil, err
 	}
 	if id == "" {
-		return nil, errors.New("Not found")
+		return nil, nil
 	}
 	return r.GetUserByID(ctx, id)
 }
@@ -137,24 +308,283 @@ func (r *repo) StoreTokens(ctx context.Context, token *utils.StoredToken) error
 	if err != nil {
 		return err
 	}
+	// Impersonation tokens have no refresh token
+	if token.RefreshUUID == "" {
+		return nil
+	}
 	key = "tokens:" + token.RefreshUUID
 	_, err = r.c.Set(ctx, key, token.ID, rt.Sub(now)).Result()
 	if err != nil {
 		return err
 	}
-	return nil
+	if token.Family == "" {
+		return nil
+	}
+	familyKey := fmt.Sprintf(tokenFamilyKey, token.Family)
+	pipe := r.c.TxPipeline()
+	pipe.SAdd(ctx, familyKey, token.AccessUUID, token.RefreshUUID)
+	pipe.Expire(ctx, familyKey, rt.Sub(now))
+	pipe.Set(ctx, fmt.Sprintf(refreshFamilyKey, token.RefreshUUID), token.Family, rt.Sub(now))
+	_, err = pipe.Exec(ctx)
+	return err
 }
 
 // DeleteToken delete token key
 func (r *repo) DeleteToken(ctx context.Context, key string) error {
-	key = "tokens:" + key
-	_, err := r.c.Del(ctx, key).Result()
+	if err := r.revokeTokens(ctx, []string{key}); err != nil {
+		return err
+	}
+	_, err := r.c.Del(ctx, "tokens:"+key, fmt.Sprintf(refreshFamilyKey, key)).Result()
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// ConsumeToken delete a token key and return its user ID, empty if the token was already used or expired
+func (r *repo) ConsumeToken(ctx context.Context, key string) (string, error) {
+	key = "tokens:" + key
+	pipe := r.c.TxPipeline()
+	get := pipe.Get(ctx, key)
+	pipe.Del(ctx, key)
+	_, err := pipe.Exec(ctx)
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+	return get.Val(), nil
+}
+
+// GetTokenFamily get the family of a refresh token UUID, it is kept after rotation to detect reuse
+func (r *repo) GetTokenFamily(ctx context.Context, refreshUUID string) (string, error) {
+	family, err := r.c.Get(ctx, fmt.Sprintf(refreshFamilyKey, refreshUUID)).Result()
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+	return family, nil
+}
+
+// RevokeTokenFamily delete all the tokens issued in a family and its session
+func (r *repo) RevokeTokenFamily(ctx context.Context, family string) error {
+	familyKey := fmt.Sprintf(tokenFamilyKey, family)
+	uuids, err := r.c.SMembers(ctx, familyKey).Result()
+	if err != nil {
+		return err
+	}
+	keys := []string{familyKey, fmt.Sprintf(sessionKey, family)}
+	for _, uuid := range uuids {
+		keys = append(keys, "tokens:"+uuid)
+	}
+	if err = r.revokeTokens(ctx, uuids); err != nil {
+		return err
+	}
+	_, err = r.c.Del(ctx, keys...).Result()
+	return err
+}
+
+// GetRevokedTokens get the UUIDs of the revoked access tokens that are not expired yet with their expiration
+func (r *repo) GetRevokedTokens(ctx context.Context) (map[string]time.Time, error) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	revoked, err := r.c.ZRangeByScoreWithScores(ctx, revokedTokensKey, &redis.ZRangeBy{Min: now, Max: "+inf"}).Result()
+	if err != nil {
+		return nil, err
+	}
+	tokens := make(map[string]time.Time, len(revoked))
+	for _, z := range revoked {
+		if uuid, ok := z.Member.(string); ok {
+			tokens[uuid] = time.Unix(int64(z.Score), 0)
+		}
+	}
+	return tokens, nil
+}
+
+// revokeTokens add the live access tokens of a list of token UUIDs to the revocation list until they expire,
+// it is read by the instances verifying the tokens without the store. Refresh tokens are skipped, they are
+// always checked against the store
+func (r *repo) revokeTokens(ctx context.Context, uuids []string) error {
+	if len(uuids) == 0 {
+		return nil
+	}
+	pipe := r.c.Pipeline()
+	ttls := make([]*redis.DurationCmd, len(uuids))
+	refresh := make([]*redis.IntCmd, len(uuids))
+	for i, uuid := range uuids {
+		ttls[i] = pipe.PTTL(ctx, "tokens:"+uuid)
+		refresh[i] = pipe.Exists(ctx, fmt.Sprintf(refreshFamilyKey, uuid))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return err
+	}
+	now := time.Now()
+	revoked := []*redis.Z{}
+	for i, uuid := range uuids {
+		if ttls[i].Val() <= 0 || refresh[i].Val() > 0 {
+			continue
+		}
+		revoked = append(revoked, &redis.Z{Score: float64(now.Add(ttls[i].Val()).Unix() + 1), Member: uuid})
+	}
+	tx := r.c.TxPipeline()
+	tx.ZRemRangeByScore(ctx, revokedTokensKey, "-inf", "("+strconv.FormatInt(now.Unix(), 10))
+	if len(revoked) > 0 {
+		tx.ZAdd(ctx, revokedTokensKey, revoked...)
+	}
+	_, err := tx.Exec(ctx)
+	return err
+}
+
+// StoreSession store a session in the index of its user, it expires with the session
+func (r *repo) StoreSession(ctx context.Context, session *entities.Session) error {
+	key := fmt.Sprintf(sessionKey, session.ID)
+	sessionsKey := fmt.Sprintf(userSessionsKey, session.UserID)
+	pipe := r.c.TxPipeline()
+	pipe.HSet(ctx, key,
+		"id", session.ID,
+		"user_id", session.UserID,
+		"issued_at", session.IssuedAt.Unix(),
+		"expires_at", session.ExpiresAt.Unix(),
+		"user_agent", session.UserAgent,
+		"ip", session.IP,
+	)
+	pipe.ExpireAt(ctx, key, session.ExpiresAt)
+	pipe.SAdd(ctx, sessionsKey, session.ID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// ExtendSession set the new expiration of a session after its refresh token was rotated
+func (r *repo) ExtendSession(ctx context.Context, id string, expiresAt time.Time) error {
+	key := fmt.Sprintf(sessionKey, id)
+	exists, err := r.c.Exists(ctx, key).Result()
+	if err != nil || exists == 0 {
+		return err
+	}
+	pipe := r.c.TxPipeline()
+	pipe.HSet(ctx, key, "expires_at", expiresAt.Unix())
+	pipe.ExpireAt(ctx, key, expiresAt)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// GetSessions get the live sessions of a user ID, revoked and expired ones are removed from the index
+func (r *repo) GetSessions(ctx context.Context, userID string) ([]entities.Session, error) {
+	sessionsKey := fmt.Sprintf(userSessionsKey, userID)
+	ids, err := r.c.SMembers(ctx, sessionsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	sessions := []entities.Session{}
+	for _, id := range ids {
+		result, err := r.c.HGetAll(ctx, fmt.Sprintf(sessionKey, id)).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(result) == 0 {
+			if err = r.c.SRem(ctx, sessionsKey, id).Err(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		issuedAt, _ := strconv.ParseInt(result["issued_at"], 10, 64)
+		expiresAt, _ := strconv.ParseInt(result["expires_at"], 10, 64)
+		sessions = append(sessions, entities.Session{
+			ID:        result["id"],
+			UserID:    result["user_id"],
+			IssuedAt:  time.Unix(issuedAt, 0),
+			ExpiresAt: time.Unix(expiresAt, 0),
+			UserAgent: result["user_agent"],
+			IP:        result["ip"],
+		})
+	}
+	return sessions, nil
+}
+
+// SetStatus store the account status of a user with the reason and time of the change
+func (r *repo) SetStatus(ctx context.Context, ID string, status *entities.AccountStatus) error {
+	return r.c.HSet(ctx, fmt.Sprintf(userKey, ID),
+		statusField, status.Status,
+		statusReasonField, status.Reason,
+		statusChangedField, status.ChangedAt.Unix(),
+	).Err()
+}
+
+// GetStatus get the account status of a user, active when it was never set
+func (r *repo) GetStatus(ctx context.Context, ID string) (*entities.AccountStatus, error) {
+	values, err := r.c.HMGet(ctx, fmt.Sprintf(userKey, ID), statusField, statusReasonField, statusChangedField).Result()
+	if err != nil {
+		return nil, err
+	}
+	status := &entities.AccountStatus{Status: entities.UserStatusActive}
+	if value, ok := values[0].(string); ok && value != "" {
+		status.Status = value
+	}
+	if value, ok := values[1].(string); ok {
+		status.Reason = value
+	}
+	if value, ok := values[2].(string); ok {
+		changedAt, _ := strconv.ParseInt(value, 10, 64)
+		status.ChangedAt = time.Unix(changedAt, 0)
+	}
+	return status, nil
+}
+
+// SetEmailVerified store the time the email of a user was verified, a zero time removes the verification
+func (r *repo) SetEmailVerified(ctx context.Context, ID string, at time.Time) error {
+	key := fmt.Sprintf(userKey, ID)
+	if at.IsZero() {
+		return r.c.HDel(ctx, key, emailVerifiedField).Err()
+	}
+	return r.c.HSet(ctx, key, emailVerifiedField, at.Unix()).Err()
+}
+
+// GetTokenVersion get the token version of a user, 0 when its roles never changed
+func (r *repo) GetTokenVersion(ctx context.Context, ID string) (int64, error) {
+	version, err := r.c.HGet(ctx, fmt.Sprintf(userKey, ID), tokenVersionField).Int64()
+	if err != nil && err != redis.Nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// IncrementTokenVersion increment the token version of a user so the tokens issued before are stale
+func (r *repo) IncrementTokenVersion(ctx context.Context, ID string) (int64, error) {
+	return r.c.HIncrBy(ctx, fmt.Sprintf(userKey, ID), tokenVersionField, 1).Result()
+}
+
+// GetUserRoles get the role IDs of a user
+func (r *repo) GetUserRoles(ctx context.Context, ID string) ([]string, error) {
+	return r.c.SMembers(ctx, fmt.Sprintf(userRoleKey, ID)).Result()
+}
+
+// GetUserActions get the actions a user can perform
+func (r *repo) GetUserActions(ctx context.Context, ID string) ([]string, error) {
+	return r.c.SMembers(ctx, fmt.Sprintf(hasPesmissionKey, ID)).Result()
+}
+
+// AddImpersonation append an impersonation to the audit log
+func (r *repo) AddImpersonation(ctx context.Context, impersonation *entities.Impersonation) error {
+	value, err := json.Marshal(impersonation)
+	if err != nil {
+		return err
+	}
+	return r.c.LPush(ctx, impersonationsKey, value).Err()
+}
+
+// GetImpersonations get the impersonations audit log, newest first
+func (r *repo) GetImpersonations(ctx context.Context) ([]entities.Impersonation, error) {
+	values, err := r.c.LRange(ctx, impersonationsKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	impersonations := []entities.Impersonation{}
+	for _, value := range values {
+		var impersonation entities.Impersonation
+		if err = json.Unmarshal([]byte(value), &impersonation); err != nil {
+			return nil, err
+		}
+		impersonations = append(impersonations, impersonation)
+	}
+	return impersonations, nil
+}
+
 // IsValidUser check if a user exist
 func (r *repo) IsValidUser(ctx context.Context, ID string) (bool, error) {
 	key := fmt.Sprintf(userKey, ID)
@@ -165,6 +595,173 @@ func (r *repo) IsValidUser(ctx context.Context, ID string) (bool, error) {
 	return res == 1, nil
 }
 
+// SetPasswordHash store the password hash for a given user ID keeping the given number of previous hashes
+func (r *repo) SetPasswordHash(ctx context.Context, ID string, hash string, history int) error {
+	current, err := r.GetPasswordHash(ctx, ID)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf(userKey, ID)
+	historyKey := fmt.Sprintf(passwordHistoryKey, ID)
+	pipe := r.c.TxPipeline()
+	pipe.HSet(ctx, key, passwordField, hash, passwordChangedField, time.Now().Unix())
+	if history > 0 && current != "" {
+		pipe.LPush(ctx, historyKey, current)
+		pipe.LTrim(ctx, historyKey, 0, int64(history-1))
+	} else if history <= 0 {
+		pipe.Del(ctx, historyKey)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// GetPasswordHash get the password hash for a given user ID, empty if not set
+func (r *repo) GetPasswordHash(ctx context.Context, ID string) (string, error) {
+	key := fmt.Sprintf(userKey, ID)
+	hash, err := r.c.HGet(ctx, key, passwordField).Result()
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// GetPasswordHistory get the previous password hashes for a given user ID, newest first
+func (r *repo) GetPasswordHistory(ctx context.Context, ID string) ([]string, error) {
+	key := fmt.Sprintf(passwordHistoryKey, ID)
+	return r.c.LRange(ctx, key, 0, -1).Result()
+}
+
+// GetPasswordChangedAt get the last time the password was set for a given user ID
+func (r *repo) GetPasswordChangedAt(ctx context.Context, ID string) (time.Time, error) {
+	key := fmt.Sprintf(userKey, ID)
+	changed, err := r.c.HGet(ctx, key, passwordChangedField).Int64()
+	if err != nil && err != redis.Nil {
+		return time.Time{}, err
+	}
+	return time.Unix(changed, 0), nil
+}
+
+// SetMFASecret store a pending TOTP secret for a given user ID, MFA stays disabled until it is enabled
+func (r *repo) SetMFASecret(ctx context.Context, ID string, secret string) error {
+	key := fmt.Sprintf(userKey, ID)
+	pipe := r.c.TxPipeline()
+	pipe.HSet(ctx, key, mfaSecretField, secret, mfaEnabledField, false)
+	pipe.HDel(ctx, key, mfaStepField)
+	pipe.Del(ctx, fmt.Sprintf(mfaRecoveryKey, ID))
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetMFASecret get the TOTP secret for a given user ID and whether MFA is enabled
+func (r *repo) GetMFASecret(ctx context.Context, ID string) (string, bool, error) {
+	key := fmt.Sprintf(userKey, ID)
+	values, err := r.c.HMGet(ctx, key, mfaSecretField, mfaEnabledField).Result()
+	if err != nil {
+		return "", false, err
+	}
+	secret, _ := values[0].(string)
+	enabled, _ := values[1].(string)
+	return secret, enabled == "1", nil
+}
+
+// EnableMFA enable MFA for a given user ID replacing the recovery code hashes
+func (r *repo) EnableMFA(ctx context.Context, ID string, recoveryHashes []string) error {
+	recoveryKey := fmt.Sprintf(mfaRecoveryKey, ID)
+	pipe := r.c.TxPipeline()
+	pipe.HSet(ctx, fmt.Sprintf(userKey, ID), mfaEnabledField, true)
+	pipe.Del(ctx, recoveryKey)
+	if len(recoveryHashes) > 0 {
+		pipe.SAdd(ctx, recoveryKey, recoveryHashes)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// DisableMFA remove the TOTP secret and recovery codes for a given user ID
+func (r *repo) DisableMFA(ctx context.Context, ID string) error {
+	pipe := r.c.TxPipeline()
+	pipe.HDel(ctx, fmt.Sprintf(userKey, ID), mfaSecretField, mfaEnabledField, mfaStepField)
+	pipe.Del(ctx, fmt.Sprintf(mfaRecoveryKey, ID))
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// useMFAStepScript set the step field only if it is greater than the stored one
+var useMFAStepScript = redis.NewScript(`
+local last = tonumber(redis.call("HGET", KEYS[1], ARGV[1]) or "-1")
+if tonumber(ARGV[2]) <= last then
+	return 0
+end
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+return 1
+`)
+
+// UseMFAStep store the last accepted TOTP time step, false if the step was already used
+func (r *repo) UseMFAStep(ctx context.Context, ID string, step int64) (bool, error) {
+	key := fmt.Sprintf(userKey, ID)
+	res, err := useMFAStepScript.Run(ctx, r.c, []string{key}, mfaStepField, step).Int()
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
+
+// UseMFARecoveryCode remove a recovery code hash for a given user ID, false if it doesn't exist
+func (r *repo) UseMFARecoveryCode(ctx context.Context, ID string, hash string) (bool, error) {
+	res, err := r.c.SRem(ctx, fmt.Sprintf(mfaRecoveryKey, ID), hash).Result()
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
+
+// StoreMFAChallenge store a login challenge for a user ID with an expiration period
+func (r *repo) StoreMFAChallenge(ctx context.Context, challenge string, ID string, expiration time.Duration) error {
+	key := fmt.Sprintf(mfaChallengeKey, challenge)
+	_, err := r.c.Set(ctx, key, ID, expiration).Result()
+	return err
+}
+
+// TakeMFAChallenge get the user ID of a login challenge and delete it, empty if not found
+func (r *repo) TakeMFAChallenge(ctx context.Context, challenge string) (string, error) {
+	key := fmt.Sprintf(mfaChallengeKey, challenge)
+	pipe := r.c.TxPipeline()
+	get := pipe.Get(ctx, key)
+	pipe.Del(ctx, key)
+	_, err := pipe.Exec(ctx)
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+	return get.Val(), nil
+}
+
+// StoreOneTimeToken store the hash of a single use token of a purpose for a user ID with an expiration period
+func (r *repo) StoreOneTimeToken(ctx context.Context, purpose string, tokenHash string, ID string, expiration time.Duration) error {
+	return r.c.Set(ctx, fmt.Sprintf(oneTimeTokenKey, purpose, tokenHash), ID, expiration).Err()
+}
+
+// GetOneTimeToken get the user ID of a single use token hash without using it, empty if not found
+func (r *repo) GetOneTimeToken(ctx context.Context, purpose string, tokenHash string) (string, error) {
+	ID, err := r.c.Get(ctx, fmt.Sprintf(oneTimeTokenKey, purpose, tokenHash)).Result()
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+	return ID, nil
+}
+
+// TakeOneTimeToken get the user ID of a single use token hash and delete it, empty if not found
+func (r *repo) TakeOneTimeToken(ctx context.Context, purpose string, tokenHash string) (string, error) {
+	key := fmt.Sprintf(oneTimeTokenKey, purpose, tokenHash)
+	pipe := r.c.TxPipeline()
+	get := pipe.Get(ctx, key)
+	pipe.Del(ctx, key)
+	_, err := pipe.Exec(ctx)
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+	return get.Val(), nil
+}
+
 // GetUsers get a list of all users
 func (r *repo) GetUsers(ctx context.Context) ([]entities.User, error) {
 	usersKeyList, err := r.c.Keys(ctx, "user:*").Result()
@@ -196,6 +793,10 @@ func (r *repo) GetUsersByRole(ctx context.Context, roleID string) ([]entities.Us
 	}
 	userList := []entities.User{}
 	for _, userID := range userKeyList {
+		// Service clients and API keys are assigned roles too, they are not users
+		if strings.HasPrefix(userID, entities.ClientSubjectPrefix) || strings.HasPrefix(userID, entities.APIKeySubjectPrefix) {
+			continue
+		}
 		hash, err := r.c.HGetAll(ctx, "user:"+userID).Result()
 		if err != nil {
 			return nil, err
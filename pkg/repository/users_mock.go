@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"time"
 
 	"github.com/StevenRojas/goaccess/pkg/entities"
 	"github.com/StevenRojas/goaccess/pkg/utils"
@@ -14,8 +15,10 @@ import (
 type UsersRepositoryMock interface {
 	// Register a user
 	Register(context.Context, *entities.User) error
-	// Unregister a user
+	// Unregister a user, same as Offboard
 	Unregister(context.Context, *entities.User) error
+	// Offboard remove a user with its tokens, sessions, API keys, role memberships and permission lists
+	Offboard(context.Context, string) error
 	// GetUsers get a user by ID
 	GetUsers(context.Context) ([]entities.User, error)
 	// GetUsersByRole get a user by ID
@@ -30,8 +33,70 @@ type UsersRepositoryMock interface {
 	StoreTokens(context.Context, *utils.StoredToken) error
 	// DeleteToken delete token key
 	DeleteToken(context.Context, string) error
+	// ConsumeToken delete a token key and return its user ID, empty if the token was already used or expired
+	ConsumeToken(context.Context, string) (string, error)
+	// GetTokenFamily get the family of a refresh token UUID, it is kept after rotation to detect reuse
+	GetTokenFamily(context.Context, string) (string, error)
+	// RevokeTokenFamily delete all the tokens issued in a family and its session
+	RevokeTokenFamily(context.Context, string) error
+	// GetRevokedTokens get the UUIDs of the revoked access tokens that are not expired yet with their expiration
+	GetRevokedTokens(context.Context) (map[string]time.Time, error)
+	// StoreSession store a session in the index of its user, it expires with the session
+	StoreSession(context.Context, *entities.Session) error
+	// ExtendSession set the new expiration of a session after its refresh token was rotated
+	ExtendSession(context.Context, string, time.Time) error
+	// GetSessions get the live sessions of a user ID, revoked and expired ones are removed from the index
+	GetSessions(context.Context, string) ([]entities.Session, error)
+	// AddImpersonation append an impersonation to the audit log
+	AddImpersonation(context.Context, *entities.Impersonation) error
+	// GetImpersonations get the impersonations audit log, newest first
+	GetImpersonations(context.Context) ([]entities.Impersonation, error)
 	// IsValidUser check if a user exist
 	IsValidUser(context.Context, string) (bool, error)
+	// SetStatus store the account status of a user with the reason and time of the change
+	SetStatus(context.Context, string, *entities.AccountStatus) error
+	// GetStatus get the account status of a user, active when it was never set
+	GetStatus(context.Context, string) (*entities.AccountStatus, error)
+	// SetEmailVerified store the time the email of a user was verified, a zero time removes the verification
+	SetEmailVerified(context.Context, string, time.Time) error
+	// GetTokenVersion get the token version of a user, 0 when its roles never changed
+	GetTokenVersion(context.Context, string) (int64, error)
+	// IncrementTokenVersion increment the token version of a user so the tokens issued before are stale
+	IncrementTokenVersion(context.Context, string) (int64, error)
+	// GetUserRoles get the role IDs of a user
+	GetUserRoles(context.Context, string) ([]string, error)
+	// GetUserActions get the actions a user can perform
+	GetUserActions(context.Context, string) ([]string, error)
+	// SetPasswordHash store the password hash for a given user ID keeping the given number of previous hashes
+	SetPasswordHash(context.Context, string, string, int) error
+	// GetPasswordHash get the password hash for a given user ID, empty if not set
+	GetPasswordHash(context.Context, string) (string, error)
+	// GetPasswordHistory get the previous password hashes for a given user ID, newest first
+	GetPasswordHistory(context.Context, string) ([]string, error)
+	// GetPasswordChangedAt get the last time the password was set for a given user ID
+	GetPasswordChangedAt(context.Context, string) (time.Time, error)
+	// SetMFASecret store a pending TOTP secret for a given user ID, MFA stays disabled until it is enabled
+	SetMFASecret(context.Context, string, string) error
+	// GetMFASecret get the TOTP secret for a given user ID and whether MFA is enabled
+	GetMFASecret(context.Context, string) (string, bool, error)
+	// EnableMFA enable MFA for a given user ID replacing the recovery code hashes
+	EnableMFA(context.Context, string, []string) error
+	// DisableMFA remove the TOTP secret and recovery codes for a given user ID
+	DisableMFA(context.Context, string) error
+	// UseMFAStep store the last accepted TOTP time step, false if the step was already used
+	UseMFAStep(context.Context, string, int64) (bool, error)
+	// UseMFARecoveryCode remove a recovery code hash for a given user ID, false if it doesn't exist
+	UseMFARecoveryCode(context.Context, string, string) (bool, error)
+	// StoreMFAChallenge store a login challenge for a user ID with an expiration period
+	StoreMFAChallenge(context.Context, string, string, time.Duration) error
+	// TakeMFAChallenge get the user ID of a login challenge and delete it, empty if not found
+	TakeMFAChallenge(context.Context, string) (string, error)
+	// StoreOneTimeToken store the hash of a single use token of a purpose for a user ID with an expiration period
+	StoreOneTimeToken(ctx context.Context, purpose string, tokenHash string, ID string, expiration time.Duration) error
+	// GetOneTimeToken get the user ID of a single use token hash without using it, empty if not found
+	GetOneTimeToken(ctx context.Context, purpose string, tokenHash string) (string, error)
+	// TakeOneTimeToken get the user ID of a single use token hash and delete it, empty if not found
+	TakeOneTimeToken(ctx context.Context, purpose string, tokenHash string) (string, error)
 }
 
 // UsersRepoMock users repo mock
@@ -107,3 +172,199 @@ func (r *UsersRepoMock) IsValidUser(ctx context.Context, id string) (bool, error
 	args := r.M.Called(id)
 	return args.Get(0).(bool), args.Error(1)
 }
+
+// SetPasswordHash store the password hash for a given user ID
+func (r *UsersRepoMock) SetPasswordHash(ctx context.Context, id string, hash string, history int) error {
+	args := r.M.Called(id, hash, history)
+	return args.Error(0)
+}
+
+// GetPasswordHash get the password hash for a given user ID
+func (r *UsersRepoMock) GetPasswordHash(ctx context.Context, id string) (string, error) {
+	args := r.M.Called(id)
+	return args.String(0), args.Error(1)
+}
+
+// GetPasswordHistory get the previous password hashes for a given user ID
+func (r *UsersRepoMock) GetPasswordHistory(ctx context.Context, id string) ([]string, error) {
+	args := r.M.Called(id)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+// GetPasswordChangedAt get the last time the password was set for a given user ID
+func (r *UsersRepoMock) GetPasswordChangedAt(ctx context.Context, id string) (time.Time, error) {
+	args := r.M.Called(id)
+	return args.Get(0).(time.Time), args.Error(1)
+}
+
+// SetMFASecret store a pending TOTP secret for a given user ID
+func (r *UsersRepoMock) SetMFASecret(ctx context.Context, id string, secret string) error {
+	args := r.M.Called(id, secret)
+	return args.Error(0)
+}
+
+// GetMFASecret get the TOTP secret for a given user ID and whether MFA is enabled
+func (r *UsersRepoMock) GetMFASecret(ctx context.Context, id string) (string, bool, error) {
+	args := r.M.Called(id)
+	return args.String(0), args.Bool(1), args.Error(2)
+}
+
+// EnableMFA enable MFA for a given user ID
+func (r *UsersRepoMock) EnableMFA(ctx context.Context, id string, recoveryHashes []string) error {
+	args := r.M.Called(id, recoveryHashes)
+	return args.Error(0)
+}
+
+// DisableMFA remove the TOTP secret and recovery codes for a given user ID
+func (r *UsersRepoMock) DisableMFA(ctx context.Context, id string) error {
+	args := r.M.Called(id)
+	return args.Error(0)
+}
+
+// UseMFAStep store the last accepted TOTP time step
+func (r *UsersRepoMock) UseMFAStep(ctx context.Context, id string, step int64) (bool, error) {
+	args := r.M.Called(id, step)
+	return args.Bool(0), args.Error(1)
+}
+
+// UseMFARecoveryCode remove a recovery code hash for a given user ID
+func (r *UsersRepoMock) UseMFARecoveryCode(ctx context.Context, id string, hash string) (bool, error) {
+	args := r.M.Called(id, hash)
+	return args.Bool(0), args.Error(1)
+}
+
+// StoreMFAChallenge store a login challenge for a user ID
+func (r *UsersRepoMock) StoreMFAChallenge(ctx context.Context, challenge string, id string, expiration time.Duration) error {
+	args := r.M.Called(challenge, id, expiration)
+	return args.Error(0)
+}
+
+// TakeMFAChallenge get the user ID of a login challenge and delete it
+func (r *UsersRepoMock) TakeMFAChallenge(ctx context.Context, challenge string) (string, error) {
+	args := r.M.Called(challenge)
+	return args.String(0), args.Error(1)
+}
+
+// StoreOneTimeToken store the hash of a single use token for a user ID
+func (r *UsersRepoMock) StoreOneTimeToken(ctx context.Context, purpose string, tokenHash string, id string, expiration time.Duration) error {
+	args := r.M.Called(purpose, tokenHash, id, expiration)
+	return args.Error(0)
+}
+
+// GetOneTimeToken get the user ID of a single use token hash without using it
+func (r *UsersRepoMock) GetOneTimeToken(ctx context.Context, purpose string, tokenHash string) (string, error) {
+	args := r.M.Called(purpose, tokenHash)
+	return args.String(0), args.Error(1)
+}
+
+// TakeOneTimeToken get the user ID of a single use token hash and delete it
+func (r *UsersRepoMock) TakeOneTimeToken(ctx context.Context, purpose string, tokenHash string) (string, error) {
+	args := r.M.Called(purpose, tokenHash)
+	return args.String(0), args.Error(1)
+}
+
+// ConsumeToken delete a token key and return its user ID
+func (r *UsersRepoMock) ConsumeToken(ctx context.Context, key string) (string, error) {
+	args := r.M.Called(key)
+	return args.String(0), args.Error(1)
+}
+
+// GetTokenFamily get the family of a refresh token UUID
+func (r *UsersRepoMock) GetTokenFamily(ctx context.Context, refreshUUID string) (string, error) {
+	args := r.M.Called(refreshUUID)
+	return args.String(0), args.Error(1)
+}
+
+// RevokeTokenFamily delete all the tokens issued in a family and its session
+func (r *UsersRepoMock) RevokeTokenFamily(ctx context.Context, family string) error {
+	args := r.M.Called(family)
+	return args.Error(0)
+}
+
+// GetRevokedTokens get the UUIDs of the revoked access tokens
+func (r *UsersRepoMock) GetRevokedTokens(ctx context.Context) (map[string]time.Time, error) {
+	args := r.M.Called()
+	return args.Get(0).(map[string]time.Time), args.Error(1)
+}
+
+// StoreSession store a session in the index of its user
+func (r *UsersRepoMock) StoreSession(ctx context.Context, session *entities.Session) error {
+	args := r.M.Called(session)
+	return args.Error(0)
+}
+
+// ExtendSession set the new expiration of a session
+func (r *UsersRepoMock) ExtendSession(ctx context.Context, id string, expiresAt time.Time) error {
+	args := r.M.Called(id, expiresAt)
+	return args.Error(0)
+}
+
+// GetSessions get the live sessions of a user ID
+func (r *UsersRepoMock) GetSessions(ctx context.Context, userID string) ([]entities.Session, error) {
+	args := r.M.Called(userID)
+	return args.Get(0).([]entities.Session), args.Error(1)
+}
+
+// Offboard remove a user with everything attached to it
+func (r *UsersRepoMock) Offboard(ctx context.Context, userID string) error {
+	args := r.M.Called(userID)
+	return args.Error(0)
+}
+
+// SetStatus store the account status of a user
+func (r *UsersRepoMock) SetStatus(ctx context.Context, id string, status *entities.AccountStatus) error {
+	args := r.M.Called(id, status)
+	return args.Error(0)
+}
+
+// AddImpersonation append an impersonation to the audit log
+func (r *UsersRepoMock) AddImpersonation(ctx context.Context, impersonation *entities.Impersonation) error {
+	args := r.M.Called(impersonation)
+	return args.Error(0)
+}
+
+// GetImpersonations get the impersonations audit log
+func (r *UsersRepoMock) GetImpersonations(ctx context.Context) ([]entities.Impersonation, error) {
+	args := r.M.Called()
+	return args.Get(0).([]entities.Impersonation), args.Error(1)
+}
+
+// SetEmailVerified store the time the email of a user was verified
+func (r *UsersRepoMock) SetEmailVerified(ctx context.Context, id string, at time.Time) error {
+	args := r.M.Called(id, at)
+	return args.Error(0)
+}
+
+// GetTokenVersion get the token version of a user
+func (r *UsersRepoMock) GetTokenVersion(ctx context.Context, id string) (int64, error) {
+	args := r.M.Called(id)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// IncrementTokenVersion increment the token version of a user
+func (r *UsersRepoMock) IncrementTokenVersion(ctx context.Context, id string) (int64, error) {
+	args := r.M.Called(id)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// GetUserRoles get the role IDs of a user
+func (r *UsersRepoMock) GetUserRoles(ctx context.Context, id string) ([]string, error) {
+	args := r.M.Called(id)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+// GetUserActions get the actions a user can perform
+func (r *UsersRepoMock) GetUserActions(ctx context.Context, id string) ([]string, error) {
+	args := r.M.Called(id)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+// GetStatus get the account status of a user
+func (r *UsersRepoMock) GetStatus(ctx context.Context, id string) (*entities.AccountStatus, error) {
+	args := r.M.Called(id)
+	status := args.Get(0)
+	if status != nil {
+		return status.(*entities.AccountStatus), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
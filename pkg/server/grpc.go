@@ -0,0 +1,319 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/StevenRojas/goaccess/pkg/pb"
+	"github.com/StevenRojas/goaccess/pkg/service"
+	"github.com/StevenRojas/goaccess/pkg/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+type grpcAuthentication struct {
+	services Services
+	logger   configuration.LoggerWrapper
+}
+
+type grpcAuthorization struct {
+	services Services
+	logger   configuration.LoggerWrapper
+}
+
+// authorizationMethods prefix of the methods of the Authorization service, they need a bearer token
+const authorizationMethods = "/pb.Authorization/"
+
+// NewGRPCServer return a new gRPC server with the authentication and authorization services registered. It uses
+// TLS when GRPC_TLS_CERT_FILE is set, otherwise the port must be reachable only from the internal network
+func NewGRPCServer(config configuration.ServerConfig, services Services, logger configuration.LoggerWrapper) (*grpc.Server, error) {
+	options := []grpc.ServerOption{grpc.ChainUnaryInterceptor(recoverUnary(logger), authorizeUnary(services, logger))}
+	if config.GRPCCertFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(config.GRPCCertFile, config.GRPCKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, grpc.Creds(creds))
+	}
+	s := grpc.NewServer(options...)
+	pb.RegisterAuthenticationServer(s, &grpcAuthentication{
+		services: services,
+		logger:   logger,
+	})
+	pb.RegisterAuthorizationServer(s, &grpcAuthorization{
+		services: services,
+		logger:   logger,
+	})
+	return s, nil
+}
+
+// recoverUnary turn a panic of a handler into an Internal error, grpc-go does not recover them and the whole
+// server would stop
+func recoverUnary(logger configuration.LoggerWrapper) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Error("gRPC handler panicked", info.FullMethod, fmt.Sprint(r))
+				err = status.Error(codes.Internal, "Internal error")
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// authorizeUnary check the bearer token of the authorization metadata before the Authorization methods run, with
+// the same access as the HTTP routes of the same data: the user of the request, an admin or a service client
+// allowed to perform the route action. The Authentication methods are public like their HTTP routes
+func authorizeUnary(services Services, logger configuration.LoggerWrapper) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !strings.HasPrefix(info.FullMethod, authorizationMethods) {
+			return handler(ctx, req)
+		}
+		userID, action, ok := grpcRouteAction(req)
+		if !ok {
+			return nil, status.Error(codes.PermissionDenied, "Permission denied")
+		}
+		authorized, err := authorize(ctx, services, adminOrOwnerAccess, grpcBearerToken(ctx), userID, action)
+		if _, denied := err.(*permissionError); denied {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		if err == errMissingToken {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		if err != nil {
+			return nil, grpcError(logger, err)
+		}
+		return handler(authorized, req)
+	}
+}
+
+// grpcRouteAction user of an Authorization request and the action of the HTTP route of the same data, e.g.
+// get:users:[]:access, the service clients must be allowed to perform it
+func grpcRouteAction(req interface{}) (string, string, bool) {
+	switch r := req.(type) {
+	case *pb.CheckPermissionRequest:
+		return r.UserId, utils.RequestAction(http.MethodGet, "/users/[]/permissions"), true
+	case *pb.AccessListRequest:
+		return r.UserId, utils.RequestAction(http.MethodGet, "/users/[]/access"), true
+	case *pb.ActionListRequest:
+		return r.UserId, utils.RequestAction(http.MethodGet, "/users/[]/actions/"+r.Module), true
+	}
+	return "", "", false
+}
+
+// grpcBearerToken get the token from the authorization metadata, e.g. "Bearer <access token>"
+func grpcBearerToken(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return ""
+	}
+	return parseBearer(values[0])
+}
+
+// Login log in a user and return access and refresh tokens
+func (g *grpcAuthentication) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
+	if req.Email == "" || req.Password == "" {
+		return nil, status.Error(codes.InvalidArgument, "Email and password are required")
+	}
+	loggedUser, err := g.services.Authentication.Login(grpcClientContext(ctx), req.Email, req.Password)
+	if err != nil {
+		return nil, grpcError(g.logger, err)
+	}
+	if loggedUser.MFAChallenge != "" {
+		return &pb.LoginResponse{MfaChallenge: loggedUser.MFAChallenge}, nil
+	}
+	return &pb.LoginResponse{
+		User:  toPBUser(loggedUser.User),
+		Token: toPBToken(loggedUser.Token),
+	}, nil
+}
+
+// LoginMFA complete a login with the MFA challenge and a TOTP or recovery code
+func (g *grpcAuthentication) LoginMFA(ctx context.Context, req *pb.LoginMFARequest) (*pb.LoginResponse, error) {
+	if req.MfaChallenge == "" || req.Code == "" {
+		return nil, status.Error(codes.InvalidArgument, "MFA challenge and code are required")
+	}
+	loggedUser, err := g.services.Authentication.LoginMFA(grpcClientContext(ctx), req.MfaChallenge, req.Code)
+	if err != nil {
+		return nil, grpcError(g.logger, err)
+	}
+	return &pb.LoginResponse{
+		User:  toPBUser(loggedUser.User),
+		Token: toPBToken(loggedUser.Token),
+	}, nil
+}
+
+// VerifyToken check if a token is valid and the user is logged in
+func (g *grpcAuthentication) VerifyToken(ctx context.Context, req *pb.TokenRequest) (*pb.VerifyTokenResponse, error) {
+	if req.Token == "" {
+		return nil, status.Error(codes.InvalidArgument, "Token is required")
+	}
+	userID, err := g.services.Authentication.VerifyToken(ctx, req.Token)
+	if err != nil {
+		return nil, grpcError(g.logger, err)
+	}
+	return &pb.VerifyTokenResponse{UserId: userID}, nil
+}
+
+// RefreshToken return a new token pair for a refresh token
+func (g *grpcAuthentication) RefreshToken(ctx context.Context, req *pb.TokenRequest) (*pb.Token, error) {
+	if req.Token == "" {
+		return nil, status.Error(codes.InvalidArgument, "Token is required")
+	}
+	token, err := g.services.Authentication.RefreshToken(ctx, req.Token)
+	if err != nil {
+		return nil, grpcError(g.logger, err)
+	}
+	return toPBToken(token), nil
+}
+
+// Logout log out a user for a given token pair
+func (g *grpcAuthentication) Logout(ctx context.Context, req *pb.Token) (*pb.LogoutResponse, error) {
+	err := g.services.Authentication.Logout(ctx, &entities.Token{
+		Access:  req.AccessToken,
+		Refresh: req.RefreshToken,
+	})
+	if err != nil {
+		return nil, grpcError(g.logger, err)
+	}
+	return &pb.LogoutResponse{}, nil
+}
+
+// VerifyInternalToken check if an internal token of a service client is valid, user tokens are rejected
+func (g *grpcAuthentication) VerifyInternalToken(ctx context.Context, req *pb.TokenRequest) (*pb.VerifyInternalTokenResponse, error) {
+	if req.Token == "" {
+		return nil, status.Error(codes.InvalidArgument, "Token is required")
+	}
+	identity, err := g.services.InternalAuthentication.VerifyInternalToken(ctx, req.Token)
+	if err != nil {
+		return nil, grpcError(g.logger, err)
+	}
+	return &pb.VerifyInternalTokenResponse{ClientId: identity.ClientID, Scopes: identity.Scopes}, nil
+}
+
+// CheckPermission checks if a user has permission to perform an action
+func (g *grpcAuthorization) CheckPermission(ctx context.Context, req *pb.CheckPermissionRequest) (*pb.CheckPermissionResponse, error) {
+	if req.UserId == "" || req.Action == "" {
+		return nil, status.Error(codes.InvalidArgument, "User ID and action are required")
+	}
+	allowed, err := g.services.Authorization.CheckPermission(ctx, req.Action, req.UserId)
+	if err != nil {
+		return nil, grpcError(g.logger, err)
+	}
+	return &pb.CheckPermissionResponse{Allowed: allowed}, nil
+}
+
+// GetAccessList get the modules, submodules and sections where the user has access
+func (g *grpcAuthorization) GetAccessList(ctx context.Context, req *pb.AccessListRequest) (*structpb.Struct, error) {
+	if req.UserId == "" {
+		return nil, status.Error(codes.InvalidArgument, "User ID is required")
+	}
+	access, err := g.services.Authorization.GetAccessList(ctx, req.UserId)
+	if err != nil {
+		return nil, grpcError(g.logger, err)
+	}
+	s, err := structpb.NewStruct(access)
+	if err != nil {
+		return nil, grpcError(g.logger, err)
+	}
+	return s, nil
+}
+
+// GetActionListByModule get the actions can be performed by a user in a module
+func (g *grpcAuthorization) GetActionListByModule(ctx context.Context, req *pb.ActionListRequest) (*structpb.Struct, error) {
+	if req.UserId == "" || req.Module == "" {
+		return nil, status.Error(codes.InvalidArgument, "User ID and module are required")
+	}
+	actions, err := g.services.Authorization.GetActionListByModule(ctx, req.Module, req.UserId)
+	if err != nil {
+		return nil, grpcError(g.logger, err)
+	}
+	s, err := structpb.NewStruct(actions)
+	if err != nil {
+		return nil, grpcError(g.logger, err)
+	}
+	return s, nil
+}
+
+// grpcError return a gRPC status error with the code that matches the error, internal errors are logged and
+// returned with a generic message
+func grpcError(logger configuration.LoggerWrapper, err error) error {
+	if _, ok := err.(*service.ValidationError); ok {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if _, ok := err.(*service.FederationError); ok {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	switch err {
+	case service.ErrUserNotFound, service.ErrRoleNotFound, service.ErrAccessNotDefined, service.ErrActionsNotDefined,
+		service.ErrRouteNotFound, service.ErrClientNotFound, service.ErrAPIKeyNotFound, service.ErrSessionNotFound:
+		return status.Error(codes.NotFound, err.Error())
+	case service.ErrInvalidToken, service.ErrExpiredToken, service.ErrInvalidCredentials, service.ErrPasswordExpired,
+		service.ErrInvalidMFACode, service.ErrInvalidMFAChallenge, service.ErrTokenReused,
+		service.ErrInvalidClientCredentials, service.ErrInvalidResetToken, service.ErrInvalidVerificationToken,
+		service.ErrInvalidLoginLink, service.ErrInvalidLoginCode, service.ErrInvalidFederationState:
+		return status.Error(codes.Unauthenticated, err.Error())
+	case service.ErrMFARequired, service.ErrInvalidScope, service.ErrAccountDisabled, service.ErrAccountLocked,
+		service.ErrAccountPending, service.ErrNotAdmin:
+		return status.Error(codes.PermissionDenied, err.Error())
+	case service.ErrTooManyAttempts:
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case service.ErrMFAEnabled, service.ErrMFANotEnabled, service.ErrKeyRotationInProgress, service.ErrEmailVerified:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case service.ErrEmptyPassword, service.ErrInvalidRedirectURI, service.ErrInvalidGrant:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case service.ErrInternalTokensDisabled, service.ErrOIDCDisabled, service.ErrFederationDisabled,
+		service.ErrKeyRotationDisabled:
+		return status.Error(codes.Unimplemented, err.Error())
+	}
+	logger.Error("request failed", err.Error())
+	return status.Error(codes.Internal, "Internal error")
+}
+
+// grpcClientContext add the user agent and peer IP of the call to its context, they are recorded in the session
+func grpcClientContext(ctx context.Context) context.Context {
+	var userAgent, ip string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("user-agent"); len(values) > 0 {
+			userAgent = values[0]
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		ip = p.Addr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+	}
+	return service.WithClientInfo(ctx, userAgent, ip)
+}
+
+func toPBUser(user *entities.User) *pb.User {
+	return &pb.User{
+		Id:      user.ID,
+		Email:   user.Email,
+		Name:    user.Name,
+		IsAdmin: user.IsAdmin,
+		Roles:   user.Roles,
+	}
+}
+
+func toPBToken(token *entities.Token) *pb.Token {
+	return &pb.Token{
+		AccessToken:  token.Access,
+		RefreshToken: token.Refresh,
+	}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/StevenRojas/goaccess/pkg/pb"
+	"github.com/StevenRojas/goaccess/pkg/repository"
+	"github.com/StevenRojas/goaccess/pkg/service"
+	"github.com/StevenRojas/goaccess/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func newGRPCAuthentication(repo *repository.UsersRepoMock) *grpcAuthentication {
+	return &grpcAuthentication{
+		services: Services{
+			Authentication: newAuthenticationService(repo),
+		},
+		logger: configuration.NewLogger(configuration.ServerConfig{LogLevel: 3}),
+	}
+}
+
+func TestGRPCLoginNotRegistered(t *testing.T) {
+	repo := new(repository.UsersRepoMock)
+	email := "notRegistered@gmail.com"
+	repo.M.On("GetUserByEmail", email).Return(nil, nil)
+	_, err := newGRPCAuthentication(repo).Login(context.TODO(), &pb.LoginRequest{Email: email, Password: "secret"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestGRPCLoginErrors(t *testing.T) {
+	repo := new(repository.UsersRepoMock)
+	email := "disabled@gmail.com"
+	repo.M.On("GetUserByEmail", email).Return(&entities.User{ID: "3", Email: email, Status: entities.UserStatusDisabled}, nil)
+	repo.M.On("GetPasswordHash", "3").Return("hash:secret", nil)
+	_, err := newGRPCAuthentication(repo).Login(context.TODO(), &pb.LoginRequest{Email: email, Password: "secret"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	repo.M.On("GetUserByEmail", "failing@gmail.com").Return(nil, errors.New("connection refused"))
+	_, err = newGRPCAuthentication(repo).Login(context.TODO(), &pb.LoginRequest{Email: "failing@gmail.com", Password: "secret"})
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestGRPCLoginSuccess(t *testing.T) {
+	repo := new(repository.UsersRepoMock)
+	email := "srojas@gmail.com"
+	repo.M.On("GetUserByEmail", email).Return(&entities.User{
+		ID:    "1",
+		Email: email,
+		Name:  "steven rojas",
+	}, nil)
+	repo.M.On("GetPasswordHash", "1").Return("hash:secret", nil)
+	repo.M.On("GetMFASecret", "1").Return("", false, nil)
+	repo.M.On("StoreTokens", &utils.StoredToken{
+		ID:             "1",
+		AccessToken:    "a_jwt",
+		AccessUUID:     "a_uuid",
+		AccessExpires:  10,
+		RefreshToken:   "r_jwt",
+		RefreshUUID:    "r_uuid",
+		RefreshExpires: 20,
+		Family:         "r_uuid",
+	}).Return(nil)
+	repo.M.On("StoreSession", mock.MatchedBy(func(session *entities.Session) bool {
+		return session.ID == "r_uuid" && session.UserAgent == "grpc-go/1.30.0"
+	})).Return(nil)
+	ctx := metadata.NewIncomingContext(context.TODO(), metadata.Pairs("user-agent", "grpc-go/1.30.0"))
+	res, err := newGRPCAuthentication(repo).Login(ctx, &pb.LoginRequest{Email: email, Password: "secret"})
+	assert.Nil(t, err)
+	assert.Equal(t, "1", res.User.Id)
+	assert.Equal(t, "a_jwt", res.Token.AccessToken)
+}
+
+func TestGRPCLogoutSwappedTokens(t *testing.T) {
+	repo := new(repository.UsersRepoMock)
+	_, err := newGRPCAuthentication(repo).Logout(context.TODO(), &pb.Token{AccessToken: "r_jwt", RefreshToken: "a_jwt"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	repo.M.AssertNotCalled(t, "DeleteToken", mock.Anything)
+}
+
+func TestGRPCRecoverPanic(t *testing.T) {
+	interceptor := recoverUnary(configuration.NewLogger(configuration.ServerConfig{LogLevel: 3}))
+	_, err := interceptor(context.TODO(), nil, &grpc.UnaryServerInfo{FullMethod: "/pb.Authentication/Logout"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			panic("interface conversion")
+		})
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestGRPCAuthorizationNeedsToken(t *testing.T) {
+	repo := new(repository.UsersRepoMock)
+	user := &entities.User{ID: "1", Email: "srojas@gmail.com"}
+	repo.M.On("GetUserByToken", "a_uuid").Return(user, nil)
+	repo.M.On("GetUserByID", "1").Return(user, nil)
+	interceptor := authorizeUnary(Services{Authentication: newAuthenticationService(repo)},
+		configuration.NewLogger(configuration.ServerConfig{LogLevel: 3}))
+	info := &grpc.UnaryServerInfo{FullMethod: "/pb.Authorization/GetAccessList"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		userID, _ := UserIDFromContext(ctx)
+		return userID, nil
+	}
+	bearer := metadata.NewIncomingContext(context.TODO(), metadata.Pairs("authorization", "Bearer a_jwt"))
+
+	_, err := interceptor(context.TODO(), &pb.AccessListRequest{UserId: "1"}, info, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	// The token of user 1 gets its own lists only
+	res, err := interceptor(bearer, &pb.AccessListRequest{UserId: "1"}, info, handler)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", res)
+	_, err = interceptor(bearer, &pb.AccessListRequest{UserId: "2"}, info, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = interceptor(bearer, &pb.CheckPermissionRequest{UserId: "2", Action: "post:brand"},
+		&grpc.UnaryServerInfo{FullMethod: "/pb.Authorization/CheckPermission"}, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// The Authentication methods are public
+	_, err = interceptor(context.TODO(), &pb.TokenRequest{Token: "a_jwt"},
+		&grpc.UnaryServerInfo{FullMethod: "/pb.Authentication/VerifyToken"}, handler)
+	assert.Nil(t, err)
+}
+
+func TestGRPCAuthorizationAdmin(t *testing.T) {
+	repo := new(repository.UsersRepoMock)
+	admin := &entities.User{ID: "1", Email: "admin@gmail.com", IsAdmin: true}
+	repo.M.On("GetUserByToken", "a_uuid").Return(admin, nil)
+	repo.M.On("GetUserByID", "1").Return(admin, nil)
+	interceptor := authorizeUnary(Services{Authentication: newAuthenticationService(repo)},
+		configuration.NewLogger(configuration.ServerConfig{LogLevel: 3}))
+	bearer := metadata.NewIncomingContext(context.TODO(), metadata.Pairs("authorization", "Bearer a_jwt"))
+	called := false
+	_, err := interceptor(bearer, &pb.ActionListRequest{UserId: "2", Module: "vehicles"},
+		&grpc.UnaryServerInfo{FullMethod: "/pb.Authorization/GetActionListByModule"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			called = true
+			return nil, nil
+		})
+	assert.Nil(t, err)
+	assert.True(t, called)
+}
+
+func TestGRPCRouteAction(t *testing.T) {
+	_, action, ok := grpcRouteAction(&pb.ActionListRequest{UserId: "2", Module: "vehicles"})
+	assert.True(t, ok)
+	assert.Equal(t, "get:users:[]:actions:vehicles", action)
+	_, action, _ = grpcRouteAction(&pb.CheckPermissionRequest{UserId: "2"})
+	assert.Equal(t, "get:users:[]:permissions", action)
+	_, _, ok = grpcRouteAction(&pb.TokenRequest{})
+	assert.False(t, ok)
+}
+
+func TestGRPCInternalError(t *testing.T) {
+	err := grpcError(configuration.NewLogger(configuration.ServerConfig{LogLevel: 3}), errors.New("dial tcp 10.0.0.3:6379: connection refused"))
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Equal(t, "Internal error", status.Convert(err).Message())
+	err = grpcError(configuration.NewLogger(configuration.ServerConfig{LogLevel: 3}), service.ErrUserNotFound)
+	assert.Equal(t, service.ErrUserNotFound.Error(), status.Convert(err).Message())
+}
@@ -0,0 +1,322 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/StevenRojas/goaccess/pkg/service"
+	"github.com/gorilla/mux"
+)
+
+type httpHandler struct {
+	services       Services
+	trustedProxies []*net.IPNet
+	logger         configuration.LoggerWrapper
+}
+
+// httpError error with the HTTP status to be returned
+type httpError struct {
+	status  int
+	err     error
+	details url.Values
+}
+
+type errorBody struct {
+	Code    int        `json:"code"`
+	Message string     `json:"message"`
+	Details url.Values `json:"details,omitempty"`
+}
+
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+// NewHTTPServer return a new HTTP server instance listening on the configured address
+func NewHTTPServer(config configuration.ServerConfig, services Services, logger configuration.LoggerWrapper) (*http.Server, error) {
+	trustedProxies, err := parseNetworks(config.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Server{
+		Addr:         config.HTTP,
+		Handler:      NewHTTPHandler(services, trustedProxies, logger),
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+	}, nil
+}
+
+// NewHTTPHandler return the handler with the routes for all services, the client IP is taken from
+// X-Forwarded-For only when the request comes through one of the trusted proxies
+func NewHTTPHandler(services Services, trustedProxies []*net.IPNet, logger configuration.LoggerWrapper) http.Handler {
+	h := &httpHandler{
+		services:       services,
+		trustedProxies: trustedProxies,
+		logger:         logger,
+	}
+	r := mux.NewRouter()
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.encodeError(w, newHTTPError(http.StatusNotFound, errors.New("Route not found")))
+	})
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.encodeError(w, newHTTPError(http.StatusMethodNotAllowed, errors.New("Method not allowed")))
+	})
+
+	// The routes to log in, verify tokens and discover the keys are public, the others need the bearer token of
+	// an admin, of the user of the route or of an allowed service client
+	admin := func(next http.HandlerFunc) http.HandlerFunc { return h.guard(adminAccess, next) }
+	adminOrOwner := func(next http.HandlerFunc) http.HandlerFunc { return h.guard(adminOrOwnerAccess, next) }
+	owner := func(next http.HandlerFunc) http.HandlerFunc { return h.guard(ownerAccess, next) }
+
+	// Authentication service
+	r.HandleFunc("/auth/register", admin(h.register)).Methods(http.MethodPost)
+	r.HandleFunc("/auth/unregister", admin(h.unregister)).Methods(http.MethodPost)
+	r.HandleFunc("/auth/login", h.login).Methods(http.MethodPost)
+	r.HandleFunc("/auth/login/mfa", h.loginMFA).Methods(http.MethodPost)
+	r.HandleFunc("/auth/login/link", h.requestLoginLink).Methods(http.MethodPost)
+	r.HandleFunc("/auth/login/link/verify", h.loginWithLink).Methods(http.MethodPost)
+	r.HandleFunc("/auth/login/code", h.requestLoginCode).Methods(http.MethodPost)
+	r.HandleFunc("/auth/login/code/verify", h.loginWithCode).Methods(http.MethodPost)
+	r.HandleFunc("/auth/verify", h.verifyToken).Methods(http.MethodPost)
+	r.HandleFunc("/auth/refresh", h.refreshToken).Methods(http.MethodPost)
+	r.HandleFunc("/auth/logout", h.logout).Methods(http.MethodPost)
+	r.HandleFunc("/auth/password/forgot", h.requestPasswordReset).Methods(http.MethodPost)
+	r.HandleFunc("/auth/password/reset", h.resetPassword).Methods(http.MethodPost)
+	r.HandleFunc("/auth/email/verify", h.verifyEmail).Methods(http.MethodPost)
+	r.HandleFunc("/.well-known/jwks.json", h.jwks).Methods(http.MethodGet)
+	r.HandleFunc("/auth/keys/rotate", admin(h.rotateSigningKey)).Methods(http.MethodPost)
+	r.HandleFunc("/users/{userID}/status", adminOrOwner(h.getAccountStatus)).Methods(http.MethodGet)
+	r.HandleFunc("/users/{userID}/status", admin(h.setAccountStatus)).Methods(http.MethodPut)
+	r.HandleFunc("/users/{userID}/impersonate", admin(h.impersonate)).Methods(http.MethodPost)
+	r.HandleFunc("/impersonations", admin(h.listImpersonations)).Methods(http.MethodGet)
+	r.HandleFunc("/users/{userID}/unlock", admin(h.unlockAccount)).Methods(http.MethodPost)
+	r.HandleFunc("/ips/{ip}/unlock", admin(h.unlockIP)).Methods(http.MethodPost)
+	r.HandleFunc("/users/{userID}/sessions", adminOrOwner(h.listSessions)).Methods(http.MethodGet)
+	r.HandleFunc("/users/{userID}/sessions", adminOrOwner(h.revokeAllSessions)).Methods(http.MethodDelete)
+	r.HandleFunc("/users/{userID}/sessions/{sessionID}", adminOrOwner(h.revokeSession)).Methods(http.MethodDelete)
+	r.HandleFunc("/users/{userID}/password", admin(h.setPassword)).Methods(http.MethodPut)
+	r.HandleFunc("/users/{userID}/password/change", adminOrOwner(h.changePassword)).Methods(http.MethodPost)
+	r.HandleFunc("/users/{userID}/email/verification", adminOrOwner(h.sendVerification)).Methods(http.MethodPost)
+	r.HandleFunc("/users/{userID}/mfa/totp", owner(h.enrollTOTP)).Methods(http.MethodPost)
+	r.HandleFunc("/users/{userID}/mfa/totp/confirm", owner(h.confirmTOTP)).Methods(http.MethodPost)
+	r.HandleFunc("/users/{userID}/mfa/totp/disable", owner(h.disableTOTP)).Methods(http.MethodPost)
+	r.HandleFunc("/users/{userID}/mfa/recovery-codes", owner(h.regenerateRecoveryCodes)).Methods(http.MethodPost)
+
+	// Internal authentication service
+	r.HandleFunc("/auth/internal/token", h.internalToken).Methods(http.MethodPost)
+	r.HandleFunc("/auth/internal/refresh", h.refreshInternalToken).Methods(http.MethodPost)
+	r.HandleFunc("/auth/internal/verify", h.verifyInternalToken).Methods(http.MethodPost)
+	r.HandleFunc("/clients", admin(h.listClients)).Methods(http.MethodGet)
+	r.HandleFunc("/clients", admin(h.registerClient)).Methods(http.MethodPost)
+	r.HandleFunc("/clients/{clientID}", admin(h.deleteClient)).Methods(http.MethodDelete)
+	r.HandleFunc("/clients/{clientID}/roles/{roleID}", admin(h.assignClientRole)).Methods(http.MethodPut)
+	r.HandleFunc("/clients/{clientID}/roles/{roleID}", admin(h.unassignClientRole)).Methods(http.MethodDelete)
+	r.HandleFunc("/oauth/token", h.oauthToken).Methods(http.MethodPost)
+	r.HandleFunc("/introspect", h.introspect).Methods(http.MethodPost)
+
+	// OpenID Connect provider
+	r.HandleFunc("/.well-known/openid-configuration", h.openIDConfiguration).Methods(http.MethodGet)
+	r.HandleFunc("/authorize", h.authorize).Methods(http.MethodGet)
+	r.HandleFunc("/authorize", h.authorizeLogin).Methods(http.MethodPost)
+	r.HandleFunc("/userinfo", h.userInfo).Methods(http.MethodGet, http.MethodPost)
+	r.HandleFunc("/oidc/clients", admin(h.listOIDCClients)).Methods(http.MethodGet)
+	r.HandleFunc("/oidc/clients", admin(h.registerOIDCClient)).Methods(http.MethodPost)
+	r.HandleFunc("/oidc/clients/{clientID}", admin(h.deleteOIDCClient)).Methods(http.MethodDelete)
+
+	// Federated login
+	r.HandleFunc("/federation/login", h.federatedLogin).Methods(http.MethodGet)
+	r.HandleFunc("/federation/callback", h.federationCallback).Methods(http.MethodGet, http.MethodPost)
+
+	// API keys service
+	r.HandleFunc("/apikeys", admin(h.listAPIKeys)).Methods(http.MethodGet)
+	r.HandleFunc("/apikeys", admin(h.createAPIKey)).Methods(http.MethodPost)
+	r.HandleFunc("/apikeys/{keyID}", admin(h.revokeAPIKey)).Methods(http.MethodDelete)
+	r.HandleFunc("/apikeys/{keyID}/expire", admin(h.expireAPIKey)).Methods(http.MethodPost)
+
+	// Access service
+	r.HandleFunc("/roles", admin(h.listRoles)).Methods(http.MethodGet)
+	r.HandleFunc("/roles", admin(h.addRole)).Methods(http.MethodPost)
+	r.HandleFunc("/roles/{roleID}", admin(h.isRoleExist)).Methods(http.MethodHead)
+	r.HandleFunc("/roles/{roleID}", admin(h.editRole)).Methods(http.MethodPut)
+	r.HandleFunc("/roles/{roleID}", admin(h.deleteRole)).Methods(http.MethodDelete)
+	r.HandleFunc("/roles/{roleID}/clone", admin(h.cloneRole)).Methods(http.MethodPost)
+	r.HandleFunc("/roles/{roleID}/access", admin(h.roleAccessList)).Methods(http.MethodGet)
+	r.HandleFunc("/roles/{roleID}/modules", admin(h.modulesListByRole)).Methods(http.MethodGet)
+	r.HandleFunc("/roles/{roleID}/modules", admin(h.assignModules)).Methods(http.MethodPost)
+	r.HandleFunc("/roles/{roleID}/modules", admin(h.unassignModules)).Methods(http.MethodDelete)
+	r.HandleFunc("/roles/{roleID}/submodules", admin(h.subModulesListByRole)).Methods(http.MethodGet)
+	r.HandleFunc("/roles/{roleID}/modules/{module}/submodules", admin(h.assignSubModules)).Methods(http.MethodPost)
+	r.HandleFunc("/roles/{roleID}/modules/{module}/submodules", admin(h.unassignSubModules)).Methods(http.MethodDelete)
+	r.HandleFunc("/roles/{roleID}/sections", admin(h.sectionsListByRole)).Methods(http.MethodGet)
+	r.HandleFunc("/roles/{roleID}/modules/{module}/submodules/{submodule}/sections", admin(h.assignSections)).Methods(http.MethodPost)
+	r.HandleFunc("/roles/{roleID}/modules/{module}/submodules/{submodule}/sections", admin(h.unassignSections)).Methods(http.MethodDelete)
+	r.HandleFunc("/modules", admin(h.modulesList)).Methods(http.MethodGet)
+	r.HandleFunc("/modules/{module}", admin(h.moduleStructure)).Methods(http.MethodGet)
+	r.HandleFunc("/users/{userID}/roles", adminOrOwner(h.listRolesByUser)).Methods(http.MethodGet)
+
+	// Authorization service
+	r.HandleFunc("/users", admin(h.listUsers)).Methods(http.MethodGet)
+	r.HandleFunc("/roles/{roleID}/users", admin(h.listUsersByRole)).Methods(http.MethodGet)
+	r.HandleFunc("/roles/{roleID}/modules/{module}/submodules/{submodule}/actions", admin(h.assignActions)).Methods(http.MethodPost)
+	r.HandleFunc("/roles/{roleID}/modules/{module}/submodules/{submodule}/actions", admin(h.unassignActions)).Methods(http.MethodDelete)
+	r.HandleFunc("/users/{userID}/roles/{roleID}", admin(h.assignRole)).Methods(http.MethodPut)
+	r.HandleFunc("/users/{userID}/roles/{roleID}", admin(h.unassignRole)).Methods(http.MethodDelete)
+	r.HandleFunc("/users/{userID}/access", adminOrOwner(h.accessList)).Methods(http.MethodGet)
+	r.HandleFunc("/users/{userID}/actions/{module}", adminOrOwner(h.actionListByModule)).Methods(http.MethodGet)
+	r.HandleFunc("/users/{userID}/permissions", adminOrOwner(h.checkPermission)).Methods(http.MethodGet)
+	r.HandleFunc("/routes/match", admin(h.matchRoute)).Methods(http.MethodGet)
+
+	// Initialization service
+	r.HandleFunc("/init", admin(h.init)).Methods(http.MethodPost)
+
+	return r
+}
+
+// init initialize modules, sections and actions
+func (h *httpHandler) init(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Force bool `json:"force"`
+	}
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	if err := h.services.Initialization.Init(r.Context(), req.Force); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	if err := h.services.Authorization.ReloadRoutes(r.Context()); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newHTTPError(status int, err error) *httpError {
+	return &httpError{
+		status: status,
+		err:    err,
+	}
+}
+
+func newValidationError(message string, details url.Values) *httpError {
+	return &httpError{
+		status:  http.StatusBadRequest,
+		err:     errors.New(message),
+		details: details,
+	}
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+// decode decode the JSON request body, an empty body is allowed
+func (h *httpHandler) decode(r *http.Request, v interface{}) error {
+	if r.Body == nil || r.ContentLength == 0 {
+		return nil
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return newHTTPError(http.StatusBadRequest, errors.New("Invalid JSON body"))
+	}
+	return nil
+}
+
+// encode write the response as JSON with the given status
+func (h *httpHandler) encode(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.logger.Error("unable to encode response", err.Error())
+	}
+}
+
+// encodeError write the error as JSON using the status that matches the error
+func (h *httpHandler) encodeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var details url.Values
+	switch e := err.(type) {
+	case *httpError:
+		status = e.status
+		details = e.details
+	case *service.ValidationError:
+		status = http.StatusBadRequest
+		details = e.Errors
+	case *service.AuthorizationError:
+		status = http.StatusBadRequest
+	case *service.FederationError:
+		status = http.StatusUnauthorized
+	default:
+		switch err {
+		case service.ErrUserNotFound, service.ErrRoleNotFound, service.ErrAccessNotDefined, service.ErrActionsNotDefined,
+			service.ErrRouteNotFound, service.ErrClientNotFound, service.ErrAPIKeyNotFound, service.ErrSessionNotFound:
+			status = http.StatusNotFound
+		case service.ErrInvalidToken, service.ErrExpiredToken, service.ErrInvalidCredentials, service.ErrPasswordExpired,
+			service.ErrInvalidMFACode, service.ErrInvalidMFAChallenge, service.ErrTokenReused,
+			service.ErrInvalidClientCredentials, service.ErrInvalidResetToken, service.ErrInvalidVerificationToken,
+			service.ErrInvalidLoginLink, service.ErrInvalidLoginCode, service.ErrInvalidFederationState:
+			status = http.StatusUnauthorized
+		case service.ErrMFARequired, service.ErrInvalidScope, service.ErrAccountDisabled, service.ErrAccountLocked,
+			service.ErrAccountPending, service.ErrNotAdmin:
+			status = http.StatusForbidden
+		case service.ErrTooManyAttempts:
+			status = http.StatusTooManyRequests
+		case service.ErrMFAEnabled, service.ErrMFANotEnabled, service.ErrKeyRotationInProgress, service.ErrEmailVerified:
+			status = http.StatusConflict
+		case service.ErrEmptyPassword, service.ErrInvalidRedirectURI, service.ErrInvalidGrant:
+			status = http.StatusBadRequest
+		case service.ErrInternalTokensDisabled, service.ErrOIDCDisabled, service.ErrFederationDisabled,
+			service.ErrKeyRotationDisabled:
+			status = http.StatusNotImplemented
+		}
+	}
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		// The cause is logged, it may carry details of the storage or the configuration
+		h.logger.Error("request failed", message)
+		message = "Internal server error"
+	}
+	h.encode(w, status, errorResponse{
+		Error: errorBody{
+			Code:    status,
+			Message: message,
+			Details: details,
+		},
+	})
+}
+
+// parseNetworks parse a list of IPs or CIDRs, an IP is a network of a single address
+func parseNetworks(values []string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", value)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
@@ -0,0 +1,284 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/gorilla/mux"
+)
+
+type modulesBody struct {
+	Modules []string `json:"modules"`
+}
+
+type subModulesBody struct {
+	SubModules []string `json:"submodules"`
+}
+
+type sectionsBody struct {
+	Sections []string `json:"sections"`
+}
+
+// listRoles get a list of all roles
+func (h *httpHandler) listRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.services.Access.ListRoles(r.Context())
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, roles)
+}
+
+// listRolesByUser get a list of all roles for a given user
+func (h *httpHandler) listRolesByUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roles, err := h.services.Access.ListRolesByUser(r.Context(), vars["userID"])
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, roles)
+}
+
+// addRole add a role and return its ID
+func (h *httpHandler) addRole(w http.ResponseWriter, r *http.Request) {
+	req, err := h.decodeRole(r)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	ID, err := h.services.Access.AddRole(r.Context(), req.Name)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusCreated, entities.Role{ID: ID, Name: req.Name})
+}
+
+// cloneRole clone a role and return the new role ID
+func (h *httpHandler) cloneRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	req, err := h.decodeRole(r)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	ID, err := h.services.Access.CloneRole(r.Context(), vars["roleID"], req.Name)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusCreated, entities.Role{ID: ID, Name: req.Name})
+}
+
+// isRoleExist respond with 200 if the role exists or 404 otherwise
+func (h *httpHandler) isRoleExist(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	ok, err := h.services.Access.IsRoleExist(r.Context(), vars["roleID"])
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// editRole edit the role name
+func (h *httpHandler) editRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	req, err := h.decodeRole(r)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	if err = h.services.Access.EditRole(r.Context(), vars["roleID"], req.Name); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, entities.Role{ID: vars["roleID"], Name: req.Name})
+}
+
+// deleteRole removes a role and its relation with users
+func (h *httpHandler) deleteRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := h.services.Access.DeleteRole(r.Context(), vars["roleID"]); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// assignModules assign modules to a role
+func (h *httpHandler) assignModules(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var req modulesBody
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	if err := h.services.Access.AssignModules(r.Context(), vars["roleID"], req.Modules); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unassignModules unassign modules from a role
+func (h *httpHandler) unassignModules(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var req modulesBody
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	if err := h.services.Access.UnassignModules(r.Context(), vars["roleID"], req.Modules); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// assignSubModules assign submodules to a role
+func (h *httpHandler) assignSubModules(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var req subModulesBody
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	err := h.services.Access.AssignSubModules(r.Context(), vars["roleID"], vars["module"], req.SubModules)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unassignSubModules unassign submodules from a role
+func (h *httpHandler) unassignSubModules(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var req subModulesBody
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	err := h.services.Access.UnassignSubModules(r.Context(), vars["roleID"], vars["module"], req.SubModules)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// assignSections assign sections to a role
+func (h *httpHandler) assignSections(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var req sectionsBody
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	err := h.services.Access.AssignSections(r.Context(), vars["roleID"], vars["module"], vars["submodule"], req.Sections)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unassignSections unassign sections from a role
+func (h *httpHandler) unassignSections(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var req sectionsBody
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	err := h.services.Access.UnassignSections(r.Context(), vars["roleID"], vars["module"], vars["submodule"], req.Sections)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// modulesList returns a list of available modules
+func (h *httpHandler) modulesList(w http.ResponseWriter, r *http.Request) {
+	modules, err := h.services.Access.ModulesList(r.Context())
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, modulesBody{Modules: modules})
+}
+
+// modulesListByRole returns a list of available modules for a given role
+func (h *httpHandler) modulesListByRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	modules, err := h.services.Access.ModulesListByRole(r.Context(), vars["roleID"])
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, modulesBody{Modules: modules})
+}
+
+// subModulesListByRole returns a list of available submodules for a given role
+func (h *httpHandler) subModulesListByRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	submodules, err := h.services.Access.SubModulesListByRole(r.Context(), vars["roleID"])
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, submodules)
+}
+
+// sectionsListByRole returns a list of available sections for a given role
+func (h *httpHandler) sectionsListByRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sections, err := h.services.Access.SectionsListByRole(r.Context(), vars["roleID"])
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, sections)
+}
+
+// moduleStructure returns the module structure to create a new role
+func (h *httpHandler) moduleStructure(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	module, err := h.services.Access.ModuleStructure(r.Context(), vars["module"])
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	if module == nil {
+		h.encodeError(w, newHTTPError(http.StatusNotFound, errors.New("Module not found")))
+		return
+	}
+	h.encode(w, http.StatusOK, module)
+}
+
+// roleAccessList get a json of modules, submodules and sections for the given role
+func (h *httpHandler) roleAccessList(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	access, err := h.services.Access.GetRoleAccessList(r.Context(), vars["roleID"])
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, access)
+}
+
+func (h *httpHandler) decodeRole(r *http.Request) (*entities.Role, error) {
+	var role entities.Role
+	if errs := entities.InitRoleValidator(r, &role).ValidateJSON(); len(errs) > 0 {
+		return nil, newValidationError("Invalid role", errs)
+	}
+	return &role, nil
+}
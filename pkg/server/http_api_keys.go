@@ -0,0 +1,79 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/gorilla/mux"
+)
+
+type createAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	UserID    string     `json:"user_id"`
+	Roles     []string   `json:"roles"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type createAPIKeyResponse struct {
+	entities.APIKey
+	Key string `json:"key"`
+}
+
+type expireAPIKeyRequest struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// createAPIKey create an API key for a user or a set of roles, the key is only returned in this response
+func (h *httpHandler) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req createAPIKeyRequest
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	key, secret, err := h.services.APIKeys.CreateAPIKey(r.Context(), req.Name, req.UserID, req.Roles, req.ExpiresAt)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusCreated, createAPIKeyResponse{APIKey: *key, Key: secret})
+}
+
+// listAPIKeys get a list of the API keys, filtered by the user_id query parameter
+func (h *httpHandler) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.services.APIKeys.ListAPIKeys(r.Context(), r.URL.Query().Get("user_id"))
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, keys)
+}
+
+// revokeAPIKey delete an API key
+func (h *httpHandler) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := h.services.APIKeys.RevokeAPIKey(r.Context(), vars["keyID"]); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// expireAPIKey set the expiration of an API key, it expires immediately when none is given
+func (h *httpHandler) expireAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req expireAPIKeyRequest
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	expiresAt := time.Now()
+	if req.ExpiresAt != nil {
+		expiresAt = *req.ExpiresAt
+	}
+	vars := mux.Vars(r)
+	if err := h.services.APIKeys.ExpireAPIKey(r.Context(), vars["keyID"], expiresAt); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
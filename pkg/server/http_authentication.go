@@ -0,0 +1,593 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/StevenRojas/goaccess/pkg/service"
+	"github.com/gorilla/mux"
+)
+
+type loginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type setPasswordRequest struct {
+	Password string `json:"password"`
+}
+
+type changePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+type emailRequest struct {
+	Email string `json:"email"`
+}
+
+type loginCodeRequest struct {
+	Email string `json:"email"`
+	Code  string `json:"code"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type accountStatusRequest struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+type tokenRequest struct {
+	Token string `json:"token"`
+}
+
+type verifyTokenResponse struct {
+	UserID string `json:"user_id"`
+}
+
+type loggedUserResponse struct {
+	User         *entities.User  `json:"user,omitempty"`
+	Token        *entities.Token `json:"token,omitempty"`
+	MFAChallenge string          `json:"mfa_challenge,omitempty"`
+}
+
+type loginMFARequest struct {
+	Challenge string `json:"mfa_challenge"`
+	Code      string `json:"code"`
+}
+
+type mfaCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type signingKeyResponse struct {
+	KeyID string `json:"kid"`
+}
+
+// register register a user
+func (h *httpHandler) register(w http.ResponseWriter, r *http.Request) {
+	var user entities.User
+	if errs := entities.InitUserValidator(r, &user).ValidateJSON(); len(errs) > 0 {
+		h.encodeError(w, newValidationError("Invalid user", errs))
+		return
+	}
+	if err := h.services.Authentication.Register(r.Context(), &user); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusCreated, user)
+}
+
+// unregister unregister a user
+func (h *httpHandler) unregister(w http.ResponseWriter, r *http.Request) {
+	var user entities.User
+	if errs := entities.InitUserValidator(r, &user).ValidateJSON(); len(errs) > 0 {
+		h.encodeError(w, newValidationError("Invalid user", errs))
+		return
+	}
+	if err := h.services.Authentication.Unregister(r.Context(), &user); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// login log in a user and return the user and its tokens
+func (h *httpHandler) login(w http.ResponseWriter, r *http.Request) {
+	var req loginRequest
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	if req.Email == "" || req.Password == "" {
+		h.encodeError(w, newHTTPError(http.StatusBadRequest, errors.New("Email and password are required")))
+		return
+	}
+	loggedUser, err := h.services.Authentication.Login(h.clientContext(r), req.Email, req.Password)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, loggedUserResponse{
+		User:         loggedUser.User,
+		Token:        loggedUser.Token,
+		MFAChallenge: loggedUser.MFAChallenge,
+	})
+}
+
+// loginMFA complete a login with the MFA challenge and a TOTP or recovery code
+func (h *httpHandler) loginMFA(w http.ResponseWriter, r *http.Request) {
+	var req loginMFARequest
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	if req.Challenge == "" || req.Code == "" {
+		h.encodeError(w, newHTTPError(http.StatusBadRequest, errors.New("MFA challenge and code are required")))
+		return
+	}
+	loggedUser, err := h.services.Authentication.LoginMFA(h.clientContext(r), req.Challenge, req.Code)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, loggedUserResponse{
+		User:  loggedUser.User,
+		Token: loggedUser.Token,
+	})
+}
+
+// requestLoginLink mail a passwordless login link, the response is the same for unknown emails
+func (h *httpHandler) requestLoginLink(w http.ResponseWriter, r *http.Request) {
+	var req emailRequest
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	if req.Email == "" {
+		h.encodeError(w, newHTTPError(http.StatusBadRequest, errors.New("Email is required")))
+		return
+	}
+	if err := h.services.Authentication.RequestLoginLink(r.Context(), req.Email); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loginWithLink log in a user with the token of a login link
+func (h *httpHandler) loginWithLink(w http.ResponseWriter, r *http.Request) {
+	var req tokenRequest
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	loggedUser, err := h.services.Authentication.LoginWithLink(h.clientContext(r), req.Token)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, loggedUserResponse{
+		User:         loggedUser.User,
+		Token:        loggedUser.Token,
+		MFAChallenge: loggedUser.MFAChallenge,
+	})
+}
+
+// requestLoginCode mail a passwordless login code, the response is the same for unknown emails
+func (h *httpHandler) requestLoginCode(w http.ResponseWriter, r *http.Request) {
+	var req emailRequest
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	if req.Email == "" {
+		h.encodeError(w, newHTTPError(http.StatusBadRequest, errors.New("Email is required")))
+		return
+	}
+	if err := h.services.Authentication.RequestLoginCode(r.Context(), req.Email); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loginWithCode log in a user with the email and a login code
+func (h *httpHandler) loginWithCode(w http.ResponseWriter, r *http.Request) {
+	var req loginCodeRequest
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	if req.Email == "" || req.Code == "" {
+		h.encodeError(w, newHTTPError(http.StatusBadRequest, errors.New("Email and code are required")))
+		return
+	}
+	loggedUser, err := h.services.Authentication.LoginWithCode(h.clientContext(r), req.Email, req.Code)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, loggedUserResponse{
+		User:         loggedUser.User,
+		Token:        loggedUser.Token,
+		MFAChallenge: loggedUser.MFAChallenge,
+	})
+}
+
+// verifyToken check if the access token is valid and return the user ID
+func (h *httpHandler) verifyToken(w http.ResponseWriter, r *http.Request) {
+	var req tokenRequest
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	if req.Token == "" {
+		h.encodeError(w, newHTTPError(http.StatusBadRequest, errors.New("Token is required")))
+		return
+	}
+	userID, err := h.services.Authentication.VerifyToken(r.Context(), req.Token)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, verifyTokenResponse{UserID: userID})
+}
+
+// refreshToken return a new token pair for the given refresh token
+func (h *httpHandler) refreshToken(w http.ResponseWriter, r *http.Request) {
+	var req tokenRequest
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	if req.Token == "" {
+		h.encodeError(w, newHTTPError(http.StatusBadRequest, errors.New("Token is required")))
+		return
+	}
+	token, err := h.services.Authentication.RefreshToken(r.Context(), req.Token)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, token)
+}
+
+// logout log out a user for the given token pair
+func (h *httpHandler) logout(w http.ResponseWriter, r *http.Request) {
+	var token entities.Token
+	if err := h.decode(r, &token); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	if err := h.services.Authentication.Logout(r.Context(), &token); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// jwks get the public keys to verify the access tokens as a JSON Web Key Set
+func (h *httpHandler) jwks(w http.ResponseWriter, r *http.Request) {
+	jwks, err := h.services.Authentication.GetJWKS(r.Context())
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	h.encode(w, http.StatusOK, jwks)
+}
+
+// rotateSigningKey replace the signing key now, tokens signed with the previous key are valid until they expire
+func (h *httpHandler) rotateSigningKey(w http.ResponseWriter, r *http.Request) {
+	kid, err := h.services.Authentication.RotateSigningKey(r.Context(), 0)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, signingKeyResponse{KeyID: kid})
+}
+
+// setPassword set the password of a user without checking the current one
+func (h *httpHandler) setPassword(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var req setPasswordRequest
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	if err := h.services.Authentication.SetPassword(r.Context(), vars["userID"], req.Password); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// changePassword change the password of a user after verifying the current one
+func (h *httpHandler) changePassword(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var req changePasswordRequest
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	err := h.services.Authentication.ChangePassword(r.Context(), vars["userID"], req.OldPassword, req.NewPassword)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requestPasswordReset mail a password reset token, the response is the same for unknown emails
+func (h *httpHandler) requestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req emailRequest
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	if req.Email == "" {
+		h.encodeError(w, newHTTPError(http.StatusBadRequest, errors.New("Email is required")))
+		return
+	}
+	if err := h.services.Authentication.RequestPasswordReset(r.Context(), req.Email); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resetPassword set a new password using a reset token
+func (h *httpHandler) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var req resetPasswordRequest
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	if err := h.services.Authentication.ResetPassword(r.Context(), req.Token, req.Password); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sendVerification mail an email verification token to a user
+func (h *httpHandler) sendVerification(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := h.services.Authentication.SendVerification(r.Context(), vars["userID"]); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// verifyEmail mark the email of the token user as verified
+func (h *httpHandler) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req tokenRequest
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	if err := h.services.Authentication.VerifyEmail(r.Context(), req.Token); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// enrollTOTP generate a new TOTP secret for a user
+func (h *httpHandler) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	enrolment, err := h.services.Authentication.EnrollTOTP(r.Context(), vars["userID"])
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusCreated, enrolment)
+}
+
+// confirmTOTP enable MFA for a user and return the recovery codes
+func (h *httpHandler) confirmTOTP(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var req mfaCodeRequest
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	codes, err := h.services.Authentication.ConfirmTOTP(r.Context(), vars["userID"], req.Code)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// disableTOTP disable MFA for a user
+func (h *httpHandler) disableTOTP(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var req mfaCodeRequest
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	if err := h.services.Authentication.DisableTOTP(r.Context(), vars["userID"], req.Code); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// regenerateRecoveryCodes replace the recovery codes of a user
+func (h *httpHandler) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var req mfaCodeRequest
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	codes, err := h.services.Authentication.RegenerateRecoveryCodes(r.Context(), vars["userID"], req.Code)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// getAccountStatus get the account status of a user
+func (h *httpHandler) getAccountStatus(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	status, err := h.services.Authentication.GetAccountStatus(r.Context(), vars["userID"])
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, status)
+}
+
+// setAccountStatus change the account status of a user
+func (h *httpHandler) setAccountStatus(w http.ResponseWriter, r *http.Request) {
+	var req accountStatusRequest
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	vars := mux.Vars(r)
+	status, err := h.services.Authentication.SetAccountStatus(r.Context(), vars["userID"], req.Status, req.Reason)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, status)
+}
+
+// unlockAccount clear the failed logins and lockout of a user
+func (h *httpHandler) unlockAccount(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := h.services.Authentication.UnlockAccount(r.Context(), vars["userID"]); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unlockIP clear the failed logins and lockout of an IP
+func (h *httpHandler) unlockIP(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := h.services.Authentication.UnlockIP(r.Context(), vars["ip"]); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// impersonate issue an access token of a user to an admin, the admin is always the authenticated user
+func (h *httpHandler) impersonate(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := UserIDFromContext(r.Context())
+	if !ok || adminID == "" {
+		h.encodeError(w, newHTTPError(http.StatusUnauthorized, errors.New("An authenticated admin is required")))
+		return
+	}
+	vars := mux.Vars(r)
+	token, err := h.services.Authentication.Impersonate(h.clientContext(r), adminID, vars["userID"])
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, token)
+}
+
+// listImpersonations get the impersonations audit log, filtered by user_id when it is given
+func (h *httpHandler) listImpersonations(w http.ResponseWriter, r *http.Request) {
+	impersonations, err := h.services.Authentication.ListImpersonations(r.Context(), r.URL.Query().Get("user_id"))
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, impersonations)
+}
+
+// listSessions get the active sessions of a user
+func (h *httpHandler) listSessions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessions, err := h.services.Authentication.ListSessions(r.Context(), vars["userID"])
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, sessions)
+}
+
+// revokeSession end a session of a user
+func (h *httpHandler) revokeSession(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := h.services.Authentication.RevokeSession(r.Context(), vars["userID"], vars["sessionID"]); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeAllSessions end all the sessions of a user
+func (h *httpHandler) revokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := h.services.Authentication.RevokeAllSessions(r.Context(), vars["userID"]); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// clientContext add the user agent and IP of the request to its context, they are recorded in the session and
+// the failed logins are counted per IP
+func (h *httpHandler) clientContext(r *http.Request) context.Context {
+	return service.WithClientInfo(r.Context(), r.UserAgent(), h.clientIP(r))
+}
+
+// clientIP the IP of the request client, the X-Forwarded-For hops are followed from the right while they are
+// trusted proxies so a client can't spoof its IP by setting the header itself
+func (h *httpHandler) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !h.isTrustedProxy(ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !h.isTrustedProxy(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+func (h *httpHandler) isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range h.trustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
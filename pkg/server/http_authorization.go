@@ -0,0 +1,145 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type actionsBody struct {
+	Actions []string `json:"actions"`
+}
+
+type permissionResponse struct {
+	Action  string `json:"action"`
+	Allowed bool   `json:"allowed"`
+}
+
+// listUsers get a list of all users
+func (h *httpHandler) listUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := h.services.Authorization.ListUsers(r.Context())
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, users)
+}
+
+// listUsersByRole get a list of all users for a given role
+func (h *httpHandler) listUsersByRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	users, err := h.services.Authorization.ListUsersByRole(r.Context(), vars["roleID"])
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, users)
+}
+
+// assignActions assign actions to a role
+func (h *httpHandler) assignActions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var req actionsBody
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	err := h.services.Authorization.AssignActions(r.Context(), vars["roleID"], vars["module"], vars["submodule"], req.Actions)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unassignActions unassign actions from a role
+func (h *httpHandler) unassignActions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var req actionsBody
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	err := h.services.Authorization.UnassignActions(r.Context(), vars["roleID"], vars["module"], vars["submodule"], req.Actions)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// assignRole assign role to a user
+func (h *httpHandler) assignRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := h.services.Authorization.AssignRole(r.Context(), vars["userID"], vars["roleID"]); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unassignRole unassign role from a user
+func (h *httpHandler) unassignRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := h.services.Authorization.UnassignRole(r.Context(), vars["userID"], vars["roleID"]); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// accessList get a json of modules, submodules and sections where the user has access
+func (h *httpHandler) accessList(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	access, err := h.services.Authorization.GetAccessList(r.Context(), vars["userID"])
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, access)
+}
+
+// actionListByModule get a json list with the actions can be performed by a user in a module
+func (h *httpHandler) actionListByModule(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	actions, err := h.services.Authorization.GetActionListByModule(r.Context(), vars["module"], vars["userID"])
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, actions)
+}
+
+// checkPermission checks if a user has permission to perform the action given in the query
+func (h *httpHandler) checkPermission(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	action := r.URL.Query().Get("action")
+	if action == "" {
+		h.encodeError(w, newHTTPError(http.StatusBadRequest, errors.New("Action is required")))
+		return
+	}
+	allowed, err := h.services.Authorization.CheckPermission(r.Context(), action, vars["userID"])
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, permissionResponse{Action: action, Allowed: allowed})
+}
+
+// matchRoute get the template action, module and submodule for the method and path given in the query
+func (h *httpHandler) matchRoute(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	method := query.Get("method")
+	path := query.Get("path")
+	if method == "" || path == "" {
+		h.encodeError(w, newHTTPError(http.StatusBadRequest, errors.New("Method and path are required")))
+		return
+	}
+	match, err := h.services.Authorization.MatchRoute(r.Context(), method, path)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, match)
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/StevenRojas/goaccess/pkg/service"
+	"github.com/StevenRojas/goaccess/pkg/utils"
+)
+
+// federationStateCookie cookie with the hash of the state of a federated login, the callback is only accepted from
+// the browser that started the login
+const federationStateCookie = "goaccess_federation_state"
+
+// federatedLogin redirect the user to the external identity provider
+func (h *httpHandler) federatedLogin(w http.ResponseWriter, r *http.Request) {
+	authorizeURL, state, err := h.services.Federation.StartLogin(r.Context())
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	// Lax so the cookie is sent on the top level redirect back from the identity provider
+	http.SetCookie(w, &http.Cookie{
+		Name:     federationStateCookie,
+		Value:    utils.HashToken(state),
+		Path:     "/federation",
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, authorizeURL, http.StatusFound)
+}
+
+// federationCallback complete a federated login with the state and code or the error the identity provider
+// redirected the user back with, they are taken from the query or the posted form
+func (h *httpHandler) federationCallback(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.encodeError(w, newHTTPError(http.StatusBadRequest, err))
+		return
+	}
+	if code := r.Form.Get("error"); code != "" {
+		reason := code
+		if description := r.Form.Get("error_description"); description != "" {
+			reason += ": " + description
+		}
+		h.encodeError(w, &service.FederationError{Reason: reason})
+		return
+	}
+	// Login CSRF, the state must be the one of the login started by this browser
+	state := r.Form.Get("state")
+	cookie, err := r.Cookie(federationStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(utils.HashToken(state))) != 1 {
+		h.encodeError(w, service.ErrInvalidFederationState)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     federationStateCookie,
+		Path:     "/federation",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	loggedUser, err := h.services.Federation.CompleteLogin(h.clientContext(r), state, r.Form.Get("code"))
+	if err == service.ErrUserNotFound {
+		// Unknown users are not provisioned
+		h.encodeError(w, newHTTPError(http.StatusUnauthorized, err))
+		return
+	}
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	h.encode(w, http.StatusOK, loggedUserResponse{
+		User:         loggedUser.User,
+		Token:        loggedUser.Token,
+		MFAChallenge: loggedUser.MFAChallenge,
+	})
+}
+
+// isSecureRequest check if the request came over HTTPS, directly or through a TLS terminating proxy
+func isSecureRequest(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/StevenRojas/goaccess/pkg/service"
+	"github.com/gorilla/mux"
+)
+
+// OAuth2 grant types supported by the token endpoint
+const (
+	grantClientCredentials = "client_credentials"
+	grantAuthorizationCode = "authorization_code" // OpenID Connect clients with PKCE
+	grantRefreshToken      = "refresh_token"      // OpenID Connect clients
+)
+
+type registerClientRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+type registerClientResponse struct {
+	entities.ServiceClient
+	Secret string `json:"client_secret"`
+}
+
+// oauthError OAuth2 error response (RFC 6749 section 5.2)
+type oauthError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+type internalTokenRequest struct {
+	ClientID string   `json:"client_id"`
+	Secret   string   `json:"client_secret"`
+	Scopes   []string `json:"scopes"`
+}
+
+// registerClient register a service client, the secret is only returned in this response
+func (h *httpHandler) registerClient(w http.ResponseWriter, r *http.Request) {
+	var req registerClientRequest
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	client, secret, err := h.services.InternalAuthentication.RegisterClient(r.Context(), req.Name, req.Scopes)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusCreated, registerClientResponse{ServiceClient: *client, Secret: secret})
+}
+
+// listClients get a list of all service clients
+func (h *httpHandler) listClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := h.services.InternalAuthentication.ListClients(r.Context())
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, clients)
+}
+
+// deleteClient delete a service client
+func (h *httpHandler) deleteClient(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := h.services.InternalAuthentication.DeleteClient(r.Context(), vars["clientID"]); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// internalToken authenticate a service client and return an internal token pair
+func (h *httpHandler) internalToken(w http.ResponseWriter, r *http.Request) {
+	var req internalTokenRequest
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	token, err := h.services.InternalAuthentication.IssueInternalToken(r.Context(), req.ClientID, req.Secret, req.Scopes)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, token)
+}
+
+// refreshInternalToken return a new internal token pair for an internal refresh token
+func (h *httpHandler) refreshInternalToken(w http.ResponseWriter, r *http.Request) {
+	var req tokenRequest
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	token, err := h.services.InternalAuthentication.RefreshInternalToken(r.Context(), req.Token)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, token)
+}
+
+// verifyInternalToken check if an internal token is valid and return the client and its scopes
+func (h *httpHandler) verifyInternalToken(w http.ResponseWriter, r *http.Request) {
+	var req tokenRequest
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	identity, err := h.services.InternalAuthentication.VerifyInternalToken(r.Context(), req.Token)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, identity)
+}
+
+// assignClientRole assign a role to a service client
+func (h *httpHandler) assignClientRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := h.services.InternalAuthentication.AssignClientRole(r.Context(), vars["clientID"], vars["roleID"]); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unassignClientRole unassign a role from a service client
+func (h *httpHandler) unassignClientRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := h.services.InternalAuthentication.UnassignClientRole(r.Context(), vars["clientID"], vars["roleID"]); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// oauthToken OAuth2 token endpoint. Service clients use the client_credentials grant and authenticate with HTTP
+// Basic or with the client_id and client_secret form parameters. OpenID Connect clients are public, they use the
+// authorization_code grant with the PKCE verifier and the refresh_token grant
+func (h *httpHandler) oauthToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	if err := r.ParseForm(); err != nil {
+		h.encode(w, http.StatusBadRequest, oauthError{Error: "invalid_request", Description: "Invalid form body"})
+		return
+	}
+	grantType := r.PostForm.Get("grant_type")
+	if grantType == "" {
+		h.encode(w, http.StatusBadRequest, oauthError{Error: "invalid_request", Description: "The grant_type parameter is required"})
+		return
+	}
+	clientID, secret, basic := clientCredentials(r)
+	var token *entities.OAuthToken
+	var err error
+	switch grantType {
+	case grantClientCredentials:
+		token, err = h.services.InternalAuthentication.ClientCredentialsToken(
+			r.Context(), clientID, secret, strings.Fields(r.PostForm.Get("scope")))
+	case grantAuthorizationCode:
+		token, err = h.services.OIDC.ExchangeCode(r.Context(), clientID, r.PostForm.Get("code"),
+			r.PostForm.Get("redirect_uri"), r.PostForm.Get("code_verifier"))
+	case grantRefreshToken:
+		token, err = h.services.OIDC.RefreshToken(r.Context(), r.PostForm.Get("refresh_token"))
+		// Like on /auth/refresh, a refresh token that can't be rotated is rejected whatever the reason
+		if err != nil && err != service.ErrOIDCDisabled {
+			err = service.ErrInvalidGrant
+		}
+	default:
+		h.encode(w, http.StatusBadRequest, oauthError{Error: "unsupported_grant_type"})
+		return
+	}
+	switch err {
+	case nil:
+		h.encode(w, http.StatusOK, token)
+	case service.ErrInvalidClientCredentials:
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="goaccess"`)
+		}
+		h.encode(w, http.StatusUnauthorized, oauthError{Error: "invalid_client", Description: err.Error()})
+	case service.ErrInvalidScope:
+		h.encode(w, http.StatusBadRequest, oauthError{Error: "invalid_scope", Description: err.Error()})
+	case service.ErrInvalidGrant:
+		h.encode(w, http.StatusBadRequest, oauthError{Error: "invalid_grant", Description: err.Error()})
+	case service.ErrOIDCDisabled:
+		h.encode(w, http.StatusBadRequest, oauthError{Error: "unsupported_grant_type", Description: err.Error()})
+	default:
+		h.encodeError(w, err)
+	}
+}
+
+// introspect token introspection endpoint (RFC 7662), the caller authenticates as a service client like on the
+// token endpoint. The token_type_hint parameter is ignored, every kind of token is tried
+func (h *httpHandler) introspect(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	if err := r.ParseForm(); err != nil {
+		h.encode(w, http.StatusBadRequest, oauthError{Error: "invalid_request", Description: "Invalid form body"})
+		return
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		h.encode(w, http.StatusBadRequest, oauthError{Error: "invalid_request", Description: "The token parameter is required"})
+		return
+	}
+	clientID, secret, basic := clientCredentials(r)
+	result, err := h.services.Introspection.Introspect(r.Context(), clientID, secret, token)
+	switch err {
+	case nil:
+		h.encode(w, http.StatusOK, result)
+	case service.ErrInvalidClientCredentials:
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="goaccess"`)
+		}
+		h.encode(w, http.StatusUnauthorized, oauthError{Error: "invalid_client", Description: err.Error()})
+	default:
+		h.encodeError(w, err)
+	}
+}
+
+// clientCredentials get the service client credentials from HTTP Basic or from the client_id and client_secret
+// form parameters, the form must be parsed
+func clientCredentials(r *http.Request) (string, string, bool) {
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		// Credentials are form encoded before the base64 encoding (RFC 6749 section 2.3.1)
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+		return clientID, secret, true
+	}
+	return r.PostForm.Get("client_id"), r.PostForm.Get("client_secret"), false
+}
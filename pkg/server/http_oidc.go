@@ -0,0 +1,219 @@
+package server
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/StevenRojas/goaccess/pkg/service"
+	"github.com/gorilla/mux"
+)
+
+type registerOIDCClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+}
+
+// authorizeFormData data of the login form of the authorization requests
+type authorizeFormData struct {
+	Request      url.Values
+	Email        string
+	MFAChallenge string
+	Error        string
+}
+
+// authorizeForm login form of the authorization endpoint, it posts the authorization request parameters back with
+// the credentials or with the MFA challenge and code
+var authorizeForm = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Log in</title>
+</head>
+<body>
+<form method="post" action="authorize">
+{{if .Error}}<p role="alert">{{.Error}}</p>
+{{end}}{{range $name, $values := .Request}}{{range $values}}<input type="hidden" name="{{$name}}" value="{{.}}">
+{{end}}{{end}}{{if .MFAChallenge}}<input type="hidden" name="mfa_challenge" value="{{.MFAChallenge}}">
+<label>Authentication code <input name="code" inputmode="numeric" autocomplete="one-time-code" required autofocus></label>
+{{else}}<label>Email <input type="email" name="email" value="{{.Email}}" autocomplete="username" required autofocus></label>
+<label>Password <input type="password" name="password" autocomplete="current-password" required></label>
+{{end}}<button type="submit">Log in</button>
+</form>
+</body>
+</html>
+`))
+
+// openIDConfiguration OpenID Connect discovery document
+func (h *httpHandler) openIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	config, err := h.services.OIDC.Configuration(r.Context())
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, config)
+}
+
+// authorize validate an authorization request and show the login form
+func (h *httpHandler) authorize(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	req := authorizationRequest(params)
+	if err := h.services.OIDC.ValidateAuthorization(r.Context(), req); err != nil {
+		h.authorizationError(w, r, req, err)
+		return
+	}
+	h.renderAuthorizeForm(w, http.StatusOK, authorizeFormData{Request: authorizationParams(params)})
+}
+
+// authorizeLogin log in the user of an authorization request and redirect it to the client with the code. The
+// form is shown again for the MFA code or when the login fails
+func (h *httpHandler) authorizeLogin(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.encodeError(w, newHTTPError(http.StatusBadRequest, err))
+		return
+	}
+	req := authorizationRequest(r.PostForm)
+	var authorization *entities.Authorization
+	var err error
+	if challenge := r.PostForm.Get("mfa_challenge"); challenge != "" {
+		authorization, err = h.services.OIDC.AuthorizeMFA(h.clientContext(r), req, challenge, r.PostForm.Get("code"))
+	} else {
+		authorization, err = h.services.OIDC.Authorize(h.clientContext(r), req, r.PostForm.Get("email"), r.PostForm.Get("password"))
+	}
+	data := authorizeFormData{Request: authorizationParams(r.PostForm), Email: r.PostForm.Get("email")}
+	if err != nil {
+		if message, status, ok := loginFormError(err); ok {
+			data.Error = message
+			h.renderAuthorizeForm(w, status, data)
+			return
+		}
+		h.authorizationError(w, r, req, err)
+		return
+	}
+	if authorization.MFAChallenge != "" {
+		data.MFAChallenge = authorization.MFAChallenge
+		h.renderAuthorizeForm(w, http.StatusOK, data)
+		return
+	}
+	http.Redirect(w, r, authorization.RedirectTo, http.StatusSeeOther)
+}
+
+// userInfo claims of the user of the bearer access token
+func (h *httpHandler) userInfo(w http.ResponseWriter, r *http.Request) {
+	info, err := h.services.OIDC.UserInfo(r.Context(), bearerToken(r))
+	if err == service.ErrInvalidToken || err == service.ErrExpiredToken {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	}
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	h.encode(w, http.StatusOK, info)
+}
+
+// registerOIDCClient register an OpenID Connect client
+func (h *httpHandler) registerOIDCClient(w http.ResponseWriter, r *http.Request) {
+	var req registerOIDCClientRequest
+	if err := h.decode(r, &req); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	client, err := h.services.OIDC.RegisterClient(r.Context(), req.Name, req.RedirectURIs)
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusCreated, client)
+}
+
+// listOIDCClients get a list of all OpenID Connect clients
+func (h *httpHandler) listOIDCClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := h.services.OIDC.ListClients(r.Context())
+	if err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	h.encode(w, http.StatusOK, clients)
+}
+
+// deleteOIDCClient delete an OpenID Connect client
+func (h *httpHandler) deleteOIDCClient(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := h.services.OIDC.DeleteClient(r.Context(), vars["clientID"]); err != nil {
+		h.encodeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizationError send an authorization error to the client redirect URI, errors of requests that can't be
+// redirected are returned to the user agent
+func (h *httpHandler) authorizationError(w http.ResponseWriter, r *http.Request, req *entities.AuthorizationRequest, err error) {
+	authErr, ok := err.(*service.AuthorizationError)
+	if !ok {
+		h.encodeError(w, err)
+		return
+	}
+	params := url.Values{"error": {authErr.Code}, "error_description": {authErr.Description}}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	http.Redirect(w, r, service.AuthorizationRedirect(req.RedirectURI, params), http.StatusSeeOther)
+}
+
+// renderAuthorizeForm write the login form, it can't be framed to prevent clickjacking
+func (h *httpHandler) renderAuthorizeForm(w http.ResponseWriter, status int, data authorizeFormData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+	w.WriteHeader(status)
+	if err := authorizeForm.Execute(w, data); err != nil {
+		h.logger.Error("unable to render authorization form", err.Error())
+	}
+}
+
+// loginFormError message and status of the login errors shown in the authorization form, unknown users get the
+// same message as wrong passwords
+func loginFormError(err error) (string, int, bool) {
+	switch err {
+	case service.ErrUserNotFound, service.ErrInvalidCredentials:
+		return service.ErrInvalidCredentials.Error(), http.StatusUnauthorized, true
+	case service.ErrInvalidMFACode, service.ErrInvalidMFAChallenge, service.ErrPasswordExpired:
+		return err.Error(), http.StatusUnauthorized, true
+	case service.ErrMFARequired, service.ErrAccountDisabled, service.ErrAccountLocked, service.ErrAccountPending:
+		return err.Error(), http.StatusForbidden, true
+	case service.ErrTooManyAttempts:
+		return err.Error(), http.StatusTooManyRequests, true
+	}
+	return "", 0, false
+}
+
+// authorizationRequest get an authorization request from the query or form parameters
+func authorizationRequest(params url.Values) *entities.AuthorizationRequest {
+	return &entities.AuthorizationRequest{
+		ResponseType:        params.Get("response_type"),
+		ClientID:            params.Get("client_id"),
+		RedirectURI:         params.Get("redirect_uri"),
+		Scope:               params.Get("scope"),
+		State:               params.Get("state"),
+		Nonce:               params.Get("nonce"),
+		CodeChallenge:       params.Get("code_challenge"),
+		CodeChallengeMethod: params.Get("code_challenge_method"),
+	}
+}
+
+// authorizationParams keep only the authorization request parameters, they are posted back by the login form
+func authorizationParams(params url.Values) url.Values {
+	kept := url.Values{}
+	for _, name := range []string{"response_type", "client_id", "redirect_uri", "scope", "state", "nonce",
+		"code_challenge", "code_challenge_method"} {
+		if value := params.Get(name); value != "" {
+			kept.Set(name, value)
+		}
+	}
+	return kept
+}
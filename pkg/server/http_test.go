@@ -0,0 +1,784 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/StevenRojas/goaccess/pkg/events"
+	"github.com/StevenRojas/goaccess/pkg/repository"
+	"github.com/StevenRojas/goaccess/pkg/service"
+	"github.com/StevenRojas/goaccess/pkg/utils"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type httpSuite struct {
+	handler     http.Handler
+	repo        *repository.UsersRepoMock
+	clientsRepo *repository.ClientsRepoMock
+	apiKeysRepo *repository.APIKeysRepoMock
+	oidcRepo    *repository.OIDCRepoMock
+	suite.Suite
+}
+
+func (s *httpSuite) SetupTest() {
+	s.repo = new(repository.UsersRepoMock)
+	s.clientsRepo = new(repository.ClientsRepoMock)
+	s.apiKeysRepo = new(repository.APIKeysRepoMock)
+	s.oidcRepo = new(repository.OIDCRepoMock)
+	logger := configuration.NewLogger(configuration.ServerConfig{LogLevel: 3})
+	tokenHandler, err := utils.NewInternalTokenHandler(configuration.SecurityConfig{
+		JWTInternalSecret:          "internal!",
+		JWTInternalTokenExpiration: 1,
+	})
+	if err != nil {
+		panic(err)
+	}
+	authentication := newAuthenticationService(s.repo)
+	internal := service.NewInternalAuthenticationService(
+		s.clientsRepo, nil, tokenHandler, events.NewSubscriber())
+	s.handler = NewHTTPHandler(Services{
+		Authentication:         authentication,
+		InternalAuthentication: internal,
+		Introspection:          service.NewIntrospectionService(authentication, internal, nil),
+		OIDC: service.NewOIDCService(s.oidcRepo, s.repo, authentication, utils.NewJwtHandlerMock(configuration.SecurityConfig{}),
+			configuration.OIDCConfig{Issuer: "https://auth.example.com", CodeExpiration: 60, IDTokenExpiration: 60}),
+		Federation: service.NewFederationService(s.oidcRepo, s.repo, authentication, nil, configuration.FederationConfig{}),
+		APIKeys:    service.NewAPIKeysService(s.apiKeysRepo, s.repo, nil, events.NewSubscriber()),
+	}, nil, logger)
+}
+
+func newAuthenticationService(repo *repository.UsersRepoMock) service.AuthenticationService {
+	policy, err := utils.NewPasswordPolicy(configuration.PasswordPolicyConfig{MinLength: 8})
+	if err != nil {
+		panic(err)
+	}
+	mfa := configuration.MFAConfig{TOTPSkew: 1, RecoveryCodes: 2}
+	// Users without roles, the user claims of the tokens are loaded on every login
+	repo.M.On("GetUserRoles", mock.Anything).Return([]string{}, nil)
+	repo.M.On("GetUserActions", mock.Anything).Return([]string{}, nil)
+	return service.NewAuthenticationService(
+		repo,
+		new(repository.APIKeysRepoMock),
+		utils.NewJwtHandlerMock(configuration.SecurityConfig{}),
+		nil,
+		utils.NewPasswordHasherMock(),
+		policy,
+		utils.NewLoginThrottle(configuration.LoginThrottleConfig{}, nil),
+		utils.NewRateLimiter(nil, 0, 0),
+		utils.NewTOTPHandler(mfa),
+		mfa,
+		utils.NewMailerMock(),
+		configuration.EmailTokensConfig{PasswordResetExpiration: 30, EmailVerificationExpiration: 24},
+		events.NewSecurityFeed(),
+	)
+}
+
+// asAdmin authenticate a request with the access token of the user 1, an admin
+func (s *httpSuite) asAdmin(r *http.Request) *http.Request {
+	admin := &entities.User{ID: "1", Email: "admin@gmail.com", IsAdmin: true}
+	s.repo.M.On("GetUserByToken", "a_uuid").Return(admin, nil)
+	s.repo.M.On("GetUserByID", "1").Return(admin, nil)
+	r.Header.Set("Authorization", "Bearer a_jwt")
+	return r
+}
+
+func TestHTTPServer(t *testing.T) {
+	suite.Run(t, new(httpSuite))
+}
+
+func (s *httpSuite) TestRouteNotFound() {
+	t := s.T()
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/unknown", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	var body errorResponse
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&body))
+	assert.Equal(t, http.StatusNotFound, body.Error.Code)
+	assert.Equal(t, "Route not found", body.Error.Message)
+}
+
+func (s *httpSuite) TestLoginWithoutEmail() {
+	t := s.T()
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func (s *httpSuite) TestLoginNotRegistered() {
+	t := s.T()
+	email := "notRegistered@gmail.com"
+	s.repo.M.On("GetUserByEmail", email).Return(nil, nil)
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"email":"`+email+`","password":"secret"}`)))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	var body errorResponse
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&body))
+	assert.Equal(t, service.ErrInvalidCredentials.Error(), body.Error.Message)
+}
+
+func (s *httpSuite) TestLoginErrors() {
+	t := s.T()
+	// Only credential errors are unauthorized, the others keep their status
+	disabled := "disabled@gmail.com"
+	s.repo.M.On("GetUserByEmail", disabled).Return(&entities.User{ID: "3", Email: disabled, Status: entities.UserStatusDisabled}, nil)
+	s.repo.M.On("GetPasswordHash", "3").Return("hash:secret", nil)
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"email":"`+disabled+`","password":"secret"}`)))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	failing := "failing@gmail.com"
+	s.repo.M.On("GetUserByEmail", failing).Return(nil, errors.New("dial tcp 10.0.0.3:6379: connection refused"))
+	w = httptest.NewRecorder()
+	s.handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"email":"`+failing+`","password":"secret"}`)))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	var body errorResponse
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&body))
+	assert.Equal(t, "Internal server error", body.Error.Message)
+}
+
+func (s *httpSuite) TestUnlockIP() {
+	t := s.T()
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, s.asAdmin(httptest.NewRequest(http.MethodPost, "/ips/not-an-ip/unlock", nil)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = httptest.NewRecorder()
+	s.handler.ServeHTTP(w, s.asAdmin(httptest.NewRequest(http.MethodPost, "/ips/10.0.0.1/unlock", nil)))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func (s *httpSuite) TestPasswordReset() {
+	t := s.T()
+	s.repo.M.On("GetUserByEmail", "unknown@gmail.com").Return(nil, nil)
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/password/forgot", strings.NewReader(`{"email":"unknown@gmail.com"}`)))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	s.repo.M.On("GetOneTimeToken", "reset", utils.HashToken("expired")).Return("", nil)
+	w = httptest.NewRecorder()
+	s.handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/password/reset", strings.NewReader(`{"token":"expired","password":"n3wpassword"}`)))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func (s *httpSuite) TestLoginWithCode() {
+	t := s.T()
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/login/code/verify", strings.NewReader(`{"email":"srojas@gmail.com"}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	s.repo.M.On("GetUserByEmail", "srojas@gmail.com").Return(&entities.User{ID: "1", Email: "srojas@gmail.com"}, nil)
+	s.repo.M.On("TakeOneTimeToken", "logincode", mock.Anything).Return("", nil)
+	w = httptest.NewRecorder()
+	s.handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/login/code/verify", strings.NewReader(`{"email":"srojas@gmail.com","code":"123456"}`)))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func (s *httpSuite) TestImpersonate() {
+	t := s.T()
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, s.asAdmin(httptest.NewRequest(http.MethodPost, "/users/1/impersonate", nil)))
+	assert.Equal(t, http.StatusBadRequest, w.Code, "an admin can't impersonate itself")
+
+	s.repo.M.On("GetUserByID", "2").Return(&entities.User{ID: "2", Email: "user@gmail.com"}, nil)
+	s.repo.M.On("AddImpersonation", mock.Anything).Return(nil)
+	s.repo.M.On("StoreTokens", mock.Anything).Return(nil)
+	w = httptest.NewRecorder()
+	s.handler.ServeHTTP(w, s.asAdmin(httptest.NewRequest(http.MethodPost, "/users/2/impersonate", strings.NewReader(`{"admin_id":"9"}`))))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var token entities.ImpersonationToken
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&token))
+	assert.Equal(t, "i_jwt", token.Access)
+	assert.Equal(t, "1", token.AdminID, "the admin is the authenticated user, not the one of the body")
+}
+
+func (s *httpSuite) TestAdminRoutesUnauthenticated() {
+	t := s.T()
+	routes := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPost, "/auth/register"},
+		{http.MethodPost, "/auth/unregister"},
+		{http.MethodPost, "/auth/keys/rotate"},
+		{http.MethodPut, "/users/1/password"},
+		{http.MethodPut, "/users/1/status"},
+		{http.MethodPut, "/users/1/roles/admin"},
+		{http.MethodDelete, "/users/1/roles/admin"},
+		{http.MethodGet, "/users/1/sessions"},
+		{http.MethodDelete, "/users/1/sessions"},
+		{http.MethodPost, "/users/1/mfa/totp"},
+		{http.MethodPost, "/users/1/mfa/totp/disable"},
+		{http.MethodPost, "/users/1/impersonate"},
+		{http.MethodPost, "/ips/10.0.0.1/unlock"},
+		{http.MethodGet, "/apikeys"},
+		{http.MethodPost, "/apikeys"},
+		{http.MethodGet, "/clients"},
+		{http.MethodPost, "/clients"},
+		{http.MethodPost, "/oidc/clients"},
+		{http.MethodPost, "/roles"},
+		{http.MethodGet, "/users"},
+		{http.MethodPost, "/init"},
+	}
+	for _, route := range routes {
+		w := httptest.NewRecorder()
+		s.handler.ServeHTTP(w, httptest.NewRequest(route.method, route.path, strings.NewReader(`{}`)))
+		assert.Equal(t, http.StatusUnauthorized, w.Code, route.method+" "+route.path)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/auth/keys/rotate", nil)
+	r.Header.Set("Authorization", "Bearer a_jwt")
+	s.repo.M.On("GetUserByToken", "a_uuid").Return((*entities.User)(nil), nil)
+	s.handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "the token of a user logged out")
+}
+
+func (s *httpSuite) TestAdminRoutesForbidden() {
+	t := s.T()
+	user := &entities.User{ID: "1", Email: "user@gmail.com"}
+	s.repo.M.On("GetUserByToken", "a_uuid").Return(user, nil)
+	s.repo.M.On("GetUserByID", "1").Return(user, nil)
+	request := func(method string, path string) *http.Request {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("Authorization", "Bearer a_jwt")
+		return r
+	}
+	for _, r := range []*http.Request{
+		request(http.MethodPost, "/auth/keys/rotate"),
+		request(http.MethodPut, "/users/1/roles/admin"),
+		request(http.MethodPut, "/users/1/status"),
+		request(http.MethodGet, "/users/2/sessions"),
+		request(http.MethodPost, "/users/2/impersonate"),
+	} {
+		w := httptest.NewRecorder()
+		s.handler.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusForbidden, w.Code, r.Method+" "+r.URL.Path)
+	}
+
+	// The owner of the route
+	s.repo.M.On("IsValidUser", "1").Return(true, nil)
+	s.repo.M.On("GetSessions", "1").Return([]entities.Session{}, nil)
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, request(http.MethodGet, "/users/1/sessions"))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func (s *httpSuite) TestMFARoutesOwnerOnly() {
+	t := s.T()
+	for _, path := range []string{"/users/2/mfa/totp", "/users/2/mfa/totp/confirm", "/users/2/mfa/totp/disable", "/users/2/mfa/recovery-codes"} {
+		w := httptest.NewRecorder()
+		s.handler.ServeHTTP(w, s.asAdmin(httptest.NewRequest(http.MethodPost, path, nil)))
+		assert.Equal(t, http.StatusForbidden, w.Code, "admins can't enroll other users: "+path)
+	}
+
+	s.repo.M.On("GetMFASecret", "1").Return("", false, nil)
+	s.repo.M.On("IsValidUser", "1").Return(true, nil)
+	s.repo.M.On("SetMFASecret", "1", mock.Anything).Return(nil)
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, s.asAdmin(httptest.NewRequest(http.MethodPost, "/users/1/mfa/totp", nil)))
+	assert.Equal(t, http.StatusCreated, w.Code, "the user itself")
+}
+
+func (s *httpSuite) TestAdminRoutesServiceClient() {
+	t := s.T()
+	s.clientsRepo.M.On("GetClientSecretHash", "c1").Return(utils.HashAPIKeySecret("s3cret"), nil)
+	s.clientsRepo.M.On("GetClient", "c1").Return(&entities.ServiceClient{ID: "c1", Scopes: []string{"post:auth:keys:rotate", "post:users:[]:impersonate"}}, nil)
+	// The mock JWT handler reads every token as the access token of the user 1
+	s.repo.M.On("GetUserByToken", "a_uuid").Return((*entities.User)(nil), nil)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader("grant_type=client_credentials"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth("c1", "s3cret")
+	s.handler.ServeHTTP(w, r)
+	var token entities.OAuthToken
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&token))
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/auth/keys/rotate", nil)
+	r.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	s.handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/apikeys", nil)
+	r.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	s.handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code, "read only routes are not allowed without the scope")
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/users/2/impersonate", nil)
+	r.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	s.handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "only users can impersonate")
+}
+
+func (s *httpSuite) TestLoginSuccess() {
+	t := s.T()
+	email := "srojas@gmail.com"
+	expected := &entities.User{
+		ID:    "1",
+		Email: email,
+		Name:  "steven rojas",
+	}
+	s.repo.M.On("GetUserByEmail", email).Return(expected, nil)
+	s.repo.M.On("GetPasswordHash", "1").Return("hash:secret", nil)
+	s.repo.M.On("GetMFASecret", "1").Return("", false, nil)
+	s.repo.M.On("StoreTokens", &utils.StoredToken{
+		ID:             "1",
+		AccessToken:    "a_jwt",
+		AccessUUID:     "a_uuid",
+		AccessExpires:  10,
+		RefreshToken:   "r_jwt",
+		RefreshUUID:    "r_uuid",
+		RefreshExpires: 20,
+		Family:         "r_uuid",
+	}).Return(nil)
+	s.repo.M.On("StoreSession", mock.MatchedBy(func(session *entities.Session) bool {
+		return session.ID == "r_uuid" && session.IP == "192.0.2.1"
+	})).Return(nil)
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"email":"`+email+`","password":"secret"}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var body loggedUserResponse
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&body))
+	assert.Equal(t, expected, body.User)
+	assert.Equal(t, "a_jwt", body.Token.Access)
+	assert.Equal(t, "r_jwt", body.Token.Refresh)
+}
+
+func (s *httpSuite) TestLogoutWithIDToken() {
+	t := s.T()
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/logout", strings.NewReader(`{"access_token":"id_jwt","refresh_token":"r_jwt"}`)))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	s.repo.M.AssertNotCalled(t, "DeleteToken", mock.Anything)
+}
+
+func (s *httpSuite) TestSetWeakPassword() {
+	t := s.T()
+	s.repo.M.On("IsValidUser", "1").Return(true, nil)
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, s.asAdmin(httptest.NewRequest(http.MethodPut, "/users/1/password", strings.NewReader(`{"password":"short"}`))))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var body errorResponse
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&body))
+	assert.NotEmpty(t, body.Error.Details["password"])
+	s.repo.M.AssertNumberOfCalls(t, "SetPasswordHash", 0)
+}
+
+func (s *httpSuite) TestJWKS() {
+	t := s.T()
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var body entities.JWKS
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&body))
+	assert.Len(t, body.Keys, 1)
+	assert.Equal(t, "kid", body.Keys[0].Kid)
+}
+
+func (s *httpSuite) TestRotateSigningKey() {
+	t := s.T()
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, s.asAdmin(httptest.NewRequest(http.MethodPost, "/auth/keys/rotate", nil)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var body signingKeyResponse
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&body))
+	assert.Equal(t, "new_kid", body.KeyID)
+}
+
+func (s *httpSuite) TestOAuthClientCredentials() {
+	t := s.T()
+	s.clientsRepo.M.On("GetClientSecretHash", "c1").Return(utils.HashAPIKeySecret("s3cret"), nil)
+	s.clientsRepo.M.On("GetClient", "c1").Return(&entities.ServiceClient{ID: "c1", Scopes: []string{"post:report"}}, nil)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader("grant_type=client_credentials"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth("c1", "s3cret")
+	s.handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	var body entities.OAuthToken
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&body))
+	assert.NotEmpty(t, body.AccessToken)
+	assert.Equal(t, "Bearer", body.TokenType)
+	assert.Equal(t, "post:report", body.Scope)
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/oauth/token",
+		strings.NewReader("grant_type=client_credentials&client_id=c1&client_secret=wrong"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	s.handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	var oauthErr oauthError
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&oauthErr))
+	assert.Equal(t, "invalid_client", oauthErr.Error)
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader("grant_type=password"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	s.handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&oauthErr))
+	assert.Equal(t, "unsupported_grant_type", oauthErr.Error)
+}
+
+func (s *httpSuite) TestIntrospect() {
+	t := s.T()
+	s.clientsRepo.M.On("GetClientSecretHash", "c1").Return(utils.HashAPIKeySecret("s3cret"), nil)
+	s.clientsRepo.M.On("GetClient", "c1").Return(&entities.ServiceClient{ID: "c1"}, nil)
+	s.repo.M.On("GetUserByToken", "r_uuid").Return((*entities.User)(nil), errors.New("Not found"))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader("token=r_jwt&token_type_hint=refresh_token"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth("c1", "s3cret")
+	s.handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	assert.JSONEq(t, `{"active":false}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader("token=r_jwt"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth("c1", "wrong")
+	s.handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Basic realm="goaccess"`, w.Header().Get("WWW-Authenticate"))
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader("client_id=c1&client_secret=s3cret"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	s.handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var oauthErr oauthError
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&oauthErr))
+	assert.Equal(t, "invalid_request", oauthErr.Error)
+}
+
+func (s *httpSuite) TestCreateAPIKey() {
+	t := s.T()
+	s.repo.M.On("IsValidUser", "1").Return(true, nil)
+	s.apiKeysRepo.M.On("AddAPIKey", mock.Anything, mock.Anything).Return(nil)
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, s.asAdmin(httptest.NewRequest(http.MethodPost, "/apikeys", strings.NewReader(`{"name":"ci","user_id":"1"}`))))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var body createAPIKeyResponse
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&body))
+	assert.Equal(t, "1", body.UserID)
+	assert.True(t, strings.HasPrefix(body.Key, utils.APIKeyPrefix+body.ID+"_"))
+
+	w = httptest.NewRecorder()
+	s.handler.ServeHTTP(w, s.asAdmin(httptest.NewRequest(http.MethodPost, "/apikeys", strings.NewReader(`{"name":"ci"}`))))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	s.apiKeysRepo.M.On("GetAPIKey", "unknown").Return(nil, "", nil)
+	w = httptest.NewRecorder()
+	s.handler.ServeHTTP(w, s.asAdmin(httptest.NewRequest(http.MethodDelete, "/apikeys/unknown", nil)))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func (s *httpSuite) TestSessions() {
+	t := s.T()
+	s.repo.M.On("IsValidUser", "1").Return(true, nil)
+	s.repo.M.On("GetSessions", "1").Return([]entities.Session{{ID: "s1", UserID: "1", UserAgent: "curl/7.68.0"}}, nil)
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, s.asAdmin(httptest.NewRequest(http.MethodGet, "/users/1/sessions", nil)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var sessions []entities.Session
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&sessions))
+	assert.Len(t, sessions, 1)
+	assert.Equal(t, "curl/7.68.0", sessions[0].UserAgent)
+
+	w = httptest.NewRecorder()
+	s.handler.ServeHTTP(w, s.asAdmin(httptest.NewRequest(http.MethodDelete, "/users/1/sessions/s2", nil)))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	s.repo.M.On("RevokeTokenFamily", "s1").Return(nil)
+	w = httptest.NewRecorder()
+	s.handler.ServeHTTP(w, s.asAdmin(httptest.NewRequest(http.MethodDelete, "/users/1/sessions", nil)))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	s.repo.M.AssertCalled(t, "RevokeTokenFamily", "s1")
+}
+
+func (s *httpSuite) TestSetAccountStatus() {
+	t := s.T()
+	s.repo.M.On("IsValidUser", "1").Return(true, nil)
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, s.asAdmin(httptest.NewRequest(http.MethodPut, "/users/1/status", strings.NewReader(`{"status":"unknown"}`))))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	s.repo.M.On("SetStatus", "1", mock.Anything).Return(nil)
+	s.repo.M.On("GetSessions", "1").Return([]entities.Session{}, nil)
+	w = httptest.NewRecorder()
+	s.handler.ServeHTTP(w, s.asAdmin(httptest.NewRequest(http.MethodPut, "/users/1/status", strings.NewReader(`{"status":"locked","reason":"suspicious activity"}`))))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var body entities.AccountStatus
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&body))
+	assert.Equal(t, entities.UserStatusLocked, body.Status)
+	assert.Equal(t, "suspicious activity", body.Reason)
+}
+
+func (s *httpSuite) TestOIDCAuthorizationCodeFlow() {
+	t := s.T()
+	srv := httptest.NewServer(s.handler)
+	defer srv.Close()
+	// Headless browser, the redirects are inspected instead of followed
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar, CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	user := &entities.User{ID: "1", Email: "srojas@gmail.com", Name: "steven rojas"}
+	s.oidcRepo.M.On("GetClient", "spa").Return(&entities.OIDCClient{ID: "spa", RedirectURIs: []string{"https://app.example.com/cb"}}, nil)
+	s.oidcRepo.M.On("GetClient", mock.Anything).Return(nil, nil)
+	s.repo.M.On("GetUserByEmail", user.Email).Return(user, nil)
+	s.repo.M.On("GetPasswordHash", "1").Return("hash:secret", nil)
+	s.repo.M.On("GetMFASecret", "1").Return("", false, nil)
+	s.repo.M.On("StoreTokens", mock.Anything).Return(nil)
+	s.repo.M.On("StoreSession", mock.Anything).Return(nil)
+	s.repo.M.On("GetUserByID", "1").Return(user, nil)
+	s.repo.M.On("GetUserByToken", "a_uuid").Return(user, nil)
+
+	res, err := client.Get(srv.URL + "/.well-known/openid-configuration")
+	assert.Nil(t, err)
+	var discovery entities.OIDCConfiguration
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&discovery))
+	res.Body.Close()
+	assert.Equal(t, "https://auth.example.com/authorize", discovery.AuthorizationEndpoint)
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"spa"},
+		"redirect_uri":          {"https://app.example.com/cb"},
+		"scope":                 {"openid profile"},
+		"state":                 {"xyz"},
+		"code_challenge":        {"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"},
+		"code_challenge_method": {"S256"},
+	}
+	res, err = client.Get(srv.URL + "/authorize?" + params.Encode())
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "DENY", res.Header.Get("X-Frame-Options"))
+
+	// Wrong passwords show the form again
+	form := url.Values{"email": {user.Email}, "password": {"wrong"}}
+	for name := range params {
+		form.Set(name, params.Get(name))
+	}
+	res, err = client.PostForm(srv.URL+"/authorize", form)
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	var stored *entities.AuthorizationCode
+	s.oidcRepo.M.On("StoreAuthorizationCode", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		stored = args.Get(1).(*entities.AuthorizationCode)
+	})
+	form.Set("password", "secret")
+	res, err = client.PostForm(srv.URL+"/authorize", form)
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusSeeOther, res.StatusCode)
+	location, err := res.Location()
+	assert.Nil(t, err)
+	assert.Equal(t, "app.example.com", location.Host)
+	assert.Equal(t, "xyz", location.Query().Get("state"))
+	code := location.Query().Get("code")
+
+	s.oidcRepo.M.On("TakeAuthorizationCode", utils.HashToken(code)).Return(stored, nil)
+	res, err = client.PostForm(srv.URL+"/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {"spa"},
+		"code":          {code},
+		"redirect_uri":  {"https://app.example.com/cb"},
+		"code_verifier": {"dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var token entities.OAuthToken
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&token))
+	res.Body.Close()
+	assert.Equal(t, "id_jwt", token.IDToken)
+	assert.Equal(t, "r_jwt", token.RefreshToken)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	res, err = client.Do(req)
+	assert.Nil(t, err)
+	var info entities.UserInfo
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&info))
+	res.Body.Close()
+	assert.Equal(t, "1", info.Sub)
+	assert.Equal(t, "steven rojas", info.Name)
+}
+
+func (s *httpSuite) TestOIDCAuthorizationErrors() {
+	t := s.T()
+	s.oidcRepo.M.On("GetClient", "spa").Return(&entities.OIDCClient{ID: "spa", RedirectURIs: []string{"https://app.example.com/cb"}}, nil)
+	s.oidcRepo.M.On("GetClient", mock.Anything).Return(nil, nil)
+
+	// Unregistered redirect URIs are never redirected to
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/authorize?client_id=spa&redirect_uri=https://evil.example.com/cb", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Empty(t, w.Header().Get("Location"))
+
+	w = httptest.NewRecorder()
+	s.handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet,
+		"/authorize?client_id=spa&redirect_uri=https://app.example.com/cb&response_type=token&state=xyz", nil))
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	location, _ := url.Parse(w.Header().Get("Location"))
+	assert.Equal(t, "unsupported_response_type", location.Query().Get("error"))
+	assert.Equal(t, "xyz", location.Query().Get("state"))
+
+	s.oidcRepo.M.On("TakeAuthorizationCode", mock.Anything).Return(nil, nil)
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader("grant_type=authorization_code&client_id=spa&code=unknown"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	s.handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var oauthErr oauthError
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&oauthErr))
+	assert.Equal(t, "invalid_grant", oauthErr.Error)
+}
+
+func (s *httpSuite) TestFederatedLogin() {
+	t := s.T()
+	provider := utils.NewOIDCProviderMock("goaccess", "s3cret")
+	defer provider.Close()
+	provider.SetClaims(jwt.MapClaims{"sub": "idp-1", "email": "srojas@gmail.com", "email_verified": true})
+	config := configuration.FederationConfig{
+		Issuer:               provider.URL(),
+		ClientID:             "goaccess",
+		ClientSecret:         "s3cret",
+		RedirectURL:          "https://auth.example.com/federation/callback",
+		Scopes:               "openid email",
+		RequireVerifiedEmail: true,
+		StateExpiration:      10,
+		KeysCacheMinutes:     60,
+	}
+	authentication := newAuthenticationService(s.repo)
+	srv := httptest.NewServer(NewHTTPHandler(Services{
+		Authentication: authentication,
+		Federation:     service.NewFederationService(s.oidcRepo, s.repo, authentication, utils.NewOIDCIssuer(config), config),
+	}, nil, configuration.NewLogger(configuration.ServerConfig{LogLevel: 3})))
+	defer srv.Close()
+	// Headless browser, the redirects are inspected instead of followed
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar, CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	var pending *entities.FederationState
+	s.oidcRepo.M.On("StoreFederationState", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		pending = args.Get(1).(*entities.FederationState)
+	})
+	user := &entities.User{ID: "1", Email: "srojas@gmail.com"}
+	s.repo.M.On("GetUserByEmail", user.Email).Return(user, nil)
+	s.oidcRepo.M.On("GetFederatedUser", provider.URL(), "idp-1").Return("", nil)
+	s.oidcRepo.M.On("GetFederatedSubject", provider.URL(), "1").Return("", nil)
+	s.oidcRepo.M.On("LinkFederatedUser", provider.URL(), "idp-1", "1").Return(nil)
+	s.repo.M.On("GetMFASecret", "1").Return("", false, nil)
+	s.repo.M.On("StoreTokens", mock.Anything).Return(nil)
+	s.repo.M.On("StoreSession", mock.Anything).Return(nil)
+
+	res, err := client.Get(srv.URL + "/federation/login")
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	assert.True(t, strings.HasPrefix(res.Header.Get("Location"), provider.URL()+"/authorize?"))
+	if assert.Len(t, res.Cookies(), 1) {
+		cookie := res.Cookies()[0]
+		assert.True(t, cookie.HttpOnly)
+		assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+		authorize, _ := url.Parse(res.Header.Get("Location"))
+		assert.Equal(t, utils.HashToken(authorize.Query().Get("state")), cookie.Value)
+	}
+
+	// The provider logs the user in and redirects it back to the callback
+	res, err = client.Get(res.Header.Get("Location"))
+	assert.Nil(t, err)
+	res.Body.Close()
+	callback, _ := url.Parse(res.Header.Get("Location"))
+	assert.Equal(t, "/federation/callback", callback.Path)
+
+	// Login CSRF, the callback is refused in a browser that didn't start the login
+	other := &http.Client{CheckRedirect: client.CheckRedirect}
+	res, err = other.Get(srv.URL + "/federation/callback?" + callback.RawQuery)
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	s.oidcRepo.M.AssertNotCalled(t, "TakeFederationState", mock.Anything)
+
+	s.oidcRepo.M.On("TakeFederationState", utils.HashToken(callback.Query().Get("state"))).Return(pending, nil).Once()
+	res, err = client.Get(srv.URL + "/federation/callback?" + callback.RawQuery)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "no-store", res.Header.Get("Cache-Control"))
+	var loggedUser loggedUserResponse
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&loggedUser))
+	res.Body.Close()
+	assert.Equal(t, "1", loggedUser.User.ID)
+	assert.Equal(t, "a_jwt", loggedUser.Token.Access)
+	s.oidcRepo.M.AssertCalled(t, "LinkFederatedUser", provider.URL(), "idp-1", "1")
+
+	// Errors of the provider and reused states
+	res, err = client.Get(srv.URL + "/federation/callback?error=access_denied&error_description=User+cancelled&state=x")
+	assert.Nil(t, err)
+	var body errorResponse
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&body))
+	res.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.Equal(t, "Federated login failed: access_denied: User cancelled", body.Error.Message)
+	s.oidcRepo.M.On("TakeFederationState", mock.Anything).Return(nil, nil)
+	res, err = client.Get(srv.URL + "/federation/callback?" + callback.RawQuery)
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+func (s *httpSuite) TestFederatedLoginDisabled() {
+	t := s.T()
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/federation/login", nil))
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseNetworks([]string{"10.0.0.0/8", "192.168.1.10"})
+	assert.Nil(t, err)
+	h := &httpHandler{trustedProxies: proxies}
+	cases := []struct {
+		remote    string
+		forwarded []string
+		expected  string
+	}{
+		{"203.0.113.7:1234", nil, "203.0.113.7"},
+		// The header of an untrusted client is ignored
+		{"203.0.113.7:1234", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"192.168.1.10:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		// A spoofed hop before the one the proxy added is ignored
+		{"10.0.0.2:1234", []string{"1.1.1.1, 198.51.100.1, 10.0.0.3"}, "198.51.100.1"},
+		{"10.0.0.2:1234", []string{"1.1.1.1", "198.51.100.1"}, "198.51.100.1"},
+		{"10.0.0.2:1234", []string{"not-an-ip"}, "10.0.0.2"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+		r.RemoteAddr = c.remote
+		for _, value := range c.forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		assert.Equal(t, c.expected, h.clientIP(r), c.remote, c.forwarded)
+	}
+	_, err = parseNetworks([]string{"10.0.0.300"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/StevenRojas/goaccess/pkg/utils"
+	"github.com/gorilla/mux"
+)
+
+type contextKey string
+
+const userIDContextKey contextKey = "user_id"
+const clientIDContextKey contextKey = "client_id"
+
+// ActionMapper maps a request to the action string to check
+type ActionMapper func(r *http.Request) string
+
+type authMiddleware struct {
+	httpHandler
+	mapper ActionMapper
+}
+
+// DefaultActionMapper maps the request method and path to an action, e.g. DELETE /brand/3 is delete:brand:[]
+func DefaultActionMapper(r *http.Request) string {
+	return utils.RequestAction(r.Method, r.URL.Path)
+}
+
+// PrefixActionMapper maps the request like DefaultActionMapper after removing a path prefix, e.g. /api/v1
+func PrefixActionMapper(prefix string) ActionMapper {
+	return func(r *http.Request) string {
+		return utils.RequestAction(r.Method, strings.TrimPrefix(r.URL.Path, prefix))
+	}
+}
+
+// NewAuthMiddleware return a middleware that verifies the bearer token and checks if the user
+// has permission to perform the requested action. GET requests are only authenticated because
+// they are controlled on the module > submodule > section access. Internal tokens of service clients
+// are accepted too, the action must be one of the token scopes or be allowed by the client roles.
+// A nil mapper uses DefaultActionMapper
+func NewAuthMiddleware(services Services, logger configuration.LoggerWrapper, mapper ActionMapper) func(http.Handler) http.Handler {
+	if mapper == nil {
+		mapper = DefaultActionMapper
+	}
+	m := &authMiddleware{
+		httpHandler: httpHandler{
+			services: services,
+			logger:   logger,
+		},
+		mapper: mapper,
+	}
+	return m.wrap
+}
+
+// UserIDFromContext get the ID of the user authenticated by the middleware
+func UserIDFromContext(ctx context.Context) (string, bool) {
+	userID, ok := ctx.Value(userIDContextKey).(string)
+	return userID, ok
+}
+
+// ClientIDFromContext get the ID of the service client authenticated by the middleware
+func ClientIDFromContext(ctx context.Context) (string, bool) {
+	clientID, ok := ctx.Value(clientIDContextKey).(string)
+	return clientID, ok
+}
+
+func (m *authMiddleware) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if token == "" {
+			m.encodeError(w, newHTTPError(http.StatusUnauthorized, errors.New("Missing bearer token")))
+			return
+		}
+		userID, err := m.services.Authentication.VerifyToken(r.Context(), token)
+		if err != nil {
+			if m.services.InternalAuthentication != nil {
+				if identity, ierr := m.services.InternalAuthentication.VerifyInternalToken(r.Context(), token); ierr == nil {
+					m.serveClient(w, r, next, identity)
+					return
+				}
+			}
+			m.encodeError(w, err)
+			return
+		}
+		if !isReadOnly(r) {
+			action := m.mapper(r)
+			allowed, err := m.services.Authorization.CheckPermission(r.Context(), action, userID)
+			if err != nil {
+				m.encodeError(w, err)
+				return
+			}
+			if !allowed {
+				m.encodeError(w, newHTTPError(http.StatusForbidden, errors.New("Permission denied: "+action)))
+				return
+			}
+		}
+		ctx := context.WithValue(r.Context(), userIDContextKey, userID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// serveClient check the requested action is one of the service client scopes or it is allowed by its roles
+func (m *authMiddleware) serveClient(w http.ResponseWriter, r *http.Request, next http.Handler, identity *entities.InternalIdentity) {
+	if !isReadOnly(r) {
+		action := m.mapper(r)
+		allowed, err := clientAllowed(r.Context(), m.services, identity, action)
+		if err != nil {
+			m.encodeError(w, err)
+			return
+		}
+		if !allowed {
+			m.encodeError(w, newHTTPError(http.StatusForbidden, errors.New("Permission denied: "+action)))
+			return
+		}
+	}
+	ctx := context.WithValue(r.Context(), clientIDContextKey, identity.ClientID)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// routeAccess users that can call a goaccess route
+type routeAccess int
+
+const (
+	// adminAccess admin users and the service clients allowed to perform the route action
+	adminAccess routeAccess = iota
+	// adminOrOwnerAccess like adminAccess plus the user of the {userID} route variable
+	adminOrOwnerAccess
+	// ownerAccess the user of the {userID} route variable only
+	ownerAccess
+)
+
+// errMissingToken returned when a goaccess route or gRPC method is called without a bearer token
+var errMissingToken = errors.New("Missing bearer token")
+
+// permissionError returned when the bearer token has no access to a goaccess route or gRPC method
+type permissionError struct {
+	action string // action a service client is not allowed to perform
+}
+
+func (e *permissionError) Error() string {
+	if e.action == "" {
+		return "Permission denied"
+	}
+	return "Permission denied: " + e.action
+}
+
+// guard protect a goaccess route, the bearer token must be of a user with the given access or, unless only the
+// owner has access, of a service client allowed to perform the route action by its scopes or roles. The
+// authenticated user or client is set in the context like the auth middleware does
+func (h *httpHandler) guard(access routeAccess, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, err := authorize(r.Context(), h.services, access, bearerToken(r), mux.Vars(r)["userID"], DefaultActionMapper(r))
+		switch err.(type) {
+		case nil:
+			next(w, r.WithContext(ctx))
+		case *permissionError:
+			h.encodeError(w, newHTTPError(http.StatusForbidden, err))
+		default:
+			if err == errMissingToken {
+				err = newHTTPError(http.StatusUnauthorized, err)
+			}
+			h.encodeError(w, err)
+		}
+	}
+}
+
+// authorize check the bearer token is of a user with the given access to the resources of the owner or, unless
+// only the owner has access, of a service client allowed to perform the action by its scopes or roles. Returns
+// the context with the authenticated user or client
+func authorize(ctx context.Context, services Services, access routeAccess, token string, ownerID string, action string) (context.Context, error) {
+	if token == "" {
+		return nil, errMissingToken
+	}
+	userID, err := services.Authentication.VerifyToken(ctx, token)
+	if err != nil {
+		if access != ownerAccess && services.InternalAuthentication != nil {
+			if identity, ierr := services.InternalAuthentication.VerifyInternalToken(ctx, token); ierr == nil {
+				allowed, err := clientAllowed(ctx, services, identity, action)
+				if err != nil {
+					return nil, err
+				}
+				if !allowed {
+					return nil, &permissionError{action: action}
+				}
+				return context.WithValue(ctx, clientIDContextKey, identity.ClientID), nil
+			}
+		}
+		return nil, err
+	}
+	allowed := access != adminAccess && ownerID != "" && ownerID == userID
+	if !allowed && access != ownerAccess {
+		if allowed, err = services.Authentication.IsAdmin(ctx, userID); err != nil {
+			return nil, err
+		}
+	}
+	if !allowed {
+		return nil, &permissionError{}
+	}
+	return context.WithValue(ctx, userIDContextKey, userID), nil
+}
+
+// clientAllowed check an action is one of the service client scopes or it is allowed by its roles
+func clientAllowed(ctx context.Context, services Services, identity *entities.InternalIdentity, action string) (bool, error) {
+	for _, scope := range identity.Scopes {
+		if scope == action {
+			return true, nil
+		}
+	}
+	if services.Authorization == nil {
+		return false, nil
+	}
+	return services.Authorization.CheckPermission(ctx, action, identity.Subject)
+}
+
+func isReadOnly(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+}
+
+// bearerToken get the token from the Authorization header
+func bearerToken(r *http.Request) string {
+	return parseBearer(r.Header.Get("Authorization"))
+}
+
+// parseBearer get the token of a "Bearer <token>" authorization value, empty for other schemes
+func parseBearer(header string) string {
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[7:])
+}
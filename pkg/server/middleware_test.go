@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/StevenRojas/goaccess/pkg/repository"
+	"github.com/StevenRojas/goaccess/pkg/service"
+	"github.com/StevenRojas/goaccess/pkg/utils"
+	"github.com/rs/xid"
+	"github.com/stretchr/testify/assert"
+)
+
+// authorizationStub allows only the given actions
+type authorizationStub struct {
+	service.AuthorizationService
+	allowed map[string]bool
+}
+
+func (a *authorizationStub) CheckPermission(ctx context.Context, action string, userID string) (bool, error) {
+	return a.allowed[action], nil
+}
+
+func newMiddlewareHandler(allowed map[string]bool) http.Handler {
+	repo := new(repository.UsersRepoMock)
+	repo.M.On("GetUserByToken", "a_uuid").Return(&entities.User{ID: "1"}, nil)
+	middleware := NewAuthMiddleware(Services{
+		Authentication: newAuthenticationService(repo),
+		Authorization:  &authorizationStub{allowed: allowed},
+	}, configuration.NewLogger(configuration.ServerConfig{LogLevel: 3}), nil)
+	return middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := UserIDFromContext(r.Context())
+		w.Write([]byte(userID))
+	}))
+}
+
+func TestRequestAction(t *testing.T) {
+	assert.Equal(t, "put:vehicle:[]", utils.RequestAction("PUT", "/vehicle/42"))
+	assert.Equal(t, "delete:vehicle:[]:photo:[]", utils.RequestAction("DELETE", "/vehicle/42/photo/bua7kgbc1osgrba1e160"))
+	assert.Equal(t, "post:brand", utils.RequestAction("POST", "/brand/"))
+	assert.Equal(t, "get:vehicle:[]", utils.RequestAction("GET", "/vehicle/"+xid.New().String()))
+	assert.Equal(t, "get:reports:abcdefghijklmnopqrst", utils.RequestAction("GET", "/reports/abcdefghijklmnopqrst"))
+}
+
+func TestMiddlewareMissingToken(t *testing.T) {
+	w := httptest.NewRecorder()
+	newMiddlewareHandler(nil).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/brand", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestMiddlewareGetIsOnlyAuthenticated(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/brand/3", nil)
+	r.Header.Set("Authorization", "Bearer a_jwt")
+	newMiddlewareHandler(nil).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Body.String())
+}
+
+func TestMiddlewarePermissionDenied(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodDelete, "/brand/3", nil)
+	r.Header.Set("Authorization", "Bearer a_jwt")
+	newMiddlewareHandler(map[string]bool{"post:brand": true}).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestMiddlewarePermissionAllowed(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodDelete, "/brand/3", nil)
+	r.Header.Set("Authorization", "Bearer a_jwt")
+	newMiddlewareHandler(map[string]bool{"delete:brand:[]": true}).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// internalAuthenticationStub accepts the i_jwt token for client c1 with the given scopes
+type internalAuthenticationStub struct {
+	service.InternalAuthenticationService
+	scopes []string
+}
+
+func (a *internalAuthenticationStub) VerifyInternalToken(ctx context.Context, token string) (*entities.InternalIdentity, error) {
+	if token != "i_jwt" {
+		return nil, service.ErrInvalidToken
+	}
+	return &entities.InternalIdentity{ClientID: "c1", Subject: "client:c1", Scopes: a.scopes}, nil
+}
+
+func newClientMiddlewareHandler(scopes []string, allowed map[string]bool) http.Handler {
+	repo := new(repository.UsersRepoMock)
+	repo.M.On("GetUserByToken", "a_uuid").Return((*entities.User)(nil), nil)
+	middleware := NewAuthMiddleware(Services{
+		Authentication:         newAuthenticationService(repo),
+		InternalAuthentication: &internalAuthenticationStub{scopes: scopes},
+		Authorization:          &authorizationStub{allowed: allowed},
+	}, configuration.NewLogger(configuration.ServerConfig{LogLevel: 3}), nil)
+	return middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientID, _ := ClientIDFromContext(r.Context())
+		w.Write([]byte(clientID))
+	}))
+}
+
+func TestMiddlewareClientScopes(t *testing.T) {
+	handler := newClientMiddlewareHandler([]string{"delete:brand:[]"}, nil)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodDelete, "/brand/3", nil)
+	r.Header.Set("Authorization", "Bearer i_jwt")
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "c1", w.Body.String())
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/brand", nil)
+	r.Header.Set("Authorization", "Bearer i_jwt")
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/brand", nil)
+	r.Header.Set("Authorization", "Bearer other")
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestMiddlewareClientRoles(t *testing.T) {
+	// The action is not a scope of the token but it is allowed by the client roles
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/brand", nil)
+	r.Header.Set("Authorization", "Bearer i_jwt")
+	newClientMiddlewareHandler(nil, map[string]bool{"post:brand": true}).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "c1", w.Body.String())
+}
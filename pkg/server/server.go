@@ -0,0 +1,35 @@
+package server
+
+import (
+	"github.com/StevenRojas/goaccess/pkg/service"
+)
+
+// Services services exposed by the servers
+type Services struct {
+	Authentication         service.AuthenticationService
+	InternalAuthentication service.InternalAuthenticationService
+	Introspection          service.IntrospectionService
+	OIDC                   service.OIDCService
+	Federation             service.FederationService
+	APIKeys                service.APIKeysService
+	Access                 service.AccessService
+	Authorization          service.AuthorizationService
+	Initialization         service.InitializationService
+}
+
+// NewServices create the services exposed by the servers using the given factory
+func NewServices(factory service.ServicesFactory) Services {
+	authentication := factory.CreateAuthenticationService()
+	internal := factory.CreateInternalAuthenticationService()
+	return Services{
+		Authentication:         authentication,
+		InternalAuthentication: internal,
+		Introspection:          factory.CreateIntrospectionService(authentication, internal),
+		OIDC:                   factory.CreateOIDCService(authentication),
+		Federation:             factory.CreateFederationService(authentication),
+		APIKeys:                factory.CreateAPIKeysService(),
+		Access:                 factory.CreateAccessService(),
+		Authorization:          factory.CreateAuthorizationService(),
+		Initialization:         factory.CreateInitializationService(),
+	}
+}
@@ -2,11 +2,12 @@ package service
 
 import (
 	"context"
-	"errors"
 
+	"github.com/StevenRojas/goaccess/pkg/configuration"
 	"github.com/StevenRojas/goaccess/pkg/entities"
 	"github.com/StevenRojas/goaccess/pkg/events"
 	"github.com/StevenRojas/goaccess/pkg/repository"
+	"github.com/StevenRojas/goaccess/pkg/utils"
 )
 
 // AccessService access service to handle modules, submodules and sections
@@ -55,21 +56,28 @@ type access struct {
 	modulesRepo    repository.ModulesRepository
 	rolesRepo      repository.RolesRepository
 	actionsRepo    repository.ActionsRepository
+	usersRepo      repository.UsersRepository
 	subscriberFeed events.SubscriberFeed
+	versioned      bool // the tokens carry the roles or permissions of the users
 }
 
-// NewAccessService return a new access service instance
+// NewAccessService return a new access service instance, the token version of the users of a deleted role is
+// increased only when the tokens carry their roles
 func NewAccessService(
 	modulesRepo repository.ModulesRepository,
 	rolesRepo repository.RolesRepository,
 	actionsRepo repository.ActionsRepository,
+	usersRepo repository.UsersRepository,
 	subscriberFeed events.SubscriberFeed,
+	securityConfig configuration.SecurityConfig,
 ) AccessService {
 	return &access{
 		modulesRepo:    modulesRepo,
 		rolesRepo:      rolesRepo,
 		actionsRepo:    actionsRepo,
+		usersRepo:      usersRepo,
 		subscriberFeed: subscriberFeed,
+		versioned:      utils.VersionedClaims(securityConfig),
 	}
 }
 
@@ -105,10 +113,21 @@ func (a *access) IsRoleExist(ctx context.Context, ID string) (bool, error) {
 
 // DeleteRole removes a role and its relation with users
 func (a *access) DeleteRole(ctx context.Context, ID string) error {
-	err := a.rolesRepo.DeleteRole(ctx, ID)
+	var users []string
+	var err error
+	if a.versioned {
+		// The users lose the role, their tokens are stale once it is deleted
+		if users, err = a.rolesRepo.UsersByRole(ctx, ID); err != nil {
+			return err
+		}
+	}
+	err = a.rolesRepo.DeleteRole(ctx, ID)
 	if err != nil {
 		return err
 	}
+	if err = incrementTokenVersions(ctx, a.usersRepo, users); err != nil {
+		return err
+	}
 	roleEvent := &entities.RoleEvent{RoleID: ID}
 	go a.subscriberFeed.Send(roleEvent)
 	return nil
@@ -117,7 +136,7 @@ func (a *access) DeleteRole(ctx context.Context, ID string) error {
 // AssignModules assign a module to a role
 func (a *access) AssignModules(ctx context.Context, roleID string, modules []string) error {
 	if ok, _ := a.rolesRepo.IsValidRole(ctx, roleID); !ok {
-		return errors.New("Role not found")
+		return ErrRoleNotFound
 	}
 	for _, module := range modules {
 		err := a.modulesRepo.AssignModule(ctx, roleID, module)
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/StevenRojas/goaccess/pkg/events"
+	"github.com/StevenRojas/goaccess/pkg/repository"
+	"github.com/StevenRojas/goaccess/pkg/utils"
+	"github.com/rs/xid"
+)
+
+// APIKeysService service interface for long-lived API keys used by scripts and CI pipelines,
+// the keys are accepted by AuthenticationService.VerifyToken
+type APIKeysService interface {
+	// CreateAPIKey create an API key that authenticates as a user or as a set of roles and return it with
+	// the key, the key is not stored and can't be retrieved later. A nil expiration never expires
+	CreateAPIKey(ctx context.Context, name string, userID string, roles []string, expiresAt *time.Time) (*entities.APIKey, string, error)
+	// ListAPIKeys get a list of the API keys, only the ones of the user when userID is not empty
+	ListAPIKeys(ctx context.Context, userID string) ([]entities.APIKey, error)
+	// RevokeAPIKey delete an API key and its role assignments, it is no longer accepted
+	RevokeAPIKey(ctx context.Context, keyID string) error
+	// ExpireAPIKey set the time when an API key expires, e.g. to give a grace period while it is replaced
+	ExpireAPIKey(ctx context.Context, keyID string, expiresAt time.Time) error
+}
+
+type apiKeys struct {
+	repo           repository.APIKeysRepository
+	usersRepo      repository.UsersRepository
+	rolesRepo      repository.RolesRepository
+	subscriberFeed events.SubscriberFeed
+}
+
+// NewAPIKeysService return a new API keys service instance
+func NewAPIKeysService(
+	apiKeysRepo repository.APIKeysRepository,
+	usersRepo repository.UsersRepository,
+	rolesRepo repository.RolesRepository,
+	subscriberFeed events.SubscriberFeed,
+) APIKeysService {
+	return &apiKeys{
+		repo:           apiKeysRepo,
+		usersRepo:      usersRepo,
+		rolesRepo:      rolesRepo,
+		subscriberFeed: subscriberFeed,
+	}
+}
+
+// CreateAPIKey create an API key that authenticates as a user or as a set of roles and return it with the key
+func (ak *apiKeys) CreateAPIKey(ctx context.Context, name string, userID string, roles []string, expiresAt *time.Time) (*entities.APIKey, string, error) {
+	errs := url.Values{}
+	if strings.TrimSpace(name) == "" {
+		errs.Add("name", "The name field is required")
+	}
+	if (userID == "") == (len(roles) == 0) {
+		errs.Add("user_id", "Either a user ID or a list of roles is required")
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		errs.Add("expires_at", "The expiration must be in the future")
+	}
+	if len(errs) > 0 {
+		return nil, "", &ValidationError{Errors: errs}
+	}
+	if userID != "" {
+		if ok, _ := ak.usersRepo.IsValidUser(ctx, userID); !ok {
+			return nil, "", ErrUserNotFound
+		}
+	}
+	for _, roleID := range roles {
+		if ok, _ := ak.rolesRepo.IsValidRole(ctx, roleID); !ok {
+			return nil, "", ErrRoleNotFound
+		}
+	}
+	key := &entities.APIKey{
+		ID:        xid.New().String(),
+		Name:      name,
+		UserID:    userID,
+		Roles:     roles,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+	secret, hash, err := utils.NewAPIKey(key.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	if err = ak.repo.AddAPIKey(ctx, key, hash); err != nil {
+		return nil, "", err
+	}
+	subject := entities.APIKeySubjectPrefix + key.ID
+	for _, roleID := range roles {
+		if err = ak.rolesRepo.AssignRole(ctx, subject, roleID); err != nil {
+			return nil, "", err
+		}
+		ak.sendRoleEvents(subject, roleID)
+	}
+	return key, secret, nil
+}
+
+// ListAPIKeys get a list of the API keys, only the ones of the user when userID is not empty
+func (ak *apiKeys) ListAPIKeys(ctx context.Context, userID string) ([]entities.APIKey, error) {
+	keys, err := ak.repo.GetAPIKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := []entities.APIKey{}
+	for _, key := range keys {
+		if userID != "" && key.UserID != userID {
+			continue
+		}
+		if key.UserID == "" {
+			if key.Roles, err = ak.keyRoles(ctx, key.ID); err != nil {
+				return nil, err
+			}
+		}
+		list = append(list, key)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list, nil
+}
+
+// RevokeAPIKey delete an API key and its role assignments, it is no longer accepted
+func (ak *apiKeys) RevokeAPIKey(ctx context.Context, keyID string) error {
+	key, _, err := ak.repo.GetAPIKey(ctx, keyID)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return ErrAPIKeyNotFound
+	}
+	roles, err := ak.keyRoles(ctx, keyID)
+	if err != nil {
+		return err
+	}
+	subject := entities.APIKeySubjectPrefix + keyID
+	for _, roleID := range roles {
+		if err = ak.rolesRepo.UnassignRole(ctx, subject, roleID); err != nil {
+			return err
+		}
+		ak.sendRoleEvents(subject, roleID)
+	}
+	return ak.repo.DeleteAPIKey(ctx, keyID)
+}
+
+// ExpireAPIKey set the time when an API key expires
+func (ak *apiKeys) ExpireAPIKey(ctx context.Context, keyID string, expiresAt time.Time) error {
+	key, _, err := ak.repo.GetAPIKey(ctx, keyID)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return ErrAPIKeyNotFound
+	}
+	return ak.repo.SetAPIKeyExpiration(ctx, keyID, expiresAt)
+}
+
+func (ak *apiKeys) keyRoles(ctx context.Context, keyID string) ([]string, error) {
+	roles, err := ak.rolesRepo.RolesByUser(ctx, entities.APIKeySubjectPrefix+keyID)
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for id := range roles {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// sendRoleEvents update the access and action lists of the key like the role listeners do for users
+func (ak *apiKeys) sendRoleEvents(subject string, roleID string) {
+	go ak.subscriberFeed.Send(&entities.RoleEvent{RoleID: roleID, UserID: subject, EventType: entities.EventTypeAccess})
+	go ak.subscriberFeed.Send(&entities.RoleEvent{RoleID: roleID, UserID: subject, EventType: entities.EventTypeAction})
+}
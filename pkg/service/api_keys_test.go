@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/StevenRojas/goaccess/pkg/events"
+	"github.com/StevenRojas/goaccess/pkg/repository"
+	"github.com/StevenRojas/goaccess/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type apiKeysSuite struct {
+	svc   APIKeysService
+	auth  AuthenticationService
+	repo  *repository.APIKeysRepoMock
+	users *repository.UsersRepoMock
+	roles *rolesRepoStub
+	suite.Suite
+}
+
+func (s *apiKeysSuite) SetupTest() {
+	s.repo = new(repository.APIKeysRepoMock)
+	s.users = new(repository.UsersRepoMock)
+	s.roles = &rolesRepoStub{assigned: map[string]map[string]string{}}
+	s.svc = NewAPIKeysService(s.repo, s.users, s.roles, events.NewSubscriber())
+	policy, _ := utils.NewPasswordPolicy(configuration.PasswordPolicyConfig{})
+	s.auth = NewAuthenticationService(
+		s.users,
+		s.repo,
+		utils.NewJwtHandlerMock(configuration.SecurityConfig{}),
+		nil,
+		utils.NewPasswordHasherMock(),
+		policy,
+		utils.NewLoginThrottle(configuration.LoginThrottleConfig{}, nil),
+		utils.NewRateLimiter(nil, 0, 0),
+		utils.NewTOTPHandler(configuration.MFAConfig{}),
+		configuration.MFAConfig{},
+		utils.NewMailerMock(),
+		configuration.EmailTokensConfig{},
+		events.NewSecurityFeed(),
+	)
+}
+
+func TestAPIKeysService(t *testing.T) {
+	suite.Run(t, new(apiKeysSuite))
+}
+
+// createKey create an API key and return it with its stored record and hash
+func (s *apiKeysSuite) createKey(userID string, roles []string, expiresAt *time.Time) (*entities.APIKey, string, string) {
+	var hash string
+	s.repo.M.On("AddAPIKey", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		hash = args.String(1)
+	}).Return(nil).Once()
+	key, secret, err := s.svc.CreateAPIKey(context.TODO(), "ci", userID, roles, expiresAt)
+	assert.Nil(s.T(), err)
+	return key, secret, hash
+}
+
+func (s *apiKeysSuite) TestCreateValidation() {
+	t := s.T()
+	_, _, err := s.svc.CreateAPIKey(context.TODO(), "ci", "", nil, nil)
+	_, ok := err.(*ValidationError)
+	assert.True(t, ok)
+	_, _, err = s.svc.CreateAPIKey(context.TODO(), "ci", "1", []string{"r1"}, nil)
+	_, ok = err.(*ValidationError)
+	assert.True(t, ok)
+	past := time.Now().Add(-time.Minute)
+	_, _, err = s.svc.CreateAPIKey(context.TODO(), "ci", "1", nil, &past)
+	_, ok = err.(*ValidationError)
+	assert.True(t, ok)
+	_, _, err = s.svc.CreateAPIKey(context.TODO(), "ci", "", []string{"unknown"}, nil)
+	assert.Equal(t, ErrRoleNotFound, err)
+	s.repo.M.AssertNumberOfCalls(t, "AddAPIKey", 0)
+}
+
+func (s *apiKeysSuite) TestUserKey() {
+	t := s.T()
+	s.users.M.On("IsValidUser", "1").Return(true, nil)
+	key, secret, hash := s.createKey("1", nil, nil)
+	assert.NotContains(t, hash, secret)
+	s.repo.M.On("GetAPIKey", key.ID).Return(key, hash, nil)
+	s.repo.M.On("TouchAPIKey", key.ID).Return(nil)
+	s.users.M.On("GetUserByID", "1").Return(&entities.User{ID: "1", Status: entities.UserStatusActive}, nil).Once()
+
+	userID, err := s.auth.VerifyToken(context.TODO(), secret)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", userID)
+	s.repo.M.AssertNumberOfCalls(t, "TouchAPIKey", 1)
+
+	// A key with the right ID and another secret is rejected
+	forged, _, _ := utils.NewAPIKey(key.ID)
+	_, err = s.auth.VerifyToken(context.TODO(), forged)
+	assert.Equal(t, ErrExpiredToken, err)
+	s.repo.M.AssertNumberOfCalls(t, "TouchAPIKey", 1)
+
+	// Keys of disabled users are rejected
+	s.users.M.On("GetUserByID", "1").Return(&entities.User{ID: "1", Status: entities.UserStatusDisabled}, nil)
+	_, err = s.auth.VerifyToken(context.TODO(), secret)
+	assert.Equal(t, ErrAccountDisabled, err)
+}
+
+func (s *apiKeysSuite) TestRoleKey() {
+	t := s.T()
+	key, secret, hash := s.createKey("", []string{"r1", "r2"}, nil)
+	subject := entities.APIKeySubjectPrefix + key.ID
+	assert.Len(t, s.roles.assigned[subject], 2)
+	s.repo.M.On("GetAPIKey", key.ID).Return(key, hash, nil)
+	s.repo.M.On("TouchAPIKey", key.ID).Return(nil)
+
+	id, err := s.auth.VerifyToken(context.TODO(), secret)
+	assert.Nil(t, err)
+	assert.Equal(t, subject, id)
+
+	s.repo.M.On("GetAPIKeys").Return([]entities.APIKey{*key, {ID: "k2", UserID: "1"}}, nil)
+	keys, err := s.svc.ListAPIKeys(context.TODO(), "")
+	assert.Nil(t, err)
+	assert.Len(t, keys, 2)
+	keys, err = s.svc.ListAPIKeys(context.TODO(), "1")
+	assert.Nil(t, err)
+	assert.Len(t, keys, 1)
+	assert.Equal(t, "k2", keys[0].ID)
+
+	s.repo.M.On("DeleteAPIKey", key.ID).Return(nil)
+	assert.Nil(t, s.svc.RevokeAPIKey(context.TODO(), key.ID))
+	assert.Empty(t, s.roles.assigned[subject])
+}
+
+func (s *apiKeysSuite) TestExpiredKey() {
+	t := s.T()
+	s.users.M.On("IsValidUser", "1").Return(true, nil)
+	key, secret, hash := s.createKey("1", nil, nil)
+	expired := time.Now().Add(-time.Second)
+	s.repo.M.On("SetAPIKeyExpiration", key.ID, expired).Return(nil)
+	s.repo.M.On("GetAPIKey", key.ID).Return(key, hash, nil).Once()
+	assert.Nil(t, s.svc.ExpireAPIKey(context.TODO(), key.ID, expired))
+
+	key.ExpiresAt = &expired
+	s.repo.M.On("GetAPIKey", key.ID).Return(key, hash, nil)
+	_, err := s.auth.VerifyToken(context.TODO(), secret)
+	assert.Equal(t, ErrExpiredToken, err)
+	s.repo.M.AssertNumberOfCalls(t, "TouchAPIKey", 0)
+}
+
+func (s *apiKeysSuite) TestRevokeUnknownKey() {
+	s.repo.M.On("GetAPIKey", "unknown").Return(nil, "", nil)
+	assert.Equal(s.T(), ErrAPIKeyNotFound, s.svc.RevokeAPIKey(context.TODO(), "unknown"))
+}
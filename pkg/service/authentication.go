@@ -2,9 +2,20 @@ package service
 
 import (
 	"context"
-	"errors"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/StevenRojas/goaccess/pkg/events"
 	"github.com/StevenRojas/goaccess/pkg/utils"
+	"github.com/dgrijalva/jwt-go"
 
 	"github.com/StevenRojas/goaccess/pkg/repository"
 
@@ -13,111 +24,704 @@ import (
 
 // AuthenticationService service interface
 type AuthenticationService interface {
-	// Register a user
+	// Register a user, the sessions of an existing admin user are revoked when it loses admin
 	Register(context.Context, *entities.User) error
-	// Unregister a user
+	// Unregister a user removing its tokens, sessions, API keys, role memberships and permission lists
 	Unregister(context.Context, *entities.User) error
-	// Login log in a user and return access and refresh tokens or an error
-	Login(context.Context, string) (*entities.LoggedUser, error)
-	// VerifyToken check if a token is valid and the user is logged in
+	// Login log in a user by email and password and return access and refresh tokens or an MFA challenge
+	Login(context.Context, string, string) (*entities.LoggedUser, error)
+	// RequestLoginLink mail a single use passwordless login link to a user, unknown emails are ignored so they are
+	// not revealed. The requests of an account are rate limited
+	RequestLoginLink(ctx context.Context, email string) error
+	// LoginWithLink log in a user with the token of a login link, same result as Login
+	LoginWithLink(ctx context.Context, token string) (*entities.LoggedUser, error)
+	// RequestLoginCode mail a single use passwordless login code to a user, unknown emails are ignored so they are
+	// not revealed. The requests of an account are rate limited
+	RequestLoginCode(ctx context.Context, email string) error
+	// LoginWithCode log in a user with the email and a login code, wrong codes count as failed logins
+	LoginWithCode(ctx context.Context, email string, code string) (*entities.LoggedUser, error)
+	// LoginFederated log in a user authenticated by an external identity provider, same result as Login without
+	// checking a password
+	LoginFederated(ctx context.Context, user *entities.User) (*entities.LoggedUser, error)
+	// LoginMFA complete a login using the MFA challenge and a TOTP or recovery code
+	LoginMFA(ctx context.Context, challenge string, code string) (*entities.LoggedUser, error)
+	// VerifyToken check if a token is valid and the user is logged in, API keys are accepted too. Returns the ID
+	// used to check the permissions, the user ID or apikey:<keyID> for the keys created for a set of roles
 	VerifyToken(context.Context, string) (string, error)
-	// Refresh refresh a token
+	// IsAdmin check if a user is an active admin, false for the API keys created for a set of roles
+	IsAdmin(ctx context.Context, userID string) (bool, error)
+	// IntrospectToken get the state of an access token, refresh token or API key (RFC 7662), the roles are not set
+	IntrospectToken(context.Context, string) (*entities.TokenIntrospection, error)
+	// RefreshToken rotate a refresh token, reusing a rotated token revokes all the tokens of its family
 	RefreshToken(context.Context, string) (*entities.Token, error)
-	// Logout log out a user for a given token
+	// Logout log out a user for a given token, its session ends
 	Logout(context.Context, *entities.Token) error
+	// ListSessions get the active sessions of a user
+	ListSessions(ctx context.Context, userID string) ([]entities.Session, error)
+	// RevokeSession end a session of a user, its access and refresh tokens are no longer accepted
+	RevokeSession(ctx context.Context, userID string, sessionID string) error
+	// RevokeAllSessions end all the sessions of a user, e.g. when the account is compromised
+	RevokeAllSessions(ctx context.Context, userID string) error
+	// Impersonate issue an access token of a user to an admin, the token carries both identities and can't be
+	// refreshed. Every impersonation is recorded in the audit log
+	Impersonate(ctx context.Context, adminID string, targetUserID string) (*entities.ImpersonationToken, error)
+	// ListImpersonations get the impersonations audit log newest first, only the ones made by or to a user when it is
+	// not empty
+	ListImpersonations(ctx context.Context, userID string) ([]entities.Impersonation, error)
+	// GetJWKS get the public keys to verify the access tokens, empty when they are signed with a shared secret
+	GetJWKS(context.Context) (*entities.JWKS, error)
+	// RotateSigningKey replace the signing key if it is older than maxAge, 0 to always rotate. Returns the active key ID
+	RotateSigningKey(ctx context.Context, maxAge time.Duration) (string, error)
+	// GetAccountStatus get the account status of a user
+	GetAccountStatus(ctx context.Context, userID string) (*entities.AccountStatus, error)
+	// SetAccountStatus change the account status of a user, the sessions of an account that is no longer
+	// active are revoked
+	SetAccountStatus(ctx context.Context, userID string, status string, reason string) (*entities.AccountStatus, error)
+	// SetPassword set the password of a user without checking the current one
+	SetPassword(ctx context.Context, userID string, password string) error
+	// ChangePassword change the password of a user after verifying the current one
+	ChangePassword(ctx context.Context, userID string, oldPassword string, newPassword string) error
+	// RequestPasswordReset mail a single use password reset token to a user, unknown emails are ignored so they
+	// are not revealed
+	RequestPasswordReset(ctx context.Context, email string) error
+	// ResetPassword set a new password using a reset token, all the sessions of the user are revoked
+	ResetPassword(ctx context.Context, token string, password string) error
+	// SendVerification mail a single use email verification token to a user
+	SendVerification(ctx context.Context, userID string) error
+	// VerifyEmail mark the email of the token user as verified, a pending account becomes active
+	VerifyEmail(ctx context.Context, token string) error
+	// EnrollTOTP generate a new TOTP secret for a user, MFA is enabled once a code is confirmed
+	EnrollTOTP(ctx context.Context, userID string) (*entities.TOTPEnrolment, error)
+	// ConfirmTOTP enable MFA after verifying a code of the enrolled secret and return the recovery codes
+	ConfirmTOTP(ctx context.Context, userID string, code string) ([]string, error)
+	// DisableTOTP disable MFA after verifying a TOTP or recovery code
+	DisableTOTP(ctx context.Context, userID string, code string) error
+	// RegenerateRecoveryCodes replace the recovery codes after verifying a TOTP code
+	RegenerateRecoveryCodes(ctx context.Context, userID string, code string) ([]string, error)
+	// UnlockAccount clear the failed logins and lockout of a user
+	UnlockAccount(ctx context.Context, userID string) error
+	// UnlockIP clear the failed logins and lockout of an IP
+	UnlockIP(ctx context.Context, ip string) error
+}
+
+// Purposes of the single use tokens mailed to the users
+const (
+	tokenPurposeReset     = "reset"
+	tokenPurposeVerify    = "verify"
+	tokenPurposeLoginLink = "loginlink"
+	tokenPurposeLoginCode = "logincode"
+)
+
+// loginCodeDigits digits of the passwordless login codes
+const loginCodeDigits = 6
+
+// clientInfoKey context key of the request client info recorded in the sessions
+type clientInfoKey struct{}
+
+type clientInfo struct {
+	userAgent string
+	ip        string
+}
+
+// WithClientInfo return a context with the user agent and IP of the request, they are recorded in the session
+// created by Login
+func WithClientInfo(ctx context.Context, userAgent string, ip string) context.Context {
+	return context.WithValue(ctx, clientInfoKey{}, clientInfo{userAgent: userAgent, ip: ip})
 }
 
 type authentication struct {
-	repo       repository.UsersRepository
-	jwtHandler utils.JwtHandler
+	repo        repository.UsersRepository
+	apiKeys     repository.APIKeysRepository
+	jwtHandler  utils.JwtHandler
+	revocations utils.RevocationList // nil to verify the access tokens against the store
+	hasher      utils.PasswordHasher
+	policy      utils.PasswordPolicy
+	throttle    utils.LoginThrottle
+	limiter     utils.RateLimiter
+	totp        utils.TOTPHandler
+	mfa         configuration.MFAConfig
+	mailer      utils.Mailer
+	emailTokens configuration.EmailTokensConfig
+	security    events.SecurityFeed
+	dummyOnce   sync.Once
+	dummyHash   string // verified instead of a missing password hash
 }
 
 // NewAuthenticationService return a new authentication service instance
-func NewAuthenticationService(usersRepo repository.UsersRepository, jwtHandler utils.JwtHandler) AuthenticationService {
+func NewAuthenticationService(
+	usersRepo repository.UsersRepository,
+	apiKeysRepo repository.APIKeysRepository,
+	jwtHandler utils.JwtHandler,
+	revocations utils.RevocationList,
+	hasher utils.PasswordHasher,
+	policy utils.PasswordPolicy,
+	throttle utils.LoginThrottle,
+	limiter utils.RateLimiter,
+	totp utils.TOTPHandler,
+	mfa configuration.MFAConfig,
+	mailer utils.Mailer,
+	emailTokens configuration.EmailTokensConfig,
+	securityFeed events.SecurityFeed,
+) AuthenticationService {
 	return &authentication{
-		repo:       usersRepo,
-		jwtHandler: jwtHandler,
+		repo:        usersRepo,
+		apiKeys:     apiKeysRepo,
+		jwtHandler:  jwtHandler,
+		revocations: revocations,
+		hasher:      hasher,
+		policy:      policy,
+		throttle:    throttle,
+		limiter:     limiter,
+		totp:        totp,
+		mfa:         mfa,
+		mailer:      mailer,
+		emailTokens: emailTokens,
+		security:    securityFeed,
 	}
 }
 
-// Register a user
+// Register a user, the sessions of an existing admin user are revoked when it loses admin
 func (ga *authentication) Register(ctx context.Context, user *entities.User) error {
-	return ga.repo.Register(ctx, user)
+	current, err := ga.repo.GetUserByID(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if err = ga.repo.Register(ctx, user); err != nil {
+		return err
+	}
+	// A new email address must be verified again
+	if current != nil && current.EmailVerified && current.Email != user.Email {
+		if err = ga.repo.SetEmailVerified(ctx, user.ID, time.Time{}); err != nil {
+			return err
+		}
+	}
+	// Sessions started as admin must not keep running once the user is no longer an admin
+	if current != nil && current.IsAdmin && !user.IsAdmin {
+		return ga.revokeAllSessions(ctx, user.ID, "admin_removed")
+	}
+	return nil
 }
 
-// Unregister a user
+// Unregister a user removing its tokens, sessions, API keys, role memberships and permission lists
 func (ga *authentication) Unregister(ctx context.Context, user *entities.User) error {
-	return ga.repo.Unregister(ctx, user)
+	if err := ga.repo.Offboard(ctx, user.ID); err != nil {
+		return err
+	}
+	go ga.security.Send(&entities.SecurityEvent{
+		Type:    entities.SecurityEventUserOffboarded,
+		UserID:  user.ID,
+		Time:    time.Now(),
+		Details: map[string]string{"email": user.Email},
+	})
+	return nil
 }
 
-// Login log in a user by email and return access and refresh tokens or an error
-func (ga *authentication) Login(ctx context.Context, email string) (*entities.LoggedUser, error) {
+// Login log in a user by email and password and return access and refresh tokens or an MFA challenge. The
+// failed logins are counted per account and IP, reaching a threshold locks them out for a while
+func (ga *authentication) Login(ctx context.Context, email string, password string) (*entities.LoggedUser, error) {
+	info, _ := ctx.Value(clientInfoKey{}).(clientInfo)
+	remaining, err := ga.throttle.Check(ctx, email, info.ip)
+	if err != nil {
+		return nil, err
+	}
+	if remaining > 0 {
+		return nil, ErrTooManyAttempts
+	}
 	user, err := ga.repo.GetUserByEmail(ctx, email)
 	if err != nil {
 		return nil, err
 	}
 	if user == nil {
-		return nil, errors.New("User not found")
+		// Same error and about the same time as a wrong password, so the registered emails are not revealed
+		_, _ = ga.hasher.Verify(ga.dummyPasswordHash(), password)
+		return nil, ga.loginFailed(ctx, email, info.ip, "", "unknown_user", ErrInvalidCredentials)
+	}
+	if err = ga.verifyPassword(ctx, user.ID, password); err != nil {
+		if err == ErrInvalidCredentials {
+			return nil, ga.loginFailed(ctx, email, info.ip, user.ID, "invalid_password", err)
+		}
+		return nil, err
+	}
+	if err = accountStatusError(user.Status); err != nil {
+		return nil, err
+	}
+	if maxAge := ga.policy.MaxAge(); maxAge > 0 {
+		changedAt, err := ga.repo.GetPasswordChangedAt(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if time.Since(changedAt) > maxAge {
+			return nil, ErrPasswordExpired
+		}
+	}
+	return ga.completeLogin(ctx, user)
+}
+
+// RequestLoginLink mail a single use passwordless login link to a user, unknown emails are ignored so they are not
+// revealed. The requests of an account are rate limited
+func (ga *authentication) RequestLoginLink(ctx context.Context, email string) error {
+	user, err := ga.passwordlessUser(ctx, email)
+	if err != nil || user == nil {
+		return err
+	}
+	expiration := time.Minute * time.Duration(ga.emailTokens.PasswordlessExpiration)
+	token, err := ga.issueOneTimeToken(ctx, tokenPurposeLoginLink, user.ID, expiration)
+	if err != nil {
+		return err
+	}
+	return ga.mailer.Send(ctx, &utils.Mail{
+		To:      user.Email,
+		Subject: "Your login link",
+		Body: fmt.Sprintf("Use this link to log in, it can be used once and expires in %v:\n\n%s\n\n"+
+			"If you didn't ask to log in you can ignore this mail.",
+			expiration, ga.emailTokens.PasswordlessURL+token),
+	})
+}
+
+// LoginWithLink log in a user with the token of a login link, same result as Login
+func (ga *authentication) LoginWithLink(ctx context.Context, token string) (*entities.LoggedUser, error) {
+	info, _ := ctx.Value(clientInfoKey{}).(clientInfo)
+	remaining, err := ga.throttle.Check(ctx, "", info.ip)
+	if err != nil {
+		return nil, err
+	}
+	if remaining > 0 {
+		return nil, ErrTooManyAttempts
+	}
+	userID := ""
+	if token != "" {
+		userID, err = ga.repo.TakeOneTimeToken(ctx, tokenPurposeLoginLink, utils.HashToken(token))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if userID == "" {
+		return nil, ga.loginFailed(ctx, "", info.ip, "", "invalid_login_link", ErrInvalidLoginLink)
+	}
+	user, err := ga.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.ID == "" {
+		return nil, ErrInvalidLoginLink
+	}
+	if err = accountStatusError(user.Status); err != nil {
+		return nil, err
+	}
+	return ga.completeLogin(ctx, user)
+}
+
+// RequestLoginCode mail a single use passwordless login code to a user, unknown emails are ignored so they are not
+// revealed. The requests of an account are rate limited
+func (ga *authentication) RequestLoginCode(ctx context.Context, email string) error {
+	user, err := ga.passwordlessUser(ctx, email)
+	if err != nil || user == nil {
+		return err
+	}
+	code, err := utils.RandomCode(loginCodeDigits)
+	if err != nil {
+		return err
+	}
+	expiration := time.Minute * time.Duration(ga.emailTokens.PasswordlessExpiration)
+	err = ga.repo.StoreOneTimeToken(ctx, tokenPurposeLoginCode, loginCodeHash(user.Email, code), user.ID, expiration)
+	if err != nil {
+		return err
+	}
+	return ga.mailer.Send(ctx, &utils.Mail{
+		To:      user.Email,
+		Subject: "Your login code",
+		Body: fmt.Sprintf("Your login code is %s, it can be used once and expires in %v.\n\n"+
+			"If you didn't ask to log in you can ignore this mail.", code, expiration),
+	})
+}
+
+// LoginWithCode log in a user with the email and a login code, wrong codes count as failed logins
+func (ga *authentication) LoginWithCode(ctx context.Context, email string, code string) (*entities.LoggedUser, error) {
+	info, _ := ctx.Value(clientInfoKey{}).(clientInfo)
+	remaining, err := ga.throttle.Check(ctx, email, info.ip)
+	if err != nil {
+		return nil, err
+	}
+	if remaining > 0 {
+		return nil, ErrTooManyAttempts
+	}
+	user, err := ga.repo.GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	userID := ""
+	if user != nil && user.ID != "" && code != "" {
+		userID, err = ga.repo.TakeOneTimeToken(ctx, tokenPurposeLoginCode, loginCodeHash(user.Email, code))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if userID == "" || userID != user.ID {
+		failedUserID := ""
+		if user != nil {
+			failedUserID = user.ID
+		}
+		return nil, ga.loginFailed(ctx, email, info.ip, failedUserID, "invalid_login_code", ErrInvalidLoginCode)
+	}
+	if err = accountStatusError(user.Status); err != nil {
+		return nil, err
+	}
+	return ga.completeLogin(ctx, user)
+}
+
+// LoginFederated log in a user authenticated by an external identity provider, same result as Login without
+// checking a password. The account status, lockout and MFA still apply
+func (ga *authentication) LoginFederated(ctx context.Context, user *entities.User) (*entities.LoggedUser, error) {
+	info, _ := ctx.Value(clientInfoKey{}).(clientInfo)
+	remaining, err := ga.throttle.Check(ctx, user.Email, info.ip)
+	if err != nil {
+		return nil, err
+	}
+	if remaining > 0 {
+		return nil, ErrTooManyAttempts
+	}
+	if err = accountStatusError(user.Status); err != nil {
+		return nil, err
+	}
+	return ga.completeLogin(ctx, user)
+}
+
+// passwordlessUser get the active user a passwordless login is mailed to, nil when the email is unknown or the
+// account is not active. The rate limit is applied before the lookup so it doesn't reveal the registered emails
+func (ga *authentication) passwordlessUser(ctx context.Context, email string) (*entities.User, error) {
+	allowed, err := ga.limiter.Allow(ctx, "passwordless:"+strings.ToLower(strings.TrimSpace(email)))
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrTooManyAttempts
+	}
+	user, err := ga.repo.GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.ID == "" || accountStatusError(user.Status) != nil {
+		return nil, nil
+	}
+	return user, nil
+}
+
+// completeLogin finish a login once the user is authenticated, returns an MFA challenge when MFA is enabled or
+// the access and refresh tokens of a new session
+func (ga *authentication) completeLogin(ctx context.Context, user *entities.User) (*entities.LoggedUser, error) {
+	_, mfaEnabled, err := ga.repo.GetMFASecret(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	// The failed logins are cleared by LoginMFA once the second factor is verified
+	if mfaEnabled {
+		return ga.createMFAChallenge(ctx, user.ID)
+	}
+	if user.IsAdmin && ga.mfa.RequiredForAdmins {
+		return nil, ErrMFARequired
+	}
+	if err = ga.throttle.Succeeded(ctx, user.Email); err != nil {
+		return nil, err
+	}
+	return ga.saveUserToken(ctx, user, "")
+}
+
+// LoginMFA complete a login using the MFA challenge and a TOTP or recovery code. Wrong codes are counted as failed
+// logins of the account and IP
+func (ga *authentication) LoginMFA(ctx context.Context, challenge string, code string) (*entities.LoggedUser, error) {
+	userID, err := ga.repo.TakeMFAChallenge(ctx, challenge)
+	if err != nil {
+		return nil, err
+	}
+	if userID == "" {
+		return nil, ErrInvalidMFAChallenge
+	}
+	secret, enabled, err := ga.repo.GetMFASecret(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, ErrInvalidMFAChallenge
+	}
+	user, err := ga.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.ID == "" {
+		return nil, ErrInvalidMFAChallenge
+	}
+	info, _ := ctx.Value(clientInfoKey{}).(clientInfo)
+	remaining, err := ga.throttle.Check(ctx, user.Email, info.ip)
+	if err != nil {
+		return nil, err
+	}
+	if remaining > 0 {
+		return nil, ErrTooManyAttempts
+	}
+	if err = ga.verifyMFACode(ctx, userID, secret, code, true); err != nil {
+		if err == ErrInvalidMFACode {
+			return nil, ga.loginFailed(ctx, user.Email, info.ip, user.ID, "invalid_mfa_code", err)
+		}
+		return nil, err
+	}
+	if err = accountStatusError(user.Status); err != nil {
+		return nil, err
 	}
-	return ga.saveUserToken(ctx, user)
+	if err = ga.throttle.Succeeded(ctx, user.Email); err != nil {
+		return nil, err
+	}
+	return ga.saveUserToken(ctx, user, "")
 }
 
-// VerifyToken check if a token is valid and the user is logged in
+// VerifyToken check if a token is valid and the user is logged in, API keys are accepted too
 func (ga *authentication) VerifyToken(ctx context.Context, token string) (string, error) {
-	claims, err := ga.jwtHandler.GetTokenClaims(token)
+	if keyID, secret, ok := utils.ParseAPIKey(token); ok {
+		_, subject, err := ga.verifyAPIKey(ctx, keyID, secret)
+		return subject, err
+	}
+	claims, err := ga.parseToken(token)
 	if err != nil {
 		return "", err
 	}
-	if _, ok := claims["access_uuid"]; !ok {
-		return "", errors.New("Invalid token")
+	accessKey, ok := claims["access_uuid"].(string)
+	if !ok {
+		return "", ErrInvalidToken
+	}
+	// Internal tokens of service clients are not user tokens
+	if _, ok := claims["token_type"]; ok {
+		return "", ErrInvalidToken
 	}
-	if _, ok := claims["user_id"]; !ok {
-		return "", errors.New("Invalid token")
+	claimedUserID, ok := claims["user_id"].(string)
+	if !ok {
+		return "", ErrInvalidToken
+	}
+	version, _ := claims["ver"].(float64)
+	// Stateless mode, the token is trusted until it expires unless it was revoked. Its claims are still checked
+	// against the token version, a single read
+	if ga.revocations != nil {
+		if ga.revocations.IsRevoked(accessKey) {
+			return "", ErrExpiredToken
+		}
+		if ga.jwtHandler.Versioned() {
+			current, err := ga.repo.GetTokenVersion(ctx, claimedUserID)
+			if err != nil {
+				return "", err
+			}
+			if int64(version) != current {
+				return "", ErrExpiredToken
+			}
+		}
+		return claimedUserID, nil
 	}
-	accessKey := claims["access_uuid"].(string)
 	user, err := ga.repo.GetUserByToken(ctx, accessKey)
 	if err != nil {
 		return "", err
 	}
-	if user == nil || user.ID != claims["user_id"].(string) {
-		return "", errors.New("Invalid or expired token")
+	if user == nil || user.ID != claimedUserID {
+		return "", ErrExpiredToken
+	}
+	// The roles of the user changed after the token was issued, its claims are stale until it is refreshed
+	if ga.jwtHandler.Versioned() && int64(version) != user.TokenVersion {
+		return "", ErrExpiredToken
+	}
+	if err = accountStatusError(user.Status); err != nil {
+		return "", err
 	}
 	return user.ID, nil
 }
 
-// Refresh refresh a token
-func (ga *authentication) RefreshToken(ctx context.Context, token string) (*entities.Token, error) {
-	claims, err := ga.jwtHandler.GetTokenClaims(token)
+// IsAdmin check if a user is an active admin, false for the API keys created for a set of roles
+func (ga *authentication) IsAdmin(ctx context.Context, userID string) (bool, error) {
+	if strings.HasPrefix(userID, entities.APIKeySubjectPrefix) {
+		return false, nil
+	}
+	user, err := ga.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	if user == nil || user.ID == "" || !user.IsAdmin {
+		return false, nil
+	}
+	return accountStatusError(user.Status) == nil, nil
+}
+
+// IntrospectToken get the state of an access token, refresh token or API key (RFC 7662), the roles are not set
+func (ga *authentication) IntrospectToken(ctx context.Context, token string) (*entities.TokenIntrospection, error) {
+	if keyID, secret, ok := utils.ParseAPIKey(token); ok {
+		key, subject, err := ga.verifyAPIKey(ctx, keyID, secret)
+		if err != nil {
+			return nil, err
+		}
+		result := &entities.TokenIntrospection{
+			Active:    true,
+			TokenType: entities.TokenTypeAPIKey,
+			Sub:       subject,
+			Jti:       key.ID,
+		}
+		if key.ExpiresAt != nil {
+			result.Exp = key.ExpiresAt.Unix()
+		}
+		return result, nil
+	}
+	claims, err := ga.parseToken(token)
 	if err != nil {
 		return nil, err
 	}
-	if _, ok := claims["refresh_uuid"]; !ok {
-		return nil, errors.New("Invalid token")
+	exp, _ := claims["exp"].(float64)
+	refreshKey, ok := claims["refresh_uuid"].(string)
+	if !ok {
+		userID, err := ga.VerifyToken(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		return &entities.TokenIntrospection{
+			Active:    true,
+			TokenType: entities.TokenTypeAccess,
+			Exp:       int64(exp),
+			Sub:       userID,
+			Jti:       claims["access_uuid"].(string),
+		}, nil
 	}
-	if _, ok := claims["user_id"]; !ok {
-		return nil, errors.New("Invalid token")
+	if _, ok := claims["token_type"]; ok {
+		return nil, ErrInvalidToken
 	}
-	refreshKey := claims["refresh_uuid"].(string)
+	userID, _ := claims["user_id"].(string)
+	// Rotated refresh tokens are no longer stored
 	user, err := ga.repo.GetUserByToken(ctx, refreshKey)
 	if err != nil {
 		return nil, err
 	}
-	if user == nil || user.ID != claims["user_id"].(string) {
-		return nil, errors.New("Invalid or expired token")
+	if user == nil || user.ID == "" || user.ID != userID {
+		return nil, ErrExpiredToken
+	}
+	if err = accountStatusError(user.Status); err != nil {
+		return nil, err
+	}
+	return &entities.TokenIntrospection{
+		Active:    true,
+		TokenType: entities.TokenTypeRefresh,
+		Exp:       int64(exp),
+		Sub:       userID,
+		Jti:       refreshKey,
+	}, nil
+}
+
+// verifyAPIKey check the secret and expiration of an API key, update its last use and return it with the ID
+// used to check its permissions
+func (ga *authentication) verifyAPIKey(ctx context.Context, keyID string, secret string) (*entities.APIKey, string, error) {
+	key, hash, err := ga.apiKeys.GetAPIKey(ctx, keyID)
+	if err != nil {
+		return nil, "", err
+	}
+	if key == nil || subtle.ConstantTimeCompare([]byte(hash), []byte(utils.HashAPIKeySecret(secret))) != 1 {
+		return nil, "", ErrExpiredToken
+	}
+	now := time.Now()
+	if key.ExpiresAt != nil && !now.Before(*key.ExpiresAt) {
+		return nil, "", ErrExpiredToken
+	}
+	subject := entities.APIKeySubjectPrefix + key.ID
+	if key.UserID != "" {
+		user, err := ga.repo.GetUserByID(ctx, key.UserID)
+		if err != nil {
+			return nil, "", err
+		}
+		if user == nil || user.ID != key.UserID {
+			return nil, "", ErrExpiredToken
+		}
+		if err = accountStatusError(user.Status); err != nil {
+			return nil, "", err
+		}
+		subject = key.UserID
+	}
+	if err = ga.apiKeys.TouchAPIKey(ctx, key.ID, now); err != nil {
+		return nil, "", err
+	}
+	return key, subject, nil
+}
+
+// parseToken verify the signature and expiration of a token, the tokens that don't verify are ErrExpiredToken
+func (ga *authentication) parseToken(token string) (jwt.MapClaims, error) {
+	claims, err := ga.jwtHandler.GetTokenClaims(token)
+	if err != nil {
+		return nil, ErrExpiredToken
+	}
+	return claims, nil
+}
+
+// RefreshToken rotate a refresh token, reusing a rotated token revokes all the tokens of its family
+func (ga *authentication) RefreshToken(ctx context.Context, token string) (*entities.Token, error) {
+	claims, err := ga.parseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	refreshKey, ok := claims["refresh_uuid"].(string)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	if _, ok := claims["token_type"]; ok {
+		return nil, ErrInvalidToken
+	}
+	claimedUserID, ok := claims["user_id"].(string)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	family, err := ga.repo.GetTokenFamily(ctx, refreshKey)
+	if err != nil {
+		return nil, err
+	}
+	userID, err := ga.repo.ConsumeToken(ctx, refreshKey)
+	if err != nil {
+		return nil, err
+	}
+	if userID == "" {
+		if family == "" {
+			return nil, ErrExpiredToken
+		}
+		// A signed and unexpired refresh token of a live family was already rotated, it may be stolen
+		if err = ga.repo.RevokeTokenFamily(ctx, family); err != nil {
+			return nil, err
+		}
+		go ga.security.Send(&entities.SecurityEvent{
+			Type:   entities.SecurityEventRefreshTokenReuse,
+			UserID: claimedUserID,
+			Time:   time.Now(),
+			Details: map[string]string{
+				"family":       family,
+				"refresh_uuid": refreshKey,
+			},
+		})
+		return nil, ErrTokenReused
+	}
+	if userID != claimedUserID {
+		return nil, ErrExpiredToken
+	}
+	user, err := ga.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	// The user was removed after the token was issued
+	if user == nil || user.ID == "" {
+		return nil, ErrInvalidToken
 	}
-	err = ga.repo.DeleteToken(ctx, refreshKey)
+	if err = accountStatusError(user.Status); err != nil {
+		return nil, err
+	}
+	loggedUser, err := ga.saveUserToken(ctx, user, family)
 	if err != nil {
 		return nil, err
 	}
-	loggedUser, err := ga.saveUserToken(ctx, user)
-	return loggedUser.Token, err
+	return loggedUser.Token, nil
 }
 
-// Logout log out a user for a given token
+// Logout log out a user for a given token, its session ends
 func (ga *authentication) Logout(ctx context.Context, token *entities.Token) error {
 	claims, err := ga.jwtHandler.GetTokenClaims(token.Access)
 	if err == nil {
-		accessKey := claims["access_uuid"].(string)
+		accessKey, ok := claims["access_uuid"].(string)
+		if !ok {
+			return ErrInvalidToken
+		}
 		err = ga.repo.DeleteToken(ctx, accessKey)
 		if err != nil {
 			return err
@@ -125,24 +729,786 @@ func (ga *authentication) Logout(ctx context.Context, token *entities.Token) err
 	}
 	claims, err = ga.jwtHandler.GetTokenClaims(token.Refresh)
 	if err == nil {
-		refreshKey := claims["refresh_uuid"].(string)
+		refreshKey, ok := claims["refresh_uuid"].(string)
+		if !ok {
+			return ErrInvalidToken
+		}
+		family, err := ga.repo.GetTokenFamily(ctx, refreshKey)
+		if err != nil {
+			return err
+		}
 		err = ga.repo.DeleteToken(ctx, refreshKey)
 		if err != nil {
 			return err
 		}
+		if family != "" {
+			return ga.repo.RevokeTokenFamily(ctx, family)
+		}
 	}
 	return nil
 }
 
-func (ga *authentication) saveUserToken(ctx context.Context, user *entities.User) (*entities.LoggedUser, error) {
-	token, err := ga.jwtHandler.CreateToken(user.ID)
+// ListSessions get the active sessions of a user
+func (ga *authentication) ListSessions(ctx context.Context, userID string) ([]entities.Session, error) {
+	if ok, _ := ga.repo.IsValidUser(ctx, userID); !ok {
+		return nil, ErrUserNotFound
+	}
+	sessions, err := ga.repo.GetSessions(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].IssuedAt.After(sessions[j].IssuedAt) })
+	return sessions, nil
+}
+
+// RevokeSession end a session of a user, its access and refresh tokens are no longer accepted
+func (ga *authentication) RevokeSession(ctx context.Context, userID string, sessionID string) error {
+	sessions, err := ga.ListSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.ID == sessionID {
+			return ga.repo.RevokeTokenFamily(ctx, sessionID)
+		}
+	}
+	return ErrSessionNotFound
+}
+
+// RevokeAllSessions end all the sessions of a user, e.g. when the account is compromised
+func (ga *authentication) RevokeAllSessions(ctx context.Context, userID string) error {
+	if ok, _ := ga.repo.IsValidUser(ctx, userID); !ok {
+		return ErrUserNotFound
+	}
+	return ga.revokeAllSessions(ctx, userID, "requested")
+}
+
+// revokeAllSessions end all the sessions of a user and send a security event with the reason
+func (ga *authentication) revokeAllSessions(ctx context.Context, userID string, reason string) error {
+	sessions, err := ga.repo.GetSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if err = ga.repo.RevokeTokenFamily(ctx, session.ID); err != nil {
+			return err
+		}
+	}
+	go ga.security.Send(&entities.SecurityEvent{
+		Type:    entities.SecurityEventSessionsRevoked,
+		UserID:  userID,
+		Time:    time.Now(),
+		Details: map[string]string{"sessions": strconv.Itoa(len(sessions)), "reason": reason},
+	})
+	return nil
+}
+
+// loginFailed record a failed login and send its security events, returns the login error
+func (ga *authentication) loginFailed(ctx context.Context, email string, ip string, userID string, reason string, loginErr error) error {
+	now := time.Now()
+	go ga.security.Send(&entities.SecurityEvent{
+		Type:    entities.SecurityEventLoginFailed,
+		UserID:  userID,
+		Time:    now,
+		Details: map[string]string{"email": email, "ip": ip, "reason": reason},
+	})
+	lockouts, err := ga.throttle.Failed(ctx, email, ip)
+	if err != nil {
+		return err
+	}
+	for _, lockout := range lockouts {
+		go ga.security.Send(&entities.SecurityEvent{
+			Type:   entities.SecurityEventLoginLockout,
+			UserID: userID,
+			Time:   now,
+			Details: map[string]string{
+				"email":    email,
+				"ip":       ip,
+				"key":      lockout.Key,
+				"duration": lockout.Duration.String(),
+			},
+		})
+	}
+	return loginErr
+}
+
+// UnlockAccount clear the failed logins and lockout of a user
+func (ga *authentication) UnlockAccount(ctx context.Context, userID string) error {
+	user, err := ga.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+	if err = ga.throttle.UnlockAccount(ctx, user.Email); err != nil {
+		return err
+	}
+	go ga.security.Send(&entities.SecurityEvent{
+		Type:    entities.SecurityEventLoginUnlocked,
+		UserID:  userID,
+		Time:    time.Now(),
+		Details: map[string]string{"email": user.Email},
+	})
+	return nil
+}
+
+// UnlockIP clear the failed logins and lockout of an IP
+func (ga *authentication) UnlockIP(ctx context.Context, ip string) error {
+	if net.ParseIP(ip) == nil {
+		return &ValidationError{Errors: url.Values{
+			"ip": {"The IP address is not valid"},
+		}}
+	}
+	if err := ga.throttle.UnlockIP(ctx, ip); err != nil {
+		return err
+	}
+	go ga.security.Send(&entities.SecurityEvent{
+		Type:    entities.SecurityEventLoginUnlocked,
+		Time:    time.Now(),
+		Details: map[string]string{"ip": ip},
+	})
+	return nil
+}
+
+// Impersonate issue an access token of a user to an admin, the token carries both identities and can't be
+// refreshed. Every impersonation is recorded in the audit log
+func (ga *authentication) Impersonate(ctx context.Context, adminID string, targetUserID string) (*entities.ImpersonationToken, error) {
+	admin, err := ga.repo.GetUserByID(ctx, adminID)
+	if err != nil {
+		return nil, err
+	}
+	if admin == nil || admin.ID == "" || !admin.IsAdmin {
+		return nil, ErrNotAdmin
+	}
+	if err = accountStatusError(admin.Status); err != nil {
+		return nil, err
+	}
+	if adminID == targetUserID {
+		return nil, &ValidationError{Errors: url.Values{
+			"user_id": {"An admin can't impersonate itself"},
+		}}
+	}
+	user, err := ga.repo.GetUserByID(ctx, targetUserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.ID == "" {
+		return nil, ErrUserNotFound
+	}
+	claims, err := ga.tokenClaims(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	token, err := ga.jwtHandler.CreateImpersonationToken(user.ID, admin.ID, claims)
+	if err != nil {
+		return nil, err
+	}
+	info, _ := ctx.Value(clientInfoKey{}).(clientInfo)
+	impersonation := &entities.Impersonation{
+		ID:        token.AccessUUID,
+		AdminID:   admin.ID,
+		UserID:    user.ID,
+		IssuedAt:  time.Now(),
+		ExpiresAt: time.Unix(token.AccessExpires, 0),
+		UserAgent: info.userAgent,
+		IP:        info.ip,
+	}
+	// The audit record is written first so no token is issued without it
+	if err = ga.repo.AddImpersonation(ctx, impersonation); err != nil {
+		return nil, err
+	}
+	if err = ga.repo.StoreTokens(ctx, token); err != nil {
+		return nil, err
+	}
+	go ga.security.Send(&entities.SecurityEvent{
+		Type:    entities.SecurityEventImpersonation,
+		UserID:  user.ID,
+		Time:    impersonation.IssuedAt,
+		Details: map[string]string{"admin_id": admin.ID, "token_id": token.AccessUUID, "ip": info.ip},
+	})
+	return &entities.ImpersonationToken{
+		Access:    token.AccessToken,
+		ExpiresAt: impersonation.ExpiresAt,
+		AdminID:   admin.ID,
+		User:      user,
+	}, nil
+}
+
+// ListImpersonations get the impersonations audit log newest first, only the ones made by or to a user when it is
+// not empty
+func (ga *authentication) ListImpersonations(ctx context.Context, userID string) ([]entities.Impersonation, error) {
+	impersonations, err := ga.repo.GetImpersonations(ctx)
+	if err != nil || userID == "" {
+		return impersonations, err
+	}
+	filtered := []entities.Impersonation{}
+	for _, impersonation := range impersonations {
+		if impersonation.UserID == userID || impersonation.AdminID == userID {
+			filtered = append(filtered, impersonation)
+		}
+	}
+	return filtered, nil
+}
+
+// GetJWKS get the public keys to verify the access tokens, empty when they are signed with a shared secret
+func (ga *authentication) GetJWKS(ctx context.Context) (*entities.JWKS, error) {
+	return ga.jwtHandler.JWKS(), nil
+}
+
+// RotateSigningKey replace the signing key if it is older than maxAge, 0 to always rotate. Returns the active key ID
+func (ga *authentication) RotateSigningKey(ctx context.Context, maxAge time.Duration) (string, error) {
+	kid, err := ga.jwtHandler.RotateKey(ctx, maxAge)
+	switch err {
+	case utils.ErrKeyRotationInProgress:
+		return "", ErrKeyRotationInProgress
+	case utils.ErrMissingKeyEncryptionKey:
+		return "", ErrKeyRotationDisabled
+	}
+	return kid, err
+}
+
+// GetAccountStatus get the account status of a user
+func (ga *authentication) GetAccountStatus(ctx context.Context, userID string) (*entities.AccountStatus, error) {
+	if ok, _ := ga.repo.IsValidUser(ctx, userID); !ok {
+		return nil, ErrUserNotFound
+	}
+	return ga.repo.GetStatus(ctx, userID)
+}
+
+// SetAccountStatus change the account status of a user, the sessions of an account that is no longer active
+// are revoked
+func (ga *authentication) SetAccountStatus(ctx context.Context, userID string, status string, reason string) (*entities.AccountStatus, error) {
+	if ok, _ := ga.repo.IsValidUser(ctx, userID); !ok {
+		return nil, ErrUserNotFound
+	}
+	switch status {
+	case entities.UserStatusActive, entities.UserStatusDisabled, entities.UserStatusLocked, entities.UserStatusPending:
+	default:
+		return nil, &ValidationError{Errors: url.Values{
+			"status": {"The status must be one of active, disabled, locked or pending"},
+		}}
+	}
+	accountStatus := &entities.AccountStatus{
+		Status:    status,
+		Reason:    reason,
+		ChangedAt: time.Now(),
+	}
+	if err := ga.repo.SetStatus(ctx, userID, accountStatus); err != nil {
+		return nil, err
+	}
+	go ga.security.Send(&entities.SecurityEvent{
+		Type:    entities.SecurityEventStatusChanged,
+		UserID:  userID,
+		Time:    accountStatus.ChangedAt,
+		Details: map[string]string{"status": status, "reason": reason},
+	})
+	if status != entities.UserStatusActive {
+		if err := ga.revokeAllSessions(ctx, userID, "account_"+status); err != nil {
+			return nil, err
+		}
+	}
+	return accountStatus, nil
+}
+
+// SetPassword set the password of a user without checking the current one
+func (ga *authentication) SetPassword(ctx context.Context, userID string, password string) error {
+	if ok, _ := ga.repo.IsValidUser(ctx, userID); !ok {
+		return ErrUserNotFound
+	}
+	if password == "" {
+		return ErrEmptyPassword
+	}
+	if errs := ga.policy.Validate(password); len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	if err := ga.checkPasswordHistory(ctx, userID, password); err != nil {
+		return err
+	}
+	return ga.savePassword(ctx, userID, password)
+}
+
+// checkPasswordHistory return a validation error when the password is one of the last passwords of the user
+func (ga *authentication) checkPasswordHistory(ctx context.Context, userID string, password string) error {
+	history := ga.policy.History()
+	if history <= 0 {
+		return nil
+	}
+	reused, err := ga.isRecentPassword(ctx, userID, password, history)
+	if err != nil {
+		return err
+	}
+	if reused {
+		return &ValidationError{Errors: url.Values{
+			"password": []string{fmt.Sprintf("The password field must be different from the last %d passwords", history)},
+		}}
+	}
+	return nil
+}
+
+// savePassword hash and store the password of a user keeping the password history
+func (ga *authentication) savePassword(ctx context.Context, userID string, password string) error {
+	hash, err := ga.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	// The current password counts as one of the last N passwords
+	return ga.repo.SetPasswordHash(ctx, userID, hash, ga.policy.History()-1)
+}
+
+// ChangePassword change the password of a user after verifying the current one
+func (ga *authentication) ChangePassword(ctx context.Context, userID string, oldPassword string, newPassword string) error {
+	if err := ga.verifyPassword(ctx, userID, oldPassword); err != nil {
+		return err
+	}
+	return ga.SetPassword(ctx, userID, newPassword)
+}
+
+// RequestPasswordReset mail a single use password reset token to a user, unknown emails are ignored so they are
+// not revealed. The rate limit is applied before the lookup like the passwordless requests
+func (ga *authentication) RequestPasswordReset(ctx context.Context, email string) error {
+	allowed, err := ga.limiter.Allow(ctx, "reset:"+strings.ToLower(strings.TrimSpace(email)))
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrTooManyAttempts
+	}
+	user, err := ga.repo.GetUserByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if user == nil || user.ID == "" {
+		return nil
+	}
+	expiration := time.Minute * time.Duration(ga.emailTokens.PasswordResetExpiration)
+	token, err := ga.issueOneTimeToken(ctx, tokenPurposeReset, user.ID, expiration)
+	if err != nil {
+		return err
+	}
+	return ga.mailer.Send(ctx, &utils.Mail{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Use this link to reset your password, it expires in %v:\n\n%s\n\n"+
+			"If you didn't ask to reset your password you can ignore this mail.",
+			expiration, ga.emailTokens.PasswordResetURL+token),
+	})
+}
+
+// ResetPassword set a new password using a reset token, all the sessions of the user are revoked
+func (ga *authentication) ResetPassword(ctx context.Context, token string, password string) error {
+	if token == "" {
+		return ErrInvalidResetToken
+	}
+	if password == "" {
+		return ErrEmptyPassword
+	}
+	// Check the password before using the token so a weak or reused password doesn't burn it
+	if errs := ga.policy.Validate(password); len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	tokenHash := utils.HashToken(token)
+	userID, err := ga.repo.GetOneTimeToken(ctx, tokenPurposeReset, tokenHash)
+	if err != nil {
+		return err
+	}
+	if userID == "" {
+		return ErrInvalidResetToken
+	}
+	if ok, _ := ga.repo.IsValidUser(ctx, userID); !ok {
+		return ErrInvalidResetToken
+	}
+	if err = ga.checkPasswordHistory(ctx, userID, password); err != nil {
+		return err
+	}
+	// Another request may have used the token meanwhile
+	taken, err := ga.repo.TakeOneTimeToken(ctx, tokenPurposeReset, tokenHash)
+	if err != nil {
+		return err
+	}
+	if taken != userID {
+		return ErrInvalidResetToken
+	}
+	if err = ga.savePassword(ctx, userID, password); err != nil {
+		return err
+	}
+	go ga.security.Send(&entities.SecurityEvent{
+		Type:   entities.SecurityEventPasswordReset,
+		UserID: userID,
+		Time:   time.Now(),
+	})
+	return ga.revokeAllSessions(ctx, userID, "password_reset")
+}
+
+// SendVerification mail a single use email verification token to a user
+func (ga *authentication) SendVerification(ctx context.Context, userID string) error {
+	user, err := ga.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil || user.ID == "" {
+		return ErrUserNotFound
+	}
+	if user.EmailVerified {
+		return ErrEmailVerified
+	}
+	expiration := time.Hour * time.Duration(ga.emailTokens.EmailVerificationExpiration)
+	token, err := ga.issueOneTimeToken(ctx, tokenPurposeVerify, verificationSubject(user.ID, user.Email), expiration)
+	if err != nil {
+		return err
+	}
+	return ga.mailer.Send(ctx, &utils.Mail{
+		To:      user.Email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf("Use this link to verify your email address, it expires in %v:\n\n%s",
+			expiration, ga.emailTokens.EmailVerificationURL+token),
+	})
+}
+
+// VerifyEmail mark the email of the token user as verified, a pending account becomes active
+func (ga *authentication) VerifyEmail(ctx context.Context, token string) error {
+	if token == "" {
+		return ErrInvalidVerificationToken
+	}
+	subject, err := ga.repo.TakeOneTimeToken(ctx, tokenPurposeVerify, utils.HashToken(token))
+	if err != nil {
+		return err
+	}
+	sep := strings.LastIndex(subject, ":")
+	if sep < 0 {
+		return ErrInvalidVerificationToken
+	}
+	userID := subject[:sep]
+	user, err := ga.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	// The token is only valid for the email it was mailed to
+	if user == nil || user.ID == "" || subject != verificationSubject(user.ID, user.Email) {
+		return ErrInvalidVerificationToken
+	}
+	now := time.Now()
+	if err = ga.repo.SetEmailVerified(ctx, userID, now); err != nil {
+		return err
+	}
+	go ga.security.Send(&entities.SecurityEvent{
+		Type:    entities.SecurityEventEmailVerified,
+		UserID:  userID,
+		Time:    now,
+		Details: map[string]string{"email": user.Email},
+	})
+	if user.Status != entities.UserStatusPending {
+		return nil
+	}
+	accountStatus := &entities.AccountStatus{
+		Status:    entities.UserStatusActive,
+		Reason:    "email_verified",
+		ChangedAt: now,
+	}
+	if err = ga.repo.SetStatus(ctx, userID, accountStatus); err != nil {
+		return err
+	}
+	go ga.security.Send(&entities.SecurityEvent{
+		Type:    entities.SecurityEventStatusChanged,
+		UserID:  userID,
+		Time:    now,
+		Details: map[string]string{"status": accountStatus.Status, "reason": accountStatus.Reason},
+	})
+	return nil
+}
+
+// EnrollTOTP generate a new TOTP secret for a user, MFA is enabled once a code is confirmed
+func (ga *authentication) EnrollTOTP(ctx context.Context, userID string) (*entities.TOTPEnrolment, error) {
+	if ok, _ := ga.repo.IsValidUser(ctx, userID); !ok {
+		return nil, ErrUserNotFound
+	}
+	_, enabled, err := ga.repo.GetMFASecret(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrMFAEnabled
+	}
+	user, err := ga.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := ga.totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err = ga.repo.SetMFASecret(ctx, userID, secret); err != nil {
+		return nil, err
+	}
+	return &entities.TOTPEnrolment{
+		Secret: secret,
+		URI:    ga.totp.ProvisioningURI(user.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTP enable MFA after verifying a code of the enrolled secret and return the recovery codes
+func (ga *authentication) ConfirmTOTP(ctx context.Context, userID string, code string) ([]string, error) {
+	secret, enabled, err := ga.repo.GetMFASecret(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrMFAEnabled
+	}
+	if secret == "" {
+		return nil, ErrMFANotEnabled
+	}
+	if err = ga.verifyMFACode(ctx, userID, secret, code, false); err != nil {
+		return nil, err
+	}
+	return ga.enableMFA(ctx, userID)
+}
+
+// DisableTOTP disable MFA after verifying a TOTP or recovery code
+func (ga *authentication) DisableTOTP(ctx context.Context, userID string, code string) error {
+	secret, enabled, err := ga.repo.GetMFASecret(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return ErrMFANotEnabled
+	}
+	if err = ga.verifyMFACode(ctx, userID, secret, code, true); err != nil {
+		return err
+	}
+	return ga.repo.DisableMFA(ctx, userID)
+}
+
+// RegenerateRecoveryCodes replace the recovery codes after verifying a TOTP code
+func (ga *authentication) RegenerateRecoveryCodes(ctx context.Context, userID string, code string) ([]string, error) {
+	secret, enabled, err := ga.repo.GetMFASecret(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, ErrMFANotEnabled
+	}
+	if err = ga.verifyMFACode(ctx, userID, secret, code, false); err != nil {
+		return nil, err
+	}
+	return ga.enableMFA(ctx, userID)
+}
+
+// enableMFA enable MFA with a new set of recovery codes, only the hashes are stored
+func (ga *authentication) enableMFA(ctx context.Context, userID string) ([]string, error) {
+	codes, err := utils.GenerateRecoveryCodes(ga.mfa.RecoveryCodes)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = utils.HashRecoveryCode(code)
+	}
+	if err = ga.repo.EnableMFA(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// verifyMFACode check a TOTP code, each time step can be used once, recovery codes are accepted if allowed
+func (ga *authentication) verifyMFACode(ctx context.Context, userID string, secret string, code string, allowRecovery bool) error {
+	if code == "" {
+		return ErrInvalidMFACode
+	}
+	if step, ok := ga.totp.Verify(secret, code, time.Now()); ok {
+		unused, err := ga.repo.UseMFAStep(ctx, userID, step)
+		if err != nil {
+			return err
+		}
+		if !unused {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+	if !allowRecovery {
+		return ErrInvalidMFACode
+	}
+	used, err := ga.repo.UseMFARecoveryCode(ctx, userID, utils.HashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// createMFAChallenge store a short lived challenge to complete the login with a second factor
+func (ga *authentication) createMFAChallenge(ctx context.Context, userID string) (*entities.LoggedUser, error) {
+	challenge, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	expiration := time.Minute * time.Duration(ga.mfa.ChallengeExpiration)
+	if err = ga.repo.StoreMFAChallenge(ctx, challenge, userID, expiration); err != nil {
+		return nil, err
+	}
+	return &entities.LoggedUser{MFAChallenge: challenge}, nil
+}
+
+// issueOneTimeToken create a single use token of a purpose for a user, only its hash is stored
+func (ga *authentication) issueOneTimeToken(ctx context.Context, purpose string, userID string, expiration time.Duration) (string, error) {
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	if err = ga.repo.StoreOneTimeToken(ctx, purpose, utils.HashToken(token), userID, expiration); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// verificationSubject the subject of an email verification token, the user ID bound to the email it is mailed to
+// so the token is void once the email changes
+func verificationSubject(userID string, email string) string {
+	return userID + ":" + utils.HashToken(strings.ToLower(email))
+}
+
+// loginCodeHash hash of a login code bound to the account email, the codes are short so they are only valid for
+// the account they were mailed to
+func loginCodeHash(email string, code string) string {
+	return utils.HashToken(strings.ToLower(email) + ":" + code)
+}
+
+// isRecentPassword check if the password matches the current one or one of the previous passwords
+func (ga *authentication) isRecentPassword(ctx context.Context, userID string, password string, history int) (bool, error) {
+	current, err := ga.repo.GetPasswordHash(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	previous, err := ga.repo.GetPasswordHistory(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	hashes := append([]string{current}, previous...)
+	if len(hashes) > history {
+		hashes = hashes[:history]
+	}
+	for _, hash := range hashes {
+		if hash == "" {
+			continue
+		}
+		ok, err := ga.hasher.Verify(hash, password)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// accountStatusError get the error returned when the account status doesn't allow to authenticate
+func accountStatusError(status string) error {
+	switch status {
+	case entities.UserStatusDisabled:
+		return ErrAccountDisabled
+	case entities.UserStatusLocked:
+		return ErrAccountLocked
+	case entities.UserStatusPending:
+		return ErrAccountPending
+	}
+	return nil
+}
+
+// verifyPassword check the password against the stored hash, users without password can't log in
+func (ga *authentication) verifyPassword(ctx context.Context, userID string, password string) error {
+	hash, err := ga.repo.GetPasswordHash(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if password == "" {
+		return ErrInvalidCredentials
+	}
+	if hash == "" {
+		_, _ = ga.hasher.Verify(ga.dummyPasswordHash(), password)
+		return ErrInvalidCredentials
+	}
+	ok, err := ga.hasher.Verify(hash, password)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// dummyPasswordHash hash of a random password verified for unknown users and users without a password, the login
+// takes about the same time as for a registered user
+func (ga *authentication) dummyPasswordHash() string {
+	ga.dummyOnce.Do(func() {
+		password, err := utils.RandomToken(16)
+		if err == nil {
+			ga.dummyHash, _ = ga.hasher.Hash(password)
+		}
+	})
+	return ga.dummyHash
+}
+
+// tokenClaims get the user claims of the access tokens, the JWT handler adds the configured ones
+func (ga *authentication) tokenClaims(ctx context.Context, user *entities.User) (*utils.TokenClaims, error) {
+	roles, err := ga.repo.GetUserRoles(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	actions, err := ga.repo.GetUserActions(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &utils.TokenClaims{
+		Email:       user.Email,
+		Name:        user.Name,
+		IsAdmin:     user.IsAdmin,
+		Roles:       roles,
+		Permissions: actions,
+		Version:     user.TokenVersion,
+	}, nil
+}
+
+// saveUserToken create and store a token pair in the given family, an empty family starts a new one and
+// records its session
+func (ga *authentication) saveUserToken(ctx context.Context, user *entities.User, family string) (*entities.LoggedUser, error) {
+	claims, err := ga.tokenClaims(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	token, err := ga.jwtHandler.CreateToken(user.ID, claims)
+	if err != nil {
+		return nil, err
+	}
+	newSession := family == ""
+	if newSession {
+		family = token.RefreshUUID
+	}
+	token.Family = family
 	err = ga.repo.StoreTokens(ctx, token)
 	if err != nil {
 		return nil, err
 	}
+	expiresAt := time.Unix(token.RefreshExpires, 0)
+	if newSession {
+		info, _ := ctx.Value(clientInfoKey{}).(clientInfo)
+		err = ga.repo.StoreSession(ctx, &entities.Session{
+			ID:        family,
+			UserID:    user.ID,
+			IssuedAt:  time.Now(),
+			ExpiresAt: expiresAt,
+			UserAgent: info.userAgent,
+			IP:        info.ip,
+		})
+	} else {
+		err = ga.repo.ExtendSession(ctx, family, expiresAt)
+	}
+	if err != nil {
+		return nil, err
+	}
 	return &entities.LoggedUser{
 		User: user,
 		Token: &entities.Token{
@@ -2,31 +2,78 @@ package service
 
 import (
 	"context"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/StevenRojas/goaccess/pkg/configuration"
 	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/StevenRojas/goaccess/pkg/events"
 	"github.com/StevenRojas/goaccess/pkg/repository"
 	"github.com/StevenRojas/goaccess/pkg/utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
 )
 
 type serviceSuite struct {
-	svc  AuthenticationService
-	repo *repository.UsersRepoMock
+	svc      AuthenticationService
+	repo     *repository.UsersRepoMock
+	apiKeys  *repository.APIKeysRepoMock
+	totp     utils.TOTPHandler
+	throttle utils.LoginThrottle
+	mailer   *utils.MailerMock
+	security events.SecurityFeed
 	suite.Suite
 }
 
 func (s *serviceSuite) SetupTest() {
 	//ctx := context.TODO()
 	s.repo = new(repository.UsersRepoMock)
+	s.repo.M.On("GetUserRoles", mock.Anything).Return([]string{}, nil)
+	s.repo.M.On("GetUserActions", mock.Anything).Return([]string{}, nil)
+	s.apiKeys = new(repository.APIKeysRepoMock)
 	jwtHander := utils.NewJwtHandlerMock(configuration.SecurityConfig{
 		JWTSecret:            "secret!",
 		JWTTokenExpiration:   10,
 		JWTRefreshExpiration: 20,
+		JWTClaims:            "roles",
 	})
-	s.svc = NewAuthenticationService(s.repo, jwtHander)
+	policy, err := utils.NewPasswordPolicy(configuration.PasswordPolicyConfig{
+		MinLength:    8,
+		RequireDigit: true,
+		History:      3,
+	})
+	if err != nil {
+		panic(err)
+	}
+	mfa := configuration.MFAConfig{
+		Issuer:              "goaccess",
+		TOTPSkew:            1,
+		ChallengeExpiration: 5,
+		RecoveryCodes:       2,
+		RequiredForAdmins:   true,
+	}
+	s.totp = utils.NewTOTPHandler(mfa)
+	s.security = events.NewSecurityFeed()
+	s.mailer = utils.NewMailerMock()
+	limiter := utils.NewRateLimiter(nil, 2, 15*time.Minute)
+	emailTokens := configuration.EmailTokensConfig{
+		PasswordResetExpiration:     30,
+		PasswordResetURL:            "https://app/reset?token=",
+		EmailVerificationExpiration: 24,
+		EmailVerificationURL:        "https://app/verify?token=",
+		PasswordlessExpiration:      10,
+		PasswordlessURL:             "https://app/login?token=",
+	}
+	s.throttle = utils.NewLoginThrottle(configuration.LoginThrottleConfig{
+		WindowMinutes:      15,
+		MaxAccountFailures: 3,
+		MaxIPFailures:      10,
+		LockoutMinutes:     5,
+		MaxLockoutMinutes:  60,
+	}, nil)
+	s.svc = NewAuthenticationService(s.repo, s.apiKeys, jwtHander, nil, utils.NewPasswordHasherMock(), policy, s.throttle, limiter, s.totp, mfa, s.mailer, emailTokens, s.security)
 }
 
 func TestAccessService(t *testing.T) {
@@ -38,9 +85,8 @@ func (s *serviceSuite) TestLoginNotRegistered() {
 	t := s.T()
 	email := "notRegistered@gmail.com"
 	s.repo.M.On("GetUserByEmail", email).Return(nil, nil)
-	_, err := s.svc.Login(context.TODO(), email)
-	assert.NotNil(t, err)
-	assert.Equal(t, "User not found", err.Error())
+	_, err := s.svc.Login(context.TODO(), email, "secret")
+	assert.Equal(t, ErrInvalidCredentials, err, "unknown emails are not revealed")
 }
 
 func (s *serviceSuite) TestLoginSuccess() {
@@ -52,6 +98,8 @@ func (s *serviceSuite) TestLoginSuccess() {
 		Name:  "steven rojas",
 	}
 	s.repo.M.On("GetUserByEmail", email).Return(expected, nil)
+	s.repo.M.On("GetPasswordHash", "1").Return("hash:secret", nil)
+	s.repo.M.On("GetMFASecret", "1").Return("", false, nil)
 	s.repo.M.On("StoreTokens", &utils.StoredToken{
 		ID:             "1",
 		AccessToken:    "a_jwt",
@@ -60,13 +108,312 @@ func (s *serviceSuite) TestLoginSuccess() {
 		RefreshToken:   "r_jwt",
 		RefreshUUID:    "r_uuid",
 		RefreshExpires: 20,
+		Family:         "r_uuid",
 	}).Return(nil)
-	token, err := s.svc.Login(context.TODO(), email)
+	s.repo.M.On("StoreSession", mock.MatchedBy(func(session *entities.Session) bool {
+		return session.ID == "r_uuid" && session.UserID == "1" && session.UserAgent == "curl/7.68.0" && session.IP == "10.0.0.1"
+	})).Return(nil)
+	ctx := WithClientInfo(context.TODO(), "curl/7.68.0", "10.0.0.1")
+	token, err := s.svc.Login(ctx, email, "secret")
 	assert.Nil(t, err)
 	assert.Equal(t, expected, token.User)
 	assert.NotNil(t, token.Token)
 }
 
+func (s *serviceSuite) TestLoginWrongPassword() {
+	t := s.T()
+	email := "srojas@gmail.com"
+	s.repo.M.On("GetUserByEmail", email).Return(&entities.User{ID: "1", Email: email}, nil)
+	s.repo.M.On("GetPasswordHash", "1").Return("hash:secret", nil)
+	_, err := s.svc.Login(context.TODO(), email, "wrong")
+	assert.Equal(t, ErrInvalidCredentials, err)
+}
+
+func (s *serviceSuite) TestLoginWithoutPassword() {
+	t := s.T()
+	email := "srojas@gmail.com"
+	s.repo.M.On("GetUserByEmail", email).Return(&entities.User{ID: "1", Email: email}, nil)
+	s.repo.M.On("GetPasswordHash", "1").Return("", nil)
+	_, err := s.svc.Login(context.TODO(), email, "")
+	assert.Equal(t, ErrInvalidCredentials, err)
+}
+
+func (s *serviceSuite) TestChangePassword() {
+	t := s.T()
+	s.repo.M.On("GetPasswordHash", "1").Return("hash:secret", nil)
+	s.repo.M.On("GetPasswordHistory", "1").Return([]string{"hash:old secret 1"}, nil)
+	s.repo.M.On("IsValidUser", "1").Return(true, nil)
+	s.repo.M.On("SetPasswordHash", "1", "hash:new secret 2", 2).Return(nil)
+	err := s.svc.ChangePassword(context.TODO(), "1", "secret", "new secret 2")
+	assert.Nil(t, err)
+	s.repo.M.AssertCalled(t, "SetPasswordHash", "1", "hash:new secret 2", 2)
+}
+
+func (s *serviceSuite) TestSetPasswordPolicy() {
+	t := s.T()
+	s.repo.M.On("IsValidUser", "1").Return(true, nil)
+	err := s.svc.SetPassword(context.TODO(), "1", "secret")
+	validationErr, ok := err.(*ValidationError)
+	assert.True(t, ok)
+	assert.Len(t, validationErr.Errors["password"], 2)
+	s.repo.M.AssertNumberOfCalls(t, "SetPasswordHash", 0)
+}
+
+func (s *serviceSuite) TestSetPasswordReused() {
+	t := s.T()
+	s.repo.M.On("IsValidUser", "1").Return(true, nil)
+	s.repo.M.On("GetPasswordHash", "1").Return("hash:secret 3", nil)
+	s.repo.M.On("GetPasswordHistory", "1").Return([]string{"hash:secret 2", "hash:secret 1"}, nil)
+	err := s.svc.SetPassword(context.TODO(), "1", "secret 2")
+	_, ok := err.(*ValidationError)
+	assert.True(t, ok)
+	s.repo.M.AssertNumberOfCalls(t, "SetPasswordHash", 0)
+}
+
+func (s *serviceSuite) TestLoginPasswordExpired() {
+	t := s.T()
+	email := "srojas@gmail.com"
+	s.repo.M.On("GetUserByEmail", email).Return(&entities.User{ID: "1", Email: email}, nil)
+	s.repo.M.On("GetPasswordHash", "1").Return("hash:secret", nil)
+	s.repo.M.On("GetPasswordChangedAt", "1").Return(time.Now().AddDate(0, 0, -91), nil)
+	svc := NewAuthenticationService(
+		s.repo,
+		s.apiKeys,
+		utils.NewJwtHandlerMock(configuration.SecurityConfig{}),
+		nil,
+		utils.NewPasswordHasherMock(),
+		expiringPolicy(90),
+		s.throttle,
+		utils.NewRateLimiter(nil, 0, 0),
+		s.totp,
+		configuration.MFAConfig{},
+		s.mailer,
+		configuration.EmailTokensConfig{},
+		s.security,
+	)
+	_, err := svc.Login(context.TODO(), email, "secret")
+	assert.Equal(t, ErrPasswordExpired, err)
+}
+
+func (s *serviceSuite) TestLoginAdminWithoutMFA() {
+	t := s.T()
+	email := "admin@gmail.com"
+	s.repo.M.On("GetUserByEmail", email).Return(&entities.User{ID: "1", Email: email, IsAdmin: true}, nil)
+	s.repo.M.On("GetPasswordHash", "1").Return("hash:secret", nil)
+	s.repo.M.On("GetMFASecret", "1").Return("", false, nil)
+	_, err := s.svc.Login(context.TODO(), email, "secret")
+	assert.Equal(t, ErrMFARequired, err)
+}
+
+func (s *serviceSuite) TestLoginWithMFA() {
+	t := s.T()
+	email := "srojas@gmail.com"
+	user := &entities.User{ID: "1", Email: email}
+	secret, _ := s.totp.GenerateSecret()
+	s.repo.M.On("GetUserByEmail", email).Return(user, nil)
+	s.repo.M.On("GetUserByID", "1").Return(user, nil)
+	s.repo.M.On("GetPasswordHash", "1").Return("hash:secret", nil)
+	s.repo.M.On("GetMFASecret", "1").Return(secret, true, nil)
+	s.repo.M.On("StoreMFAChallenge", mock.Anything, "1", 5*time.Minute).Return(nil)
+	loggedUser, err := s.svc.Login(context.TODO(), email, "secret")
+	assert.Nil(t, err)
+	assert.Nil(t, loggedUser.Token)
+	assert.NotEmpty(t, loggedUser.MFAChallenge)
+
+	challenge := loggedUser.MFAChallenge
+	s.repo.M.On("TakeMFAChallenge", challenge).Return("1", nil)
+	s.repo.M.On("UseMFAStep", "1", mock.Anything).Return(true, nil)
+	s.repo.M.On("StoreTokens", mock.Anything).Return(nil)
+	s.repo.M.On("StoreSession", mock.Anything).Return(nil)
+	code, _ := s.totp.Code(secret, time.Now())
+	loggedUser, err = s.svc.LoginMFA(context.TODO(), challenge, code)
+	assert.Nil(t, err)
+	assert.Equal(t, user, loggedUser.User)
+	assert.Equal(t, "a_jwt", loggedUser.Token.Access)
+}
+
+func (s *serviceSuite) TestLoginMFARecoveryCode() {
+	t := s.T()
+	s.repo.M.On("TakeMFAChallenge", "challenge").Return("1", nil)
+	s.repo.M.On("GetMFASecret", "1").Return("JBSWY3DPEHPK3PXP", true, nil)
+	s.repo.M.On("UseMFARecoveryCode", "1", utils.HashRecoveryCode("abcde-fghij")).Return(false, nil)
+	s.repo.M.On("GetUserByID", "1").Return(&entities.User{ID: "1", Email: "srojas@gmail.com"}, nil)
+	for i := 0; i < 3; i++ {
+		_, err := s.svc.LoginMFA(context.TODO(), "challenge", "ABCDE-FGHIJ")
+		assert.Equal(t, ErrInvalidMFACode, err)
+	}
+	// Wrong codes are failed logins of the account
+	_, err := s.svc.LoginMFA(context.TODO(), "challenge", "ABCDE-FGHIJ")
+	assert.Equal(t, ErrTooManyAttempts, err)
+}
+
+func (s *serviceSuite) TestLoginMFAKeepsFailedLogins() {
+	t := s.T()
+	email := "srojas@gmail.com"
+	s.repo.M.On("GetUserByEmail", email).Return(&entities.User{ID: "1", Email: email}, nil)
+	s.repo.M.On("GetPasswordHash", "1").Return("hash:secret", nil)
+	s.repo.M.On("GetMFASecret", "1").Return("JBSWY3DPEHPK3PXP", true, nil)
+	s.repo.M.On("StoreMFAChallenge", mock.Anything, "1", mock.Anything).Return(nil)
+	for i := 0; i < 2; i++ {
+		_, err := s.svc.Login(context.TODO(), email, "wrong")
+		assert.Equal(t, ErrInvalidCredentials, err)
+	}
+	// The password alone doesn't clear the failed logins until the second factor is verified
+	loggedUser, err := s.svc.Login(context.TODO(), email, "secret")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, loggedUser.MFAChallenge)
+	_, err = s.svc.Login(context.TODO(), email, "wrong")
+	assert.Equal(t, ErrInvalidCredentials, err)
+	_, err = s.svc.Login(context.TODO(), email, "secret")
+	assert.Equal(t, ErrTooManyAttempts, err)
+}
+
+func (s *serviceSuite) TestLoginMFAExpiredChallenge() {
+	t := s.T()
+	s.repo.M.On("TakeMFAChallenge", "challenge").Return("", nil)
+	_, err := s.svc.LoginMFA(context.TODO(), "challenge", "123456")
+	assert.Equal(t, ErrInvalidMFAChallenge, err)
+}
+
+func (s *serviceSuite) TestConfirmTOTP() {
+	t := s.T()
+	secret, _ := s.totp.GenerateSecret()
+	s.repo.M.On("GetMFASecret", "1").Return(secret, false, nil)
+	s.repo.M.On("UseMFAStep", "1", mock.Anything).Return(true, nil)
+	s.repo.M.On("EnableMFA", "1", mock.Anything).Return(nil)
+	code, _ := s.totp.Code(secret, time.Now())
+	codes, err := s.svc.ConfirmTOTP(context.TODO(), "1", code)
+	assert.Nil(t, err)
+	assert.Len(t, codes, 2)
+	s.repo.M.AssertCalled(t, "EnableMFA", "1", []string{utils.HashRecoveryCode(codes[0]), utils.HashRecoveryCode(codes[1])})
+}
+
+func (s *serviceSuite) TestConfirmTOTPReplayedCode() {
+	t := s.T()
+	secret, _ := s.totp.GenerateSecret()
+	s.repo.M.On("GetMFASecret", "1").Return(secret, false, nil)
+	s.repo.M.On("UseMFAStep", "1", mock.Anything).Return(false, nil)
+	code, _ := s.totp.Code(secret, time.Now())
+	_, err := s.svc.ConfirmTOTP(context.TODO(), "1", code)
+	assert.Equal(t, ErrInvalidMFACode, err)
+	s.repo.M.AssertNumberOfCalls(t, "EnableMFA", 0)
+}
+
+func (s *serviceSuite) TestRefreshTokenRotation() {
+	t := s.T()
+	user := &entities.User{ID: "1", Email: "srojas@gmail.com"}
+	s.repo.M.On("GetTokenFamily", "r_uuid").Return("family", nil)
+	s.repo.M.On("ConsumeToken", "r_uuid").Return("1", nil)
+	s.repo.M.On("GetUserByID", "1").Return(user, nil)
+	s.repo.M.On("StoreTokens", mock.MatchedBy(func(token *utils.StoredToken) bool {
+		return token.Family == "family"
+	})).Return(nil)
+	s.repo.M.On("ExtendSession", "family", time.Unix(20, 0)).Return(nil)
+	token, err := s.svc.RefreshToken(context.TODO(), "r_jwt")
+	assert.Nil(t, err)
+	assert.Equal(t, "r_jwt", token.Refresh)
+	s.repo.M.AssertNumberOfCalls(t, "StoreSession", 0)
+}
+
+func (s *serviceSuite) TestRefreshTokenRemovedUser() {
+	t := s.T()
+	s.repo.M.On("GetTokenFamily", "r_uuid").Return("family", nil)
+	s.repo.M.On("ConsumeToken", "r_uuid").Return("1", nil)
+	s.repo.M.On("GetUserByID", "1").Return((*entities.User)(nil), nil)
+	_, err := s.svc.RefreshToken(context.TODO(), "r_jwt")
+	assert.Equal(t, ErrInvalidToken, err)
+	s.repo.M.AssertNumberOfCalls(t, "StoreTokens", 0)
+}
+
+func (s *serviceSuite) TestRevokeSession() {
+	t := s.T()
+	s.repo.M.On("IsValidUser", "1").Return(true, nil)
+	s.repo.M.On("GetSessions", "1").Return([]entities.Session{{ID: "s1", UserID: "1"}}, nil)
+	s.repo.M.On("RevokeTokenFamily", "s1").Return(nil)
+	assert.Equal(t, ErrSessionNotFound, s.svc.RevokeSession(context.TODO(), "1", "s2"))
+	s.repo.M.AssertNumberOfCalls(t, "RevokeTokenFamily", 0)
+	assert.Nil(t, s.svc.RevokeSession(context.TODO(), "1", "s1"))
+	s.repo.M.AssertCalled(t, "RevokeTokenFamily", "s1")
+}
+
+func (s *serviceSuite) TestRevokeAllSessions() {
+	t := s.T()
+	received := make(chan *entities.SecurityEvent, 1)
+	sub := s.security.Subscribe(received)
+	defer sub.Unsubscribe("")
+	s.repo.M.On("IsValidUser", "1").Return(true, nil)
+	s.repo.M.On("GetSessions", "1").Return([]entities.Session{{ID: "s1"}, {ID: "s2"}}, nil)
+	s.repo.M.On("RevokeTokenFamily", mock.Anything).Return(nil)
+	assert.Nil(t, s.svc.RevokeAllSessions(context.TODO(), "1"))
+	s.repo.M.AssertCalled(t, "RevokeTokenFamily", "s1")
+	s.repo.M.AssertCalled(t, "RevokeTokenFamily", "s2")
+	select {
+	case event := <-received:
+		assert.Equal(t, entities.SecurityEventSessionsRevoked, event.Type)
+		assert.Equal(t, "2", event.Details["sessions"])
+	case <-time.After(time.Second):
+		t.Fatal("security event not sent")
+	}
+}
+
+func (s *serviceSuite) TestLogoutEndsSession() {
+	t := s.T()
+	s.repo.M.On("DeleteToken", mock.Anything).Return(nil)
+	s.repo.M.On("GetTokenFamily", "r_uuid").Return("family", nil)
+	s.repo.M.On("RevokeTokenFamily", "family").Return(nil)
+	assert.Nil(t, s.svc.Logout(context.TODO(), &entities.Token{Access: "a_jwt", Refresh: "r_jwt"}))
+	s.repo.M.AssertCalled(t, "RevokeTokenFamily", "family")
+}
+
+func (s *serviceSuite) TestIDTokenIsNotAnAccessToken() {
+	t := s.T()
+	err := s.svc.Logout(context.TODO(), &entities.Token{Access: "id_jwt", Refresh: "r_jwt"})
+	assert.Equal(t, ErrInvalidToken, err)
+	_, err = s.svc.VerifyToken(context.TODO(), "id_jwt")
+	assert.Equal(t, ErrInvalidToken, err)
+	_, err = s.svc.RefreshToken(context.TODO(), "id_jwt")
+	assert.Equal(t, ErrInvalidToken, err)
+	s.repo.M.AssertNotCalled(t, "DeleteToken", mock.Anything)
+}
+
+func (s *serviceSuite) TestRefreshTokenReuse() {
+	t := s.T()
+	received := make(chan *entities.SecurityEvent, 1)
+	sub := s.security.Subscribe(received)
+	defer sub.Unsubscribe("")
+	s.repo.M.On("GetTokenFamily", "r_uuid").Return("family", nil)
+	s.repo.M.On("ConsumeToken", "r_uuid").Return("", nil)
+	s.repo.M.On("RevokeTokenFamily", "family").Return(nil)
+	_, err := s.svc.RefreshToken(context.TODO(), "r_jwt")
+	assert.Equal(t, ErrTokenReused, err)
+	s.repo.M.AssertCalled(t, "RevokeTokenFamily", "family")
+	select {
+	case event := <-received:
+		assert.Equal(t, entities.SecurityEventRefreshTokenReuse, event.Type)
+		assert.Equal(t, "1", event.UserID)
+		assert.Equal(t, "family", event.Details["family"])
+	case <-time.After(time.Second):
+		t.Error("security event not sent")
+	}
+}
+
+func (s *serviceSuite) TestRefreshExpiredToken() {
+	t := s.T()
+	s.repo.M.On("GetTokenFamily", "r_uuid").Return("", nil)
+	s.repo.M.On("ConsumeToken", "r_uuid").Return("", nil)
+	_, err := s.svc.RefreshToken(context.TODO(), "r_jwt")
+	assert.Equal(t, ErrExpiredToken, err)
+	s.repo.M.AssertNumberOfCalls(t, "RevokeTokenFamily", 0)
+}
+
+func expiringPolicy(days int) utils.PasswordPolicy {
+	policy, err := utils.NewPasswordPolicy(configuration.PasswordPolicyConfig{MaxAgeDays: days})
+	if err != nil {
+		panic(err)
+	}
+	return policy
+}
+
 // func (s *serviceSuite) TestValidToken() {
 // 	t := s.T()
 // 	token := "a_jwt"
@@ -85,3 +432,376 @@ func (s *serviceSuite) TestLoginSuccess() {
 // 	assert.Nil(t, err)
 // 	assert.Equal(t, eUser.ID, ID)
 // }
+
+func (s *serviceSuite) TestUnregisterOffboards() {
+	t := s.T()
+	received := make(chan *entities.SecurityEvent, 1)
+	sub := s.security.Subscribe(received)
+	defer sub.Unsubscribe("")
+	s.repo.M.On("Offboard", "1").Return(nil)
+	assert.Nil(t, s.svc.Unregister(context.TODO(), &entities.User{ID: "1", Email: "srojas@gmail.com"}))
+	s.repo.M.AssertCalled(t, "Offboard", "1")
+	select {
+	case event := <-received:
+		assert.Equal(t, entities.SecurityEventUserOffboarded, event.Type)
+		assert.Equal(t, "1", event.UserID)
+	case <-time.After(time.Second):
+		t.Fatal("security event not sent")
+	}
+}
+
+func (s *serviceSuite) TestRegisterRemovesAdmin() {
+	t := s.T()
+	user := &entities.User{ID: "1", Email: "srojas@gmail.com"}
+	s.repo.M.On("GetUserByID", "1").Return(&entities.User{ID: "1", IsAdmin: true}, nil)
+	s.repo.M.On("Register", "1").Return(nil, nil)
+	s.repo.M.On("GetSessions", "1").Return([]entities.Session{{ID: "s1"}}, nil)
+	s.repo.M.On("RevokeTokenFamily", "s1").Return(nil)
+	assert.Nil(t, s.svc.Register(context.TODO(), user))
+	s.repo.M.AssertCalled(t, "RevokeTokenFamily", "s1")
+
+	// Keeping or getting admin doesn't revoke the sessions
+	admin := &entities.User{ID: "1", Email: "srojas@gmail.com", IsAdmin: true}
+	assert.Nil(t, s.svc.Register(context.TODO(), admin))
+	s.repo.M.AssertNumberOfCalls(t, "RevokeTokenFamily", 1)
+}
+
+func (s *serviceSuite) TestLoginInactiveAccount() {
+	t := s.T()
+	email := "srojas@gmail.com"
+	s.repo.M.On("GetUserByEmail", email).Return(&entities.User{ID: "1", Email: email, Status: entities.UserStatusDisabled}, nil)
+	s.repo.M.On("GetPasswordHash", "1").Return("hash:secret", nil)
+	_, err := s.svc.Login(context.TODO(), email, "secret")
+	assert.Equal(t, ErrAccountDisabled, err)
+	s.repo.M.AssertNumberOfCalls(t, "StoreTokens", 0)
+	// The status is not revealed without the right password
+	_, err = s.svc.Login(context.TODO(), email, "wrong")
+	assert.Equal(t, ErrInvalidCredentials, err)
+}
+
+func (s *serviceSuite) TestVerifyTokenStateless() {
+	t := s.T()
+	s.repo.M.On("GetRevokedTokens").Return(map[string]time.Time{"r_uuid": time.Now().Add(time.Hour)}, nil).Once()
+	revocations := utils.NewRevocationList(configuration.SecurityConfig{JWTRevocationSyncSeconds: 60}, s.repo)
+	assert.Nil(t, revocations.Load(context.TODO()))
+	svc := NewAuthenticationService(
+		s.repo,
+		s.apiKeys,
+		utils.NewJwtHandlerMock(configuration.SecurityConfig{}),
+		revocations,
+		utils.NewPasswordHasherMock(),
+		expiringPolicy(0),
+		s.throttle,
+		utils.NewRateLimiter(nil, 0, 0),
+		s.totp,
+		configuration.MFAConfig{},
+		s.mailer,
+		configuration.EmailTokensConfig{},
+		s.security,
+	)
+	userID, err := svc.VerifyToken(context.TODO(), "a_jwt")
+	assert.Nil(t, err)
+	assert.Equal(t, "1", userID)
+	s.repo.M.AssertNotCalled(t, "GetUserByToken", "a_uuid")
+
+	// With roles or permissions in the tokens the token version is checked too
+	versioned := NewAuthenticationService(s.repo, s.apiKeys, utils.NewJwtHandlerMock(configuration.SecurityConfig{JWTClaims: "roles"}),
+		revocations, utils.NewPasswordHasherMock(), nil, s.throttle, nil, s.totp, configuration.MFAConfig{}, s.mailer,
+		configuration.EmailTokensConfig{}, s.security)
+	s.repo.M.On("GetTokenVersion", "1").Return(int64(0), nil).Once()
+	userID, err = versioned.VerifyToken(context.TODO(), "a_jwt")
+	assert.Nil(t, err)
+	assert.Equal(t, "1", userID)
+	s.repo.M.On("GetTokenVersion", "1").Return(int64(1), nil).Once()
+	_, err = versioned.VerifyToken(context.TODO(), "a_jwt")
+	assert.Equal(t, ErrExpiredToken, err)
+	s.repo.M.AssertNotCalled(t, "GetUserByToken", "a_uuid")
+
+	s.repo.M.On("GetRevokedTokens").Return(map[string]time.Time{"a_uuid": time.Now().Add(time.Hour)}, nil)
+	assert.Nil(t, revocations.Load(context.TODO()))
+	_, err = svc.VerifyToken(context.TODO(), "a_jwt")
+	assert.Equal(t, ErrExpiredToken, err)
+}
+
+func (s *serviceSuite) TestVerifyTokenLockedAccount() {
+	t := s.T()
+	s.repo.M.On("GetUserByToken", "a_uuid").Return(&entities.User{ID: "1", Status: entities.UserStatusLocked}, nil)
+	_, err := s.svc.VerifyToken(context.TODO(), "a_jwt")
+	assert.Equal(t, ErrAccountLocked, err)
+}
+
+func (s *serviceSuite) TestVerifyTokenStaleVersion() {
+	t := s.T()
+	// The token has no ver claim and the roles of the user changed since
+	s.repo.M.On("GetUserByToken", "a_uuid").Return(&entities.User{ID: "1", TokenVersion: 1}, nil)
+	_, err := s.svc.VerifyToken(context.TODO(), "a_jwt")
+	assert.Equal(t, ErrExpiredToken, err)
+
+	// Without roles and permissions in the tokens the version is not checked
+	svc := NewAuthenticationService(s.repo, s.apiKeys, utils.NewJwtHandlerMock(configuration.SecurityConfig{JWTClaims: "email"}),
+		nil, utils.NewPasswordHasherMock(), nil, s.throttle, nil, s.totp, configuration.MFAConfig{}, s.mailer,
+		configuration.EmailTokensConfig{}, s.security)
+	userID, err := svc.VerifyToken(context.TODO(), "a_jwt")
+	assert.Nil(t, err)
+	assert.Equal(t, "1", userID)
+}
+
+func (s *serviceSuite) TestRefreshTokenPendingAccount() {
+	t := s.T()
+	s.repo.M.On("GetTokenFamily", "r_uuid").Return("family", nil)
+	s.repo.M.On("ConsumeToken", "r_uuid").Return("1", nil)
+	s.repo.M.On("GetUserByID", "1").Return(&entities.User{ID: "1", Status: entities.UserStatusPending}, nil)
+	_, err := s.svc.RefreshToken(context.TODO(), "r_jwt")
+	assert.Equal(t, ErrAccountPending, err)
+	s.repo.M.AssertNumberOfCalls(t, "StoreTokens", 0)
+}
+
+func (s *serviceSuite) TestSetAccountStatus() {
+	t := s.T()
+	received := make(chan *entities.SecurityEvent, 2)
+	sub := s.security.Subscribe(received)
+	defer sub.Unsubscribe("")
+	s.repo.M.On("IsValidUser", "1").Return(true, nil)
+	_, err := s.svc.SetAccountStatus(context.TODO(), "1", "banned", "")
+	_, ok := err.(*ValidationError)
+	assert.True(t, ok)
+
+	s.repo.M.On("SetStatus", "1", mock.MatchedBy(func(status *entities.AccountStatus) bool {
+		return status.Status == entities.UserStatusDisabled && status.Reason == "left the company"
+	})).Return(nil)
+	s.repo.M.On("GetSessions", "1").Return([]entities.Session{{ID: "s1"}}, nil)
+	s.repo.M.On("RevokeTokenFamily", "s1").Return(nil)
+	status, err := s.svc.SetAccountStatus(context.TODO(), "1", entities.UserStatusDisabled, "left the company")
+	assert.Nil(t, err)
+	assert.False(t, status.ChangedAt.IsZero())
+	s.repo.M.AssertCalled(t, "RevokeTokenFamily", "s1")
+	types := map[string]string{}
+	for i := 0; i < 2; i++ {
+		select {
+		case event := <-received:
+			types[event.Type] = event.Details["reason"]
+		case <-time.After(time.Second):
+			t.Fatal("security event not sent")
+		}
+	}
+	assert.Equal(t, "left the company", types[entities.SecurityEventStatusChanged])
+	assert.Equal(t, "account_disabled", types[entities.SecurityEventSessionsRevoked])
+}
+
+func (s *serviceSuite) TestLoginLockout() {
+	t := s.T()
+	received := make(chan *entities.SecurityEvent, 4)
+	sub := s.security.Subscribe(received)
+	defer sub.Unsubscribe("")
+	email := "srojas@gmail.com"
+	s.repo.M.On("GetUserByEmail", email).Return(&entities.User{ID: "1", Email: email}, nil)
+	s.repo.M.On("GetPasswordHash", "1").Return("hash:secret", nil)
+	ctx := WithClientInfo(context.TODO(), "curl/7.68.0", "10.0.0.1")
+	for i := 0; i < 3; i++ {
+		_, err := s.svc.Login(ctx, email, "wrong")
+		assert.Equal(t, ErrInvalidCredentials, err)
+	}
+	// The right password is refused while the account is locked out
+	_, err := s.svc.Login(ctx, email, "secret")
+	assert.Equal(t, ErrTooManyAttempts, err)
+	lockout := false
+	for i := 0; i < 4 && !lockout; i++ {
+		select {
+		case event := <-received:
+			lockout = event.Type == entities.SecurityEventLoginLockout
+		case <-time.After(time.Second):
+			t.Fatal("security event not sent")
+		}
+	}
+	assert.True(t, lockout)
+
+	s.repo.M.On("GetUserByID", "1").Return(&entities.User{ID: "1", Email: email}, nil)
+	assert.Nil(t, s.svc.UnlockAccount(context.TODO(), "1"))
+	remaining, err := s.throttle.Check(context.TODO(), email, "10.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), remaining)
+}
+
+func (s *serviceSuite) TestUnlockIPValidation() {
+	t := s.T()
+	err := s.svc.UnlockIP(context.TODO(), "not-an-ip")
+	_, ok := err.(*ValidationError)
+	assert.True(t, ok)
+	assert.Nil(t, s.svc.UnlockIP(context.TODO(), "10.0.0.1"))
+}
+
+func (s *serviceSuite) TestPasswordReset() {
+	t := s.T()
+	email := "srojas@gmail.com"
+	s.repo.M.On("GetUserByEmail", "unknown@gmail.com").Return(nil, nil)
+	assert.Nil(t, s.svc.RequestPasswordReset(context.TODO(), "unknown@gmail.com"))
+	assert.Empty(t, s.mailer.Sent())
+
+	var tokenHash string
+	s.repo.M.On("GetUserByEmail", email).Return(&entities.User{ID: "1", Email: email}, nil)
+	s.repo.M.On("StoreOneTimeToken", "reset", mock.Anything, "1", 30*time.Minute).Run(func(args mock.Arguments) {
+		tokenHash = args.String(1)
+	}).Return(nil)
+	assert.Nil(t, s.svc.RequestPasswordReset(context.TODO(), email))
+	sent := s.mailer.Sent()
+	assert.Len(t, sent, 1)
+	assert.Equal(t, email, sent[0].To)
+	token := sent[0].Body[strings.Index(sent[0].Body, "token=")+len("token="):]
+	token = token[:strings.Index(token, "\n")]
+	assert.Equal(t, tokenHash, utils.HashToken(token))
+
+	// A weak password doesn't use the token
+	err := s.svc.ResetPassword(context.TODO(), token, "weak")
+	_, ok := err.(*ValidationError)
+	assert.True(t, ok)
+	s.repo.M.AssertNumberOfCalls(t, "TakeOneTimeToken", 0)
+
+	// Nor does a recent password
+	s.repo.M.On("GetOneTimeToken", "reset", tokenHash).Return("1", nil).Once()
+	s.repo.M.On("IsValidUser", "1").Return(true, nil)
+	s.repo.M.On("GetPasswordHash", "1").Return("hash:old", nil)
+	s.repo.M.On("GetPasswordHistory", "1").Return([]string{"hash:0ldpassword"}, nil)
+	err = s.svc.ResetPassword(context.TODO(), token, "0ldpassword")
+	_, ok = err.(*ValidationError)
+	assert.True(t, ok)
+	s.repo.M.AssertNumberOfCalls(t, "TakeOneTimeToken", 0)
+
+	s.repo.M.On("GetOneTimeToken", "reset", tokenHash).Return("1", nil).Once()
+	s.repo.M.On("TakeOneTimeToken", "reset", tokenHash).Return("1", nil).Once()
+	s.repo.M.On("SetPasswordHash", "1", "hash:n3wpassword", 2).Return(nil)
+	s.repo.M.On("GetSessions", "1").Return([]entities.Session{{ID: "s1"}}, nil)
+	s.repo.M.On("RevokeTokenFamily", "s1").Return(nil)
+	assert.Nil(t, s.svc.ResetPassword(context.TODO(), token, "n3wpassword"))
+	s.repo.M.AssertCalled(t, "RevokeTokenFamily", "s1")
+
+	s.repo.M.On("GetOneTimeToken", "reset", tokenHash).Return("", nil)
+	assert.Equal(t, ErrInvalidResetToken, s.svc.ResetPassword(context.TODO(), token, "n3wpassword"))
+
+	// The requests of an email are limited like the passwordless ones
+	assert.Nil(t, s.svc.RequestPasswordReset(context.TODO(), email))
+	assert.Equal(t, ErrTooManyAttempts, s.svc.RequestPasswordReset(context.TODO(), " SRojas@gmail.com"))
+	assert.Len(t, s.mailer.Sent(), 2)
+}
+
+func (s *serviceSuite) TestEmailVerification() {
+	t := s.T()
+	email := "srojas@gmail.com"
+	s.repo.M.On("GetUserByID", "2").Return(&entities.User{ID: "2", Email: email, EmailVerified: true}, nil)
+	assert.Equal(t, ErrEmailVerified, s.svc.SendVerification(context.TODO(), "2"))
+
+	s.repo.M.On("GetUserByID", "1").Return(&entities.User{ID: "1", Email: email, Status: entities.UserStatusPending}, nil)
+	subject := "1:" + utils.HashToken(email)
+	s.repo.M.On("StoreOneTimeToken", "verify", mock.Anything, subject, 24*time.Hour).Return(nil)
+	assert.Nil(t, s.svc.SendVerification(context.TODO(), "1"))
+	sent := s.mailer.Sent()
+	assert.Len(t, sent, 1)
+	token := sent[0].Body[strings.Index(sent[0].Body, "token=")+len("token="):]
+
+	// A token mailed to a previous email of the user is not valid
+	s.repo.M.On("TakeOneTimeToken", "verify", utils.HashToken("previous")).Return("1:"+utils.HashToken("old@gmail.com"), nil)
+	assert.Equal(t, ErrInvalidVerificationToken, s.svc.VerifyEmail(context.TODO(), "previous"))
+	s.repo.M.AssertNotCalled(t, "SetEmailVerified", "1", mock.Anything)
+
+	s.repo.M.On("TakeOneTimeToken", "verify", utils.HashToken(token)).Return(subject, nil)
+	s.repo.M.On("SetEmailVerified", "1", mock.Anything).Return(nil)
+	s.repo.M.On("SetStatus", "1", mock.MatchedBy(func(status *entities.AccountStatus) bool {
+		return status.Status == entities.UserStatusActive && status.Reason == "email_verified"
+	})).Return(nil)
+	assert.Nil(t, s.svc.VerifyEmail(context.TODO(), token))
+	s.repo.M.AssertCalled(t, "SetStatus", "1", mock.Anything)
+
+	s.repo.M.On("TakeOneTimeToken", "verify", utils.HashToken("used")).Return("", nil)
+	assert.Equal(t, ErrInvalidVerificationToken, s.svc.VerifyEmail(context.TODO(), "used"))
+}
+
+func (s *serviceSuite) TestLoginWithLink() {
+	t := s.T()
+	email := "srojas@gmail.com"
+	user := &entities.User{ID: "1", Email: email, Status: entities.UserStatusActive}
+	s.repo.M.On("GetUserByEmail", email).Return(user, nil)
+	s.repo.M.On("StoreOneTimeToken", "loginlink", mock.Anything, "1", 10*time.Minute).Return(nil)
+	assert.Nil(t, s.svc.RequestLoginLink(context.TODO(), email))
+	sent := s.mailer.Sent()
+	assert.Len(t, sent, 1)
+	token := sent[0].Body[strings.Index(sent[0].Body, "token=")+len("token="):]
+	token = token[:strings.Index(token, "\n")]
+
+	s.repo.M.On("TakeOneTimeToken", "loginlink", utils.HashToken(token)).Return("1", nil).Once()
+	s.repo.M.On("GetUserByID", "1").Return(user, nil)
+	s.repo.M.On("GetMFASecret", "1").Return("", false, nil)
+	s.repo.M.On("StoreTokens", mock.Anything).Return(nil)
+	s.repo.M.On("StoreSession", mock.Anything).Return(nil)
+	loggedUser, err := s.svc.LoginWithLink(context.TODO(), token)
+	assert.Nil(t, err)
+	assert.Equal(t, "a_jwt", loggedUser.Token.Access)
+
+	s.repo.M.On("TakeOneTimeToken", "loginlink", utils.HashToken(token)).Return("", nil)
+	_, err = s.svc.LoginWithLink(context.TODO(), token)
+	assert.Equal(t, ErrInvalidLoginLink, err)
+}
+
+func (s *serviceSuite) TestRequestLoginRateLimit() {
+	t := s.T()
+	s.repo.M.On("GetUserByEmail", "unknown@gmail.com").Return(nil, nil)
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, s.svc.RequestLoginCode(context.TODO(), "unknown@gmail.com"))
+	}
+	assert.Equal(t, ErrTooManyAttempts, s.svc.RequestLoginLink(context.TODO(), "Unknown@gmail.com"))
+	assert.Empty(t, s.mailer.Sent())
+}
+
+func (s *serviceSuite) TestLoginWithCode() {
+	t := s.T()
+	email := "srojas@gmail.com"
+	user := &entities.User{ID: "1", Email: email, Status: entities.UserStatusActive}
+	s.repo.M.On("GetUserByEmail", email).Return(user, nil)
+	var codeHash string
+	s.repo.M.On("StoreOneTimeToken", "logincode", mock.Anything, "1", 10*time.Minute).Run(func(args mock.Arguments) {
+		codeHash = args.String(1)
+	}).Return(nil)
+	assert.Nil(t, s.svc.RequestLoginCode(context.TODO(), email))
+	assert.Len(t, s.mailer.Sent(), 1)
+
+	// Wrong codes count as failed logins until the account is locked out
+	s.repo.M.On("TakeOneTimeToken", "logincode", mock.Anything).Return("", nil)
+	for i := 0; i < 3; i++ {
+		_, err := s.svc.LoginWithCode(context.TODO(), email, "000000")
+		assert.Equal(t, ErrInvalidLoginCode, err)
+	}
+	_, err := s.svc.LoginWithCode(context.TODO(), email, "000000")
+	assert.Equal(t, ErrTooManyAttempts, err)
+	assert.NotEmpty(t, codeHash)
+}
+
+func (s *serviceSuite) TestImpersonate() {
+	t := s.T()
+	s.repo.M.On("GetUserByID", "1").Return(&entities.User{ID: "1", Email: "user@gmail.com"}, nil)
+	s.repo.M.On("GetUserByID", "9").Return(&entities.User{ID: "9", Email: "admin@gmail.com", IsAdmin: true}, nil)
+	_, err := s.svc.Impersonate(context.TODO(), "1", "9")
+	assert.Equal(t, ErrNotAdmin, err)
+
+	s.repo.M.On("AddImpersonation", mock.MatchedBy(func(impersonation *entities.Impersonation) bool {
+		return impersonation.ID == "i_uuid" && impersonation.AdminID == "9" && impersonation.UserID == "1" &&
+			impersonation.IP == "10.0.0.1"
+	})).Return(nil)
+	s.repo.M.On("StoreTokens", mock.MatchedBy(func(token *utils.StoredToken) bool {
+		return token.AccessUUID == "i_uuid" && token.RefreshToken == ""
+	})).Return(nil)
+	ctx := WithClientInfo(context.TODO(), "curl/7.68.0", "10.0.0.1")
+	token, err := s.svc.Impersonate(ctx, "9", "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "i_jwt", token.Access)
+	assert.Equal(t, "9", token.AdminID)
+	assert.Equal(t, "1", token.User.ID)
+	s.repo.M.AssertCalled(t, "AddImpersonation", mock.Anything)
+
+	s.repo.M.On("GetImpersonations").Return([]entities.Impersonation{
+		{ID: "i_uuid", AdminID: "9", UserID: "1"},
+		{ID: "other", AdminID: "9", UserID: "2"},
+	}, nil)
+	impersonations, err := s.svc.ListImpersonations(context.TODO(), "2")
+	assert.Nil(t, err)
+	assert.Len(t, impersonations, 1)
+	impersonations, _ = s.svc.ListImpersonations(context.TODO(), "")
+	assert.Len(t, impersonations, 2)
+}
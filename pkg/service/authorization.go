@@ -3,11 +3,15 @@ package service
 import (
 	"context"
 	"encoding/json"
-	"errors"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/StevenRojas/goaccess/pkg/configuration"
 	"github.com/StevenRojas/goaccess/pkg/entities"
 	"github.com/StevenRojas/goaccess/pkg/events"
 	"github.com/StevenRojas/goaccess/pkg/repository"
+	"github.com/StevenRojas/goaccess/pkg/utils"
 )
 
 // AuthorizationService authorization service to handle modules, submodules and sections
@@ -28,8 +32,13 @@ type AuthorizationService interface {
 	GetAccessList(ctx context.Context, userID string) (map[string]interface{}, error)
 	// GetActionListByModule get a json list with the actions can be performed by a user in a module
 	GetActionListByModule(ctx context.Context, module string, userID string) (map[string]interface{}, error)
-	// CheckPermission checks if a user has permission to perform an action
+	// CheckPermission checks if a user has permission to perform an action, the action could be
+	// a template action (put:vehicle:[]), a concrete action (put:vehicle:42) or a request (PUT /vehicle/42)
 	CheckPermission(ctx context.Context, action string, userID string) (bool, error)
+	// MatchRoute get the template action, module and submodule for a method and a raw path
+	MatchRoute(ctx context.Context, method string, path string) (*entities.RouteMatch, error)
+	// ReloadRoutes compile the route matcher again from the module templates
+	ReloadRoutes(ctx context.Context) error
 }
 
 type authorization struct {
@@ -38,15 +47,23 @@ type authorization struct {
 	actionsRepo    repository.ActionsRepository
 	usersRepo      repository.UsersRepository
 	subscriberFeed events.SubscriberFeed
+	versioned      bool // the tokens carry the roles or permissions of the users
+	lock           sync.RWMutex
+	matcher        utils.RouteMatcher
+	matcherTTL     time.Duration
+	matcherAt      time.Time // when the route matcher was compiled
 }
 
-// NewAuthorizationService return a new authorization service instance
+// NewAuthorizationService return a new authorization service instance, the token version of the users is increased
+// with their roles only when the tokens carry them. The route matcher is compiled again every ROUTES_RELOAD_SECONDS
+// to get the modules initialized by other instances
 func NewAuthorizationService(
 	modulesRepo repository.ModulesRepository,
 	rolesRepo repository.RolesRepository,
 	actionsRepo repository.ActionsRepository,
 	usersRepo repository.UsersRepository,
 	subscriberFeed events.SubscriberFeed,
+	securityConfig configuration.SecurityConfig,
 ) AuthorizationService {
 	return &authorization{
 		modulesRepo:    modulesRepo,
@@ -54,6 +71,8 @@ func NewAuthorizationService(
 		actionsRepo:    actionsRepo,
 		usersRepo:      usersRepo,
 		subscriberFeed: subscriberFeed,
+		versioned:      utils.VersionedClaims(securityConfig),
+		matcherTTL:     time.Second * time.Duration(securityConfig.RoutesReloadSeconds),
 	}
 }
 
@@ -70,12 +89,15 @@ func (a *authorization) ListUsersByRole(ctx context.Context, roleID string) ([]e
 // AssignActions assign actions to a role
 func (a *authorization) AssignActions(ctx context.Context, roleID string, module string, submodule string, actions []string) error {
 	if ok, _ := a.rolesRepo.IsValidRole(ctx, roleID); !ok {
-		return errors.New("Role not found")
+		return ErrRoleNotFound
 	}
 	err := a.actionsRepo.AssignActions(ctx, roleID, module, submodule, actions)
 	if err != nil {
 		return err
 	}
+	if err = a.incrementRoleTokenVersions(ctx, roleID); err != nil {
+		return err
+	}
 	roleEvent := &entities.RoleEvent{RoleID: roleID, EventType: entities.EventTypeAction}
 	go a.subscriberFeed.Send(roleEvent)
 	return nil
@@ -84,12 +106,15 @@ func (a *authorization) AssignActions(ctx context.Context, roleID string, module
 // UnassignActions unassign actions from a role
 func (a *authorization) UnassignActions(ctx context.Context, roleID string, module string, submodule string, actions []string) error {
 	if ok, _ := a.rolesRepo.IsValidRole(ctx, roleID); !ok {
-		return errors.New("Role not found")
+		return ErrRoleNotFound
 	}
 	err := a.actionsRepo.UnassignActions(ctx, roleID, module, submodule, actions)
 	if err != nil {
 		return err
 	}
+	if err = a.incrementRoleTokenVersions(ctx, roleID); err != nil {
+		return err
+	}
 	roleEvent := &entities.RoleEvent{RoleID: roleID, EventType: entities.EventTypeAction}
 	go a.subscriberFeed.Send(roleEvent)
 	return nil
@@ -98,15 +123,18 @@ func (a *authorization) UnassignActions(ctx context.Context, roleID string, modu
 // AssingRole assign role to a user
 func (a *authorization) AssignRole(ctx context.Context, userID string, roleID string) error {
 	if ok, _ := a.usersRepo.IsValidUser(ctx, userID); !ok {
-		return errors.New("User not found")
+		return ErrUserNotFound
 	}
 	if ok, _ := a.rolesRepo.IsValidRole(ctx, roleID); !ok {
-		return errors.New("Role not found")
+		return ErrRoleNotFound
 	}
 	err := a.rolesRepo.AssignRole(ctx, userID, roleID)
 	if err != nil {
 		return err
 	}
+	if err = a.incrementTokenVersion(ctx, userID, roleID); err != nil {
+		return err
+	}
 	roleEvent := &entities.RoleEvent{RoleID: roleID, UserID: userID, EventType: entities.EventTypeAccess}
 	go a.subscriberFeed.Send(roleEvent)
 	roleEvent = &entities.RoleEvent{RoleID: roleID, UserID: userID, EventType: entities.EventTypeAction}
@@ -117,15 +145,18 @@ func (a *authorization) AssignRole(ctx context.Context, userID string, roleID st
 // UnassignRole unassign role from a user
 func (a *authorization) UnassignRole(ctx context.Context, userID string, roleID string) error {
 	if ok, _ := a.usersRepo.IsValidUser(ctx, userID); !ok {
-		return errors.New("User not found")
+		return ErrUserNotFound
 	}
 	if ok, _ := a.rolesRepo.IsValidRole(ctx, roleID); !ok {
-		return errors.New("Role not found")
+		return ErrRoleNotFound
 	}
 	err := a.rolesRepo.UnassignRole(ctx, userID, roleID)
 	if err != nil {
 		return err
 	}
+	if err = a.incrementTokenVersion(ctx, userID, roleID); err != nil {
+		return err
+	}
 	go a.subscriberFeed.Send(&entities.RoleEvent{
 		RoleID:    roleID,
 		UserID:    userID,
@@ -147,7 +178,7 @@ func (a *authorization) GetAccessList(ctx context.Context, userID string) (map[s
 		return nil, err
 	}
 	if access == "" {
-		return nil, errors.New("User has not access defined")
+		return nil, ErrAccessNotDefined
 	}
 	var j map[string]interface{}
 	err = json.Unmarshal([]byte(access), &j)
@@ -164,7 +195,7 @@ func (a *authorization) GetActionListByModule(ctx context.Context, module string
 		return nil, err
 	}
 	if actions == "" {
-		return nil, errors.New("User has not actions defined")
+		return nil, ErrActionsNotDefined
 	}
 	var j map[string]interface{}
 	err = json.Unmarshal([]byte(actions), &j)
@@ -174,7 +205,133 @@ func (a *authorization) GetActionListByModule(ctx context.Context, module string
 	return j, nil
 }
 
-// CheckPermission checks if a user has permission to perform an action
+// CheckPermission checks if a user has permission to perform an action, the action could be
+// a template action (put:vehicle:[]), a concrete action (put:vehicle:42) or a request (PUT /vehicle/42)
 func (a *authorization) CheckPermission(ctx context.Context, action string, userID string) (bool, error) {
-	return a.actionsRepo.CheckPermission(ctx, action, userID)
+	if !strings.Contains(action, " ") {
+		allowed, err := a.actionsRepo.CheckPermission(ctx, action, userID)
+		if err != nil || allowed {
+			return allowed, err
+		}
+	}
+	matcher, err := a.routeMatcher(ctx)
+	if err != nil {
+		return false, err
+	}
+	var match *entities.RouteMatch
+	var ok bool
+	if parts := strings.SplitN(action, " ", 2); len(parts) == 2 {
+		match, ok = matcher.Match(parts[0], parts[1])
+	} else {
+		match, ok = matcher.MatchAction(action)
+	}
+	if !ok || match.Action == action {
+		return false, nil
+	}
+	return a.actionsRepo.CheckPermission(ctx, match.Action, userID)
+}
+
+// MatchRoute get the template action, module and submodule for a method and a raw path
+func (a *authorization) MatchRoute(ctx context.Context, method string, path string) (*entities.RouteMatch, error) {
+	matcher, err := a.routeMatcher(ctx)
+	if err != nil {
+		return nil, err
+	}
+	match, ok := matcher.Match(method, path)
+	if !ok {
+		return nil, ErrRouteNotFound
+	}
+	return match, nil
+}
+
+// ReloadRoutes compile the route matcher again from the module templates
+func (a *authorization) ReloadRoutes(ctx context.Context) error {
+	modules, err := a.actionsRepo.ActionTemplates(ctx)
+	if err != nil {
+		return err
+	}
+	a.lock.Lock()
+	a.matcher = utils.NewRouteMatcher(modules)
+	a.matcherAt = time.Now()
+	a.lock.Unlock()
+	return nil
+}
+
+// incrementTokenVersion increment the token version of a user, the roles in its tokens changed. Its action list
+// is rebuilt first, the action listener does it later, so a token refreshed right after carries the new
+// permissions digest along with the new version
+func (a *authorization) incrementTokenVersion(ctx context.Context, userID string, roleID string) error {
+	if !a.versioned {
+		return nil
+	}
+	if err := a.rebuildActionLists(ctx, roleID, []string{userID}); err != nil {
+		return err
+	}
+	return incrementTokenVersions(ctx, a.usersRepo, []string{userID})
+}
+
+// incrementRoleTokenVersions increment the token version of the users of a role once their action lists are
+// rebuilt, the permissions in their tokens changed
+func (a *authorization) incrementRoleTokenVersions(ctx context.Context, roleID string) error {
+	if !a.versioned {
+		return nil
+	}
+	users, err := a.rolesRepo.UsersByRole(ctx, roleID)
+	if err != nil {
+		return err
+	}
+	if err = a.rebuildActionLists(ctx, roleID, users); err != nil {
+		return err
+	}
+	return incrementTokenVersions(ctx, a.usersRepo, users)
+}
+
+// rebuildActionLists rebuild the action lists of the users of a role like the action listener does
+func (a *authorization) rebuildActionLists(ctx context.Context, roleID string, users []string) error {
+	for _, userID := range users {
+		if err := a.actionsRepo.SetActionList(ctx, userID); err != nil {
+			return err
+		}
+	}
+	return a.actionsRepo.UpdateActionList(ctx, roleID)
+}
+
+// incrementTokenVersions increment the token version of the given users
+func incrementTokenVersions(ctx context.Context, usersRepo repository.UsersRepository, users []string) error {
+	for _, userID := range users {
+		// Service clients and API keys are assigned roles too, they have no user tokens
+		if strings.HasPrefix(userID, entities.ClientSubjectPrefix) || strings.HasPrefix(userID, entities.APIKeySubjectPrefix) {
+			continue
+		}
+		if _, err := usersRepo.IncrementTokenVersion(ctx, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// routeMatcher get the route matcher compiling it on first use and again when it is older than
+// ROUTES_RELOAD_SECONDS, the modules can be initialized by another instance
+func (a *authorization) routeMatcher(ctx context.Context) (utils.RouteMatcher, error) {
+	a.lock.Lock()
+	matcher := a.matcher
+	stale := a.matcherTTL > 0 && time.Since(a.matcherAt) > a.matcherTTL
+	if matcher != nil && stale {
+		// Only this request reloads the routes, the others keep using the current matcher meanwhile
+		a.matcherAt = time.Now()
+	}
+	a.lock.Unlock()
+	if matcher != nil && !stale {
+		return matcher, nil
+	}
+	if err := a.ReloadRoutes(ctx); err != nil {
+		if matcher != nil {
+			// Keep the current routes, they are reloaded again after ROUTES_RELOAD_SECONDS
+			return matcher, nil
+		}
+		return nil, err
+	}
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return a.matcher, nil
 }
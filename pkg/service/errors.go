@@ -0,0 +1,118 @@
+package service
+
+import (
+	"errors"
+	"net/url"
+)
+
+var (
+	// ErrUserNotFound returned when the user does not exist
+	ErrUserNotFound = errors.New("User not found")
+	// ErrRoleNotFound returned when the role does not exist
+	ErrRoleNotFound = errors.New("Role not found")
+	// ErrAccessNotDefined returned when the user has no access list
+	ErrAccessNotDefined = errors.New("User has not access defined")
+	// ErrActionsNotDefined returned when the user has no actions for the module
+	ErrActionsNotDefined = errors.New("User has not actions defined")
+	// ErrRouteNotFound returned when a request does not match any action template
+	ErrRouteNotFound = errors.New("Route not found")
+	// ErrInvalidCredentials returned when the password doesn't match or the user has no password
+	ErrInvalidCredentials = errors.New("Invalid email or password")
+	// ErrEmptyPassword returned when trying to set an empty password
+	ErrEmptyPassword = errors.New("Password is required")
+	// ErrPasswordExpired returned when the password is older than the policy max age
+	ErrPasswordExpired = errors.New("Password expired, it must be changed")
+	// ErrMFARequired returned when an admin user without MFA tries to log in
+	ErrMFARequired = errors.New("Multi-factor authentication is required for admin users")
+	// ErrMFAEnabled returned when enrolling a user that already has MFA enabled
+	ErrMFAEnabled = errors.New("Multi-factor authentication is already enabled")
+	// ErrMFANotEnabled returned when the operation requires MFA to be enabled or enrolled
+	ErrMFANotEnabled = errors.New("Multi-factor authentication is not enabled")
+	// ErrInvalidMFACode returned when a TOTP or recovery code is not valid
+	ErrInvalidMFACode = errors.New("Invalid authentication code")
+	// ErrInvalidMFAChallenge returned when the login challenge doesn't exist or has expired
+	ErrInvalidMFAChallenge = errors.New("Invalid or expired MFA challenge")
+	// ErrTokenReused returned when a rotated refresh token is used again, the token family is revoked
+	ErrTokenReused = errors.New("Refresh token already used, the session was revoked")
+	// ErrKeyRotationInProgress returned when the signing key is being rotated by another instance
+	ErrKeyRotationInProgress = errors.New("Signing key rotation already in progress")
+	// ErrKeyRotationDisabled returned when rotating the signing key without a key encryption key to store it
+	ErrKeyRotationDisabled = errors.New("Signing key rotation requires JWT_KEY_ENCRYPTION_KEY")
+	// ErrClientNotFound returned when the service client does not exist
+	ErrClientNotFound = errors.New("Client not found")
+	// ErrInvalidClientCredentials returned when the client ID or secret doesn't match
+	ErrInvalidClientCredentials = errors.New("Invalid client ID or secret")
+	// ErrInvalidScope returned when a client requests a scope it was not granted
+	ErrInvalidScope = errors.New("Requested scope not granted to the client")
+	// ErrInternalTokensDisabled returned when the internal tokens secret is not configured
+	ErrInternalTokensDisabled = errors.New("Internal tokens are disabled")
+	// ErrAPIKeyNotFound returned when the API key does not exist
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	// ErrSessionNotFound returned when the session does not exist or belongs to another user
+	ErrSessionNotFound = errors.New("Session not found")
+	// ErrAccountDisabled returned when a disabled user tries to authenticate
+	ErrAccountDisabled = errors.New("Account disabled")
+	// ErrAccountLocked returned when a locked user tries to authenticate
+	ErrAccountLocked = errors.New("Account locked")
+	// ErrAccountPending returned when a user pending verification tries to authenticate
+	ErrAccountPending = errors.New("Account pending verification")
+	// ErrTooManyAttempts returned when the account or IP is locked out after too many failed logins
+	ErrTooManyAttempts = errors.New("Too many failed login attempts, try again later")
+	// ErrNotAdmin returned when a user that is not an admin tries an admin only operation
+	ErrNotAdmin = errors.New("Only admin users can perform this operation")
+	// ErrInvalidResetToken returned when the password reset token doesn't exist, was used or has expired
+	ErrInvalidResetToken = errors.New("Invalid or expired password reset token")
+	// ErrInvalidVerificationToken returned when the email verification token doesn't exist, was used or has expired
+	ErrInvalidVerificationToken = errors.New("Invalid or expired email verification token")
+	// ErrInvalidLoginLink returned when the passwordless login link doesn't exist, was used or has expired
+	ErrInvalidLoginLink = errors.New("Invalid or expired login link")
+	// ErrInvalidLoginCode returned when the passwordless login code doesn't match, was used or has expired
+	ErrInvalidLoginCode = errors.New("Invalid or expired login code")
+	// ErrEmailVerified returned when sending a verification to a user whose email is already verified
+	ErrEmailVerified = errors.New("Email already verified")
+	// ErrInvalidToken returned when the token claims are not the expected ones
+	ErrInvalidToken = errors.New("Invalid token")
+	// ErrExpiredToken returned when the token is not stored or belongs to another user
+	ErrExpiredToken = errors.New("Invalid or expired token")
+	// ErrOIDCDisabled returned when the OpenID Connect issuer is not configured
+	ErrOIDCDisabled = errors.New("OpenID Connect provider is disabled")
+	// ErrInvalidRedirectURI returned when the OpenID Connect client doesn't exist or the redirect URI is not
+	// registered, the user agent must not be redirected
+	ErrInvalidRedirectURI = errors.New("Unknown client or redirect URI")
+	// ErrInvalidGrant returned when the authorization code doesn't exist, was used, has expired or doesn't match
+	// the client, redirect URI or PKCE verifier
+	ErrInvalidGrant = errors.New("Invalid or expired authorization code")
+	// ErrFederationDisabled returned when the external identity provider is not configured
+	ErrFederationDisabled = errors.New("Federated login is disabled")
+	// ErrInvalidFederationState returned when the state of a federated login doesn't exist, was used or has expired
+	ErrInvalidFederationState = errors.New("Invalid or expired federated login")
+)
+
+// ValidationError error with the validation messages by field
+type ValidationError struct {
+	Errors url.Values
+}
+
+func (e *ValidationError) Error() string {
+	return "Validation failed"
+}
+
+// AuthorizationError OAuth2 error of an authorization request, it is sent to the client redirect URI
+// (RFC 6749 section 4.1.2.1)
+type AuthorizationError struct {
+	Code        string
+	Description string
+}
+
+func (e *AuthorizationError) Error() string {
+	return e.Description
+}
+
+// FederationError federated login rejected by the external identity provider or whose ID token is not valid
+type FederationError struct {
+	Reason string
+}
+
+func (e *FederationError) Error() string {
+	return "Federated login failed: " + e.Reason
+}
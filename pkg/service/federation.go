@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/StevenRojas/goaccess/pkg/repository"
+	"github.com/StevenRojas/goaccess/pkg/utils"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/rs/xid"
+)
+
+// FederationService federated login through an external OpenID Connect identity provider, the users are mapped
+// by email and get the usual access and refresh tokens
+type FederationService interface {
+	// StartLogin start a federated login and return the identity provider URL the user is redirected to and the
+	// state of the login, the state must be bound to the user agent, e.g. in a cookie, and checked in the callback
+	StartLogin(ctx context.Context) (authorizeURL string, state string, err error)
+	// CompleteLogin complete a federated login with the state and code the identity provider redirected the user
+	// back with, same result as Login for the user linked to the ID token subject or of its email. Unknown emails
+	// are provisioned when enabled
+	CompleteLogin(ctx context.Context, state string, code string) (*entities.LoggedUser, error)
+}
+
+type federation struct {
+	repo           repository.OIDCRepository
+	usersRepo      repository.UsersRepository
+	authentication AuthenticationService
+	issuer         utils.OIDCIssuer
+	config         configuration.FederationConfig
+}
+
+// NewFederationService return a new federated login instance on top of the authentication service
+func NewFederationService(
+	oidcRepo repository.OIDCRepository,
+	usersRepo repository.UsersRepository,
+	authentication AuthenticationService,
+	issuer utils.OIDCIssuer,
+	config configuration.FederationConfig,
+) FederationService {
+	return &federation{
+		repo:           oidcRepo,
+		usersRepo:      usersRepo,
+		authentication: authentication,
+		issuer:         issuer,
+		config:         config,
+	}
+}
+
+// StartLogin start a federated login and return the identity provider URL the user is redirected to and the state
+// of the login. The state is single use and keeps the nonce and PKCE verifier of the login until
+// FEDERATION_STATE_EXPIRE_MINUTES
+func (f *federation) StartLogin(ctx context.Context) (string, string, error) {
+	if f.config.Issuer == "" {
+		return "", "", ErrFederationDisabled
+	}
+	state, err := utils.RandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := utils.RandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := utils.RandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	expiration := time.Minute * time.Duration(f.config.StateExpiration)
+	err = f.repo.StoreFederationState(ctx, utils.HashToken(state), &entities.FederationState{
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+	}, expiration)
+	if err != nil {
+		return "", "", err
+	}
+	authorizeURL, err := f.issuer.AuthorizationURL(ctx, state, nonce, codeChallenge(verifier))
+	if err != nil {
+		return "", "", err
+	}
+	return authorizeURL, state, nil
+}
+
+// CompleteLogin complete a federated login with the state and code the identity provider redirected the user
+// back with, same result as Login for the user of the ID token email. Unknown emails are provisioned when enabled
+func (f *federation) CompleteLogin(ctx context.Context, state string, code string) (*entities.LoggedUser, error) {
+	if f.config.Issuer == "" {
+		return nil, ErrFederationDisabled
+	}
+	if state == "" {
+		return nil, ErrInvalidFederationState
+	}
+	pending, err := f.repo.TakeFederationState(ctx, utils.HashToken(state))
+	if err != nil {
+		return nil, err
+	}
+	if pending == nil {
+		return nil, ErrInvalidFederationState
+	}
+	claims, err := f.issuer.Exchange(ctx, code, pending.CodeVerifier, pending.Nonce)
+	if err != nil {
+		return nil, &FederationError{Reason: err.Error()}
+	}
+	user, err := f.federatedUser(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+	return f.authentication.LoginFederated(ctx, user)
+}
+
+// federatedUser get the user linked to the ID token subject or, on its first login, the user of the ID token email.
+// The email must be verified by the identity provider unless FEDERATION_REQUIRE_VERIFIED_EMAIL is disabled. A user
+// is linked to one subject of the issuer, admin users only when FEDERATION_LINK_ADMINS is enabled. Unknown users are
+// created with no password and no roles when FEDERATION_PROVISION is enabled
+func (f *federation) federatedUser(ctx context.Context, claims jwt.MapClaims) (*entities.User, error) {
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, &FederationError{Reason: "the ID token has no subject"}
+	}
+	userID, err := f.repo.GetFederatedUser(ctx, f.config.Issuer, subject)
+	if err != nil {
+		return nil, err
+	}
+	if userID != "" {
+		user, err := f.usersRepo.GetUserByID(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		// The link is kept even if the email changes at the identity provider, users removed since are linked again
+		if user != nil && user.ID != "" {
+			return user, nil
+		}
+	}
+	email, _ := claims["email"].(string)
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return nil, &FederationError{Reason: "the ID token has no email"}
+	}
+	verified := trueClaim(claims["email_verified"])
+	if f.config.RequireVerifiedEmail && !verified {
+		return nil, &FederationError{Reason: "the email is not verified by the identity provider"}
+	}
+	user, err := f.usersRepo.GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	if user != nil && user.ID != "" {
+		linked, err := f.repo.GetFederatedSubject(ctx, f.config.Issuer, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if linked != "" && linked != subject {
+			return nil, &FederationError{Reason: "the user is linked to another identity"}
+		}
+		if user.IsAdmin && !f.config.LinkAdmins {
+			return nil, &FederationError{Reason: "admin users are not linked automatically"}
+		}
+		if err = f.repo.LinkFederatedUser(ctx, f.config.Issuer, subject, user.ID); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+	if !f.config.Provision {
+		return nil, ErrUserNotFound
+	}
+	name, _ := claims["name"].(string)
+	user = &entities.User{
+		ID:     xid.New().String(),
+		Email:  email,
+		Name:   name,
+		Status: entities.UserStatusActive,
+	}
+	if err = f.usersRepo.Register(ctx, user); err != nil {
+		return nil, err
+	}
+	if verified {
+		if err = f.usersRepo.SetEmailVerified(ctx, user.ID, time.Now()); err != nil {
+			return nil, err
+		}
+		user.EmailVerified = true
+	}
+	if err = f.repo.LinkFederatedUser(ctx, f.config.Issuer, subject, user.ID); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// trueClaim check a boolean claim, some identity providers send it as a string
+func trueClaim(claim interface{}) bool {
+	switch value := claim.(type) {
+	case bool:
+		return value
+	case string:
+		return value == "true"
+	}
+	return false
+}
@@ -0,0 +1,258 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/StevenRojas/goaccess/pkg/events"
+	"github.com/StevenRojas/goaccess/pkg/repository"
+	"github.com/StevenRojas/goaccess/pkg/utils"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+// memFederationRepo keeps the pending federated logins like the repository does, single use, and the linked
+// identities
+type memFederationRepo struct {
+	repository.OIDCRepoMock
+	states   map[string]*entities.FederationState
+	subjects map[string]string
+	users    map[string]string
+}
+
+func (r *memFederationRepo) StoreFederationState(ctx context.Context, stateHash string, state *entities.FederationState, ttl time.Duration) error {
+	r.states[stateHash] = state
+	return nil
+}
+
+func (r *memFederationRepo) GetFederatedUser(ctx context.Context, issuer string, subject string) (string, error) {
+	return r.subjects[issuer+" "+subject], nil
+}
+
+func (r *memFederationRepo) GetFederatedSubject(ctx context.Context, issuer string, userID string) (string, error) {
+	return r.users[issuer+" "+userID], nil
+}
+
+func (r *memFederationRepo) LinkFederatedUser(ctx context.Context, issuer string, subject string, userID string) error {
+	r.subjects[issuer+" "+subject] = userID
+	r.users[issuer+" "+userID] = subject
+	return nil
+}
+
+func (r *memFederationRepo) TakeFederationState(ctx context.Context, stateHash string) (*entities.FederationState, error) {
+	state := r.states[stateHash]
+	delete(r.states, stateHash)
+	return state, nil
+}
+
+type federationSuite struct {
+	svc      FederationService
+	users    *repository.UsersRepoMock
+	oidc     *memFederationRepo
+	provider *utils.OIDCProviderMock
+	config   configuration.FederationConfig
+	suite.Suite
+}
+
+func (s *federationSuite) SetupTest() {
+	s.provider = utils.NewOIDCProviderMock("goaccess", "s3cret")
+	s.provider.SetClaims(jwt.MapClaims{"sub": "idp-1", "email": "srojas@gmail.com", "email_verified": true, "name": "steven rojas"})
+	s.users = new(repository.UsersRepoMock)
+	s.users.M.On("GetUserRoles", mock.Anything).Return([]string{}, nil)
+	s.users.M.On("GetUserActions", mock.Anything).Return([]string{}, nil)
+	s.users.M.On("GetMFASecret", mock.Anything).Return("", false, nil)
+	s.users.M.On("StoreTokens", mock.Anything).Return(nil)
+	s.users.M.On("StoreSession", mock.Anything).Return(nil)
+	s.oidc = &memFederationRepo{
+		states:   make(map[string]*entities.FederationState),
+		subjects: make(map[string]string),
+		users:    make(map[string]string),
+	}
+	s.config = configuration.FederationConfig{
+		Issuer:               s.provider.URL(),
+		ClientID:             "goaccess",
+		ClientSecret:         "s3cret",
+		RedirectURL:          "https://auth.example.com/federation/callback",
+		Scopes:               "openid email profile",
+		RequireVerifiedEmail: true,
+		StateExpiration:      10,
+		KeysCacheMinutes:     60,
+	}
+	s.svc = s.newService(s.config)
+}
+
+func (s *federationSuite) TearDownTest() {
+	s.provider.Close()
+}
+
+func TestFederationService(t *testing.T) {
+	suite.Run(t, new(federationSuite))
+}
+
+func (s *federationSuite) newService(config configuration.FederationConfig) FederationService {
+	policy, _ := utils.NewPasswordPolicy(configuration.PasswordPolicyConfig{})
+	authentication := NewAuthenticationService(
+		s.users,
+		new(repository.APIKeysRepoMock),
+		utils.NewJwtHandlerMock(configuration.SecurityConfig{}),
+		nil,
+		utils.NewPasswordHasherMock(),
+		policy,
+		utils.NewLoginThrottle(configuration.LoginThrottleConfig{}, nil),
+		utils.NewRateLimiter(nil, 0, 0),
+		utils.NewTOTPHandler(configuration.MFAConfig{}),
+		configuration.MFAConfig{ChallengeExpiration: 5},
+		utils.NewMailerMock(),
+		configuration.EmailTokensConfig{},
+		events.NewSecurityFeed(),
+	)
+	return NewFederationService(s.oidc, s.users, authentication, utils.NewOIDCIssuer(config), config)
+}
+
+// login start a federated login and follow it through the provider, returns the state and code of the callback
+func (s *federationSuite) login() (string, string) {
+	t := s.T()
+	authorizeURL, started, err := s.svc.StartLogin(context.TODO())
+	assert.Nil(t, err)
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authorizeURL)
+	if !assert.Nil(t, err) {
+		return "", ""
+	}
+	resp.Body.Close()
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+	assert.Equal(t, "https://auth.example.com/federation/callback", callback.Scheme+"://"+callback.Host+callback.Path)
+	assert.Equal(t, started, callback.Query().Get("state"))
+	return callback.Query().Get("state"), callback.Query().Get("code")
+}
+
+func (s *federationSuite) TestCompleteLogin() {
+	t := s.T()
+	user := &entities.User{ID: "1", Email: "srojas@gmail.com", Status: entities.UserStatusActive}
+	s.users.M.On("GetUserByEmail", "srojas@gmail.com").Return(user, nil)
+	state, code := s.login()
+	loggedUser, err := s.svc.CompleteLogin(context.TODO(), state, code)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", loggedUser.User.ID)
+	assert.Equal(t, "a_jwt", loggedUser.Token.Access)
+	s.users.M.AssertNotCalled(t, "Register", mock.Anything)
+	assert.Equal(t, "1", s.oidc.subjects[s.provider.URL()+" idp-1"], "the identity is linked on the first login")
+
+	// States are single use
+	_, err = s.svc.CompleteLogin(context.TODO(), state, code)
+	assert.Equal(t, ErrInvalidFederationState, err)
+}
+
+func (s *federationSuite) TestCompleteLoginProvision() {
+	t := s.T()
+	s.users.M.On("GetUserByEmail", "srojas@gmail.com").Return(nil, nil)
+	state, code := s.login()
+	_, err := s.svc.CompleteLogin(context.TODO(), state, code)
+	assert.Equal(t, ErrUserNotFound, err, "unknown users are not provisioned by default")
+
+	s.config.Provision = true
+	s.svc = s.newService(s.config)
+	s.users.M.On("Register", mock.Anything).Return(nil, nil)
+	s.users.M.On("SetEmailVerified", mock.Anything, mock.Anything).Return(nil)
+	state, code = s.login()
+	loggedUser, err := s.svc.CompleteLogin(context.TODO(), state, code)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, loggedUser.User.ID)
+	assert.Equal(t, "srojas@gmail.com", loggedUser.User.Email)
+	assert.Equal(t, "steven rojas", loggedUser.User.Name)
+	assert.True(t, loggedUser.User.EmailVerified)
+	assert.False(t, loggedUser.User.IsAdmin)
+	s.users.M.AssertCalled(t, "Register", loggedUser.User.ID)
+	s.users.M.AssertCalled(t, "SetEmailVerified", loggedUser.User.ID, mock.Anything)
+	assert.Equal(t, loggedUser.User.ID, s.oidc.subjects[s.provider.URL()+" idp-1"])
+	assert.Equal(t, "a_jwt", loggedUser.Token.Access)
+}
+
+func (s *federationSuite) TestCompleteLoginLinkedIdentity() {
+	t := s.T()
+	user := &entities.User{ID: "1", Email: "srojas@gmail.com", Status: entities.UserStatusActive}
+	s.users.M.On("GetUserByID", "1").Return(user, nil)
+	s.oidc.LinkFederatedUser(context.TODO(), s.provider.URL(), "idp-1", "1")
+	// The linked user logs in even if its email changed at the identity provider
+	s.provider.SetClaims(jwt.MapClaims{"sub": "idp-1", "email": "steven@example.com", "email_verified": true})
+	state, code := s.login()
+	loggedUser, err := s.svc.CompleteLogin(context.TODO(), state, code)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", loggedUser.User.ID)
+	s.users.M.AssertNotCalled(t, "GetUserByEmail", mock.Anything)
+
+	// Another identity with the email of the linked user is refused
+	s.users.M.On("GetUserByEmail", "srojas@gmail.com").Return(user, nil)
+	s.provider.SetClaims(jwt.MapClaims{"sub": "idp-2", "email": "SRojas@Gmail.com ", "email_verified": true})
+	state, code = s.login()
+	_, err = s.svc.CompleteLogin(context.TODO(), state, code)
+	if assert.IsType(t, &FederationError{}, err) {
+		assert.Contains(t, err.Error(), "linked to another identity")
+	}
+	s.users.M.AssertCalled(t, "GetUserByEmail", "srojas@gmail.com")
+}
+
+func (s *federationSuite) TestCompleteLoginAdmin() {
+	t := s.T()
+	admin := &entities.User{ID: "1", Email: "srojas@gmail.com", IsAdmin: true, Status: entities.UserStatusActive}
+	s.users.M.On("GetUserByEmail", "srojas@gmail.com").Return(admin, nil)
+	state, code := s.login()
+	_, err := s.svc.CompleteLogin(context.TODO(), state, code)
+	if assert.IsType(t, &FederationError{}, err) {
+		assert.Contains(t, err.Error(), "admin users are not linked automatically")
+	}
+	assert.Empty(t, s.oidc.subjects)
+
+	s.config.LinkAdmins = true
+	s.svc = s.newService(s.config)
+	state, code = s.login()
+	loggedUser, err := s.svc.CompleteLogin(context.TODO(), state, code)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", loggedUser.User.ID)
+}
+
+func (s *federationSuite) TestCompleteLoginRejected() {
+	t := s.T()
+	s.provider.SetClaims(jwt.MapClaims{"sub": "idp-1", "email": "srojas@gmail.com", "email_verified": false})
+	state, code := s.login()
+	_, err := s.svc.CompleteLogin(context.TODO(), state, code)
+	assert.IsType(t, &FederationError{}, err)
+
+	// A code of another login can't be used with this state
+	state, _ = s.login()
+	_, code = s.login()
+	_, err = s.svc.CompleteLogin(context.TODO(), state, code)
+	if assert.IsType(t, &FederationError{}, err) {
+		assert.Contains(t, err.Error(), "invalid_grant")
+	}
+
+	_, err = s.svc.CompleteLogin(context.TODO(), "unknown", code)
+	assert.Equal(t, ErrInvalidFederationState, err)
+	s.users.M.AssertNotCalled(t, "GetUserByEmail", mock.Anything)
+}
+
+func (s *federationSuite) TestCompleteLoginAccountStatus() {
+	t := s.T()
+	user := &entities.User{ID: "1", Email: "srojas@gmail.com", Status: entities.UserStatusDisabled}
+	s.users.M.On("GetUserByEmail", "srojas@gmail.com").Return(user, nil)
+	state, code := s.login()
+	_, err := s.svc.CompleteLogin(context.TODO(), state, code)
+	assert.Equal(t, ErrAccountDisabled, err)
+	s.users.M.AssertNotCalled(t, "StoreTokens", mock.Anything)
+}
+
+func (s *federationSuite) TestDisabled() {
+	t := s.T()
+	disabled := NewFederationService(s.oidc, s.users, nil, nil, configuration.FederationConfig{})
+	_, _, err := disabled.StartLogin(context.TODO())
+	assert.Equal(t, ErrFederationDisabled, err)
+	_, err = disabled.CompleteLogin(context.TODO(), "state", "code")
+	assert.Equal(t, ErrFederationDisabled, err)
+}
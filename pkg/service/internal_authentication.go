@@ -0,0 +1,417 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/StevenRojas/goaccess/pkg/events"
+	"github.com/StevenRojas/goaccess/pkg/repository"
+	"github.com/StevenRojas/goaccess/pkg/utils"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/rs/xid"
+)
+
+// clientSecretSize random bytes of the generated client secrets
+const clientSecretSize = 32
+
+// InternalAuthenticationService service interface for machine identities, e.g. backend jobs
+type InternalAuthenticationService interface {
+	// RegisterClient register a service client with the given scopes and return it with its secret,
+	// the secret is not stored and can't be retrieved later
+	RegisterClient(ctx context.Context, name string, scopes []string) (*entities.ServiceClient, string, error)
+	// ListClients get a list of all service clients
+	ListClients(ctx context.Context) ([]entities.ServiceClient, error)
+	// DeleteClient delete a service client and its role assignments, its tokens are no longer accepted
+	DeleteClient(ctx context.Context, clientID string) error
+	// AssignClientRole assign a role to a service client, its permissions are checked like the ones of a user
+	AssignClientRole(ctx context.Context, clientID string, roleID string) error
+	// UnassignClientRole unassign a role from a service client
+	UnassignClientRole(ctx context.Context, clientID string, roleID string) error
+	// ClientCredentialsToken OAuth2 client_credentials grant, authenticate a service client and return
+	// an access token for the requested scopes, all the client scopes when none is requested
+	ClientCredentialsToken(ctx context.Context, clientID string, secret string, scopes []string) (*entities.OAuthToken, error)
+	// IssueInternalToken authenticate a service client and return an internal token pair for the requested
+	// scopes, all the client scopes when none is requested
+	IssueInternalToken(ctx context.Context, clientID string, secret string, scopes []string) (*entities.Token, error)
+	// RefreshInternalToken rotate an internal refresh token
+	RefreshInternalToken(ctx context.Context, token string) (*entities.Token, error)
+	// VerifyInternalToken check if an internal token is valid and return the client and its granted scopes,
+	// user tokens are rejected
+	VerifyInternalToken(ctx context.Context, token string) (*entities.InternalIdentity, error)
+	// AuthenticateClient check the secret of a service client
+	AuthenticateClient(ctx context.Context, clientID string, secret string) (*entities.ServiceClient, error)
+	// IntrospectToken get the state of an internal access or refresh token (RFC 7662), the roles are not set.
+	// Nil when the token doesn't verify as an internal token
+	IntrospectToken(ctx context.Context, token string) (*entities.TokenIntrospection, error)
+}
+
+type internalAuthentication struct {
+	repo           repository.ClientsRepository
+	rolesRepo      repository.RolesRepository
+	tokenHandler   utils.InternalTokenHandler
+	subscriberFeed events.SubscriberFeed
+}
+
+// NewInternalAuthenticationService return a new internal authentication service instance
+func NewInternalAuthenticationService(
+	clientsRepo repository.ClientsRepository,
+	rolesRepo repository.RolesRepository,
+	tokenHandler utils.InternalTokenHandler,
+	subscriberFeed events.SubscriberFeed,
+) InternalAuthenticationService {
+	return &internalAuthentication{
+		repo:           clientsRepo,
+		rolesRepo:      rolesRepo,
+		tokenHandler:   tokenHandler,
+		subscriberFeed: subscriberFeed,
+	}
+}
+
+// RegisterClient register a service client with the given scopes and return it with its secret
+func (ia *internalAuthentication) RegisterClient(ctx context.Context, name string, scopes []string) (*entities.ServiceClient, string, error) {
+	errs := url.Values{}
+	if strings.TrimSpace(name) == "" {
+		errs.Add("name", "The name field is required")
+	}
+	for _, scope := range scopes {
+		if scope == "" || strings.ContainsAny(scope, " \t\n") {
+			errs.Add("scopes", "The scopes can't be empty or contain spaces")
+			break
+		}
+	}
+	if len(errs) > 0 {
+		return nil, "", &ValidationError{Errors: errs}
+	}
+	secret, err := utils.RandomToken(clientSecretSize)
+	if err != nil {
+		return nil, "", err
+	}
+	client := &entities.ServiceClient{
+		ID:        xid.New().String(),
+		Name:      name,
+		Scopes:    scopes,
+		Roles:     []string{},
+		CreatedAt: time.Now(),
+	}
+	if err = ia.repo.AddClient(ctx, client, utils.HashAPIKeySecret(secret)); err != nil {
+		return nil, "", err
+	}
+	return client, secret, nil
+}
+
+// ListClients get a list of all service clients
+func (ia *internalAuthentication) ListClients(ctx context.Context) ([]entities.ServiceClient, error) {
+	clients, err := ia.repo.GetClients(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range clients {
+		if clients[i].Roles, err = ia.clientRoles(ctx, clients[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return clients, nil
+}
+
+// DeleteClient delete a service client and its role assignments, its tokens are no longer accepted
+func (ia *internalAuthentication) DeleteClient(ctx context.Context, clientID string) error {
+	client, err := ia.repo.GetClient(ctx, clientID)
+	if err != nil {
+		return err
+	}
+	if client == nil {
+		return ErrClientNotFound
+	}
+	roles, err := ia.clientRoles(ctx, clientID)
+	if err != nil {
+		return err
+	}
+	for _, roleID := range roles {
+		if err = ia.UnassignClientRole(ctx, clientID, roleID); err != nil {
+			return err
+		}
+	}
+	return ia.repo.DeleteClient(ctx, clientID)
+}
+
+// AssignClientRole assign a role to a service client, its permissions are checked like the ones of a user
+func (ia *internalAuthentication) AssignClientRole(ctx context.Context, clientID string, roleID string) error {
+	if err := ia.checkClientRole(ctx, clientID, roleID); err != nil {
+		return err
+	}
+	subject := entities.ClientSubjectPrefix + clientID
+	if err := ia.rolesRepo.AssignRole(ctx, subject, roleID); err != nil {
+		return err
+	}
+	ia.sendRoleEvents(subject, roleID)
+	return nil
+}
+
+// UnassignClientRole unassign a role from a service client
+func (ia *internalAuthentication) UnassignClientRole(ctx context.Context, clientID string, roleID string) error {
+	if err := ia.checkClientRole(ctx, clientID, roleID); err != nil {
+		return err
+	}
+	subject := entities.ClientSubjectPrefix + clientID
+	if err := ia.rolesRepo.UnassignRole(ctx, subject, roleID); err != nil {
+		return err
+	}
+	ia.sendRoleEvents(subject, roleID)
+	return nil
+}
+
+// IssueInternalToken authenticate a service client and return an internal token pair for the requested scopes
+func (ia *internalAuthentication) IssueInternalToken(ctx context.Context, clientID string, secret string, scopes []string) (*entities.Token, error) {
+	client, scopes, err := ia.authenticateClient(ctx, clientID, secret, scopes)
+	if err != nil {
+		return nil, err
+	}
+	return ia.saveInternalToken(ctx, client.ID, scopes)
+}
+
+// ClientCredentialsToken OAuth2 client_credentials grant, authenticate a service client and return
+// an access token for the requested scopes, all the client scopes when none is requested
+func (ia *internalAuthentication) ClientCredentialsToken(ctx context.Context, clientID string, secret string, scopes []string) (*entities.OAuthToken, error) {
+	client, scopes, err := ia.authenticateClient(ctx, clientID, secret, scopes)
+	if err != nil {
+		return nil, err
+	}
+	// No refresh token is issued for this grant (RFC 6749 section 4.4.3), the client authenticates again
+	token, exp, err := ia.tokenHandler.CreateAccessToken(client.ID, scopes)
+	if err != nil {
+		if err == utils.ErrInternalTokensDisabled {
+			return nil, ErrInternalTokensDisabled
+		}
+		return nil, err
+	}
+	return &entities.OAuthToken{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   exp - time.Now().Unix(),
+		Scope:       strings.Join(scopes, " "),
+	}, nil
+}
+
+// RefreshInternalToken rotate an internal refresh token
+func (ia *internalAuthentication) RefreshInternalToken(ctx context.Context, token string) (*entities.Token, error) {
+	claims, err := ia.parseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	refreshKey, ok := claims["refresh_uuid"].(string)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	clientID, err := ia.repo.ConsumeInternalToken(ctx, refreshKey)
+	if err != nil {
+		return nil, err
+	}
+	if claimedClientID, _ := claims["client_id"].(string); clientID == "" || clientID != claimedClientID {
+		return nil, ErrExpiredToken
+	}
+	client, err := ia.repo.GetClient(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, ErrExpiredToken
+	}
+	// Scopes removed from the client since the token was issued are dropped
+	scopes := grantedScopes(claimScopes(claims), client.Scopes)
+	if len(scopes) == 0 {
+		return nil, ErrInvalidScope
+	}
+	return ia.saveInternalToken(ctx, client.ID, scopes)
+}
+
+// VerifyInternalToken check if an internal token is valid and return the client and its granted scopes
+func (ia *internalAuthentication) VerifyInternalToken(ctx context.Context, token string) (*entities.InternalIdentity, error) {
+	claims, err := ia.parseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := claims["access_uuid"]; !ok {
+		return nil, ErrInvalidToken
+	}
+	clientID, ok := claims["client_id"].(string)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	client, err := ia.repo.GetClient(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, ErrExpiredToken
+	}
+	return &entities.InternalIdentity{
+		ClientID: client.ID,
+		Subject:  entities.ClientSubjectPrefix + client.ID,
+		Scopes:   grantedScopes(claimScopes(claims), client.Scopes),
+	}, nil
+}
+
+// AuthenticateClient check the secret of a service client
+func (ia *internalAuthentication) AuthenticateClient(ctx context.Context, clientID string, secret string) (*entities.ServiceClient, error) {
+	client, _, err := ia.authenticateClient(ctx, clientID, secret, nil)
+	return client, err
+}
+
+// IntrospectToken get the state of an internal access or refresh token (RFC 7662), nil when the token doesn't
+// verify as an internal token
+func (ia *internalAuthentication) IntrospectToken(ctx context.Context, token string) (*entities.TokenIntrospection, error) {
+	claims, err := ia.tokenHandler.GetTokenClaims(token)
+	if err != nil {
+		// Not signed with the internal key, expired or internal tokens are disabled
+		return nil, nil
+	}
+	clientID, _ := claims["client_id"].(string)
+	exp, _ := claims["exp"].(float64)
+	result := &entities.TokenIntrospection{
+		Active:   true,
+		ClientID: clientID,
+		Exp:      int64(exp),
+		Sub:      entities.ClientSubjectPrefix + clientID,
+	}
+	if refreshKey, ok := claims["refresh_uuid"].(string); ok {
+		// Rotated refresh tokens are no longer stored
+		stored, err := ia.repo.GetInternalToken(ctx, refreshKey)
+		if err != nil {
+			return nil, err
+		}
+		if stored == "" || stored != clientID {
+			return nil, ErrExpiredToken
+		}
+		result.TokenType = entities.TokenTypeRefresh
+		result.Jti = refreshKey
+	} else if accessKey, ok := claims["access_uuid"].(string); ok {
+		result.TokenType = entities.TokenTypeAccess
+		result.Jti = accessKey
+	} else {
+		return nil, ErrInvalidToken
+	}
+	client, err := ia.repo.GetClient(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, ErrExpiredToken
+	}
+	result.Scope = strings.Join(grantedScopes(claimScopes(claims), client.Scopes), " ")
+	return result, nil
+}
+
+// parseToken verify the signature and expiration of an internal token, the tokens that don't verify are
+// ErrExpiredToken
+func (ia *internalAuthentication) parseToken(token string) (jwt.MapClaims, error) {
+	claims, err := ia.tokenHandler.GetTokenClaims(token)
+	if err == utils.ErrInternalTokensDisabled {
+		return nil, ErrInternalTokensDisabled
+	}
+	if err != nil {
+		return nil, ErrExpiredToken
+	}
+	return claims, nil
+}
+
+// authenticateClient verify the client secret and the requested scopes, all the client scopes when none is requested
+func (ia *internalAuthentication) authenticateClient(ctx context.Context, clientID string, secret string, scopes []string) (*entities.ServiceClient, []string, error) {
+	hash, err := ia.repo.GetClientSecretHash(ctx, clientID)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The secrets are random so a fast hash is enough, an expensive password hash would let anyone load the
+	// service with client credentials requests
+	if hash == "" || secret == "" || subtle.ConstantTimeCompare([]byte(hash), []byte(utils.HashAPIKeySecret(secret))) != 1 {
+		return nil, nil, ErrInvalidClientCredentials
+	}
+	client, err := ia.repo.GetClient(ctx, clientID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if client == nil {
+		return nil, nil, ErrInvalidClientCredentials
+	}
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	if granted := grantedScopes(scopes, client.Scopes); len(granted) != len(scopes) {
+		return nil, nil, ErrInvalidScope
+	}
+	return client, scopes, nil
+}
+
+func (ia *internalAuthentication) checkClientRole(ctx context.Context, clientID string, roleID string) error {
+	client, err := ia.repo.GetClient(ctx, clientID)
+	if err != nil {
+		return err
+	}
+	if client == nil {
+		return ErrClientNotFound
+	}
+	if ok, _ := ia.rolesRepo.IsValidRole(ctx, roleID); !ok {
+		return ErrRoleNotFound
+	}
+	return nil
+}
+
+func (ia *internalAuthentication) clientRoles(ctx context.Context, clientID string) ([]string, error) {
+	roles, err := ia.rolesRepo.RolesByUser(ctx, entities.ClientSubjectPrefix+clientID)
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for id := range roles {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// sendRoleEvents update the access and action lists of the client like the role listeners do for users
+func (ia *internalAuthentication) sendRoleEvents(subject string, roleID string) {
+	go ia.subscriberFeed.Send(&entities.RoleEvent{RoleID: roleID, UserID: subject, EventType: entities.EventTypeAccess})
+	go ia.subscriberFeed.Send(&entities.RoleEvent{RoleID: roleID, UserID: subject, EventType: entities.EventTypeAction})
+}
+
+func (ia *internalAuthentication) saveInternalToken(ctx context.Context, clientID string, scopes []string) (*entities.Token, error) {
+	t, err := ia.tokenHandler.CreateToken(clientID, scopes)
+	if err != nil {
+		if err == utils.ErrInternalTokensDisabled {
+			return nil, ErrInternalTokensDisabled
+		}
+		return nil, err
+	}
+	ttl := time.Until(time.Unix(t.RefreshExpires, 0))
+	if err = ia.repo.StoreInternalToken(ctx, t.RefreshUUID, clientID, ttl); err != nil {
+		return nil, err
+	}
+	return &entities.Token{
+		Access:  t.AccessToken,
+		Refresh: t.RefreshToken,
+	}, nil
+}
+
+// grantedScopes get the requested scopes that are allowed
+func grantedScopes(requested []string, allowed []string) []string {
+	granted := []string{}
+	for _, scope := range requested {
+		for _, a := range allowed {
+			if scope == a {
+				granted = append(granted, scope)
+				break
+			}
+		}
+	}
+	return granted
+}
+
+func claimScopes(claims map[string]interface{}) []string {
+	scope, _ := claims["scope"].(string)
+	return strings.Fields(scope)
+}
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/StevenRojas/goaccess/pkg/events"
+	"github.com/StevenRojas/goaccess/pkg/repository"
+	"github.com/StevenRojas/goaccess/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+// rolesRepoStub keeps the role assignments in memory
+type rolesRepoStub struct {
+	repository.RolesRepository
+	assigned map[string]map[string]string
+}
+
+func (r *rolesRepoStub) IsValidRole(ctx context.Context, ID string) (bool, error) {
+	return ID == "r1" || ID == "r2", nil
+}
+
+func (r *rolesRepoStub) AssignRole(ctx context.Context, userID string, roleID string) error {
+	if r.assigned[userID] == nil {
+		r.assigned[userID] = map[string]string{}
+	}
+	r.assigned[userID][roleID] = roleID
+	return nil
+}
+
+func (r *rolesRepoStub) UnassignRole(ctx context.Context, userID string, roleID string) error {
+	delete(r.assigned[userID], roleID)
+	return nil
+}
+
+func (r *rolesRepoStub) RolesByUser(ctx context.Context, userID string) (map[string]string, error) {
+	return r.assigned[userID], nil
+}
+
+type internalSuite struct {
+	svc    InternalAuthenticationService
+	repo   *repository.ClientsRepoMock
+	roles  *rolesRepoStub
+	feed   events.SubscriberFeed
+	client *entities.ServiceClient
+	suite.Suite
+}
+
+func (s *internalSuite) SetupTest() {
+	s.repo = new(repository.ClientsRepoMock)
+	tokenHandler, err := utils.NewInternalTokenHandler(configuration.SecurityConfig{
+		JWTSecret:                    "secret!",
+		JWTInternalSecret:            "internal!",
+		JWTInternalTokenExpiration:   2,
+		JWTInternalRefreshExpiration: 5,
+	})
+	if err != nil {
+		panic(err)
+	}
+	s.client = &entities.ServiceClient{ID: "c1", Name: "reports", Scopes: []string{"post:report", "delete:report:[]"}}
+	s.roles = &rolesRepoStub{assigned: map[string]map[string]string{}}
+	s.feed = events.NewSubscriber()
+	s.svc = NewInternalAuthenticationService(s.repo, s.roles, tokenHandler, s.feed)
+}
+
+func TestInternalAuthenticationService(t *testing.T) {
+	suite.Run(t, new(internalSuite))
+}
+
+func (s *internalSuite) TestRegisterClient() {
+	t := s.T()
+	s.repo.M.On("AddClient", mock.Anything, mock.Anything).Return(nil)
+	client, secret, err := s.svc.RegisterClient(context.TODO(), "reports", []string{"post:report"})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, client.ID)
+	assert.NotEmpty(t, secret)
+	s.repo.M.AssertCalled(t, "AddClient", client, utils.HashAPIKeySecret(secret))
+}
+
+func (s *internalSuite) TestRegisterClientValidation() {
+	t := s.T()
+	_, _, err := s.svc.RegisterClient(context.TODO(), "", []string{"post report"})
+	validationErr, ok := err.(*ValidationError)
+	assert.True(t, ok)
+	assert.NotEmpty(t, validationErr.Errors["name"])
+	assert.NotEmpty(t, validationErr.Errors["scopes"])
+	s.repo.M.AssertNumberOfCalls(t, "AddClient", 0)
+}
+
+func (s *internalSuite) TestIssueWrongSecret() {
+	t := s.T()
+	s.repo.M.On("GetClientSecretHash", "c1").Return(utils.HashAPIKeySecret("secret"), nil)
+	s.repo.M.On("GetClientSecretHash", "unknown").Return("", nil)
+	_, err := s.svc.IssueInternalToken(context.TODO(), "c1", "wrong", nil)
+	assert.Equal(t, ErrInvalidClientCredentials, err)
+	_, err = s.svc.IssueInternalToken(context.TODO(), "unknown", "secret", nil)
+	assert.Equal(t, ErrInvalidClientCredentials, err)
+}
+
+func (s *internalSuite) TestIssueScopeNotGranted() {
+	t := s.T()
+	s.repo.M.On("GetClientSecretHash", "c1").Return(utils.HashAPIKeySecret("secret"), nil)
+	s.repo.M.On("GetClient", "c1").Return(s.client, nil)
+	_, err := s.svc.IssueInternalToken(context.TODO(), "c1", "secret", []string{"post:report", "post:user"})
+	assert.Equal(t, ErrInvalidScope, err)
+}
+
+func (s *internalSuite) TestIssueAndVerify() {
+	t := s.T()
+	s.repo.M.On("GetClientSecretHash", "c1").Return(utils.HashAPIKeySecret("secret"), nil)
+	s.repo.M.On("GetClient", "c1").Return(s.client, nil)
+	s.repo.M.On("StoreInternalToken", mock.Anything, "c1").Return(nil)
+	token, err := s.svc.IssueInternalToken(context.TODO(), "c1", "secret", []string{"post:report"})
+	assert.Nil(t, err)
+	identity, err := s.svc.VerifyInternalToken(context.TODO(), token.Access)
+	assert.Nil(t, err)
+	assert.Equal(t, "c1", identity.ClientID)
+	assert.Equal(t, []string{"post:report"}, identity.Scopes)
+
+	// Refresh tokens are not access tokens
+	_, err = s.svc.VerifyInternalToken(context.TODO(), token.Refresh)
+	assert.Equal(t, ErrInvalidToken, err)
+}
+
+func (s *internalSuite) TestVerifyDeletedClient() {
+	t := s.T()
+	s.repo.M.On("GetClientSecretHash", "c1").Return(utils.HashAPIKeySecret("secret"), nil)
+	s.repo.M.On("GetClient", "c1").Return(s.client, nil).Once()
+	s.repo.M.On("StoreInternalToken", mock.Anything, "c1").Return(nil)
+	token, err := s.svc.IssueInternalToken(context.TODO(), "c1", "secret", nil)
+	assert.Nil(t, err)
+	s.repo.M.On("GetClient", "c1").Return(nil, nil)
+	_, err = s.svc.VerifyInternalToken(context.TODO(), token.Access)
+	assert.Equal(t, ErrExpiredToken, err)
+}
+
+func (s *internalSuite) TestRefreshConsumesToken() {
+	t := s.T()
+	s.repo.M.On("GetClientSecretHash", "c1").Return(utils.HashAPIKeySecret("secret"), nil)
+	s.repo.M.On("GetClient", "c1").Return(s.client, nil)
+	s.repo.M.On("StoreInternalToken", mock.Anything, "c1").Return(nil)
+	token, err := s.svc.IssueInternalToken(context.TODO(), "c1", "secret", nil)
+	assert.Nil(t, err)
+	s.repo.M.On("ConsumeInternalToken", mock.Anything).Return("c1", nil).Once()
+	refreshed, err := s.svc.RefreshInternalToken(context.TODO(), token.Refresh)
+	assert.Nil(t, err)
+	assert.NotEqual(t, token.Access, refreshed.Access)
+
+	s.repo.M.On("ConsumeInternalToken", mock.Anything).Return("", nil)
+	_, err = s.svc.RefreshInternalToken(context.TODO(), token.Refresh)
+	assert.Equal(t, ErrExpiredToken, err)
+}
+
+func (s *internalSuite) TestInternalTokensDisabled() {
+	t := s.T()
+	tokenHandler, _ := utils.NewInternalTokenHandler(configuration.SecurityConfig{})
+	svc := NewInternalAuthenticationService(s.repo, s.roles, tokenHandler, s.feed)
+	_, err := svc.VerifyInternalToken(context.TODO(), "token")
+	assert.Equal(t, ErrInternalTokensDisabled, err)
+}
+
+func (s *internalSuite) TestClientCredentialsToken() {
+	t := s.T()
+	s.repo.M.On("GetClientSecretHash", "c1").Return(utils.HashAPIKeySecret("secret"), nil)
+	s.repo.M.On("GetClient", "c1").Return(s.client, nil)
+	token, err := s.svc.ClientCredentialsToken(context.TODO(), "c1", "secret", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer", token.TokenType)
+	assert.Equal(t, "post:report delete:report:[]", token.Scope)
+	assert.True(t, token.ExpiresIn > 0)
+	// No refresh token is stored for this grant
+	s.repo.M.AssertNumberOfCalls(t, "StoreInternalToken", 0)
+
+	identity, err := s.svc.VerifyInternalToken(context.TODO(), token.AccessToken)
+	assert.Nil(t, err)
+	assert.Equal(t, "client:c1", identity.Subject)
+
+	_, err = s.svc.ClientCredentialsToken(context.TODO(), "c1", "wrong", nil)
+	assert.Equal(t, ErrInvalidClientCredentials, err)
+}
+
+func (s *internalSuite) TestClientRoles() {
+	t := s.T()
+	ch := make(chan *entities.RoleEvent, 2)
+	s.feed.Subscribe(entities.EventTypeAction, ch)
+	s.repo.M.On("GetClient", "c1").Return(s.client, nil)
+	s.repo.M.On("GetClient", "unknown").Return(nil, nil)
+	s.repo.M.On("GetClients").Return([]entities.ServiceClient{*s.client}, nil)
+	s.repo.M.On("DeleteClient", "c1").Return(nil)
+
+	assert.Nil(t, s.svc.AssignClientRole(context.TODO(), "c1", "r1"))
+	event := <-ch
+	assert.Equal(t, "client:c1", event.UserID)
+	assert.Equal(t, "r1", event.RoleID)
+	assert.Equal(t, ErrRoleNotFound, s.svc.AssignClientRole(context.TODO(), "c1", "r9"))
+	assert.Equal(t, ErrClientNotFound, s.svc.AssignClientRole(context.TODO(), "unknown", "r1"))
+
+	clients, err := s.svc.ListClients(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"r1"}, clients[0].Roles)
+
+	// Deleting the client removes its role assignments
+	assert.Nil(t, s.svc.DeleteClient(context.TODO(), "c1"))
+	assert.Empty(t, s.roles.assigned["client:c1"])
+}
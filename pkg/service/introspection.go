@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"sort"
+
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/StevenRojas/goaccess/pkg/repository"
+)
+
+// IntrospectionService token introspection (RFC 7662) for the components that can't verify the tokens
+// themselves, e.g. API gateways
+type IntrospectionService interface {
+	// Introspect authenticate the calling service client and get the state of a user access or refresh token,
+	// an internal token or an API key with the role IDs of its subject. Invalid, expired and revoked tokens
+	// are inactive
+	Introspect(ctx context.Context, clientID string, secret string, token string) (*entities.TokenIntrospection, error)
+}
+
+type introspection struct {
+	authentication AuthenticationService
+	internal       InternalAuthenticationService
+	rolesRepo      repository.RolesRepository
+}
+
+// NewIntrospectionService return a new introspection service instance
+func NewIntrospectionService(
+	authentication AuthenticationService,
+	internal InternalAuthenticationService,
+	rolesRepo repository.RolesRepository,
+) IntrospectionService {
+	return &introspection{
+		authentication: authentication,
+		internal:       internal,
+		rolesRepo:      rolesRepo,
+	}
+}
+
+// Introspect authenticate the calling service client and get the state of a token with the role IDs of its
+// subject
+func (i *introspection) Introspect(ctx context.Context, clientID string, secret string, token string) (*entities.TokenIntrospection, error) {
+	if _, err := i.internal.AuthenticateClient(ctx, clientID, secret); err != nil {
+		return nil, err
+	}
+	// Internal tokens are signed with another key, a user token or API key doesn't verify as one
+	result, err := i.internal.IntrospectToken(ctx, token)
+	if err == nil && result == nil {
+		result, err = i.authentication.IntrospectToken(ctx, token)
+	}
+	if err != nil {
+		// The reason a token is not active is not disclosed (RFC 7662 section 2.2)
+		return &entities.TokenIntrospection{Active: false}, nil
+	}
+	roles, err := i.rolesRepo.RolesByUser(ctx, result.Sub)
+	if err != nil {
+		return nil, err
+	}
+	result.Roles = []string{}
+	for id := range roles {
+		result.Roles = append(result.Roles, id)
+	}
+	sort.Strings(result.Roles)
+	return result, nil
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/StevenRojas/goaccess/pkg/events"
+	"github.com/StevenRojas/goaccess/pkg/repository"
+	"github.com/StevenRojas/goaccess/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type introspectionSuite struct {
+	svc      IntrospectionService
+	internal InternalAuthenticationService
+	users    *repository.UsersRepoMock
+	clients  *repository.ClientsRepoMock
+	roles    *rolesRepoStub
+	suite.Suite
+}
+
+func (s *introspectionSuite) SetupTest() {
+	s.users = new(repository.UsersRepoMock)
+	s.users.M.On("GetUserRoles", mock.Anything).Return([]string{}, nil)
+	s.users.M.On("GetUserActions", mock.Anything).Return([]string{}, nil)
+	s.clients = new(repository.ClientsRepoMock)
+	s.clients.M.On("GetClientSecretHash", "gateway").Return(utils.HashAPIKeySecret("secret"), nil)
+	s.clients.M.On("GetClient", "gateway").Return(&entities.ServiceClient{ID: "gateway"}, nil)
+	s.roles = &rolesRepoStub{assigned: map[string]map[string]string{}}
+	tokenHandler, err := utils.NewInternalTokenHandler(configuration.SecurityConfig{
+		JWTSecret:                    "secret!",
+		JWTInternalSecret:            "internal!",
+		JWTInternalTokenExpiration:   2,
+		JWTInternalRefreshExpiration: 5,
+	})
+	if err != nil {
+		panic(err)
+	}
+	s.internal = NewInternalAuthenticationService(s.clients, s.roles, tokenHandler, events.NewSubscriber())
+	policy, _ := utils.NewPasswordPolicy(configuration.PasswordPolicyConfig{})
+	authentication := NewAuthenticationService(
+		s.users,
+		new(repository.APIKeysRepoMock),
+		utils.NewJwtHandlerMock(configuration.SecurityConfig{}),
+		nil,
+		utils.NewPasswordHasherMock(),
+		policy,
+		utils.NewLoginThrottle(configuration.LoginThrottleConfig{}, nil),
+		utils.NewRateLimiter(nil, 0, 0),
+		utils.NewTOTPHandler(configuration.MFAConfig{}),
+		configuration.MFAConfig{},
+		utils.NewMailerMock(),
+		configuration.EmailTokensConfig{},
+		events.NewSecurityFeed(),
+	)
+	s.svc = NewIntrospectionService(authentication, s.internal, s.roles)
+}
+
+func TestIntrospectionService(t *testing.T) {
+	suite.Run(t, new(introspectionSuite))
+}
+
+func (s *introspectionSuite) TestWrongClient() {
+	t := s.T()
+	_, err := s.svc.Introspect(context.TODO(), "gateway", "wrong", "a_jwt")
+	assert.Equal(t, ErrInvalidClientCredentials, err)
+}
+
+func (s *introspectionSuite) TestUserTokens() {
+	t := s.T()
+	s.roles.assigned["1"] = map[string]string{"r2": "r2", "r1": "r1"}
+	s.users.M.On("GetUserByToken", "a_uuid").Return(&entities.User{ID: "1"}, nil)
+	result, err := s.svc.Introspect(context.TODO(), "gateway", "secret", "a_jwt")
+	assert.Nil(t, err)
+	assert.True(t, result.Active)
+	assert.Equal(t, "1", result.Sub)
+	assert.Equal(t, "a_uuid", result.Jti)
+	assert.Equal(t, entities.TokenTypeAccess, result.TokenType)
+	assert.Equal(t, []string{"r1", "r2"}, result.Roles)
+
+	s.users.M.On("GetUserByToken", "r_uuid").Return(&entities.User{ID: "1"}, nil)
+	result, err = s.svc.Introspect(context.TODO(), "gateway", "secret", "r_jwt")
+	assert.Nil(t, err)
+	assert.True(t, result.Active)
+	assert.Equal(t, entities.TokenTypeRefresh, result.TokenType)
+}
+
+func (s *introspectionSuite) TestInactiveToken() {
+	t := s.T()
+	// The refresh token was rotated and belongs to another user now
+	s.users.M.On("GetUserByToken", "r_uuid").Return(&entities.User{ID: "2"}, nil)
+	result, err := s.svc.Introspect(context.TODO(), "gateway", "secret", "r_jwt")
+	assert.Nil(t, err)
+	assert.Equal(t, &entities.TokenIntrospection{Active: false}, result)
+}
+
+func (s *introspectionSuite) TestInternalToken() {
+	t := s.T()
+	client := &entities.ServiceClient{ID: "c1", Scopes: []string{"post:report", "delete:report:[]"}}
+	s.clients.M.On("GetClientSecretHash", "c1").Return(utils.HashAPIKeySecret("c1secret"), nil)
+	s.clients.M.On("GetClient", "c1").Return(client, nil)
+	s.clients.M.On("StoreInternalToken", mock.Anything, "c1").Return(nil)
+	s.roles.assigned[entities.ClientSubjectPrefix+"c1"] = map[string]string{"r1": "r1"}
+	token, err := s.internal.IssueInternalToken(context.TODO(), "c1", "c1secret", []string{"post:report"})
+	assert.Nil(t, err)
+
+	result, err := s.svc.Introspect(context.TODO(), "gateway", "secret", token.Access)
+	assert.Nil(t, err)
+	assert.True(t, result.Active)
+	assert.Equal(t, "c1", result.ClientID)
+	assert.Equal(t, entities.ClientSubjectPrefix+"c1", result.Sub)
+	assert.Equal(t, "post:report", result.Scope)
+	assert.Equal(t, []string{"r1"}, result.Roles)
+	assert.NotZero(t, result.Exp)
+
+	// Rotated internal refresh tokens are inactive
+	s.clients.M.On("GetInternalToken", mock.Anything).Return("", nil)
+	result, err = s.svc.Introspect(context.TODO(), "gateway", "secret", token.Refresh)
+	assert.Nil(t, err)
+	assert.False(t, result.Active)
+}
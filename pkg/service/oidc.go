@@ -0,0 +1,422 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/StevenRojas/goaccess/pkg/repository"
+	"github.com/StevenRojas/goaccess/pkg/utils"
+	"github.com/rs/xid"
+)
+
+// OpenID Connect scopes, other requested scopes are ignored
+const (
+	scopeOpenID  = "openid"
+	scopeProfile = "profile"
+	scopeEmail   = "email"
+)
+
+// codeChallengeS256 the only PKCE method supported, plain challenges would expose the verifier
+const codeChallengeS256 = "S256"
+
+// OIDCService OpenID Connect provider for SPAs, the authorization code flow with PKCE on top of the
+// authentication service Login and token storage
+type OIDCService interface {
+	// Configuration get the OpenID Connect discovery document
+	Configuration(ctx context.Context) (*entities.OIDCConfiguration, error)
+	// RegisterClient register an OpenID Connect client with the URIs the users can be redirected to
+	RegisterClient(ctx context.Context, name string, redirectURIs []string) (*entities.OIDCClient, error)
+	// ListClients get a list of all OpenID Connect clients
+	ListClients(ctx context.Context) ([]entities.OIDCClient, error)
+	// DeleteClient delete an OpenID Connect client, its pending authorization codes can't be exchanged
+	DeleteClient(ctx context.Context, clientID string) error
+	// ValidateAuthorization check the client, redirect URI, scope and PKCE challenge of an authorization request.
+	// An AuthorizationError is sent to the redirect URI, any other error must not redirect the user agent
+	ValidateAuthorization(ctx context.Context, req *entities.AuthorizationRequest) error
+	// Authorize log in a user by email and password for an authorization request and return the redirect URI
+	// with the authorization code, or an MFA challenge to complete with AuthorizeMFA
+	Authorize(ctx context.Context, req *entities.AuthorizationRequest, email string, password string) (*entities.Authorization, error)
+	// AuthorizeMFA complete the login of an authorization request with the MFA challenge and a TOTP or
+	// recovery code
+	AuthorizeMFA(ctx context.Context, req *entities.AuthorizationRequest, challenge string, code string) (*entities.Authorization, error)
+	// ExchangeCode authorization_code grant, exchange a single use code and its PKCE verifier for the access,
+	// refresh and ID tokens
+	ExchangeCode(ctx context.Context, clientID string, code string, redirectURI string, verifier string) (*entities.OAuthToken, error)
+	// RefreshToken refresh_token grant, rotate a refresh token like the authentication service does
+	RefreshToken(ctx context.Context, token string) (*entities.OAuthToken, error)
+	// UserInfo get the claims of the user of an access token
+	UserInfo(ctx context.Context, accessToken string) (*entities.UserInfo, error)
+}
+
+type oidcProvider struct {
+	repo           repository.OIDCRepository
+	usersRepo      repository.UsersRepository
+	authentication AuthenticationService
+	jwtHandler     utils.JwtHandler
+	config         configuration.OIDCConfig
+}
+
+// NewOIDCService return a new OpenID Connect provider instance, the ID tokens are signed by the JWT handler
+func NewOIDCService(
+	oidcRepo repository.OIDCRepository,
+	usersRepo repository.UsersRepository,
+	authentication AuthenticationService,
+	jwtHandler utils.JwtHandler,
+	config configuration.OIDCConfig,
+) OIDCService {
+	config.Issuer = strings.TrimSuffix(config.Issuer, "/")
+	return &oidcProvider{
+		repo:           oidcRepo,
+		usersRepo:      usersRepo,
+		authentication: authentication,
+		jwtHandler:     jwtHandler,
+		config:         config,
+	}
+}
+
+// Configuration get the OpenID Connect discovery document
+func (o *oidcProvider) Configuration(ctx context.Context) (*entities.OIDCConfiguration, error) {
+	if o.config.Issuer == "" {
+		return nil, ErrOIDCDisabled
+	}
+	// Rotated keys keep the configured algorithm, shared secrets have no public keys
+	algs := []string{}
+	for _, key := range o.jwtHandler.JWKS().Keys {
+		if key.Alg != "" && !contains(algs, key.Alg) {
+			algs = append(algs, key.Alg)
+		}
+	}
+	if len(algs) == 0 {
+		algs = append(algs, utils.AlgHS256)
+	}
+	return &entities.OIDCConfiguration{
+		Issuer:                            o.config.Issuer,
+		AuthorizationEndpoint:             o.config.Issuer + "/authorize",
+		TokenEndpoint:                     o.config.Issuer + "/oauth/token",
+		UserInfoEndpoint:                  o.config.Issuer + "/userinfo",
+		JWKSURI:                           o.config.Issuer + "/.well-known/jwks.json",
+		ScopesSupported:                   []string{scopeOpenID, scopeProfile, scopeEmail},
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  algs,
+		TokenEndpointAuthMethodsSupported: []string{"none"},
+		CodeChallengeMethodsSupported:     []string{codeChallengeS256},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "email", "email_verified", "name"},
+	}, nil
+}
+
+// RegisterClient register an OpenID Connect client with the URIs the users can be redirected to. The URIs must
+// be absolute https URIs, http is only allowed for localhost
+func (o *oidcProvider) RegisterClient(ctx context.Context, name string, redirectURIs []string) (*entities.OIDCClient, error) {
+	errs := url.Values{}
+	if strings.TrimSpace(name) == "" {
+		errs.Add("name", "The name field is required")
+	}
+	if len(redirectURIs) == 0 {
+		errs.Add("redirect_uris", "At least one redirect URI is required")
+	}
+	for _, redirectURI := range redirectURIs {
+		if !validRedirectURI(redirectURI) {
+			errs.Add("redirect_uris", "The redirect URIs must be absolute https URIs without fragment")
+			break
+		}
+	}
+	if len(errs) > 0 {
+		return nil, &ValidationError{Errors: errs}
+	}
+	client := &entities.OIDCClient{
+		ID:           xid.New().String(),
+		Name:         name,
+		RedirectURIs: redirectURIs,
+		CreatedAt:    time.Now(),
+	}
+	if err := o.repo.AddClient(ctx, client); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// ListClients get a list of all OpenID Connect clients
+func (o *oidcProvider) ListClients(ctx context.Context) ([]entities.OIDCClient, error) {
+	return o.repo.GetClients(ctx)
+}
+
+// DeleteClient delete an OpenID Connect client, its pending authorization codes can't be exchanged
+func (o *oidcProvider) DeleteClient(ctx context.Context, clientID string) error {
+	client, err := o.repo.GetClient(ctx, clientID)
+	if err != nil {
+		return err
+	}
+	if client == nil {
+		return ErrClientNotFound
+	}
+	return o.repo.DeleteClient(ctx, clientID)
+}
+
+// ValidateAuthorization check the client, redirect URI, scope and PKCE challenge of an authorization request
+func (o *oidcProvider) ValidateAuthorization(ctx context.Context, req *entities.AuthorizationRequest) error {
+	if o.config.Issuer == "" {
+		return ErrOIDCDisabled
+	}
+	client, err := o.repo.GetClient(ctx, req.ClientID)
+	if err != nil {
+		return err
+	}
+	// The redirect URI is compared as a whole, a user agent is never sent to an unregistered URI
+	if client == nil || !contains(client.RedirectURIs, req.RedirectURI) {
+		return ErrInvalidRedirectURI
+	}
+	if req.ResponseType != "code" {
+		return &AuthorizationError{Code: "unsupported_response_type", Description: "Only the code response type is supported"}
+	}
+	if !contains(strings.Fields(req.Scope), scopeOpenID) {
+		return &AuthorizationError{Code: "invalid_scope", Description: "The openid scope is required"}
+	}
+	if req.CodeChallenge == "" {
+		return &AuthorizationError{Code: "invalid_request", Description: "The code_challenge parameter is required"}
+	}
+	if req.CodeChallengeMethod != codeChallengeS256 {
+		return &AuthorizationError{Code: "invalid_request", Description: "Only the S256 code challenge method is supported"}
+	}
+	return nil
+}
+
+// Authorize log in a user by email and password for an authorization request and return the redirect URI with
+// the authorization code, or an MFA challenge to complete with AuthorizeMFA
+func (o *oidcProvider) Authorize(ctx context.Context, req *entities.AuthorizationRequest, email string, password string) (*entities.Authorization, error) {
+	if err := o.ValidateAuthorization(ctx, req); err != nil {
+		return nil, err
+	}
+	loggedUser, err := o.authentication.Login(ctx, email, password)
+	if err != nil {
+		return nil, err
+	}
+	return o.authorized(ctx, req, loggedUser)
+}
+
+// AuthorizeMFA complete the login of an authorization request with the MFA challenge and a TOTP or recovery code
+func (o *oidcProvider) AuthorizeMFA(ctx context.Context, req *entities.AuthorizationRequest, challenge string, code string) (*entities.Authorization, error) {
+	if err := o.ValidateAuthorization(ctx, req); err != nil {
+		return nil, err
+	}
+	loggedUser, err := o.authentication.LoginMFA(ctx, challenge, code)
+	if err != nil {
+		return nil, err
+	}
+	return o.authorized(ctx, req, loggedUser)
+}
+
+// authorized store the tokens of a login under a new authorization code and return the redirect URI with the
+// code and state, the MFA challenge is returned as it is
+func (o *oidcProvider) authorized(ctx context.Context, req *entities.AuthorizationRequest, loggedUser *entities.LoggedUser) (*entities.Authorization, error) {
+	if loggedUser.MFAChallenge != "" {
+		return &entities.Authorization{MFAChallenge: loggedUser.MFAChallenge}, nil
+	}
+	code, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	scopes := []string{}
+	for _, scope := range strings.Fields(req.Scope) {
+		if (scope == scopeOpenID || scope == scopeProfile || scope == scopeEmail) && !contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	expiration := time.Second * time.Duration(o.config.CodeExpiration)
+	err = o.repo.StoreAuthorizationCode(ctx, utils.HashToken(code), &entities.AuthorizationCode{
+		ClientID:      req.ClientID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         strings.Join(scopes, " "),
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		UserID:        loggedUser.User.ID,
+		AuthTime:      time.Now().Unix(),
+		Access:        loggedUser.Token.Access,
+		Refresh:       loggedUser.Token.Refresh,
+	}, expiration)
+	if err != nil {
+		return nil, err
+	}
+	params := url.Values{"code": {code}}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	return &entities.Authorization{RedirectTo: AuthorizationRedirect(req.RedirectURI, params)}, nil
+}
+
+// ExchangeCode authorization_code grant, exchange a single use code and its PKCE verifier for the access, refresh
+// and ID tokens
+func (o *oidcProvider) ExchangeCode(ctx context.Context, clientID string, code string, redirectURI string, verifier string) (*entities.OAuthToken, error) {
+	if o.config.Issuer == "" {
+		return nil, ErrOIDCDisabled
+	}
+	if code == "" {
+		return nil, ErrInvalidGrant
+	}
+	stored, err := o.repo.TakeAuthorizationCode(ctx, utils.HashToken(code))
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return nil, ErrInvalidGrant
+	}
+	token := &entities.Token{Access: stored.Access, Refresh: stored.Refresh}
+	if stored.ClientID != clientID || stored.RedirectURI != redirectURI || !verifyCodeChallenge(stored.CodeChallenge, verifier) {
+		return nil, o.invalidGrant(ctx, token)
+	}
+	client, err := o.repo.GetClient(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	user, err := o.usersRepo.GetUserByID(ctx, stored.UserID)
+	if err != nil {
+		return nil, err
+	}
+	// The client or the user was removed after the code was issued
+	if client == nil || user == nil || user.ID == "" {
+		return nil, o.invalidGrant(ctx, token)
+	}
+	scopes := strings.Fields(stored.Scope)
+	idClaims := &utils.IDTokenClaims{
+		Issuer:    o.config.Issuer,
+		Audience:  clientID,
+		Nonce:     stored.Nonce,
+		AuthTime:  stored.AuthTime,
+		ExpiresAt: time.Now().Add(time.Minute * time.Duration(o.config.IDTokenExpiration)).Unix(),
+	}
+	if contains(scopes, scopeEmail) {
+		idClaims.Email = user.Email
+		idClaims.EmailVerified = user.EmailVerified
+	}
+	if contains(scopes, scopeProfile) {
+		idClaims.Name = user.Name
+	}
+	idToken, err := o.jwtHandler.CreateIDToken(user.ID, idClaims)
+	if err != nil {
+		return nil, err
+	}
+	result := o.oauthToken(token)
+	result.Scope = stored.Scope
+	result.IDToken = idToken
+	return result, nil
+}
+
+// RefreshToken refresh_token grant, rotate a refresh token like the authentication service does
+func (o *oidcProvider) RefreshToken(ctx context.Context, token string) (*entities.OAuthToken, error) {
+	if o.config.Issuer == "" {
+		return nil, ErrOIDCDisabled
+	}
+	refreshed, err := o.authentication.RefreshToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return o.oauthToken(refreshed), nil
+}
+
+// UserInfo get the claims of the user of an access token, API keys are not accepted
+func (o *oidcProvider) UserInfo(ctx context.Context, accessToken string) (*entities.UserInfo, error) {
+	if o.config.Issuer == "" {
+		return nil, ErrOIDCDisabled
+	}
+	if _, _, ok := utils.ParseAPIKey(accessToken); ok {
+		return nil, ErrInvalidToken
+	}
+	userID, err := o.authentication.VerifyToken(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	user, err := o.usersRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.ID == "" {
+		return nil, ErrInvalidToken
+	}
+	return &entities.UserInfo{
+		Sub:           user.ID,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Name:          user.Name,
+	}, nil
+}
+
+// invalidGrant end the login session of a used up authorization code so its tokens are never handed out, returns
+// ErrInvalidGrant unless the session can't be ended
+func (o *oidcProvider) invalidGrant(ctx context.Context, token *entities.Token) error {
+	if err := o.authentication.Logout(ctx, token); err != nil {
+		return err
+	}
+	return ErrInvalidGrant
+}
+
+// oauthToken OAuth2 token response of a user token pair, the lifetime is taken from the access token
+func (o *oidcProvider) oauthToken(token *entities.Token) *entities.OAuthToken {
+	result := &entities.OAuthToken{
+		AccessToken:  token.Access,
+		TokenType:    "Bearer",
+		RefreshToken: token.Refresh,
+	}
+	if claims, err := o.jwtHandler.GetTokenClaims(token.Access); err == nil {
+		if exp, ok := claims["exp"].(float64); ok {
+			result.ExpiresIn = int64(exp) - time.Now().Unix()
+		}
+	}
+	return result
+}
+
+// AuthorizationRedirect add the response parameters to the query of a redirect URI, keeping its own parameters
+func AuthorizationRedirect(redirectURI string, params url.Values) string {
+	separator := "?"
+	if strings.Contains(redirectURI, "?") {
+		separator = "&"
+	}
+	return redirectURI + separator + params.Encode()
+}
+
+// verifyCodeChallenge check a PKCE verifier against its S256 challenge (RFC 7636 section 4.6)
+func verifyCodeChallenge(challenge string, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(codeChallenge(verifier)), []byte(challenge)) == 1
+}
+
+// codeChallenge S256 PKCE challenge of a verifier
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// validRedirectURI check that a redirect URI is absolute without fragment, https unless it is a loopback URI
+func validRedirectURI(redirectURI string) bool {
+	u, err := url.Parse(redirectURI)
+	if err != nil || u.Host == "" || u.Fragment != "" || strings.ContainsAny(redirectURI, " \t\n") {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	}
+	return false
+}
+
+// contains check if a value is in the list
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
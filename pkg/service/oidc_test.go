@@ -0,0 +1,244 @@
+package service
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/StevenRojas/goaccess/pkg/events"
+	"github.com/StevenRojas/goaccess/pkg/repository"
+	"github.com/StevenRojas/goaccess/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+// PKCE example of RFC 7636 appendix B
+const (
+	testCodeVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testCodeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+type oidcSuite struct {
+	svc   OIDCService
+	users *repository.UsersRepoMock
+	oidc  *repository.OIDCRepoMock
+	user  *entities.User
+	suite.Suite
+}
+
+func (s *oidcSuite) SetupTest() {
+	s.users = new(repository.UsersRepoMock)
+	s.users.M.On("GetUserRoles", mock.Anything).Return([]string{}, nil)
+	s.users.M.On("GetUserActions", mock.Anything).Return([]string{}, nil)
+	s.oidc = new(repository.OIDCRepoMock)
+	s.oidc.M.On("GetClient", "spa").Return(&entities.OIDCClient{
+		ID:           "spa",
+		RedirectURIs: []string{"https://app.example.com/callback"},
+	}, nil)
+	s.oidc.M.On("GetClient", mock.Anything).Return(nil, nil)
+	s.user = &entities.User{ID: "1", Email: "srojas@gmail.com", Name: "steven rojas", EmailVerified: true}
+	jwtHandler := utils.NewJwtHandlerMock(configuration.SecurityConfig{})
+	policy, _ := utils.NewPasswordPolicy(configuration.PasswordPolicyConfig{})
+	authentication := NewAuthenticationService(
+		s.users,
+		new(repository.APIKeysRepoMock),
+		jwtHandler,
+		nil,
+		utils.NewPasswordHasherMock(),
+		policy,
+		utils.NewLoginThrottle(configuration.LoginThrottleConfig{}, nil),
+		utils.NewRateLimiter(nil, 0, 0),
+		utils.NewTOTPHandler(configuration.MFAConfig{}),
+		configuration.MFAConfig{ChallengeExpiration: 5},
+		utils.NewMailerMock(),
+		configuration.EmailTokensConfig{},
+		events.NewSecurityFeed(),
+	)
+	s.svc = NewOIDCService(s.oidc, s.users, authentication, jwtHandler, configuration.OIDCConfig{
+		Issuer:            "https://auth.example.com/",
+		CodeExpiration:    60,
+		IDTokenExpiration: 60,
+	})
+}
+
+func TestOIDCService(t *testing.T) {
+	suite.Run(t, new(oidcSuite))
+}
+
+func (s *oidcSuite) authorizationRequest() *entities.AuthorizationRequest {
+	return &entities.AuthorizationRequest{
+		ResponseType:        "code",
+		ClientID:            "spa",
+		RedirectURI:         "https://app.example.com/callback",
+		Scope:               "openid email offline_access email",
+		State:               "af0ifjsldkj",
+		Nonce:               "n-0S6_WzA2Mj",
+		CodeChallenge:       testCodeChallenge,
+		CodeChallengeMethod: "S256",
+	}
+}
+
+func (s *oidcSuite) expectLogin(mfaEnabled bool) {
+	s.users.M.On("GetUserByEmail", s.user.Email).Return(s.user, nil)
+	s.users.M.On("GetPasswordHash", "1").Return("hash:secret", nil)
+	s.users.M.On("GetMFASecret", "1").Return("", mfaEnabled, nil)
+	s.users.M.On("StoreTokens", mock.Anything).Return(nil)
+	s.users.M.On("StoreSession", mock.Anything).Return(nil)
+}
+
+func (s *oidcSuite) TestConfiguration() {
+	t := s.T()
+	config, err := s.svc.Configuration(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "https://auth.example.com", config.Issuer)
+	assert.Equal(t, "https://auth.example.com/authorize", config.AuthorizationEndpoint)
+	assert.Equal(t, "https://auth.example.com/.well-known/jwks.json", config.JWKSURI)
+	assert.Equal(t, []string{"HS256"}, config.IDTokenSigningAlgValuesSupported)
+	assert.Equal(t, []string{"S256"}, config.CodeChallengeMethodsSupported)
+
+	disabled := NewOIDCService(s.oidc, s.users, nil, nil, configuration.OIDCConfig{})
+	_, err = disabled.Configuration(context.TODO())
+	assert.Equal(t, ErrOIDCDisabled, err)
+}
+
+func (s *oidcSuite) TestValidateAuthorization() {
+	t := s.T()
+	assert.Nil(t, s.svc.ValidateAuthorization(context.TODO(), s.authorizationRequest()))
+
+	req := s.authorizationRequest()
+	req.ClientID = "unknown"
+	assert.Equal(t, ErrInvalidRedirectURI, s.svc.ValidateAuthorization(context.TODO(), req))
+	req = s.authorizationRequest()
+	req.RedirectURI = "https://app.example.com/callback/../evil"
+	assert.Equal(t, ErrInvalidRedirectURI, s.svc.ValidateAuthorization(context.TODO(), req))
+
+	cases := map[string]func(*entities.AuthorizationRequest){
+		"unsupported_response_type": func(req *entities.AuthorizationRequest) { req.ResponseType = "token" },
+		"invalid_scope":             func(req *entities.AuthorizationRequest) { req.Scope = "email" },
+		"invalid_request":           func(req *entities.AuthorizationRequest) { req.CodeChallengeMethod = "plain" },
+	}
+	for code, change := range cases {
+		req = s.authorizationRequest()
+		change(req)
+		err := s.svc.ValidateAuthorization(context.TODO(), req)
+		if assert.IsType(t, &AuthorizationError{}, err, code) {
+			assert.Equal(t, code, err.(*AuthorizationError).Code)
+		}
+	}
+}
+
+func (s *oidcSuite) TestAuthorizationCodeFlow() {
+	t := s.T()
+	s.expectLogin(false)
+	var stored *entities.AuthorizationCode
+	var storedHash string
+	s.oidc.M.On("StoreAuthorizationCode", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		storedHash = args.String(0)
+		stored = args.Get(1).(*entities.AuthorizationCode)
+	})
+	authorization, err := s.svc.Authorize(context.TODO(), s.authorizationRequest(), s.user.Email, "secret")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(authorization.RedirectTo, "https://app.example.com/callback?"))
+	redirect, _ := url.Parse(authorization.RedirectTo)
+	code := redirect.Query().Get("code")
+	assert.Equal(t, "af0ifjsldkj", redirect.Query().Get("state"))
+	assert.Equal(t, utils.HashToken(code), storedHash)
+	assert.Equal(t, "openid email", stored.Scope)
+	assert.Equal(t, "1", stored.UserID)
+	assert.Equal(t, "a_jwt", stored.Access)
+
+	s.oidc.M.On("TakeAuthorizationCode", storedHash).Return(stored, nil).Once()
+	s.users.M.On("GetUserByID", "1").Return(s.user, nil)
+	token, err := s.svc.ExchangeCode(context.TODO(), "spa", code, "https://app.example.com/callback", testCodeVerifier)
+	assert.Nil(t, err)
+	assert.Equal(t, "a_jwt", token.AccessToken)
+	assert.Equal(t, "r_jwt", token.RefreshToken)
+	assert.Equal(t, "id_jwt", token.IDToken)
+	assert.Equal(t, "Bearer", token.TokenType)
+	assert.Equal(t, "openid email", token.Scope)
+
+	// Codes are single use
+	s.oidc.M.On("TakeAuthorizationCode", storedHash).Return(nil, nil)
+	_, err = s.svc.ExchangeCode(context.TODO(), "spa", code, "https://app.example.com/callback", testCodeVerifier)
+	assert.Equal(t, ErrInvalidGrant, err)
+}
+
+func (s *oidcSuite) TestExchangeCodeWrongVerifier() {
+	t := s.T()
+	s.oidc.M.On("TakeAuthorizationCode", utils.HashToken("code")).Return(&entities.AuthorizationCode{
+		ClientID:      "spa",
+		RedirectURI:   "https://app.example.com/callback",
+		CodeChallenge: testCodeChallenge,
+		UserID:        "1",
+		Access:        "a_jwt",
+		Refresh:       "r_jwt",
+	}, nil)
+	// The session of the login is ended, its tokens are never handed out
+	s.users.M.On("DeleteToken", mock.Anything).Return(nil)
+	s.users.M.On("GetTokenFamily", "r_uuid").Return("r_uuid", nil)
+	s.users.M.On("RevokeTokenFamily", "r_uuid").Return(nil)
+	_, err := s.svc.ExchangeCode(context.TODO(), "spa", "code", "https://app.example.com/callback", strings.Repeat("x", 43))
+	assert.Equal(t, ErrInvalidGrant, err)
+	s.users.M.AssertCalled(t, "DeleteToken", "a_uuid")
+	s.users.M.AssertCalled(t, "RevokeTokenFamily", "r_uuid")
+}
+
+func (s *oidcSuite) TestExchangeCodeRemovedUser() {
+	t := s.T()
+	s.oidc.M.On("TakeAuthorizationCode", utils.HashToken("code")).Return(&entities.AuthorizationCode{
+		ClientID:      "spa",
+		RedirectURI:   "https://app.example.com/callback",
+		CodeChallenge: testCodeChallenge,
+		UserID:        "2",
+		Access:        "a_jwt",
+		Refresh:       "r_jwt",
+	}, nil)
+	s.users.M.On("GetUserByID", "2").Return((*entities.User)(nil), nil)
+	// The user was removed after the login, its session is ended too
+	s.users.M.On("DeleteToken", mock.Anything).Return(nil)
+	s.users.M.On("GetTokenFamily", "r_uuid").Return("r_uuid", nil)
+	s.users.M.On("RevokeTokenFamily", "r_uuid").Return(nil)
+	_, err := s.svc.ExchangeCode(context.TODO(), "spa", "code", "https://app.example.com/callback", testCodeVerifier)
+	assert.Equal(t, ErrInvalidGrant, err)
+	s.users.M.AssertCalled(t, "RevokeTokenFamily", "r_uuid")
+}
+
+func (s *oidcSuite) TestAuthorizeMFA() {
+	t := s.T()
+	s.expectLogin(true)
+	s.users.M.On("StoreMFAChallenge", mock.Anything, "1", mock.Anything).Return(nil)
+	authorization, err := s.svc.Authorize(context.TODO(), s.authorizationRequest(), s.user.Email, "secret")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, authorization.MFAChallenge)
+	assert.Empty(t, authorization.RedirectTo)
+	s.oidc.M.AssertNotCalled(t, "StoreAuthorizationCode", mock.Anything, mock.Anything)
+}
+
+func (s *oidcSuite) TestUserInfo() {
+	t := s.T()
+	s.users.M.On("GetUserByToken", "a_uuid").Return(s.user, nil)
+	s.users.M.On("GetUserByID", "1").Return(s.user, nil)
+	info, err := s.svc.UserInfo(context.TODO(), "a_jwt")
+	assert.Nil(t, err)
+	assert.Equal(t, &entities.UserInfo{Sub: "1", Email: "srojas@gmail.com", EmailVerified: true, Name: "steven rojas"}, info)
+
+	_, err = s.svc.UserInfo(context.TODO(), "gak_key_secret")
+	assert.Equal(t, ErrInvalidToken, err)
+}
+
+func (s *oidcSuite) TestRegisterClient() {
+	t := s.T()
+	_, err := s.svc.RegisterClient(context.TODO(), "spa", []string{"http://app.example.com/callback"})
+	assert.IsType(t, &ValidationError{}, err)
+	_, err = s.svc.RegisterClient(context.TODO(), "spa", []string{"https://app.example.com/callback#token"})
+	assert.IsType(t, &ValidationError{}, err)
+
+	s.oidc.M.On("AddClient", mock.Anything).Return(nil)
+	client, err := s.svc.RegisterClient(context.TODO(), "spa", []string{"https://app.example.com/callback", "http://localhost:3000/callback"})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, client.ID)
+}
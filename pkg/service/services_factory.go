@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"os"
+	"time"
 
 	"github.com/StevenRojas/goaccess/pkg/configuration"
 	"github.com/StevenRojas/goaccess/pkg/events"
@@ -18,12 +19,24 @@ type ServicesFactory interface {
 	Setup()
 	// CreateAuthenticationService create Authentication service
 	CreateAuthenticationService() AuthenticationService
+	// CreateInternalAuthenticationService create Internal Authentication service for service clients
+	CreateInternalAuthenticationService() InternalAuthenticationService
+	// CreateIntrospectionService create Introspection service on top of the authentication services
+	CreateIntrospectionService(authentication AuthenticationService, internal InternalAuthenticationService) IntrospectionService
+	// CreateOIDCService create OpenID Connect provider service on top of the authentication service
+	CreateOIDCService(authentication AuthenticationService) OIDCService
+	// CreateFederationService create federated login service on top of the authentication service
+	CreateFederationService(authentication AuthenticationService) FederationService
+	// CreateAPIKeysService create API Keys service
+	CreateAPIKeysService() APIKeysService
 	// CreateAccessService create Access service
 	CreateAccessService() AccessService
 	// CreateAuthorizationService create Authorization service
 	CreateAuthorizationService() AuthorizationService
 	// CreateInitService create Initialization service
 	CreateInitializationService() InitializationService
+	// SecurityFeed get the feed of security events to subscribe additional listeners
+	SecurityFeed() events.SecurityFeed
 }
 
 type serviceFactory struct {
@@ -35,7 +48,14 @@ type serviceFactory struct {
 	rolesRepo      repository.RolesRepository
 	actionsRepo    repository.ActionsRepository
 	initRepo       repository.InitRepository
+	keysRepo       repository.KeysRepository
+	clientsRepo    repository.ClientsRepository
+	oidcRepo       repository.OIDCRepository
+	apiKeysRepo    repository.APIKeysRepository
+	attemptsRepo   repository.AttemptsRepository
+	jwtHandler     utils.JwtHandler // one key ring shared by the services that sign tokens
 	subscriberFeed events.SubscriberFeed
+	securityFeed   events.SecurityFeed
 }
 
 // NewServiceFactory get a new service factory instance
@@ -76,7 +96,32 @@ func (sb *serviceFactory) Setup() {
 	if err != nil {
 		panic(errors.New("Unable to create init repository"))
 	}
+	sb.keysRepo, err = repository.NewKeysRepository(sb.ctx, redisClient)
+	if err != nil {
+		panic(errors.New("Unable to create keys repository"))
+	}
+	sb.clientsRepo, err = repository.NewClientsRepository(sb.ctx, redisClient)
+	if err != nil {
+		panic(errors.New("Unable to create clients repository"))
+	}
+	sb.oidcRepo, err = repository.NewOIDCRepository(sb.ctx, redisClient)
+	if err != nil {
+		panic(errors.New("Unable to create OpenID Connect repository"))
+	}
+	sb.apiKeysRepo, err = repository.NewAPIKeysRepository(sb.ctx, redisClient)
+	if err != nil {
+		panic(errors.New("Unable to create API keys repository"))
+	}
+	sb.attemptsRepo, err = repository.NewAttemptsRepository(sb.ctx, redisClient)
+	if err != nil {
+		panic(errors.New("Unable to create attempts repository"))
+	}
+	sb.jwtHandler = sb.createJwtHandler()
 	sb.subscriberFeed = events.NewSubscriber()
+	sb.securityFeed = events.NewSecurityFeed()
+	// Security events are logged, more listeners can be subscribed using SecurityFeed
+	securityListener := events.NewSecurityListener(configuration.NewLogger(sb.serviceConfig.Server), sb.securityFeed)
+	go securityListener.RegisterSecurityListener()
 	sb.reposReady = true
 }
 
@@ -85,8 +130,109 @@ func (sb serviceFactory) CreateAuthenticationService() AuthenticationService {
 	if !sb.reposReady {
 		panic(errors.New("Repositories not created, use Setup method first"))
 	}
-	jwtHander := utils.NewJwtHandler(sb.serviceConfig.Security)
-	return NewAuthenticationService(sb.usersRepo, jwtHander)
+	// Stateless mode verifies the access tokens by signature and expiration with an in memory revocation list
+	var revocations utils.RevocationList
+	if sb.serviceConfig.Security.JWTStateless {
+		revocations = utils.NewRevocationList(sb.serviceConfig.Security, sb.usersRepo)
+		if err := revocations.Load(sb.ctx); err != nil {
+			panic(err)
+		}
+	}
+	hasher, err := utils.NewPasswordHasher(sb.serviceConfig.Security)
+	if err != nil {
+		panic(err)
+	}
+	policy, err := utils.NewPasswordPolicy(sb.serviceConfig.PasswordPolicy)
+	if err != nil {
+		panic(err)
+	}
+	// Failed logins are shared by all the instances through the attempts repository
+	throttle := utils.NewLoginThrottle(sb.serviceConfig.LoginThrottle, sb.attemptsRepo)
+	// Passwordless login requests of an account are limited within a sliding window
+	limiter := utils.NewRateLimiter(
+		sb.attemptsRepo,
+		sb.serviceConfig.EmailTokens.PasswordlessMaxRequests,
+		time.Minute*time.Duration(sb.serviceConfig.EmailTokens.PasswordlessWindow),
+	)
+	mailer, err := utils.NewMailer(sb.serviceConfig.Mail, configuration.NewLogger(sb.serviceConfig.Server))
+	if err != nil {
+		panic(err)
+	}
+	totp := utils.NewTOTPHandler(sb.serviceConfig.MFA)
+	return NewAuthenticationService(
+		sb.usersRepo,
+		sb.apiKeysRepo,
+		sb.jwtHandler,
+		revocations,
+		hasher,
+		policy,
+		throttle,
+		limiter,
+		totp,
+		sb.serviceConfig.MFA,
+		mailer,
+		sb.serviceConfig.EmailTokens,
+		sb.securityFeed,
+	)
+}
+
+// CreateInternalAuthenticationService create Internal Authentication service for service clients
+func (sb serviceFactory) CreateInternalAuthenticationService() InternalAuthenticationService {
+	if !sb.reposReady {
+		panic(errors.New("Repositories not created, use Setup method first"))
+	}
+	tokenHandler, err := utils.NewInternalTokenHandler(sb.serviceConfig.Security)
+	if err != nil {
+		panic(err)
+	}
+	return NewInternalAuthenticationService(sb.clientsRepo, sb.rolesRepo, tokenHandler, sb.subscriberFeed)
+}
+
+// CreateIntrospectionService create Introspection service on top of the authentication services
+func (sb serviceFactory) CreateIntrospectionService(authentication AuthenticationService, internal InternalAuthenticationService) IntrospectionService {
+	if !sb.reposReady {
+		panic(errors.New("Repositories not created, use Setup method first"))
+	}
+	return NewIntrospectionService(authentication, internal, sb.rolesRepo)
+}
+
+// CreateOIDCService create OpenID Connect provider service on top of the authentication service
+func (sb serviceFactory) CreateOIDCService(authentication AuthenticationService) OIDCService {
+	if !sb.reposReady {
+		panic(errors.New("Repositories not created, use Setup method first"))
+	}
+	return NewOIDCService(sb.oidcRepo, sb.usersRepo, authentication, sb.jwtHandler, sb.serviceConfig.OIDC)
+}
+
+// CreateFederationService create federated login service on top of the authentication service
+func (sb serviceFactory) CreateFederationService(authentication AuthenticationService) FederationService {
+	if !sb.reposReady {
+		panic(errors.New("Repositories not created, use Setup method first"))
+	}
+	issuer := utils.NewOIDCIssuer(sb.serviceConfig.Federation)
+	return NewFederationService(sb.oidcRepo, sb.usersRepo, authentication, issuer, sb.serviceConfig.Federation)
+}
+
+// createJwtHandler create the JWT handler signing with the key ring, signing keys are shared by all the instances
+// through the keys repository. It is created once on setup, a second ring would rotate the keys on its own
+func (sb serviceFactory) createJwtHandler() utils.JwtHandler {
+	ring := utils.NewKeyRing(sb.serviceConfig.Security, sb.keysRepo)
+	if err := ring.Load(sb.ctx); err != nil {
+		panic(err)
+	}
+	jwtHandler, err := utils.NewJwtHandler(sb.serviceConfig.Security, ring)
+	if err != nil {
+		panic(err)
+	}
+	return jwtHandler
+}
+
+// CreateAPIKeysService create API Keys service
+func (sb serviceFactory) CreateAPIKeysService() APIKeysService {
+	if !sb.reposReady {
+		panic(errors.New("Repositories not created, use Setup method first"))
+	}
+	return NewAPIKeysService(sb.apiKeysRepo, sb.usersRepo, sb.rolesRepo, sb.subscriberFeed)
 }
 
 // CreateAccessService create Access service
@@ -100,7 +246,8 @@ func (sb serviceFactory) CreateAccessService() AccessService {
 	actionListener := events.NewActionListener(sb.actionsRepo, sb.rolesRepo, sb.subscriberFeed)
 	go actionListener.RegisterActionListener()
 
-	return NewAccessService(sb.modulesRepo, sb.rolesRepo, sb.actionsRepo, sb.subscriberFeed)
+	return NewAccessService(sb.modulesRepo, sb.rolesRepo, sb.actionsRepo, sb.usersRepo, sb.subscriberFeed,
+		sb.serviceConfig.Security)
 }
 
 // CreateAuthorizationService create Authorization service
@@ -108,7 +255,8 @@ func (sb serviceFactory) CreateAuthorizationService() AuthorizationService {
 	if !sb.reposReady {
 		panic(errors.New("Repositories not created, use Setup method first"))
 	}
-	return NewAuthorizationService(sb.modulesRepo, sb.rolesRepo, sb.actionsRepo, sb.usersRepo, sb.subscriberFeed)
+	return NewAuthorizationService(sb.modulesRepo, sb.rolesRepo, sb.actionsRepo, sb.usersRepo, sb.subscriberFeed,
+		sb.serviceConfig.Security)
 }
 
 // CreateInitService create Initialization service
@@ -121,3 +269,11 @@ func (sb serviceFactory) CreateInitializationService() InitializationService {
 	jsonHandler := utils.NewJSONHandler(path)
 	return NewInitService(sb.initRepo, jsonHandler)
 }
+
+// SecurityFeed get the feed of security events to subscribe additional listeners
+func (sb serviceFactory) SecurityFeed() events.SecurityFeed {
+	if !sb.reposReady {
+		panic(errors.New("Repositories not created, use Setup method first"))
+	}
+	return sb.securityFeed
+}
@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// APIKeyPrefix prefix of the API keys, it tells them apart from JWTs
+const APIKeyPrefix = "gak_"
+
+// apiKeySecretSize random bytes of the secret part of an API key
+const apiKeySecretSize = 32
+
+// NewAPIKey create an API key for a key ID and return it with the hash to store, the key has
+// the form gak_<keyID>_<secret>
+func NewAPIKey(keyID string) (string, string, error) {
+	secret, err := RandomToken(apiKeySecretSize)
+	if err != nil {
+		return "", "", err
+	}
+	return APIKeyPrefix + keyID + "_" + secret, HashAPIKeySecret(secret), nil
+}
+
+// ParseAPIKey split an API key into its key ID and secret, ok is false when it is not an API key
+func ParseAPIKey(key string) (keyID string, secret string, ok bool) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return "", "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(key, APIKeyPrefix), "_", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// HashAPIKeySecret hash the secret of an API key or a service client, the secrets are random so a fast hash is enough
+func HashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
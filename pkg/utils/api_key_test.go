@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKey(t *testing.T) {
+	key, hash, err := NewAPIKey("c0ffee")
+	assert.Nil(t, err)
+	keyID, secret, ok := ParseAPIKey(key)
+	assert.True(t, ok)
+	assert.Equal(t, "c0ffee", keyID)
+	assert.Equal(t, hash, HashAPIKeySecret(secret))
+	assert.NotContains(t, hash, secret)
+
+	_, _, ok = ParseAPIKey("eyJhbGciOiJIUzI1NiJ9.e30.sig")
+	assert.False(t, ok)
+	_, _, ok = ParseAPIKey(APIKeyPrefix + "c0ffee")
+	assert.False(t, ok)
+}
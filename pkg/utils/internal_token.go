@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/rs/xid"
+)
+
+// InternalTokenType value of the token_type claim of the service client tokens, user tokens don't have it
+const InternalTokenType = "internal"
+
+// ErrInternalTokensDisabled returned when JWT_INTERNAL_SECRET_KEY is not set
+var ErrInternalTokensDisabled = errors.New("Internal tokens are disabled, JWT_INTERNAL_SECRET_KEY is not set")
+
+// InternalTokenHandler issue and verify the tokens of service clients, they are signed with
+// JWT_INTERNAL_SECRET_KEY so they can't be used as user tokens and the other way around
+type InternalTokenHandler interface {
+	// CreateToken create an access and refresh token pair for a client with the given scopes
+	CreateToken(clientID string, scopes []string) (*StoredToken, error)
+	// CreateAccessToken create only an access token for a client with the given scopes, returns its expiration
+	CreateAccessToken(clientID string, scopes []string) (string, int64, error)
+	// GetTokenClaims verify an internal token and return its claims
+	GetTokenClaims(token string) (jwt.MapClaims, error)
+}
+
+type internalTokenHandler struct {
+	signer                       JwtSigner
+	JWTInternalTokenExpiration   int
+	JWTInternalRefreshExpiration int
+}
+
+// NewInternalTokenHandler return a new internal token handler, the internal secret must differ from JWT_SECRET_KEY
+func NewInternalTokenHandler(config configuration.SecurityConfig) (InternalTokenHandler, error) {
+	if config.JWTInternalSecret != "" && config.JWTInternalSecret == config.JWTSecret {
+		return nil, errors.New("JWT_INTERNAL_SECRET_KEY must be different from JWT_SECRET_KEY")
+	}
+	h := &internalTokenHandler{
+		JWTInternalTokenExpiration:   config.JWTInternalTokenExpiration,
+		JWTInternalRefreshExpiration: config.JWTInternalRefreshExpiration,
+	}
+	if config.JWTInternalSecret == "" {
+		return h, nil
+	}
+	signer, err := newKeySigner(entities.SigningKey{Algorithm: AlgHS256, Key: config.JWTInternalSecret})
+	if err != nil {
+		return nil, err
+	}
+	h.signer = signer
+	return h, nil
+}
+
+// CreateToken create an access and refresh token pair for a client with the given scopes
+func (h *internalTokenHandler) CreateToken(clientID string, scopes []string) (*StoredToken, error) {
+	if h.signer == nil {
+		return nil, ErrInternalTokensDisabled
+	}
+	aUUDI := xid.New().String()
+	aExp := time.Now().Add(time.Hour * time.Duration(h.JWTInternalTokenExpiration)).Unix()
+	atoken, err := h.sign(clientID, scopes, "access_uuid", aUUDI, aExp)
+	if err != nil {
+		return nil, err
+	}
+	rUUDI := xid.New().String()
+	rExp := time.Now().Add(time.Hour * time.Duration(h.JWTInternalRefreshExpiration)).Unix()
+	rtoken, err := h.sign(clientID, scopes, "refresh_uuid", rUUDI, rExp)
+	if err != nil {
+		return nil, err
+	}
+	return &StoredToken{
+		ID:             clientID,
+		AccessToken:    atoken,
+		AccessUUID:     aUUDI,
+		AccessExpires:  aExp,
+		RefreshToken:   rtoken,
+		RefreshUUID:    rUUDI,
+		RefreshExpires: rExp,
+	}, nil
+}
+
+// CreateAccessToken create only an access token for a client with the given scopes, returns its expiration
+func (h *internalTokenHandler) CreateAccessToken(clientID string, scopes []string) (string, int64, error) {
+	if h.signer == nil {
+		return "", 0, ErrInternalTokensDisabled
+	}
+	exp := time.Now().Add(time.Hour * time.Duration(h.JWTInternalTokenExpiration)).Unix()
+	token, err := h.sign(clientID, scopes, "access_uuid", xid.New().String(), exp)
+	if err != nil {
+		return "", 0, err
+	}
+	return token, exp, nil
+}
+
+// sign sign the claims of an internal access or refresh token
+func (h *internalTokenHandler) sign(clientID string, scopes []string, uuidClaim string, uuid string, exp int64) (string, error) {
+	claims := jwt.MapClaims{}
+	claims["token_type"] = InternalTokenType
+	claims["client_id"] = clientID
+	claims["scope"] = strings.Join(scopes, " ")
+	claims[uuidClaim] = uuid
+	claims["exp"] = exp
+	token, err := h.signer.Sign(claims)
+	if err != nil {
+		return "", errors.New("Unable to create token")
+	}
+	return token, nil
+}
+
+// GetTokenClaims verify an internal token and return its claims
+func (h *internalTokenHandler) GetTokenClaims(token string) (jwt.MapClaims, error) {
+	if h.signer == nil {
+		return nil, ErrInternalTokensDisabled
+	}
+	claims, err := h.signer.Parse(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims["token_type"] != InternalTokenType {
+		return nil, errors.New("Not an internal token")
+	}
+	if _, ok := claims["client_id"].(string); !ok {
+		return nil, errors.New("Invalid token claims")
+	}
+	return claims, nil
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInternalToken(t *testing.T) {
+	config := configuration.SecurityConfig{
+		JWTSecret:                    "secret",
+		JWTInternalSecret:            "internal",
+		JWTInternalTokenExpiration:   1,
+		JWTInternalRefreshExpiration: 2,
+	}
+	h, err := NewInternalTokenHandler(config)
+	assert.Nil(t, err)
+	token, err := h.CreateToken("client", []string{"post:report", "delete:report:[]"})
+	assert.Nil(t, err)
+	claims, err := h.GetTokenClaims(token.AccessToken)
+	assert.Nil(t, err)
+	assert.Equal(t, "client", claims["client_id"])
+	assert.Equal(t, "post:report delete:report:[]", claims["scope"])
+	assert.Equal(t, token.AccessUUID, claims["access_uuid"])
+	claims, err = h.GetTokenClaims(token.RefreshToken)
+	assert.Nil(t, err)
+	assert.Equal(t, token.RefreshUUID, claims["refresh_uuid"])
+
+	// User tokens are signed with another key and don't have the internal token type
+	ring := NewKeyRing(config, nil)
+	assert.Nil(t, ring.Load(context.Background()))
+	userHandler, err := NewJwtHandler(config, ring)
+	assert.Nil(t, err)
+	userToken, _ := userHandler.CreateToken("1", nil)
+	_, err = h.GetTokenClaims(userToken.AccessToken)
+	assert.NotNil(t, err)
+	_, err = ring.Parse(token.AccessToken)
+	assert.NotNil(t, err)
+}
+
+func TestInternalTokenConfig(t *testing.T) {
+	_, err := NewInternalTokenHandler(configuration.SecurityConfig{JWTSecret: "secret", JWTInternalSecret: "secret"})
+	assert.NotNil(t, err)
+
+	h, err := NewInternalTokenHandler(configuration.SecurityConfig{JWTSecret: "secret"})
+	assert.Nil(t, err)
+	_, err = h.CreateToken("client", []string{"post:report"})
+	assert.Equal(t, ErrInternalTokensDisabled, err)
+	_, err = h.GetTokenClaims("token")
+	assert.Equal(t, ErrInternalTokensDisabled, err)
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"errors"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// SigningMethodEdDSA Ed25519 signing method (RFC 8037), not provided by jwt-go
+var SigningMethodEdDSA = &signingMethodEdDSA{}
+
+type signingMethodEdDSA struct{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+// Alg algorithm name of the JWT header
+func (m *signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+// Sign sign the string with an ed25519.PrivateKey
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return "", errors.New("Invalid Ed25519 private key")
+	}
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+// Verify verify the signature with an ed25519.PublicKey
+func (m *signingMethodEdDSA) Verify(signingString string, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if len(publicKey) != ed25519.PublicKeySize || !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
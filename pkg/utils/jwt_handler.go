@@ -1,10 +1,17 @@
 package utils
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/StevenRojas/goaccess/pkg/entities"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/rs/xid"
 )
@@ -18,38 +25,104 @@ type StoredToken struct {
 	RefreshToken   string
 	RefreshUUID    string
 	RefreshExpires int64
+	Family         string // refresh token family, the first refresh UUID issued at login
+}
+
+// Optional user claims of the access tokens, set with JWT_CLAIMS
+const (
+	ClaimEmail       = "email"
+	ClaimName        = "name"
+	ClaimIsAdmin     = "is_admin"
+	ClaimRoles       = "roles"
+	ClaimPermissions = "permissions" // digest of the user actions, the claim is perms
+)
+
+// TokenClaims user claims of an access token, only the configured ones are added. The version is in the ver claim
+// when the roles or permissions are
+type TokenClaims struct {
+	Email       string
+	Name        string
+	IsAdmin     bool
+	Roles       []string
+	Permissions []string // actions the user can perform
+	Version     int64    // token version of the user, it changes with the roles of the user
+}
+
+// IDTokenClaims claims of an OpenID Connect ID token, the email and name are only set for the scopes that
+// grant them
+type IDTokenClaims struct {
+	Issuer        string
+	Audience      string // client ID of the relying party
+	Nonce         string
+	AuthTime      int64
+	ExpiresAt     int64
+	Email         string
+	EmailVerified bool
+	Name          string
 }
 
 // JwtHandler interface
 type JwtHandler interface {
-	CreateToken(ID string) (*StoredToken, error)
+	// CreateToken create an access and refresh token pair of a user, the user claims are added to the access token
+	CreateToken(ID string, claims *TokenClaims) (*StoredToken, error)
+	// CreateImpersonationToken create only an access token of a user for an impersonator, the impersonator ID is
+	// in the act claim (RFC 8693)
+	CreateImpersonationToken(ID string, impersonatorID string, claims *TokenClaims) (*StoredToken, error)
+	// CreateIDToken create an OpenID Connect ID token of a user, it has no access_uuid so it is not accepted as
+	// an access token
+	CreateIDToken(ID string, claims *IDTokenClaims) (string, error)
 	GetTokenClaims(token string) (jwt.MapClaims, error)
+	// JWKS public keys to verify the tokens, empty when they are signed with a shared secret
+	JWKS() *entities.JWKS
+	// RotateKey replace the signing key if it is older than maxAge, 0 to always rotate. Returns the active key ID
+	RotateKey(ctx context.Context, maxAge time.Duration) (string, error)
+	// Versioned check if the access tokens carry the token version of the user, only when they carry its roles
+	// or permissions
+	Versioned() bool
 }
 
 type jwtHandler struct {
-	JWTSecret            string
-	JWTTokenExpiration   int
-	JWTRefreshExpiration int
+	ring                       KeyRing
+	JWTTokenExpiration         int
+	JWTRefreshExpiration       int
+	JWTImpersonationExpiration int
+	claims                     map[string]bool
 }
 
-// NewJwtHandler return a new JWT handler instance
-func NewJwtHandler(config configuration.SecurityConfig) JwtHandler {
-	return &jwtHandler{
-		JWTSecret:            config.JWTSecret,
-		JWTTokenExpiration:   config.JWTTokenExpiration,
-		JWTRefreshExpiration: config.JWTRefreshExpiration,
+// NewJwtHandler return a new JWT handler instance signing with the active key of the ring
+func NewJwtHandler(config configuration.SecurityConfig, ring KeyRing) (JwtHandler, error) {
+	claims := make(map[string]bool)
+	for _, claim := range strings.Split(config.JWTClaims, ",") {
+		claim = strings.TrimSpace(claim)
+		switch claim {
+		case "":
+			continue
+		case ClaimEmail, ClaimName, ClaimIsAdmin, ClaimRoles, ClaimPermissions:
+			claims[claim] = true
+		default:
+			return nil, errors.New("Unsupported JWT claim: " + claim)
+		}
 	}
+	return &jwtHandler{
+		ring:                       ring,
+		JWTTokenExpiration:         config.JWTTokenExpiration,
+		JWTRefreshExpiration:       config.JWTRefreshExpiration,
+		JWTImpersonationExpiration: config.JWTImpersonationExpiration,
+		claims:                     claims,
+	}, nil
 }
 
-func (h *jwtHandler) CreateToken(ID string) (*StoredToken, error) {
+// CreateToken create an access and refresh token pair of a user, the user claims are added to the access token
+func (h *jwtHandler) CreateToken(ID string, userClaims *TokenClaims) (*StoredToken, error) {
 	aUUDI := xid.New().String()
 	aExp := time.Now().Add(time.Hour * time.Duration(h.JWTTokenExpiration)).Unix()
 	claims := jwt.MapClaims{}
+	h.addUserClaims(claims, userClaims)
 	claims["user_id"] = ID
 	claims["access_uuid"] = aUUDI
+	claims["jti"] = aUUDI
 	claims["exp"] = aExp
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	atoken, err := t.SignedString([]byte(h.JWTSecret))
+	atoken, err := h.ring.Sign(claims)
 	if err != nil {
 		return nil, errors.New("Unable to create token")
 	}
@@ -60,8 +133,7 @@ func (h *jwtHandler) CreateToken(ID string) (*StoredToken, error) {
 	claims["user_id"] = ID
 	claims["refresh_uuid"] = rUUDI
 	claims["exp"] = rExp
-	t = jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	rtoken, err := t.SignedString([]byte(h.JWTSecret))
+	rtoken, err := h.ring.Sign(claims)
 	if err != nil {
 		return nil, errors.New("Unable to create token")
 	}
@@ -76,19 +148,121 @@ func (h *jwtHandler) CreateToken(ID string) (*StoredToken, error) {
 	}, nil
 }
 
-func (h *jwtHandler) GetTokenClaims(token string) (jwt.MapClaims, error) {
-	parsed, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("Wrong signed method")
-		}
-		return []byte(h.JWTSecret), nil
-	})
+// CreateImpersonationToken create only an access token of a user for an impersonator, the impersonator ID is in
+// the act claim (RFC 8693)
+func (h *jwtHandler) CreateImpersonationToken(ID string, impersonatorID string, userClaims *TokenClaims) (*StoredToken, error) {
+	aUUDI := xid.New().String()
+	aExp := time.Now().Add(time.Minute * time.Duration(h.JWTImpersonationExpiration)).Unix()
+	claims := jwt.MapClaims{}
+	h.addUserClaims(claims, userClaims)
+	claims["user_id"] = ID
+	claims["access_uuid"] = aUUDI
+	claims["jti"] = aUUDI
+	claims["exp"] = aExp
+	claims["act"] = map[string]interface{}{"sub": impersonatorID}
+	atoken, err := h.ring.Sign(claims)
 	if err != nil {
-		return nil, err
+		return nil, errors.New("Unable to create token")
 	}
-	claims, ok := parsed.Claims.(jwt.MapClaims)
-	if !ok && !parsed.Valid {
-		return nil, err
+	return &StoredToken{
+		ID:            ID,
+		AccessToken:   atoken,
+		AccessUUID:    aUUDI,
+		AccessExpires: aExp,
+	}, nil
+}
+
+// CreateIDToken create an OpenID Connect ID token of a user, it has no access_uuid so it is not accepted as an
+// access token
+func (h *jwtHandler) CreateIDToken(ID string, idClaims *IDTokenClaims) (string, error) {
+	claims := jwt.MapClaims{}
+	claims["iss"] = idClaims.Issuer
+	claims["sub"] = ID
+	claims["aud"] = idClaims.Audience
+	claims["exp"] = idClaims.ExpiresAt
+	claims["iat"] = time.Now().Unix()
+	claims["auth_time"] = idClaims.AuthTime
+	if idClaims.Nonce != "" {
+		claims["nonce"] = idClaims.Nonce
 	}
-	return claims, nil
+	if idClaims.Email != "" {
+		claims["email"] = idClaims.Email
+		claims["email_verified"] = idClaims.EmailVerified
+	}
+	if idClaims.Name != "" {
+		claims["name"] = idClaims.Name
+	}
+	token, err := h.ring.Sign(claims)
+	if err != nil {
+		return "", errors.New("Unable to create token")
+	}
+	return token, nil
+}
+
+// Versioned check if the access tokens carry the token version of the user
+func (h *jwtHandler) Versioned() bool {
+	return h.claims[ClaimRoles] || h.claims[ClaimPermissions]
+}
+
+// VersionedClaims check if the configured JWT_CLAIMS change with the roles of the user, only then the token version
+// of the users is increased and checked
+func VersionedClaims(config configuration.SecurityConfig) bool {
+	for _, claim := range strings.Split(config.JWTClaims, ",") {
+		if claim = strings.TrimSpace(claim); claim == ClaimRoles || claim == ClaimPermissions {
+			return true
+		}
+	}
+	return false
+}
+
+// addUserClaims add the configured user claims and the token version when they change with the roles of the user
+func (h *jwtHandler) addUserClaims(claims jwt.MapClaims, userClaims *TokenClaims) {
+	if userClaims == nil {
+		return
+	}
+	if h.Versioned() {
+		claims["ver"] = userClaims.Version
+	}
+	if h.claims[ClaimEmail] {
+		claims["email"] = userClaims.Email
+	}
+	if h.claims[ClaimName] {
+		claims["name"] = userClaims.Name
+	}
+	if h.claims[ClaimIsAdmin] {
+		claims["is_admin"] = userClaims.IsAdmin
+	}
+	if h.claims[ClaimRoles] {
+		roles := append([]string{}, userClaims.Roles...)
+		sort.Strings(roles)
+		claims["roles"] = roles
+	}
+	if h.claims[ClaimPermissions] {
+		claims["perms"] = PermissionDigest(userClaims.Permissions)
+	}
+}
+
+// PermissionDigest compact digest of a list of actions, the first 128 bits of the SHA-256 of the JSON array of
+// the sorted actions encoded as base64url. Services can cache the permissions of a user by its digest and fetch
+// them again when it changes
+func PermissionDigest(actions []string) string {
+	sorted := append([]string{}, actions...)
+	sort.Strings(sorted)
+	j, _ := json.Marshal(sorted)
+	sum := sha256.Sum256(j)
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
+}
+
+func (h *jwtHandler) GetTokenClaims(token string) (jwt.MapClaims, error) {
+	return h.ring.Parse(token)
+}
+
+// JWKS public keys to verify the tokens, empty when they are signed with a shared secret
+func (h *jwtHandler) JWKS() *entities.JWKS {
+	return h.ring.JWKS()
+}
+
+// RotateKey replace the signing key if it is older than maxAge, 0 to always rotate. Returns the active key ID
+func (h *jwtHandler) RotateKey(ctx context.Context, maxAge time.Duration) (string, error) {
+	return h.ring.Rotate(ctx, maxAge)
 }
@@ -1,25 +1,35 @@
 package utils
 
 import (
+	"context"
+	"time"
+
 	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/StevenRojas/goaccess/pkg/entities"
 	"github.com/dgrijalva/jwt-go"
 )
 
 // JwtHandlerMock interface
 type JwtHandlerMock interface {
-	CreateToken(ID string) (*StoredToken, error)
+	CreateToken(ID string, claims *TokenClaims) (*StoredToken, error)
+	CreateImpersonationToken(ID string, impersonatorID string, claims *TokenClaims) (*StoredToken, error)
+	CreateIDToken(ID string, claims *IDTokenClaims) (string, error)
 	GetTokenClaims(token string) (jwt.MapClaims, error)
+	JWKS() *entities.JWKS
+	RotateKey(ctx context.Context, maxAge time.Duration) (string, error)
+	Versioned() bool
 }
 
 type jwtHandlerMock struct {
+	versioned bool
 }
 
 // NewJwtHandlerMock return a new JWT handler instance
 func NewJwtHandlerMock(config configuration.SecurityConfig) JwtHandlerMock {
-	return &jwtHandlerMock{}
+	return &jwtHandlerMock{versioned: VersionedClaims(config)}
 }
 
-func (h *jwtHandlerMock) CreateToken(ID string) (*StoredToken, error) {
+func (h *jwtHandlerMock) CreateToken(ID string, claims *TokenClaims) (*StoredToken, error) {
 	return &StoredToken{
 		ID:             "1",
 		AccessToken:    "a_jwt",
@@ -31,10 +41,47 @@ func (h *jwtHandlerMock) CreateToken(ID string) (*StoredToken, error) {
 	}, nil
 }
 
+func (h *jwtHandlerMock) CreateImpersonationToken(ID string, impersonatorID string, claims *TokenClaims) (*StoredToken, error) {
+	return &StoredToken{
+		ID:            ID,
+		AccessToken:   "i_jwt",
+		AccessUUID:    "i_uuid",
+		AccessExpires: 30,
+	}, nil
+}
+
+func (h *jwtHandlerMock) CreateIDToken(ID string, claims *IDTokenClaims) (string, error) {
+	return "id_jwt", nil
+}
+
 func (h *jwtHandlerMock) GetTokenClaims(token string) (jwt.MapClaims, error) {
 	claims := make(map[string]interface{})
+	if token == "id_jwt" {
+		claims["sub"] = "1"
+		claims["aud"] = "spa"
+		claims["exp"] = "10"
+		return claims, nil
+	}
+	if token == "r_jwt" {
+		claims["refresh_uuid"] = "r_uuid"
+		claims["user_id"] = "1"
+		claims["exp"] = "20"
+		return claims, nil
+	}
 	claims["access_uuid"] = "a_uuid"
 	claims["user_id"] = "1"
 	claims["exp"] = "10"
 	return claims, nil
 }
+
+func (h *jwtHandlerMock) JWKS() *entities.JWKS {
+	return &entities.JWKS{Keys: []entities.JWK{{Kty: "OKP", Crv: "Ed25519", Kid: "kid", X: "x"}}}
+}
+
+func (h *jwtHandlerMock) RotateKey(ctx context.Context, maxAge time.Duration) (string, error) {
+	return "new_kid", nil
+}
+
+func (h *jwtHandlerMock) Versioned() bool {
+	return h.versioned
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImpersonationToken(t *testing.T) {
+	config := configuration.SecurityConfig{
+		JWTSecret:                  "secret",
+		JWTImpersonationExpiration: 30,
+		JWTClaims:                  "roles",
+	}
+	ring := NewKeyRing(config, nil)
+	assert.Nil(t, ring.Load(context.Background()))
+	h, err := NewJwtHandler(config, ring)
+	assert.Nil(t, err)
+	token, err := h.CreateImpersonationToken("1", "9", &TokenClaims{Version: 2})
+	assert.Nil(t, err)
+	assert.Empty(t, token.RefreshToken)
+	assert.Empty(t, token.RefreshUUID)
+	assert.InDelta(t, time.Now().Add(30*time.Minute).Unix(), token.AccessExpires, 5)
+	claims, err := h.GetTokenClaims(token.AccessToken)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", claims["user_id"])
+	assert.Equal(t, token.AccessUUID, claims["access_uuid"])
+	assert.Equal(t, map[string]interface{}{"sub": "9"}, claims["act"])
+	assert.Equal(t, float64(2), claims["ver"])
+}
+
+func TestIDToken(t *testing.T) {
+	config := configuration.SecurityConfig{JWTSecret: "secret"}
+	ring := NewKeyRing(config, nil)
+	assert.Nil(t, ring.Load(context.Background()))
+	h, err := NewJwtHandler(config, ring)
+	assert.Nil(t, err)
+	exp := time.Now().Add(time.Hour).Unix()
+	token, err := h.CreateIDToken("1", &IDTokenClaims{
+		Issuer:    "https://auth.example.com",
+		Audience:  "spa",
+		Nonce:     "n-0S6",
+		AuthTime:  exp - 3600,
+		ExpiresAt: exp,
+		Email:     "user@example.com",
+	})
+	assert.Nil(t, err)
+	claims, err := h.GetTokenClaims(token)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://auth.example.com", claims["iss"])
+	assert.Equal(t, "1", claims["sub"])
+	assert.Equal(t, "spa", claims["aud"])
+	assert.Equal(t, "n-0S6", claims["nonce"])
+	assert.Equal(t, float64(exp), claims["exp"])
+	assert.Equal(t, "user@example.com", claims["email"])
+	assert.Equal(t, false, claims["email_verified"])
+	assert.NotContains(t, claims, "name")
+	assert.NotContains(t, claims, "access_uuid", "ID tokens are not access tokens")
+}
+
+func TestTokenClaims(t *testing.T) {
+	config := configuration.SecurityConfig{
+		JWTSecret:            "secret",
+		JWTTokenExpiration:   1,
+		JWTRefreshExpiration: 2,
+		JWTClaims:            "email, roles,permissions",
+	}
+	ring := NewKeyRing(config, nil)
+	assert.Nil(t, ring.Load(context.Background()))
+	h, err := NewJwtHandler(config, ring)
+	assert.Nil(t, err)
+	token, err := h.CreateToken("1", &TokenClaims{
+		Email:       "user@mail.com",
+		Name:        "user",
+		IsAdmin:     true,
+		Roles:       []string{"r2", "r1"},
+		Permissions: []string{"get:vehicle:[]", "post:vehicle"},
+		Version:     3,
+	})
+	assert.Nil(t, err)
+	claims, err := h.GetTokenClaims(token.AccessToken)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", claims["user_id"])
+	assert.Equal(t, "user@mail.com", claims["email"])
+	assert.Equal(t, []interface{}{"r1", "r2"}, claims["roles"])
+	assert.Equal(t, PermissionDigest([]string{"post:vehicle", "get:vehicle:[]"}), claims["perms"])
+	assert.Equal(t, float64(3), claims["ver"])
+	assert.NotContains(t, claims, "name")
+	assert.NotContains(t, claims, "is_admin")
+
+	// The user claims are only in the access token
+	claims, err = h.GetTokenClaims(token.RefreshToken)
+	assert.Nil(t, err)
+	assert.NotContains(t, claims, "email")
+	assert.NotContains(t, claims, "ver")
+
+	_, err = NewJwtHandler(configuration.SecurityConfig{JWTClaims: "email,phone"}, ring)
+	assert.NotNil(t, err)
+
+	// Without roles and permissions the claims don't change with the roles of the user, there is no version
+	h, err = NewJwtHandler(configuration.SecurityConfig{JWTClaims: "email,name"}, ring)
+	assert.Nil(t, err)
+	assert.False(t, h.Versioned())
+	token, err = h.CreateToken("1", &TokenClaims{Email: "user@mail.com", Version: 3})
+	assert.Nil(t, err)
+	claims, err = h.GetTokenClaims(token.AccessToken)
+	assert.Nil(t, err)
+	assert.NotContains(t, claims, "ver")
+}
+
+func TestPermissionDigest(t *testing.T) {
+	digest := PermissionDigest([]string{"get:vehicle:[]", "post:vehicle"})
+	assert.Len(t, digest, 22)
+	assert.Equal(t, digest, PermissionDigest([]string{"post:vehicle", "get:vehicle:[]"}))
+	assert.NotEqual(t, digest, PermissionDigest([]string{"get:vehicle:[]"}))
+	assert.NotEqual(t, PermissionDigest([]string{"a", "b"}), PermissionDigest([]string{"a\nb"}))
+}
@@ -0,0 +1,307 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// AlgHS256 HMAC SHA-256 with the shared JWT_SECRET_KEY
+	AlgHS256 = "HS256"
+	// AlgRS256 RSA PKCS#1 v1.5 SHA-256
+	AlgRS256 = "RS256"
+	// AlgES256 ECDSA P-256 SHA-256
+	AlgES256 = "ES256"
+	// AlgEdDSA Ed25519
+	AlgEdDSA = "EdDSA"
+)
+
+// JwtSigner sign and verify tokens with the configured algorithm and key
+type JwtSigner interface {
+	// KeyID key ID set as kid header of the signed tokens
+	KeyID() string
+	// Sign sign the claims and return the compact serialized token
+	Sign(claims jwt.MapClaims) (string, error)
+	// Parse verify the token signature and expiration and return its claims
+	Parse(token string) (jwt.MapClaims, error)
+	// JWKS public keys to verify the tokens, empty for HS256
+	JWKS() *entities.JWKS
+}
+
+type hmacSigner struct {
+	kid    string
+	secret []byte
+}
+
+type asymmetricSigner struct {
+	method     jwt.SigningMethod
+	privateKey crypto.Signer
+	publicKey  crypto.PublicKey
+	jwk        entities.JWK
+}
+
+// NewJwtSigner return a new signer for the configured algorithm, asymmetric keys are loaded from a PEM file
+func NewJwtSigner(config configuration.SecurityConfig) (JwtSigner, error) {
+	key, err := configuredSigningKey(config)
+	if err != nil {
+		return nil, err
+	}
+	return newKeySigner(key)
+}
+
+// configuredSigningKey get the key set by JWT_ALGORITHM, JWT_SECRET_KEY and JWT_PRIVATE_KEY_FILE
+func configuredSigningKey(config configuration.SecurityConfig) (entities.SigningKey, error) {
+	key := entities.SigningKey{
+		Algorithm: config.JWTAlgorithm,
+		CreatedAt: time.Now(),
+	}
+	switch config.JWTAlgorithm {
+	case AlgHS256, "":
+		key.Algorithm = AlgHS256
+		key.Key = config.JWTSecret
+	case AlgRS256, AlgES256, AlgEdDSA:
+		data, err := ioutil.ReadFile(config.JWTPrivateKeyFile)
+		if err != nil {
+			return key, err
+		}
+		key.Key = string(data)
+	default:
+		return key, errors.New("Unsupported JWT algorithm: " + config.JWTAlgorithm)
+	}
+	signer, err := newKeySigner(key)
+	if err != nil {
+		return key, err
+	}
+	key.ID = signer.KeyID()
+	return key, nil
+}
+
+// newKeySigner create the signer of a key, the key ID is derived from the key when it is empty
+func newKeySigner(key entities.SigningKey) (JwtSigner, error) {
+	switch key.Algorithm {
+	case AlgHS256:
+		kid := key.ID
+		if kid == "" {
+			sum := sha256.Sum256([]byte(key.Key))
+			kid = "hs-" + base64.RawURLEncoding.EncodeToString(sum[:12])
+		}
+		return &hmacSigner{kid: kid, secret: []byte(key.Key)}, nil
+	case AlgRS256, AlgES256, AlgEdDSA:
+		s, err := newAsymmetricSigner(key.Algorithm, []byte(key.Key))
+		if err != nil {
+			return nil, err
+		}
+		if key.ID != "" {
+			s.jwk.Kid = key.ID
+		}
+		return s, nil
+	}
+	return nil, errors.New("Unsupported JWT algorithm: " + key.Algorithm)
+}
+
+// KeyID key ID set as kid header of the signed tokens
+func (s *hmacSigner) KeyID() string {
+	return s.kid
+}
+
+// Sign sign the claims and return the compact serialized token
+func (s *hmacSigner) Sign(claims jwt.MapClaims) (string, error) {
+	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	t.Header["kid"] = s.kid
+	return t.SignedString(s.secret)
+}
+
+// Parse verify the token signature and expiration and return its claims
+func (s *hmacSigner) Parse(token string) (jwt.MapClaims, error) {
+	return parseClaims(token, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("Wrong signed method")
+		}
+		if kid, ok := t.Header["kid"]; ok && kid != s.kid {
+			return nil, errors.New("Unknown signing key")
+		}
+		return s.secret, nil
+	})
+}
+
+// JWKS public keys to verify the tokens, empty for HS256
+func (s *hmacSigner) JWKS() *entities.JWKS {
+	return &entities.JWKS{Keys: []entities.JWK{}}
+}
+
+// newAsymmetricSigner create a signer from a PEM encoded private key that matches the algorithm
+func newAsymmetricSigner(alg string, data []byte) (*asymmetricSigner, error) {
+	privateKey, err := parsePrivateKeyPEM(data)
+	if err != nil {
+		return nil, err
+	}
+	s := &asymmetricSigner{
+		privateKey: privateKey,
+		publicKey:  privateKey.Public(),
+	}
+	switch key := privateKey.Public().(type) {
+	case *rsa.PublicKey:
+		if alg != AlgRS256 {
+			return nil, errors.New("RSA keys can only be used with " + AlgRS256)
+		}
+		if key.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must have at least 2048 bits")
+		}
+		s.method = jwt.SigningMethodRS256
+		s.jwk = entities.JWK{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		if alg != AlgES256 || key.Curve != elliptic.P256() {
+			return nil, errors.New("EC keys can only be used with " + AlgES256 + " and the P-256 curve")
+		}
+		s.method = jwt.SigningMethodES256
+		s.jwk = entities.JWK{
+			Kty: "EC",
+			Crv: "P-256",
+			X:   base64.RawURLEncoding.EncodeToString(padBytes(key.X.Bytes(), 32)),
+			Y:   base64.RawURLEncoding.EncodeToString(padBytes(key.Y.Bytes(), 32)),
+		}
+	case ed25519.PublicKey:
+		if alg != AlgEdDSA {
+			return nil, errors.New("Ed25519 keys can only be used with " + AlgEdDSA)
+		}
+		s.method = SigningMethodEdDSA
+		s.jwk = entities.JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(key),
+		}
+	default:
+		return nil, errors.New("Unsupported private key type")
+	}
+	s.jwk.Use = "sig"
+	s.jwk.Alg = alg
+	s.jwk.Kid = thumbprint(s.jwk)
+	return s, nil
+}
+
+// KeyID key ID set as kid header of the signed tokens
+func (s *asymmetricSigner) KeyID() string {
+	return s.jwk.Kid
+}
+
+// Sign sign the claims and return the compact serialized token, the kid header identifies the key
+func (s *asymmetricSigner) Sign(claims jwt.MapClaims) (string, error) {
+	t := jwt.NewWithClaims(s.method, claims)
+	t.Header["kid"] = s.jwk.Kid
+	return t.SignedString(s.privateKey)
+}
+
+// Parse verify the token signature and expiration and return its claims
+func (s *asymmetricSigner) Parse(token string) (jwt.MapClaims, error) {
+	return parseClaims(token, func(t *jwt.Token) (interface{}, error) {
+		// The algorithm is fixed by the key, never taken from the token header
+		if t.Method.Alg() != s.method.Alg() {
+			return nil, errors.New("Wrong signed method")
+		}
+		if kid, ok := t.Header["kid"]; ok && kid != s.jwk.Kid {
+			return nil, errors.New("Unknown signing key")
+		}
+		return s.publicKey, nil
+	})
+}
+
+// JWKS public keys to verify the tokens
+func (s *asymmetricSigner) JWKS() *entities.JWKS {
+	return &entities.JWKS{Keys: []entities.JWK{s.jwk}}
+}
+
+func parseClaims(token string, keyFunc jwt.Keyfunc) (jwt.MapClaims, error) {
+	parsed, err := jwt.Parse(token, keyFunc)
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok || !parsed.Valid {
+		return nil, errors.New("Invalid token claims")
+	}
+	return claims, nil
+}
+
+// parsePrivateKeyPEM parse a PKCS#8, PKCS#1 (RSA) or SEC 1 (EC) private key
+func parsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("Invalid PEM private key")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("Unsupported private key type")
+		}
+		return signer, nil
+	}
+	return nil, errors.New("Unsupported PEM block type: " + block.Type)
+}
+
+// thumbprint JWK thumbprint (RFC 7638) used as key ID
+func thumbprint(jwk entities.JWK) string {
+	// Required members only, in lexicographic order
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeKeyFile(t *testing.T, key interface{}) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.Nil(t, err)
+	file, err := ioutil.TempFile("", "jwt-key")
+	assert.Nil(t, err)
+	defer file.Close()
+	assert.Nil(t, pem.Encode(file, &pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	return file.Name()
+}
+
+func TestAsymmetricSigners(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	tests := []struct {
+		alg string
+		key interface{}
+		kty string
+	}{
+		{AlgRS256, rsaKey, "RSA"},
+		{AlgES256, ecKey, "EC"},
+		{AlgEdDSA, edKey, "OKP"},
+	}
+	for _, test := range tests {
+		path := writeKeyFile(t, test.key)
+		defer os.Remove(path)
+		signer, err := NewJwtSigner(configuration.SecurityConfig{JWTAlgorithm: test.alg, JWTPrivateKeyFile: path})
+		assert.Nil(t, err, test.alg)
+
+		token, err := signer.Sign(jwt.MapClaims{"user_id": "1", "exp": time.Now().Add(time.Hour).Unix()})
+		assert.Nil(t, err, test.alg)
+		claims, err := signer.Parse(token)
+		assert.Nil(t, err, test.alg)
+		assert.Equal(t, "1", claims["user_id"])
+
+		jwks := signer.JWKS()
+		assert.Len(t, jwks.Keys, 1)
+		assert.Equal(t, test.kty, jwks.Keys[0].Kty)
+		assert.Equal(t, test.alg, jwks.Keys[0].Alg)
+		parsed, _ := jwt.Parse(token, nil)
+		assert.Equal(t, jwks.Keys[0].Kid, parsed.Header["kid"])
+	}
+}
+
+func TestAsymmetricSignerRejectsHMAC(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	path := writeKeyFile(t, edKey)
+	defer os.Remove(path)
+	signer, err := NewJwtSigner(configuration.SecurityConfig{JWTAlgorithm: AlgEdDSA, JWTPrivateKeyFile: path})
+	assert.Nil(t, err)
+	// A token signed with the public key as HMAC secret must not be accepted
+	forged, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": "1"}).
+		SignedString([]byte(edKey.Public().(ed25519.PublicKey)))
+	_, err = signer.Parse(forged)
+	assert.NotNil(t, err)
+}
+
+func TestAsymmetricSignerWrongKeyType(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	path := writeKeyFile(t, ecKey)
+	defer os.Remove(path)
+	_, err := NewJwtSigner(configuration.SecurityConfig{JWTAlgorithm: AlgRS256, JWTPrivateKeyFile: path})
+	assert.NotNil(t, err)
+	_, err = NewJwtSigner(configuration.SecurityConfig{JWTAlgorithm: "none"})
+	assert.NotNil(t, err)
+}
+
+func TestJWKThumbprint(t *testing.T) {
+	// RFC 7638 section 3.1 example
+	n := "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw"
+	jwk := entities.JWK{Kty: "RSA", N: n, E: "AQAB", Alg: AlgRS256, Kid: "2011-04-29"}
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", thumbprint(jwk))
+}
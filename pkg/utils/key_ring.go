@@ -0,0 +1,527 @@
+package utils
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// rotationLockTTL maximum time an instance holds the rotation lock
+	rotationLockTTL = 30 * time.Second
+	// encryptedKeyPrefix prefix of the stored keys encrypted with JWT_KEY_ENCRYPTION_KEY
+	encryptedKeyPrefix = "enc:"
+)
+
+var (
+	// ErrKeyRotationInProgress returned when another instance is rotating the keys
+	ErrKeyRotationInProgress = errors.New("Signing key rotation already in progress")
+	// ErrMissingKeyEncryptionKey returned when asymmetric or rotated keys are used without JWT_KEY_ENCRYPTION_KEY
+	ErrMissingKeyEncryptionKey = errors.New("JWT_KEY_ENCRYPTION_KEY is required to store the signing keys")
+	// ErrInvalidKeyEncryptionKey returned when JWT_KEY_ENCRYPTION_KEY is not a base64 encoded 32 bytes key
+	ErrInvalidKeyEncryptionKey = errors.New("JWT_KEY_ENCRYPTION_KEY must be a base64 encoded 32 bytes key")
+	// ErrKeyDecryption returned when a stored key can't be decrypted with JWT_KEY_ENCRYPTION_KEY
+	ErrKeyDecryption = errors.New("Unable to decrypt the stored signing key, check JWT_KEY_ENCRYPTION_KEY")
+)
+
+// KeyStore persistence of the signing keys shared by all the instances
+type KeyStore interface {
+	// GetSigningKeys get all the stored signing keys
+	GetSigningKeys(ctx context.Context) ([]entities.SigningKey, error)
+	// SaveSigningKeys store or replace the given keys and delete the removed key IDs
+	SaveSigningKeys(ctx context.Context, keys []entities.SigningKey, removed []string) error
+	// GetConfiguredKeyID get the ID of the last key loaded from the configuration
+	GetConfiguredKeyID(ctx context.Context) (string, error)
+	// SetConfiguredKeyID set the ID of the last key loaded from the configuration
+	SetConfiguredKeyID(ctx context.Context, kid string) error
+	// LockRotation get a lock to rotate the keys for an owner token, false if another instance holds it
+	LockRotation(ctx context.Context, owner string, ttl time.Duration) (bool, error)
+	// UnlockRotation release the rotation lock if it is still held by the owner token
+	UnlockRotation(ctx context.Context, owner string) error
+}
+
+// KeyRing set of signing keys identified by kid, tokens are signed with the active key and verified with any
+// key that is not retired yet
+type KeyRing interface {
+	JwtSigner
+	// Load load the keys from the store, a new configured key becomes the active key
+	Load(ctx context.Context) error
+	// Rotate replace the active key by a new generated key if it is older than maxAge, 0 to always rotate.
+	// The previous key is still accepted until the tokens it signed expire. Returns the active key ID
+	Rotate(ctx context.Context, maxAge time.Duration) (string, error)
+}
+
+type ringKey struct {
+	entities.SigningKey
+	signer JwtSigner
+}
+
+type keyRing struct {
+	lock         sync.RWMutex
+	config       configuration.SecurityConfig
+	store        KeyStore
+	unencrypted  KeyStore    // store not used without key encryption key, it must have no keys
+	encryption   cipher.AEAD // encrypts the keys of the store
+	configuredID string      // last configured key ID when there is no store
+	keys         map[string]*ringKey
+	active       *ringKey
+	oldest       *ringKey
+	overlap      time.Duration
+	reload       time.Duration
+	loadedAt     time.Time
+}
+
+// NewKeyRing return a new key ring, keys are kept only in memory when the store is nil. The keys are encrypted
+// with AES-256-GCM and the JWT_KEY_ENCRYPTION_KEY before they are stored. Without JWT_KEY_ENCRYPTION_KEY only a
+// shared secret is accepted, it is kept in memory and can't be rotated
+func NewKeyRing(config configuration.SecurityConfig, store KeyStore) KeyRing {
+	expiration := config.JWTRefreshExpiration
+	if config.JWTTokenExpiration > expiration {
+		expiration = config.JWTTokenExpiration
+	}
+	var unencrypted KeyStore
+	if config.JWTKeyEncryptionKey == "" {
+		store, unencrypted = nil, store
+	}
+	return &keyRing{
+		config:      config,
+		store:       store,
+		unencrypted: unencrypted,
+		encryption:  keyEncryption(config.JWTKeyEncryptionKey),
+		keys:        make(map[string]*ringKey),
+		overlap:     time.Hour * time.Duration(expiration),
+		reload:      time.Second * time.Duration(config.JWTKeyReloadSeconds),
+	}
+}
+
+// Load load the keys from the store, a new configured key becomes the active key
+func (r *keyRing) Load(ctx context.Context) error {
+	configured, err := configuredSigningKey(r.config)
+	if err != nil {
+		return err
+	}
+	if r.config.JWTKeyEncryptionKey != "" && r.encryption == nil {
+		return ErrInvalidKeyEncryptionKey
+	}
+	if r.unencrypted != nil {
+		if err = r.checkUnencrypted(ctx, configured); err != nil {
+			return err
+		}
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	keys, err := r.storedKeys(ctx)
+	if err != nil {
+		return err
+	}
+	if err = r.setKeys(keys); err != nil {
+		return err
+	}
+	lastConfigured := r.configuredID
+	if r.store != nil {
+		if lastConfigured, err = r.store.GetConfiguredKeyID(ctx); err != nil {
+			return err
+		}
+		// Keys stored in plaintext by previous versions are encrypted in place
+		if err = r.encryptPlaintextKeys(ctx); err != nil {
+			return err
+		}
+	}
+	if lastConfigured == configured.ID && r.active != nil {
+		return nil
+	}
+	// The configured key changed, it is rotated in keeping the previous keys until their tokens expire
+	if err = r.rotateTo(ctx, configured); err != nil {
+		return err
+	}
+	r.configuredID = configured.ID
+	if r.store != nil {
+		return r.store.SetConfiguredKeyID(ctx, configured.ID)
+	}
+	return nil
+}
+
+// Rotate replace the active key by a new generated key if it is older than maxAge, 0 to always rotate
+func (r *keyRing) Rotate(ctx context.Context, maxAge time.Duration) (string, error) {
+	// The rotated keys must be shared with the other instances
+	if r.unencrypted != nil {
+		return "", ErrMissingKeyEncryptionKey
+	}
+	if r.store != nil {
+		// The lock is released only by its owner, it may have expired and be held by another instance
+		owner, err := RandomToken(16)
+		if err != nil {
+			return "", err
+		}
+		locked, err := r.store.LockRotation(ctx, owner, rotationLockTTL)
+		if err != nil {
+			return "", err
+		}
+		if !locked {
+			return "", ErrKeyRotationInProgress
+		}
+		defer r.store.UnlockRotation(ctx, owner)
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	// Other instances may have rotated the keys already
+	keys, err := r.storedKeys(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err = r.setKeys(keys); err != nil {
+		return "", err
+	}
+	if r.active != nil && maxAge > 0 && time.Since(r.active.CreatedAt) < maxAge {
+		return r.active.ID, nil
+	}
+	key, err := generateSigningKey(r.config.JWTAlgorithm)
+	if err != nil {
+		return "", err
+	}
+	if err = r.rotateTo(ctx, key); err != nil {
+		return "", err
+	}
+	return key.ID, nil
+}
+
+// KeyID key ID of the active key
+func (r *keyRing) KeyID() string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if r.active == nil {
+		return ""
+	}
+	return r.active.ID
+}
+
+// Sign sign the claims with the active key
+func (r *keyRing) Sign(claims jwt.MapClaims) (string, error) {
+	r.reloadIfStale()
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if r.active == nil {
+		return "", errors.New("No active signing key, load the key ring first")
+	}
+	return r.active.signer.Sign(claims)
+}
+
+// Parse verify the token with the key of its kid header, tokens without kid are verified with the oldest key
+func (r *keyRing) Parse(token string) (jwt.MapClaims, error) {
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		return nil, err
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	key := r.verificationKey(kid)
+	if key == nil && kid != "" {
+		// The key may have been rotated by another instance
+		r.reloadIfStale()
+		key = r.verificationKey(kid)
+	}
+	if key == nil {
+		return nil, errors.New("Unknown signing key")
+	}
+	return key.signer.Parse(token)
+}
+
+// JWKS public keys of all the keys that are not retired
+func (r *keyRing) JWKS() *entities.JWKS {
+	r.reloadIfStale()
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	jwks := &entities.JWKS{Keys: []entities.JWK{}}
+	for _, key := range r.sortedKeys() {
+		if key.retired() {
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, key.signer.JWKS().Keys...)
+	}
+	return jwks
+}
+
+func (r *keyRing) verificationKey(kid string) *ringKey {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	key := r.oldest
+	if kid != "" {
+		key = r.keys[kid]
+	}
+	if key == nil || key.retired() {
+		return nil
+	}
+	return key
+}
+
+// reloadIfStale reload the keys from the store when they were loaded more than JWT_KEY_RELOAD_SECONDS ago
+func (r *keyRing) reloadIfStale() {
+	if r.store == nil || r.reload <= 0 {
+		return
+	}
+	r.lock.RLock()
+	stale := time.Since(r.loadedAt) > r.reload
+	r.lock.RUnlock()
+	if !stale {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if time.Since(r.loadedAt) <= r.reload {
+		return
+	}
+	keys, err := r.storedKeys(context.Background())
+	if err == nil {
+		err = r.setKeys(keys)
+	}
+	if err != nil {
+		// Keep the current keys, the store is checked again on the next reload
+		r.loadedAt = time.Now()
+	}
+}
+
+// rotateTo add a key as the active key, the previous active key retires when its tokens expire
+func (r *keyRing) rotateTo(ctx context.Context, key entities.SigningKey) error {
+	now := time.Now()
+	key.CreatedAt = now
+	key.RetireAt = time.Time{}
+	keys := []entities.SigningKey{key}
+	removed := []string{}
+	for _, k := range r.sortedKeys() {
+		if k.ID == key.ID {
+			continue
+		}
+		if k.retired() {
+			removed = append(removed, k.ID)
+			continue
+		}
+		if k.RetireAt.IsZero() {
+			k.RetireAt = now.Add(r.overlap)
+		}
+		keys = append(keys, k.SigningKey)
+	}
+	if r.store != nil {
+		if err := r.saveKeys(ctx, keys, removed); err != nil {
+			return err
+		}
+	}
+	return r.setKeys(keys)
+}
+
+// checkUnencrypted check the configured key can be used without key encryption key, asymmetric keys, scheduled
+// rotations and keys stored by previous rotations need it
+func (r *keyRing) checkUnencrypted(ctx context.Context, configured entities.SigningKey) error {
+	if configured.Algorithm != AlgHS256 || r.config.JWTKeyRotationHours > 0 {
+		return ErrMissingKeyEncryptionKey
+	}
+	stored, err := r.unencrypted.GetSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+	if len(stored) > 0 {
+		return ErrMissingKeyEncryptionKey
+	}
+	return nil
+}
+
+// saveKeys encrypt the keys and store them
+func (r *keyRing) saveKeys(ctx context.Context, keys []entities.SigningKey, removed []string) error {
+	if r.encryption == nil {
+		return ErrMissingKeyEncryptionKey
+	}
+	encrypted := make([]entities.SigningKey, 0, len(keys))
+	for _, k := range keys {
+		sealed, err := r.encryptKey(k.Key)
+		if err != nil {
+			return err
+		}
+		k.Key = sealed
+		encrypted = append(encrypted, k)
+	}
+	return r.store.SaveSigningKeys(ctx, encrypted, removed)
+}
+
+// encryptPlaintextKeys store again encrypted the keys of the store that are in plaintext
+func (r *keyRing) encryptPlaintextKeys(ctx context.Context) error {
+	stored, err := r.store.GetSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+	plaintext := []entities.SigningKey{}
+	for _, k := range stored {
+		if !strings.HasPrefix(k.Key, encryptedKeyPrefix) {
+			plaintext = append(plaintext, k)
+		}
+	}
+	if len(plaintext) == 0 {
+		return nil
+	}
+	return r.saveKeys(ctx, plaintext, nil)
+}
+
+// encryptKey encrypt a key with a random nonce, the nonce is prepended to the ciphertext
+func (r *keyRing) encryptKey(key string) (string, error) {
+	nonce := make([]byte, r.encryption.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := r.encryption.Seal(nonce, nonce, []byte(key), nil)
+	return encryptedKeyPrefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// decryptKey decrypt a stored key, keys stored in plaintext by previous versions are returned as they are
+func (r *keyRing) decryptKey(key string) (string, error) {
+	if !strings.HasPrefix(key, encryptedKeyPrefix) {
+		return key, nil
+	}
+	if r.encryption == nil {
+		return "", ErrMissingKeyEncryptionKey
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(key, encryptedKeyPrefix))
+	if err != nil || len(sealed) < r.encryption.NonceSize() {
+		return "", ErrKeyDecryption
+	}
+	size := r.encryption.NonceSize()
+	plain, err := r.encryption.Open(nil, sealed[:size], sealed[size:], nil)
+	if err != nil {
+		return "", ErrKeyDecryption
+	}
+	return string(plain), nil
+}
+
+// setKeys replace the keys of the ring and select the active and oldest keys
+func (r *keyRing) setKeys(keys []entities.SigningKey) error {
+	ring := make(map[string]*ringKey, len(keys))
+	var active, oldest *ringKey
+	for _, k := range keys {
+		signer, err := newKeySigner(k)
+		if err != nil {
+			return err
+		}
+		key := &ringKey{SigningKey: k, signer: signer}
+		ring[k.ID] = key
+		if k.RetireAt.IsZero() && (active == nil || k.CreatedAt.After(active.CreatedAt)) {
+			active = key
+		}
+		if oldest == nil || k.CreatedAt.Before(oldest.CreatedAt) {
+			oldest = key
+		}
+	}
+	r.keys = ring
+	r.active = active
+	r.oldest = oldest
+	r.loadedAt = time.Now()
+	return nil
+}
+
+func (r *keyRing) storedKeys(ctx context.Context) ([]entities.SigningKey, error) {
+	if r.store == nil {
+		keys := []entities.SigningKey{}
+		for _, k := range r.keys {
+			keys = append(keys, k.SigningKey)
+		}
+		return keys, nil
+	}
+	stored, err := r.store.GetSigningKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]entities.SigningKey, 0, len(stored))
+	for _, k := range stored {
+		if k.Key, err = r.decryptKey(k.Key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// sortedKeys keys of the ring, newest first
+func (r *keyRing) sortedKeys() []*ringKey {
+	keys := make([]*ringKey, 0, len(r.keys))
+	for _, k := range r.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+	return keys
+}
+
+func (k *ringKey) retired() bool {
+	return !k.RetireAt.IsZero() && time.Now().After(k.RetireAt)
+}
+
+// generateSigningKey create a random key for the algorithm, private keys are PKCS#8 PEM encoded
+func generateSigningKey(alg string) (entities.SigningKey, error) {
+	key := entities.SigningKey{Algorithm: alg}
+	var privateKey interface{}
+	var err error
+	switch alg {
+	case AlgHS256, "":
+		key.Algorithm = AlgHS256
+		secret := make([]byte, 32)
+		if _, err = rand.Read(secret); err != nil {
+			return key, err
+		}
+		key.Key = base64.RawURLEncoding.EncodeToString(secret)
+	case AlgRS256:
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgES256:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return key, errors.New("Unsupported JWT algorithm: " + alg)
+	}
+	if err != nil {
+		return key, err
+	}
+	if privateKey != nil {
+		der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		if err != nil {
+			return key, err
+		}
+		key.Key = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	}
+	signer, err := newKeySigner(key)
+	if err != nil {
+		return key, err
+	}
+	key.ID = signer.KeyID()
+	return key, nil
+}
+
+// keyEncryption AES-256-GCM cipher of the base64 encoded key encryption key, nil when it is not set or it is not
+// a 32 bytes key. The key is used as it is, it must be random, e.g. openssl rand -base64 32
+func keyEncryption(secret string) cipher.AEAD {
+	key, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil || len(key) != 32 {
+		return nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil
+	}
+	return aead
+}
@@ -0,0 +1,277 @@
+package utils
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// testKEK base64 encoded 32 bytes key encryption key
+const testKEK = "a2V5IGVuY3J5cHRpb24ga2V5IGZvciB0aGUgdGVzdHM="
+
+type memKeyStore struct {
+	lock       sync.Mutex
+	keys       map[string]entities.SigningKey
+	configured string
+	owner      string
+}
+
+func newMemKeyStore() *memKeyStore {
+	return &memKeyStore{keys: make(map[string]entities.SigningKey)}
+}
+
+func (s *memKeyStore) GetSigningKeys(ctx context.Context) ([]entities.SigningKey, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	keys := []entities.SigningKey{}
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func (s *memKeyStore) SaveSigningKeys(ctx context.Context, keys []entities.SigningKey, removed []string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, k := range keys {
+		s.keys[k.ID] = k
+	}
+	for _, kid := range removed {
+		delete(s.keys, kid)
+	}
+	return nil
+}
+
+func (s *memKeyStore) GetConfiguredKeyID(ctx context.Context) (string, error) {
+	return s.configured, nil
+}
+
+func (s *memKeyStore) SetConfiguredKeyID(ctx context.Context, kid string) error {
+	s.configured = kid
+	return nil
+}
+
+func (s *memKeyStore) LockRotation(ctx context.Context, owner string, ttl time.Duration) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.owner != "" {
+		return false, nil
+	}
+	s.owner = owner
+	return true, nil
+}
+
+func (s *memKeyStore) UnlockRotation(ctx context.Context, owner string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.owner == owner {
+		s.owner = ""
+	}
+	return nil
+}
+
+func testClaims() jwt.MapClaims {
+	return jwt.MapClaims{"user_id": "1", "exp": time.Now().Add(time.Hour).Unix()}
+}
+
+func TestKeyRingRotate(t *testing.T) {
+	ctx := context.Background()
+	ring := NewKeyRing(configuration.SecurityConfig{JWTSecret: "secret", JWTTokenExpiration: 1}, nil)
+	assert.Nil(t, ring.Load(ctx))
+	firstKey := ring.KeyID()
+	oldToken, err := ring.Sign(testClaims())
+	assert.Nil(t, err)
+
+	kid, err := ring.Rotate(ctx, 0)
+	assert.Nil(t, err)
+	assert.NotEqual(t, firstKey, kid)
+	assert.Equal(t, kid, ring.KeyID())
+	newToken, err := ring.Sign(testClaims())
+	assert.Nil(t, err)
+	parsed, _ := jwt.Parse(newToken, nil)
+	assert.Equal(t, kid, parsed.Header["kid"])
+
+	// Tokens signed with the previous key are valid until they expire
+	_, err = ring.Parse(oldToken)
+	assert.Nil(t, err)
+	_, err = ring.Parse(newToken)
+	assert.Nil(t, err)
+
+	// The active key is kept while it is younger than maxAge
+	same, err := ring.Rotate(ctx, time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, kid, same)
+}
+
+func TestKeyRingRetiredKey(t *testing.T) {
+	ctx := context.Background()
+	// Without token expiration the previous key retires as soon as it is rotated
+	ring := NewKeyRing(configuration.SecurityConfig{JWTSecret: "secret"}, nil)
+	assert.Nil(t, ring.Load(ctx))
+	oldToken, _ := ring.Sign(testClaims())
+	_, err := ring.Rotate(ctx, 0)
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond)
+	_, err = ring.Parse(oldToken)
+	assert.NotNil(t, err)
+}
+
+func TestKeyRingTokenWithoutKid(t *testing.T) {
+	ctx := context.Background()
+	ring := NewKeyRing(configuration.SecurityConfig{JWTSecret: "secret", JWTTokenExpiration: 1}, nil)
+	assert.Nil(t, ring.Load(ctx))
+	_, err := ring.Rotate(ctx, 0)
+	assert.Nil(t, err)
+	// Tokens issued before the key ring existed have no kid, they are verified with the oldest key
+	legacy, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims()).SignedString([]byte("secret"))
+	claims, err := ring.Parse(legacy)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", claims["user_id"])
+	forged, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims()).SignedString([]byte("other"))
+	_, err = ring.Parse(forged)
+	assert.NotNil(t, err)
+}
+
+func TestKeyRingSharedStore(t *testing.T) {
+	ctx := context.Background()
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	path := writeKeyFile(t, edKey)
+	defer os.Remove(path)
+	config := configuration.SecurityConfig{
+		JWTAlgorithm:         AlgEdDSA,
+		JWTPrivateKeyFile:    path,
+		JWTRefreshExpiration: 24,
+		JWTKeyEncryptionKey:  testKEK,
+	}
+	store := newMemKeyStore()
+	first := NewKeyRing(config, store)
+	second := NewKeyRing(config, store)
+	assert.Nil(t, first.Load(ctx))
+	assert.Nil(t, second.Load(ctx))
+	assert.Equal(t, first.KeyID(), second.KeyID())
+	oldToken, _ := first.Sign(testClaims())
+
+	kid, err := first.Rotate(ctx, 0)
+	assert.Nil(t, err)
+	newToken, _ := first.Sign(testClaims())
+	assert.Nil(t, second.Load(ctx))
+	assert.Equal(t, kid, second.KeyID())
+	_, err = second.Parse(oldToken)
+	assert.Nil(t, err)
+	_, err = second.Parse(newToken)
+	assert.Nil(t, err)
+
+	// Both keys are published until the previous one retires
+	jwks := second.JWKS()
+	assert.Len(t, jwks.Keys, 2)
+	assert.Equal(t, kid, jwks.Keys[0].Kid)
+
+	// Only one instance rotates at a time, and the lock is not released by the instance that couldn't take it
+	assert.Empty(t, store.owner)
+	store.owner = "other instance"
+	_, err = second.Rotate(ctx, 0)
+	assert.Equal(t, ErrKeyRotationInProgress, err)
+	assert.Equal(t, "other instance", store.owner)
+}
+
+func TestKeyRingConfiguredKeyChange(t *testing.T) {
+	ctx := context.Background()
+	store := newMemKeyStore()
+	ring := NewKeyRing(configuration.SecurityConfig{JWTSecret: "secret", JWTTokenExpiration: 1, JWTKeyEncryptionKey: testKEK}, store)
+	assert.Nil(t, ring.Load(ctx))
+	oldToken, _ := ring.Sign(testClaims())
+
+	// A new JWT_SECRET_KEY becomes the active key, the previous one is kept until its tokens expire
+	changed := NewKeyRing(configuration.SecurityConfig{JWTSecret: "changed", JWTTokenExpiration: 1, JWTKeyEncryptionKey: testKEK}, store)
+	assert.Nil(t, changed.Load(ctx))
+	assert.NotEqual(t, ring.KeyID(), changed.KeyID())
+	_, err := changed.Parse(oldToken)
+	assert.Nil(t, err)
+	assert.Len(t, store.keys, 2)
+
+	// Loading the same configuration again doesn't rotate
+	again := NewKeyRing(configuration.SecurityConfig{JWTSecret: "changed", JWTTokenExpiration: 1, JWTKeyEncryptionKey: testKEK}, store)
+	assert.Nil(t, again.Load(ctx))
+	assert.Equal(t, changed.KeyID(), again.KeyID())
+	assert.Len(t, store.keys, 2)
+}
+
+func TestKeyRingEncryptedStore(t *testing.T) {
+	ctx := context.Background()
+	store := newMemKeyStore()
+	config := configuration.SecurityConfig{JWTSecret: "secret", JWTTokenExpiration: 1, JWTKeyEncryptionKey: testKEK}
+	ring := NewKeyRing(config, store)
+	assert.Nil(t, ring.Load(ctx))
+	token, _ := ring.Sign(testClaims())
+	_, err := ring.Rotate(ctx, 0)
+	assert.Nil(t, err)
+	assert.Len(t, store.keys, 2)
+	for _, k := range store.keys {
+		assert.True(t, strings.HasPrefix(k.Key, encryptedKeyPrefix))
+		assert.NotContains(t, k.Key, "secret")
+	}
+
+	// The keys can't be loaded without the key encryption key or with another one
+	assert.Equal(t, ErrMissingKeyEncryptionKey, NewKeyRing(configuration.SecurityConfig{JWTSecret: "secret"}, store).Load(ctx))
+	config.JWTKeyEncryptionKey = "c2VjcmV0IGtleSBlbmNyeXB0aW9uIGtleSBvdGhlciE="
+	assert.Equal(t, ErrKeyDecryption, NewKeyRing(config, store).Load(ctx))
+	// Passphrases are not accepted, the key is used as it is
+	config.JWTKeyEncryptionKey = "key encryption key"
+	assert.Equal(t, ErrInvalidKeyEncryptionKey, NewKeyRing(config, store).Load(ctx))
+	config.JWTKeyEncryptionKey = testKEK
+	again := NewKeyRing(config, store)
+	assert.Nil(t, again.Load(ctx))
+	_, err = again.Parse(token)
+	assert.Nil(t, err)
+}
+
+func TestKeyRingPlaintextStore(t *testing.T) {
+	ctx := context.Background()
+	// Keys stored in plaintext by previous versions are still loaded and encrypted in place
+	store := newMemKeyStore()
+	legacy := NewKeyRing(configuration.SecurityConfig{JWTSecret: "secret", JWTTokenExpiration: 1}, nil)
+	assert.Nil(t, legacy.Load(ctx))
+	token, _ := legacy.Sign(testClaims())
+	store.keys[legacy.KeyID()] = entities.SigningKey{ID: legacy.KeyID(), Algorithm: AlgHS256, Key: "secret", CreatedAt: time.Now()}
+	store.configured = legacy.KeyID()
+
+	ring := NewKeyRing(configuration.SecurityConfig{JWTSecret: "secret", JWTTokenExpiration: 1, JWTKeyEncryptionKey: testKEK}, store)
+	assert.Nil(t, ring.Load(ctx))
+	assert.Equal(t, legacy.KeyID(), ring.KeyID())
+	_, err := ring.Parse(token)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(store.keys[legacy.KeyID()].Key, encryptedKeyPrefix))
+}
+
+func TestKeyRingWithoutEncryptionKey(t *testing.T) {
+	ctx := context.Background()
+	// A shared secret is kept in memory without key encryption key, it can't be rotated
+	store := newMemKeyStore()
+	ring := NewKeyRing(configuration.SecurityConfig{JWTSecret: "secret", JWTTokenExpiration: 1}, store)
+	assert.Nil(t, ring.Load(ctx))
+	token, _ := ring.Sign(testClaims())
+	_, err := ring.Parse(token)
+	assert.Nil(t, err)
+	assert.Empty(t, store.keys)
+	_, err = ring.Rotate(ctx, 0)
+	assert.Equal(t, ErrMissingKeyEncryptionKey, err)
+
+	// Scheduled rotations and asymmetric keys need it
+	config := configuration.SecurityConfig{JWTSecret: "secret", JWTKeyRotationHours: 720}
+	assert.Equal(t, ErrMissingKeyEncryptionKey, NewKeyRing(config, store).Load(ctx))
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	path := writeKeyFile(t, edKey)
+	defer os.Remove(path)
+	config = configuration.SecurityConfig{JWTAlgorithm: AlgEdDSA, JWTPrivateKeyFile: path}
+	assert.Equal(t, ErrMissingKeyEncryptionKey, NewKeyRing(config, store).Load(ctx))
+}
@@ -0,0 +1,289 @@
+package utils
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+)
+
+// AttemptStore persistence of the failed login attempts shared by all the instances
+type AttemptStore interface {
+	// AddFailure record a failure of a key and return the number of failures within the window
+	AddFailure(ctx context.Context, key string, at time.Time, window time.Duration) (int, error)
+	// IncrementLockouts increment the consecutive lockouts of a key, they are forgotten after ttl
+	IncrementLockouts(ctx context.Context, key string, ttl time.Duration) (int, error)
+	// SetLock lock a key out for a duration
+	SetLock(ctx context.Context, key string, duration time.Duration) error
+	// GetLock get the remaining lockout of a key, 0 when it is not locked
+	GetLock(ctx context.Context, key string) (time.Duration, error)
+	// ClearFailures delete the failures of a key
+	ClearFailures(ctx context.Context, key string) error
+	// Clear delete the failures, lockouts and lock of a key
+	Clear(ctx context.Context, key string) error
+}
+
+// Lockout account or IP locked out by a failed login
+type Lockout struct {
+	Key      string // account:<email> or ip:<address>
+	Duration time.Duration
+}
+
+// LoginThrottle brute-force protection of the logins, failures are counted per account and per IP within a
+// sliding window and reaching the threshold locks the account or IP out, each consecutive lockout doubles
+type LoginThrottle interface {
+	// Check get the remaining lockout of an account or IP, 0 when the login is allowed
+	Check(ctx context.Context, account string, ip string) (time.Duration, error)
+	// Failed record a failed login and return the lockouts it started
+	Failed(ctx context.Context, account string, ip string) ([]Lockout, error)
+	// Succeeded clear the failures and lockouts of an account after a successful login
+	Succeeded(ctx context.Context, account string) error
+	// UnlockAccount clear the lockout and failures of an account
+	UnlockAccount(ctx context.Context, account string) error
+	// UnlockIP clear the lockout and failures of an IP
+	UnlockIP(ctx context.Context, ip string) error
+}
+
+type throttleKey struct {
+	key         string
+	maxFailures int
+}
+
+type loginThrottle struct {
+	store              AttemptStore
+	window             time.Duration
+	maxAccountFailures int
+	maxIPFailures      int
+	lockout            time.Duration
+	maxLockout         time.Duration
+}
+
+// NewLoginThrottle return a new login throttle, the attempts are kept only in memory when the store is nil
+func NewLoginThrottle(config configuration.LoginThrottleConfig, store AttemptStore) LoginThrottle {
+	if store == nil {
+		store = newMemAttemptStore()
+	}
+	return &loginThrottle{
+		store:              store,
+		window:             time.Minute * time.Duration(config.WindowMinutes),
+		maxAccountFailures: config.MaxAccountFailures,
+		maxIPFailures:      config.MaxIPFailures,
+		lockout:            time.Minute * time.Duration(config.LockoutMinutes),
+		maxLockout:         time.Minute * time.Duration(config.MaxLockoutMinutes),
+	}
+}
+
+// Check get the remaining lockout of an account or IP, 0 when the login is allowed
+func (t *loginThrottle) Check(ctx context.Context, account string, ip string) (time.Duration, error) {
+	var remaining time.Duration
+	for _, k := range t.keys(account, ip) {
+		d, err := t.store.GetLock(ctx, k.key)
+		if err != nil {
+			return 0, err
+		}
+		if d > remaining {
+			remaining = d
+		}
+	}
+	return remaining, nil
+}
+
+// Failed record a failed login and return the lockouts it started
+func (t *loginThrottle) Failed(ctx context.Context, account string, ip string) ([]Lockout, error) {
+	now := time.Now()
+	lockouts := []Lockout{}
+	for _, k := range t.keys(account, ip) {
+		failures, err := t.store.AddFailure(ctx, k.key, now, t.window)
+		if err != nil {
+			return nil, err
+		}
+		if failures < k.maxFailures {
+			continue
+		}
+		consecutive, err := t.store.IncrementLockouts(ctx, k.key, t.window+t.maxLockout)
+		if err != nil {
+			return nil, err
+		}
+		d := t.lockoutDuration(consecutive)
+		if err = t.store.SetLock(ctx, k.key, d); err != nil {
+			return nil, err
+		}
+		if err = t.store.ClearFailures(ctx, k.key); err != nil {
+			return nil, err
+		}
+		lockouts = append(lockouts, Lockout{Key: k.key, Duration: d})
+	}
+	return lockouts, nil
+}
+
+// Succeeded clear the failures and lockouts of an account after a successful login
+func (t *loginThrottle) Succeeded(ctx context.Context, account string) error {
+	return t.store.Clear(ctx, accountThrottleKey(account))
+}
+
+// UnlockAccount clear the lockout and failures of an account
+func (t *loginThrottle) UnlockAccount(ctx context.Context, account string) error {
+	return t.store.Clear(ctx, accountThrottleKey(account))
+}
+
+// UnlockIP clear the lockout and failures of an IP
+func (t *loginThrottle) UnlockIP(ctx context.Context, ip string) error {
+	return t.store.Clear(ctx, "ip:"+ip)
+}
+
+// keys get the keys with a failures limit, the IP is skipped when it is unknown
+func (t *loginThrottle) keys(account string, ip string) []throttleKey {
+	keys := []throttleKey{}
+	if t.maxAccountFailures > 0 && account != "" {
+		keys = append(keys, throttleKey{key: accountThrottleKey(account), maxFailures: t.maxAccountFailures})
+	}
+	if t.maxIPFailures > 0 && ip != "" {
+		keys = append(keys, throttleKey{key: "ip:" + ip, maxFailures: t.maxIPFailures})
+	}
+	return keys
+}
+
+// lockoutDuration the base lockout doubled for each previous consecutive lockout, up to the max lockout
+func (t *loginThrottle) lockoutDuration(consecutive int) time.Duration {
+	d := t.lockout
+	for i := 1; i < consecutive && d < t.maxLockout; i++ {
+		d *= 2
+	}
+	if t.maxLockout > 0 && d > t.maxLockout {
+		d = t.maxLockout
+	}
+	return d
+}
+
+func accountThrottleKey(account string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(account))
+}
+
+// maxMemAttemptKeys keys kept by the in memory store, one per account and IP with recent failures
+const maxMemAttemptKeys = 10000
+
+type memAttempts struct {
+	failures      []time.Time
+	lockouts      int
+	lockoutsUntil time.Time
+	lockedUntil   time.Time
+	expires       time.Time // nothing of the key is in use after it
+}
+
+// memAttemptStore attempts of a single instance, bounded to maxMemAttemptKeys keys
+type memAttemptStore struct {
+	lock     sync.Mutex
+	attempts map[string]*memAttempts
+	maxKeys  int
+}
+
+func newMemAttemptStore() *memAttemptStore {
+	return &memAttemptStore{attempts: make(map[string]*memAttempts), maxKeys: maxMemAttemptKeys}
+}
+
+func (s *memAttemptStore) get(key string) *memAttempts {
+	a, ok := s.attempts[key]
+	if !ok {
+		if len(s.attempts) >= s.maxKeys {
+			s.evict()
+		}
+		a = &memAttempts{}
+		s.attempts[key] = a
+	}
+	return a
+}
+
+// evict remove the expired keys, or the key that expires first when none has expired so the store doesn't grow
+// with the IPs or accounts of a distributed attack
+func (s *memAttemptStore) evict() {
+	now := time.Now()
+	var first string
+	for key, a := range s.attempts {
+		if now.After(a.expires) {
+			delete(s.attempts, key)
+			continue
+		}
+		if first == "" || a.expires.Before(s.attempts[first].expires) {
+			first = key
+		}
+	}
+	if len(s.attempts) >= s.maxKeys && first != "" {
+		delete(s.attempts, first)
+	}
+}
+
+// extend keep the key until the given time at least
+func (a *memAttempts) extend(until time.Time) {
+	if until.After(a.expires) {
+		a.expires = until
+	}
+}
+
+func (s *memAttemptStore) AddFailure(ctx context.Context, key string, at time.Time, window time.Duration) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	a := s.get(key)
+	failures := []time.Time{at}
+	for _, f := range a.failures {
+		if at.Sub(f) < window {
+			failures = append(failures, f)
+		}
+	}
+	a.failures = failures
+	a.extend(at.Add(window))
+	return len(failures), nil
+}
+
+func (s *memAttemptStore) IncrementLockouts(ctx context.Context, key string, ttl time.Duration) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	a := s.get(key)
+	now := time.Now()
+	if now.After(a.lockoutsUntil) {
+		a.lockouts = 0
+	}
+	a.lockouts++
+	a.lockoutsUntil = now.Add(ttl)
+	a.extend(a.lockoutsUntil)
+	return a.lockouts, nil
+}
+
+func (s *memAttemptStore) SetLock(ctx context.Context, key string, duration time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	a := s.get(key)
+	a.lockedUntil = time.Now().Add(duration)
+	a.extend(a.lockedUntil)
+	return nil
+}
+
+func (s *memAttemptStore) GetLock(ctx context.Context, key string) (time.Duration, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	a, ok := s.attempts[key]
+	if !ok {
+		return 0, nil
+	}
+	if remaining := time.Until(a.lockedUntil); remaining > 0 {
+		return remaining, nil
+	}
+	return 0, nil
+}
+
+func (s *memAttemptStore) ClearFailures(ctx context.Context, key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if a, ok := s.attempts[key]; ok {
+		a.failures = nil
+	}
+	return nil
+}
+
+func (s *memAttemptStore) Clear(ctx context.Context, key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestThrottle() LoginThrottle {
+	return NewLoginThrottle(configuration.LoginThrottleConfig{
+		WindowMinutes:      15,
+		MaxAccountFailures: 3,
+		MaxIPFailures:      5,
+		LockoutMinutes:     5,
+		MaxLockoutMinutes:  15,
+	}, nil)
+}
+
+func TestLoginThrottleAccountLockout(t *testing.T) {
+	ctx := context.TODO()
+	throttle := newTestThrottle()
+	for i := 0; i < 2; i++ {
+		lockouts, err := throttle.Failed(ctx, "User@Mail.com", "10.0.0.1")
+		assert.Nil(t, err)
+		assert.Empty(t, lockouts)
+	}
+	lockouts, err := throttle.Failed(ctx, "user@mail.com", "10.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, []Lockout{{Key: "account:user@mail.com", Duration: 5 * time.Minute}}, lockouts)
+	remaining, err := throttle.Check(ctx, "USER@mail.com", "10.0.0.2")
+	assert.Nil(t, err)
+	assert.True(t, remaining > 4*time.Minute)
+	// Other accounts from the same IP are still allowed
+	remaining, _ = throttle.Check(ctx, "other@mail.com", "10.0.0.1")
+	assert.Equal(t, time.Duration(0), remaining)
+
+	assert.Nil(t, throttle.UnlockAccount(ctx, "user@mail.com"))
+	remaining, _ = throttle.Check(ctx, "user@mail.com", "10.0.0.2")
+	assert.Equal(t, time.Duration(0), remaining)
+}
+
+func TestLoginThrottleBackOff(t *testing.T) {
+	ctx := context.TODO()
+	throttle := newTestThrottle()
+	expected := []time.Duration{5 * time.Minute, 10 * time.Minute, 15 * time.Minute}
+	for _, duration := range expected {
+		var lockouts []Lockout
+		for i := 0; i < 3; i++ {
+			lockouts, _ = throttle.Failed(ctx, "user@mail.com", "")
+		}
+		assert.Equal(t, []Lockout{{Key: "account:user@mail.com", Duration: duration}}, lockouts)
+	}
+	// A successful login resets the back-off
+	assert.Nil(t, throttle.Succeeded(ctx, "user@mail.com"))
+	var lockouts []Lockout
+	for i := 0; i < 3; i++ {
+		lockouts, _ = throttle.Failed(ctx, "user@mail.com", "")
+	}
+	assert.Equal(t, 5*time.Minute, lockouts[0].Duration)
+}
+
+func TestLoginThrottleIPLockout(t *testing.T) {
+	ctx := context.TODO()
+	throttle := newTestThrottle()
+	var lockouts []Lockout
+	for i := 0; i < 5; i++ {
+		lockouts, _ = throttle.Failed(ctx, "user"+string(rune('a'+i))+"@mail.com", "10.0.0.1")
+	}
+	assert.Equal(t, []Lockout{{Key: "ip:10.0.0.1", Duration: 5 * time.Minute}}, lockouts)
+	remaining, _ := throttle.Check(ctx, "new@mail.com", "10.0.0.1")
+	assert.True(t, remaining > 0)
+
+	assert.Nil(t, throttle.UnlockIP(ctx, "10.0.0.1"))
+	remaining, _ = throttle.Check(ctx, "new@mail.com", "10.0.0.1")
+	assert.Equal(t, time.Duration(0), remaining)
+}
+
+func TestMemAttemptStoreBounded(t *testing.T) {
+	ctx := context.TODO()
+	store := newMemAttemptStore()
+	store.maxKeys = 3
+	now := time.Now()
+	_, _ = store.AddFailure(ctx, "ip:10.0.0.1", now.Add(-time.Hour), time.Minute)
+	_, _ = store.AddFailure(ctx, "ip:10.0.0.2", now, 2*time.Minute)
+	_, _ = store.AddFailure(ctx, "ip:10.0.0.3", now, time.Minute)
+	assert.Nil(t, store.SetLock(ctx, "ip:10.0.0.3", time.Hour))
+	// The expired key is removed first
+	_, _ = store.AddFailure(ctx, "ip:10.0.0.4", now, time.Minute)
+	assert.Len(t, store.attempts, 3)
+	assert.NotContains(t, store.attempts, "ip:10.0.0.1")
+	// Then the key that expires first, the locked out keys are kept
+	_, _ = store.AddFailure(ctx, "ip:10.0.0.5", now, time.Minute)
+	assert.Len(t, store.attempts, 3)
+	assert.NotContains(t, store.attempts, "ip:10.0.0.4")
+	remaining, _ := store.GetLock(ctx, "ip:10.0.0.3")
+	assert.True(t, remaining > 0)
+}
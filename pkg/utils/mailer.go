@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+)
+
+const (
+	// MailerSMTP send the mails through an SMTP server
+	MailerSMTP = "smtp"
+	// MailerLog write the mails to a file or the log, for local development
+	MailerLog = "log"
+)
+
+// Mail plain text mail
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer interface to deliver the mails sent to the users
+type Mailer interface {
+	// Send deliver a mail
+	Send(ctx context.Context, mail *Mail) error
+}
+
+// NewMailer return a new mailer of the configured kind
+func NewMailer(config configuration.MailConfig, logger configuration.LoggerWrapper) (Mailer, error) {
+	switch config.Mailer {
+	case MailerSMTP:
+		return NewSMTPMailer(config)
+	case MailerLog:
+		return NewLogMailer(config, logger), nil
+	}
+	return nil, errors.New("Unsupported mailer: " + config.Mailer)
+}
+
+type smtpMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer return a mailer that sends the mails through the configured SMTP server
+func NewSMTPMailer(config configuration.MailConfig) (Mailer, error) {
+	host, _, err := net.SplitHostPort(config.SMTPAddr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid SMTP address: %v", err)
+	}
+	m := &smtpMailer{
+		addr: config.SMTPAddr,
+		from: config.From,
+	}
+	if config.SMTPUser != "" {
+		m.auth = smtp.PlainAuth("", config.SMTPUser, config.SMTPPass, host)
+	}
+	return m, nil
+}
+
+// Send deliver a mail through the SMTP server
+func (m *smtpMailer) Send(ctx context.Context, mail *Mail) error {
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{mail.To}, formatMail(m.from, mail, time.Now()))
+}
+
+type logMailer struct {
+	lock   sync.Mutex
+	from   string
+	file   string
+	logger configuration.LoggerWrapper
+}
+
+// NewLogMailer return a mailer that appends the mails to the configured file or logs them when there is no file
+func NewLogMailer(config configuration.MailConfig, logger configuration.LoggerWrapper) Mailer {
+	return &logMailer{
+		from:   config.From,
+		file:   config.File,
+		logger: logger,
+	}
+}
+
+// Send write a mail to the file or the log
+func (m *logMailer) Send(ctx context.Context, mail *Mail) error {
+	if m.file == "" {
+		m.logger.Info("mail sent", "to", mail.To, "subject", mail.Subject, "body", mail.Body)
+		return nil
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	f, err := os.OpenFile(m.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(formatMail(m.from, mail, time.Now()), "\r\n"...))
+	return err
+}
+
+// formatMail build the RFC 5322 message of a plain text mail
+func formatMail(from string, mail *Mail, date time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", headerValue(from))
+	fmt.Fprintf(&b, "To: %s\r\n", headerValue(mail.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", headerValue(mail.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(mail.Body, "\r\n", "\n"), "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// headerValue remove line breaks from a header value so it can't inject other headers
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
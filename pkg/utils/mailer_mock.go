@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"context"
+	"sync"
+)
+
+// MailerMock mailer that keeps the sent mails
+type MailerMock struct {
+	lock  sync.Mutex
+	mails []Mail
+}
+
+// NewMailerMock return a new mailer mock instance
+func NewMailerMock() *MailerMock {
+	return &MailerMock{}
+}
+
+// Send keep a copy of the mail
+func (m *MailerMock) Send(ctx context.Context, mail *Mail) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.mails = append(m.mails, *mail)
+	return nil
+}
+
+// Sent get the mails sent so far
+func (m *MailerMock) Sent() []Mail {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]Mail{}, m.mails...)
+}
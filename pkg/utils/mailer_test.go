@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatMail(t *testing.T) {
+	date := time.Date(2020, 7, 1, 10, 0, 0, 0, time.UTC)
+	message := string(formatMail("goaccess@localhost", &Mail{
+		To:      "user@mail.com",
+		Subject: "Hello\r\nBcc: other@mail.com",
+		Body:    "line 1\nline 2",
+	}, date))
+	assert.Contains(t, message, "Subject: HelloBcc: other@mail.com\r\n")
+	assert.NotContains(t, message, "\r\nBcc:")
+	assert.True(t, strings.HasSuffix(message, "\r\n\r\nline 1\r\nline 2\r\n"))
+}
+
+func TestLogMailerFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mailer")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "mails.txt")
+	mailer, err := NewMailer(configuration.MailConfig{Mailer: MailerLog, From: "goaccess@localhost", File: file}, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, mailer.Send(context.TODO(), &Mail{To: "a@mail.com", Subject: "First", Body: "1"}))
+	assert.Nil(t, mailer.Send(context.TODO(), &Mail{To: "b@mail.com", Subject: "Second", Body: "2"}))
+	content, err := ioutil.ReadFile(file)
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), "To: a@mail.com")
+	assert.Contains(t, string(content), "To: b@mail.com")
+}
+
+func TestNewMailerUnsupported(t *testing.T) {
+	_, err := NewMailer(configuration.MailConfig{Mailer: "pigeon"}, nil)
+	assert.NotNil(t, err)
+	_, err = NewMailer(configuration.MailConfig{Mailer: MailerSMTP, SMTPAddr: "no-port"}, nil)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,378 @@
+package utils
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// issuerKeysMinRefresh min time between the key fetches triggered by tokens signed with an unknown key
+	issuerKeysMinRefresh = time.Minute
+	// issuerClockSkew accepted difference between the issuer clock and ours when checking the expiration
+	issuerClockSkew = time.Minute
+	// issuerMaxResponse max size of the issuer responses
+	issuerMaxResponse = 1 << 20
+)
+
+// OIDCIssuer client of an external OpenID Connect identity provider, the endpoints and signing keys are taken
+// from its discovery document
+type OIDCIssuer interface {
+	// AuthorizationURL URL of the issuer authorization endpoint for a login with the given state, nonce and S256
+	// PKCE challenge
+	AuthorizationURL(ctx context.Context, state string, nonce string, codeChallenge string) (string, error)
+	// Exchange redeem an authorization code at the issuer token endpoint and return the claims of its verified
+	// ID token
+	Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (jwt.MapClaims, error)
+	// VerifyIDToken verify an ID token signature with the issuer keys and check its issuer, audience, expiration
+	// and nonce
+	VerifyIDToken(ctx context.Context, token string, nonce string) (jwt.MapClaims, error)
+}
+
+// issuerMetadata endpoints of the issuer discovery document
+type issuerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// issuerTokenResponse response of the issuer token endpoint
+type issuerTokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// issuerKey public key of the issuer JWKS with the algorithm it is restricted to, empty for any
+type issuerKey struct {
+	alg string
+	key crypto.PublicKey
+}
+
+type oidcIssuer struct {
+	lock      sync.Mutex
+	config    configuration.FederationConfig
+	client    *http.Client
+	metadata  *issuerMetadata
+	keys      map[string]issuerKey
+	keysAt    time.Time
+	keysCache time.Duration
+}
+
+// NewOIDCIssuer return a new client of the configured identity provider, the discovery document is fetched on
+// first use
+func NewOIDCIssuer(config configuration.FederationConfig) OIDCIssuer {
+	return &oidcIssuer{
+		config:    config,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		keysCache: time.Minute * time.Duration(config.KeysCacheMinutes),
+	}
+}
+
+// AuthorizationURL URL of the issuer authorization endpoint for a login with the given state, nonce and S256
+// PKCE challenge
+func (i *oidcIssuer) AuthorizationURL(ctx context.Context, state string, nonce string, codeChallenge string) (string, error) {
+	metadata, err := i.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	authorizeURL, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	params := authorizeURL.Query()
+	params.Set("response_type", "code")
+	params.Set("client_id", i.config.ClientID)
+	params.Set("redirect_uri", i.config.RedirectURL)
+	params.Set("scope", i.config.Scopes)
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+	authorizeURL.RawQuery = params.Encode()
+	return authorizeURL.String(), nil
+}
+
+// Exchange redeem an authorization code at the issuer token endpoint and return the claims of its verified ID
+// token. The client authenticates with its secret when it has one
+func (i *oidcIssuer) Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (jwt.MapClaims, error) {
+	metadata, err := i.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {i.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	if i.config.ClientSecret == "" {
+		form.Set("client_id", i.config.ClientID)
+	}
+	req, err := http.NewRequest(http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if i.config.ClientSecret != "" {
+		// The credentials are form encoded before the basic authentication (RFC 6749 section 2.3.1)
+		req.SetBasicAuth(url.QueryEscape(i.config.ClientID), url.QueryEscape(i.config.ClientSecret))
+	}
+	resp, err := i.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var token issuerTokenResponse
+	if err = json.NewDecoder(io.LimitReader(resp.Body, issuerMaxResponse)).Decode(&token); err != nil {
+		return nil, fmt.Errorf("Invalid token response of the issuer: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if token.ErrorDescription != "" {
+			return nil, fmt.Errorf("Token request rejected by the issuer: %s: %s", token.Error, token.ErrorDescription)
+		}
+		return nil, fmt.Errorf("Token request rejected by the issuer: %s", token.Error)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("The issuer returned no ID token")
+	}
+	return i.VerifyIDToken(ctx, token.IDToken, nonce)
+}
+
+// VerifyIDToken verify an ID token signature with the issuer keys and check its issuer, audience, expiration and
+// nonce. Only asymmetric algorithms are accepted
+func (i *oidcIssuer) VerifyIDToken(ctx context.Context, token string, nonce string) (jwt.MapClaims, error) {
+	metadata, err := i.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The time claims are checked below with some clock skew
+	parser := &jwt.Parser{
+		ValidMethods:         []string{AlgRS256, AlgES256, AlgEdDSA},
+		SkipClaimsValidation: true,
+	}
+	parsed, err := parser.Parse(token, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := i.signingKey(ctx, metadata, kid)
+		if err != nil {
+			return nil, err
+		}
+		if key.alg != "" && key.alg != t.Method.Alg() {
+			return nil, errors.New("Wrong signed method")
+		}
+		return key.key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok || !parsed.Valid {
+		return nil, errors.New("Invalid token claims")
+	}
+	if iss, _ := claims["iss"].(string); iss != metadata.Issuer {
+		return nil, errors.New("ID token issued by another issuer")
+	}
+	if !claimsAudience(claims, i.config.ClientID) {
+		return nil, errors.New("ID token issued to another client")
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != i.config.ClientID {
+		return nil, errors.New("ID token authorized to another client")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("ID token without expiration")
+	}
+	if time.Now().Add(-issuerClockSkew).After(time.Unix(int64(exp), 0)) {
+		return nil, errors.New("ID token expired")
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return nil, errors.New("ID token nonce doesn't match")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("ID token without subject")
+	}
+	return claims, nil
+}
+
+// discover get the issuer metadata, the discovery document is fetched once and must be of the configured issuer
+func (i *oidcIssuer) discover(ctx context.Context) (*issuerMetadata, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if i.metadata != nil {
+		return i.metadata, nil
+	}
+	var metadata issuerMetadata
+	discoveryURL := strings.TrimSuffix(i.config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := i.getJSON(ctx, discoveryURL, &metadata); err != nil {
+		return nil, err
+	}
+	if metadata.Issuer != i.config.Issuer {
+		return nil, errors.New("Discovery document of another issuer: " + metadata.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, errors.New("Incomplete discovery document of the issuer")
+	}
+	i.metadata = &metadata
+	return i.metadata, nil
+}
+
+// signingKey get the issuer key of a kid header, tokens without kid need an issuer with a single key. The keys
+// are fetched again when they are stale or, at most once a minute, when the kid is unknown
+func (i *oidcIssuer) signingKey(ctx context.Context, metadata *issuerMetadata, kid string) (issuerKey, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	key, found := i.findKey(kid)
+	stale := time.Since(i.keysAt) > i.keysCache
+	if stale || (!found && time.Since(i.keysAt) > issuerKeysMinRefresh) {
+		var jwks entities.JWKS
+		if err := i.getJSON(ctx, metadata.JWKSURI, &jwks); err != nil {
+			if found {
+				// Keep verifying with the cached keys while the issuer is unreachable
+				return key, nil
+			}
+			return key, err
+		}
+		i.keys = issuerKeys(jwks)
+		i.keysAt = time.Now()
+		key, found = i.findKey(kid)
+	}
+	if !found {
+		return key, errors.New("Unknown signing key")
+	}
+	return key, nil
+}
+
+func (i *oidcIssuer) findKey(kid string) (issuerKey, bool) {
+	if kid == "" {
+		if len(i.keys) != 1 {
+			return issuerKey{}, false
+		}
+		for _, key := range i.keys {
+			return key, true
+		}
+	}
+	key, found := i.keys[kid]
+	return key, found
+}
+
+// getJSON get and decode a JSON document of the issuer
+func (i *oidcIssuer) getJSON(ctx context.Context, documentURL string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, documentURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := i.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unable to get %s: %s", documentURL, resp.Status)
+	}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, issuerMaxResponse)).Decode(v); err != nil {
+		return fmt.Errorf("Invalid document %s: %v", documentURL, err)
+	}
+	return nil
+}
+
+// issuerKeys get the signature keys of a JWKS by key ID, the keys of other uses or unsupported types are skipped
+func issuerKeys(jwks entities.JWKS) map[string]issuerKey {
+	keys := make(map[string]issuerKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwkPublicKey(jwk)
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = issuerKey{alg: jwk.Alg, key: key}
+	}
+	return keys
+}
+
+// jwkPublicKey get the public key of an RSA, EC P-256 or Ed25519 JWK
+func jwkPublicKey(jwk entities.JWK) (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("Invalid RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, errors.New("Unsupported curve: " + jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("Invalid EC key")
+		}
+		return key, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, errors.New("Unsupported curve: " + jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("Invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, errors.New("Unsupported key type: " + jwk.Kty)
+}
+
+// claimsAudience check if the aud claim, a string or an array, contains the client ID
+func claimsAudience(claims jwt.MapClaims, clientID string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestIssuer(provider *OIDCProviderMock) OIDCIssuer {
+	return NewOIDCIssuer(configuration.FederationConfig{
+		Issuer:           provider.URL(),
+		ClientID:         "goaccess",
+		ClientSecret:     "s3cret&",
+		RedirectURL:      "https://auth.example.com/federation/callback",
+		Scopes:           "openid email",
+		KeysCacheMinutes: 60,
+	})
+}
+
+func TestOIDCIssuerExchange(t *testing.T) {
+	provider := NewOIDCProviderMock("goaccess", "s3cret&")
+	defer provider.Close()
+	provider.SetClaims(jwt.MapClaims{"sub": "u1", "email": "srojas@gmail.com", "email_verified": true})
+	issuer := newTestIssuer(provider)
+
+	authorizeURL, err := issuer.AuthorizationURL(context.TODO(), "st", "n0nce", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(authorizeURL, provider.URL()+"/authorize?"))
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authorizeURL)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	location, _ := url.Parse(resp.Header.Get("Location"))
+	assert.Equal(t, "st", location.Query().Get("state"))
+	code := location.Query().Get("code")
+
+	claims, err := issuer.Exchange(context.TODO(), code, "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk", "n0nce")
+	assert.Nil(t, err)
+	assert.Equal(t, "u1", claims["sub"])
+	assert.Equal(t, "srojas@gmail.com", claims["email"])
+
+	// Codes are single use
+	_, err = issuer.Exchange(context.TODO(), code, "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk", "n0nce")
+	assert.EqualError(t, err, "Token request rejected by the issuer: invalid_grant")
+}
+
+func TestOIDCIssuerVerifyIDToken(t *testing.T) {
+	provider := NewOIDCProviderMock("goaccess", "s3cret&")
+	defer provider.Close()
+	issuer := newTestIssuer(provider)
+
+	claims, err := issuer.VerifyIDToken(context.TODO(), provider.IDToken(jwt.MapClaims{"sub": "u1", "nonce": "n"}), "n")
+	assert.Nil(t, err)
+	assert.Equal(t, "u1", claims["sub"])
+	claims, err = issuer.VerifyIDToken(context.TODO(), provider.IDToken(jwt.MapClaims{
+		"sub": "u1", "nonce": "n", "aud": []string{"goaccess", "other"}, "azp": "goaccess",
+	}), "n")
+	assert.Nil(t, err)
+
+	other := NewOIDCProviderMock("goaccess", "s3cret&")
+	defer other.Close()
+	hs256, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss": provider.URL(), "aud": "goaccess", "sub": "u1", "nonce": "n", "exp": time.Now().Add(time.Minute).Unix(),
+	}).SignedString([]byte("s3cret&"))
+	cases := map[string]string{
+		"wrong nonce":      provider.IDToken(jwt.MapClaims{"sub": "u1", "nonce": "other"}),
+		"other audience":   provider.IDToken(jwt.MapClaims{"sub": "u1", "nonce": "n", "aud": "other"}),
+		"other party":      provider.IDToken(jwt.MapClaims{"sub": "u1", "nonce": "n", "aud": []string{"goaccess", "other"}, "azp": "other"}),
+		"other issuer":     provider.IDToken(jwt.MapClaims{"sub": "u1", "nonce": "n", "iss": "https://evil.example.com"}),
+		"expired":          provider.IDToken(jwt.MapClaims{"sub": "u1", "nonce": "n", "exp": time.Now().Add(-2 * time.Minute).Unix()}),
+		"no subject":       provider.IDToken(jwt.MapClaims{"nonce": "n"}),
+		"unknown key":      other.IDToken(jwt.MapClaims{"sub": "u1", "nonce": "n", "iss": provider.URL()}),
+		"shared secret":    hs256,
+		"not a JWT at all": "abc",
+	}
+	for name, token := range cases {
+		_, err = issuer.VerifyIDToken(context.TODO(), token, "n")
+		assert.NotNil(t, err, name)
+	}
+}
+
+func TestOIDCIssuerDiscovery(t *testing.T) {
+	provider := NewOIDCProviderMock("goaccess", "")
+	defer provider.Close()
+	issuer := NewOIDCIssuer(configuration.FederationConfig{Issuer: provider.URL() + "/", ClientID: "goaccess"})
+	_, err := issuer.AuthorizationURL(context.TODO(), "st", "n", "c")
+	assert.EqualError(t, err, "Discovery document of another issuer: "+provider.URL())
+}
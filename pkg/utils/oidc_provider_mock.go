@@ -0,0 +1,209 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// OIDCProviderMock local OpenID Connect identity provider to test the federated logins. It logs in the user of
+// the claims set with SetClaims without any prompt, only the authorization code flow with S256 PKCE is supported
+// and the ID tokens are signed with a generated RSA key
+type OIDCProviderMock struct {
+	lock         sync.Mutex
+	server       *httptest.Server
+	signer       *asymmetricSigner
+	clientID     string
+	clientSecret string
+	claims       jwt.MapClaims
+	codes        map[string]mockAuthorization
+}
+
+// mockAuthorization pending authorization code of the provider mock
+type mockAuthorization struct {
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	claims        jwt.MapClaims
+}
+
+// NewOIDCProviderMock start a new provider mock for a client, an empty secret for a public client. Close it once
+// done
+func NewOIDCProviderMock(clientID string, clientSecret string) *OIDCProviderMock {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	signer, err := newAsymmetricSigner(AlgRS256, data)
+	if err != nil {
+		panic(err)
+	}
+	m := &OIDCProviderMock{
+		signer:       signer,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		claims:       jwt.MapClaims{},
+		codes:        make(map[string]mockAuthorization),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.configuration)
+	mux.HandleFunc("/jwks", m.jwks)
+	mux.HandleFunc("/authorize", m.authorize)
+	mux.HandleFunc("/token", m.token)
+	m.server = httptest.NewServer(mux)
+	return m
+}
+
+// URL issuer URL of the provider
+func (m *OIDCProviderMock) URL() string {
+	return m.server.URL
+}
+
+// Close stop the provider
+func (m *OIDCProviderMock) Close() {
+	m.server.Close()
+}
+
+// SetClaims set the user claims of the next logins, e.g. sub, email, email_verified and name
+func (m *OIDCProviderMock) SetClaims(claims jwt.MapClaims) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.claims = claims
+}
+
+// IDToken sign an ID token of the provider with the given claims added to valid iss, aud, iat and exp claims
+func (m *OIDCProviderMock) IDToken(claims jwt.MapClaims) string {
+	now := time.Now()
+	token := jwt.MapClaims{
+		"iss": m.server.URL,
+		"aud": m.clientID,
+		"iat": now.Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+	}
+	for name, value := range claims {
+		token[name] = value
+	}
+	signed, err := m.signer.Sign(token)
+	if err != nil {
+		panic(err)
+	}
+	return signed
+}
+
+func (m *OIDCProviderMock) configuration(w http.ResponseWriter, r *http.Request) {
+	mockJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                m.server.URL,
+		"authorization_endpoint":                m.server.URL + "/authorize",
+		"token_endpoint":                        m.server.URL + "/token",
+		"jwks_uri":                              m.server.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{AlgRS256},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (m *OIDCProviderMock) jwks(w http.ResponseWriter, r *http.Request) {
+	mockJSON(w, http.StatusOK, m.signer.JWKS())
+}
+
+// authorize log in the user right away and redirect it back with the code
+func (m *OIDCProviderMock) authorize(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	redirectURI := params.Get("redirect_uri")
+	if params.Get("client_id") != m.clientID || redirectURI == "" || params.Get("response_type") != "code" ||
+		params.Get("code_challenge") == "" || params.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	code, err := RandomToken(16)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	m.lock.Lock()
+	m.codes[code] = mockAuthorization{
+		redirectURI:   redirectURI,
+		nonce:         params.Get("nonce"),
+		codeChallenge: params.Get("code_challenge"),
+		claims:        m.claims,
+	}
+	m.lock.Unlock()
+	redirect, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := redirect.Query()
+	query.Set("code", code)
+	query.Set("state", params.Get("state"))
+	redirect.RawQuery = query.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token redeem a code, it can be used once
+func (m *OIDCProviderMock) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.Method != http.MethodPost {
+		mockJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+	}
+	if clientID != m.clientID || subtle.ConstantTimeCompare([]byte(secret), []byte(m.clientSecret)) != 1 {
+		mockJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		mockJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+	code := r.PostForm.Get("code")
+	m.lock.Lock()
+	authorization, found := m.codes[code]
+	delete(m.codes, code)
+	m.lock.Unlock()
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !found || authorization.redirectURI != r.PostForm.Get("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != authorization.codeChallenge {
+		mockJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	claims := jwt.MapClaims{}
+	for name, value := range authorization.claims {
+		claims[name] = value
+	}
+	if authorization.nonce != "" {
+		claims["nonce"] = authorization.nonce
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	mockJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "mock_access_token",
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     m.IDToken(claims),
+	})
+}
+
+func mockJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// HashBcrypt bcrypt password hashing
+	HashBcrypt = "bcrypt"
+	// HashArgon2id argon2id password hashing
+	HashArgon2id = "argon2id"
+
+	argon2idPrefix = "$argon2id$"
+	argon2SaltLen  = 16
+	argon2KeyLen   = 32
+)
+
+// PasswordHasher interface
+type PasswordHasher interface {
+	// Hash get the encoded hash of a password
+	Hash(password string) (string, error)
+	// Verify check if the password matches the encoded hash, hashes of any supported algorithm are accepted
+	Verify(hash string, password string) (bool, error)
+}
+
+type passwordHasher struct {
+	algorithm         string
+	bcryptCost        int
+	argon2Memory      uint32
+	argon2Iterations  uint32
+	argon2Parallelism uint8
+}
+
+// NewPasswordHasher return a new password hasher using the configured algorithm
+func NewPasswordHasher(config configuration.SecurityConfig) (PasswordHasher, error) {
+	if config.PasswordHash != HashBcrypt && config.PasswordHash != HashArgon2id {
+		return nil, errors.New("Unsupported password hash algorithm: " + config.PasswordHash)
+	}
+	return &passwordHasher{
+		algorithm:         config.PasswordHash,
+		bcryptCost:        config.BcryptCost,
+		argon2Memory:      uint32(config.Argon2Memory),
+		argon2Iterations:  uint32(config.Argon2Iterations),
+		argon2Parallelism: uint8(config.Argon2Parallelism),
+	}, nil
+}
+
+// Hash get the encoded hash of a password
+func (h *passwordHasher) Hash(password string) (string, error) {
+	if h.algorithm == HashBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	}
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.argon2Iterations, h.argon2Memory, h.argon2Parallelism, argon2KeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		h.argon2Memory,
+		h.argon2Iterations,
+		h.argon2Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify check if the password matches the encoded hash, hashes of any supported algorithm are accepted
+func (h *passwordHasher) Verify(hash string, password string) (bool, error) {
+	if strings.HasPrefix(hash, argon2idPrefix) {
+		return h.verifyArgon2id(hash, password)
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// verifyArgon2id verify a password against a hash in the format $argon2id$v=19$m=65536,t=3,p=2$salt$key
+func (h *passwordHasher) verifyArgon2id(hash string, password string) (bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, errors.New("Invalid argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, errors.New("Unsupported argon2id version")
+	}
+	var memory, iterations uint32
+	var parallelism uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &parallelism); err != nil {
+		return false, errors.New("Invalid argon2id parameters")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, errors.New("Invalid argon2id salt")
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, errors.New("Invalid argon2id key")
+	}
+	other := argon2.IDKey([]byte(password), salt, iterations, memory, parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
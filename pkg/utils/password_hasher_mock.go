@@ -0,0 +1,23 @@
+package utils
+
+// PasswordHasherMock interface
+type PasswordHasherMock interface {
+	Hash(password string) (string, error)
+	Verify(hash string, password string) (bool, error)
+}
+
+type passwordHasherMock struct {
+}
+
+// NewPasswordHasherMock return a new password hasher mock instance
+func NewPasswordHasherMock() PasswordHasherMock {
+	return &passwordHasherMock{}
+}
+
+func (h *passwordHasherMock) Hash(password string) (string, error) {
+	return "hash:" + password, nil
+}
+
+func (h *passwordHasherMock) Verify(hash string, password string) (bool, error) {
+	return hash == "hash:"+password, nil
+}
@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestHasher(t *testing.T, algorithm string) PasswordHasher {
+	h, err := NewPasswordHasher(configuration.SecurityConfig{
+		PasswordHash:      algorithm,
+		BcryptCost:        4,
+		Argon2Memory:      1024,
+		Argon2Iterations:  1,
+		Argon2Parallelism: 1,
+	})
+	assert.Nil(t, err)
+	return h
+}
+
+func TestPasswordHasherArgon2id(t *testing.T) {
+	h := newTestHasher(t, HashArgon2id)
+	hash, err := h.Hash("s3cret!")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"))
+	ok, err := h.Verify(hash, "s3cret!")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = h.Verify(hash, "wrong")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestPasswordHasherVerifyOtherAlgorithm(t *testing.T) {
+	hash, err := newTestHasher(t, HashBcrypt).Hash("s3cret!")
+	assert.Nil(t, err)
+	ok, err := newTestHasher(t, HashArgon2id).Verify(hash, "s3cret!")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestPasswordHasherUnsupported(t *testing.T) {
+	_, err := NewPasswordHasher(configuration.SecurityConfig{PasswordHash: "md5"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"bufio"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/StevenRojas/goaccess/pkg/entities"
+)
+
+// PasswordPolicy interface
+type PasswordPolicy interface {
+	// Validate check the password length, character classes and deny list, returns the errors by field
+	Validate(password string) url.Values
+	// History number of previous passwords that can't be reused
+	History() int
+	// MaxAge time a password is valid before it has to be changed, 0 if it never expires
+	MaxAge() time.Duration
+}
+
+type passwordPolicy struct {
+	rules    []string
+	denyList map[string]bool
+	history  int
+	maxAge   time.Duration
+}
+
+// NewPasswordPolicy return a new password policy instance loading the deny list file if configured
+func NewPasswordPolicy(config configuration.PasswordPolicyConfig) (PasswordPolicy, error) {
+	rules := []string{"required"}
+	if config.MinLength > 0 {
+		rules = append(rules, "min:"+strconv.Itoa(config.MinLength))
+	}
+	if config.RequireUpper {
+		rules = append(rules, "password_upper")
+	}
+	if config.RequireLower {
+		rules = append(rules, "password_lower")
+	}
+	if config.RequireDigit {
+		rules = append(rules, "password_digit")
+	}
+	if config.RequireSymbol {
+		rules = append(rules, "password_symbol")
+	}
+	p := &passwordPolicy{
+		rules:    rules,
+		denyList: make(map[string]bool),
+		history:  config.History,
+		maxAge:   time.Duration(config.MaxAgeDays) * 24 * time.Hour,
+	}
+	if config.DenyListFile != "" {
+		if err := p.loadDenyList(config.DenyListFile); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Validate check the password length, character classes and deny list, returns the errors by field
+func (p *passwordPolicy) Validate(password string) url.Values {
+	errs := entities.InitPasswordValidator(&entities.PasswordRequest{Password: password}, p.rules).ValidateStruct()
+	if p.denyList[strings.ToLower(password)] {
+		errs.Add("password", "The password field is too common")
+	}
+	return errs
+}
+
+// History number of previous passwords that can't be reused
+func (p *passwordPolicy) History() int {
+	return p.history
+}
+
+// MaxAge time a password is valid before it has to be changed, 0 if it never expires
+func (p *passwordPolicy) MaxAge() time.Duration {
+	return p.maxAge
+}
+
+// loadDenyList read the file with one password per line, empty lines and lines starting with # are ignored
+func (p *passwordPolicy) loadDenyList(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.denyList[strings.ToLower(line)] = true
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	file, err := ioutil.TempFile("", "deny-list")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString("# common passwords\n\nPassword1\n")
+	assert.Nil(t, err)
+	file.Close()
+
+	policy, err := NewPasswordPolicy(configuration.PasswordPolicyConfig{
+		MinLength:     8,
+		RequireUpper:  true,
+		RequireLower:  true,
+		RequireDigit:  true,
+		RequireSymbol: true,
+		DenyListFile:  file.Name(),
+		History:       5,
+		MaxAgeDays:    90,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, policy.History())
+	assert.Equal(t, float64(90*24), policy.MaxAge().Hours())
+
+	assert.Empty(t, policy.Validate("S3cure!pass"))
+	assert.Len(t, policy.Validate("short")["password"], 4)
+	assert.Contains(t, policy.Validate("")["password"], "The password field is required")
+	assert.Contains(t, policy.Validate("password1")["password"], "The password field is too common")
+}
+
+func TestPasswordPolicyMissingDenyList(t *testing.T) {
+	_, err := NewPasswordPolicy(configuration.PasswordPolicyConfig{DenyListFile: "/not/found"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"math/big"
+)
+
+// RandomToken get a URL safe random token of the given number of bytes
+func RandomToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// RandomCode get a random numeric code of the given number of digits
+func RandomCode(digits int) (string, error) {
+	code := make([]byte, digits)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		code[i] = byte('0' + n.Int64())
+	}
+	return string(code), nil
+}
+
+// HashToken hash a random token before storing it, the tokens are random so a fast hash is enough
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"context"
+	"time"
+)
+
+// RateLimiter limit the actions of a key within a sliding window, e.g. the login links mailed to an account
+type RateLimiter interface {
+	// Allow record an action of a key, false when the key reached the limit of the window
+	Allow(ctx context.Context, key string) (bool, error)
+}
+
+type rateLimiter struct {
+	store  AttemptStore
+	max    int
+	window time.Duration
+}
+
+// NewRateLimiter return a rate limiter of max actions per window, 0 for no limit. The actions are kept only in
+// memory when the store is nil
+func NewRateLimiter(store AttemptStore, max int, window time.Duration) RateLimiter {
+	if store == nil {
+		store = newMemAttemptStore()
+	}
+	return &rateLimiter{
+		store:  store,
+		max:    max,
+		window: window,
+	}
+}
+
+// Allow record an action of a key, false when the key reached the limit of the window
+func (l *rateLimiter) Allow(ctx context.Context, key string) (bool, error) {
+	if l.max <= 0 {
+		return true, nil
+	}
+	count, err := l.store.AddFailure(ctx, "ratelimit:"+key, time.Now(), l.window)
+	if err != nil {
+		return false, err
+	}
+	return count <= l.max, nil
+}
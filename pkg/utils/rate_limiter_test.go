@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	ctx := context.TODO()
+	limiter := NewRateLimiter(nil, 2, time.Minute)
+	for i := 0; i < 2; i++ {
+		allowed, err := limiter.Allow(ctx, "a")
+		assert.Nil(t, err)
+		assert.True(t, allowed)
+	}
+	allowed, _ := limiter.Allow(ctx, "a")
+	assert.False(t, allowed)
+	allowed, _ = limiter.Allow(ctx, "b")
+	assert.True(t, allowed)
+
+	unlimited := NewRateLimiter(nil, 0, time.Minute)
+	for i := 0; i < 5; i++ {
+		allowed, _ = unlimited.Allow(ctx, "a")
+		assert.True(t, allowed)
+	}
+}
+
+func TestRandomCode(t *testing.T) {
+	code, err := RandomCode(6)
+	assert.Nil(t, err)
+	assert.Regexp(t, "^[0-9]{6}$", code)
+}
@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+const actionPlaceholder = "[]"
+
+// idPattern match URL segments that are resource IDs: numbers, UUIDs, xids and object IDs. The last character of
+// a xid only holds one bit of its 12 bytes, it is 0 or g, so plain 20 letter words are not taken for xids
+var idPattern = regexp.MustCompile(`^([0-9]+|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|[0-9a-v]{19}[0g]|[0-9a-fA-F]{24})$`)
+
+// RequestAction build the action string for a request in the same format of the PostmanParser
+// e.g. PUT /vehicle/42/photo is put:vehicle:[]:photo
+func RequestAction(method string, path string) string {
+	parts := []string{strings.ToLower(method)}
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" {
+			continue
+		}
+		if idPattern.MatchString(segment) {
+			segment = actionPlaceholder
+		}
+		parts = append(parts, segment)
+	}
+	return strings.Join(parts, ":")
+}
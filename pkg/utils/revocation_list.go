@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+)
+
+// RevocationStore persistence of the revoked access tokens shared by all the instances
+type RevocationStore interface {
+	// GetRevokedTokens get the IDs of the revoked tokens that are not expired yet with their expiration
+	GetRevokedTokens(ctx context.Context) (map[string]time.Time, error)
+}
+
+// RevocationList in memory copy of the revoked access token IDs (jti), it lets the tokens be verified by
+// signature and expiration only, without a store round trip. The list is synced with the store when it is
+// older than the sync period, so a revocation may take that long to reach every instance
+type RevocationList interface {
+	// Load load the revoked token IDs from the store
+	Load(ctx context.Context) error
+	// IsRevoked check if a token ID is revoked, the list is synced first when it is stale
+	IsRevoked(jti string) bool
+}
+
+type revocationList struct {
+	lock     sync.RWMutex
+	store    RevocationStore
+	revoked  map[string]time.Time
+	sync     time.Duration
+	loadedAt time.Time
+	syncing  bool // a sync is fetching the store
+}
+
+// NewRevocationList return a new revocation list synced with the store every JWT_REVOCATION_SYNC_SECONDS
+func NewRevocationList(config configuration.SecurityConfig, store RevocationStore) RevocationList {
+	return &revocationList{
+		store:   store,
+		revoked: make(map[string]time.Time),
+		sync:    time.Second * time.Duration(config.JWTRevocationSyncSeconds),
+	}
+}
+
+// Load load the revoked token IDs from the store
+func (l *revocationList) Load(ctx context.Context) error {
+	revoked, err := l.store.GetRevokedTokens(ctx)
+	if err != nil {
+		return err
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.revoked = revoked
+	l.loadedAt = time.Now()
+	return nil
+}
+
+// IsRevoked check if a token ID is revoked, the list is synced first when it is stale
+func (l *revocationList) IsRevoked(jti string) bool {
+	l.syncIfStale()
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	exp, ok := l.revoked[jti]
+	return ok && time.Now().Before(exp)
+}
+
+// syncIfStale fetch the store outside the lock and swap the list in, the tokens are checked against the current
+// list meanwhile and only one caller fetches at a time
+func (l *revocationList) syncIfStale() {
+	l.lock.RLock()
+	stale := time.Since(l.loadedAt) > l.sync && !l.syncing
+	l.lock.RUnlock()
+	if !stale {
+		return
+	}
+	l.lock.Lock()
+	if time.Since(l.loadedAt) <= l.sync || l.syncing {
+		l.lock.Unlock()
+		return
+	}
+	l.syncing = true
+	l.lock.Unlock()
+
+	revoked, err := l.store.GetRevokedTokens(context.Background())
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.syncing = false
+	if err == nil {
+		l.revoked = revoked
+	}
+	// Keep the current list when the store fails, it is checked again on the next sync
+	l.loadedAt = time.Now()
+}
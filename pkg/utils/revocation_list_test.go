@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/stretchr/testify/assert"
+)
+
+type memRevocationStore struct {
+	lock    sync.Mutex
+	revoked map[string]time.Time
+	reads   int
+	err     error
+	block   chan struct{} // GetRevokedTokens waits for it when set
+}
+
+func (s *memRevocationStore) GetRevokedTokens(ctx context.Context) (map[string]time.Time, error) {
+	if s.block != nil {
+		<-s.block
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.reads++
+	if s.err != nil {
+		return nil, s.err
+	}
+	revoked := make(map[string]time.Time)
+	for jti, exp := range s.revoked {
+		revoked[jti] = exp
+	}
+	return revoked, nil
+}
+
+func (s *memRevocationStore) revoke(jti string, exp time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.revoked[jti] = exp
+}
+
+func TestRevocationList(t *testing.T) {
+	store := &memRevocationStore{revoked: map[string]time.Time{
+		"a": time.Now().Add(time.Hour),
+		"b": time.Now().Add(-time.Second),
+	}}
+	l := NewRevocationList(configuration.SecurityConfig{JWTRevocationSyncSeconds: 60}, store)
+	assert.Nil(t, l.Load(context.Background()))
+	assert.True(t, l.IsRevoked("a"))
+	assert.False(t, l.IsRevoked("b"), "expired tokens are rejected by their exp claim")
+	assert.False(t, l.IsRevoked("c"))
+
+	// Revocations reach the list on the next sync
+	store.revoke("c", time.Now().Add(time.Hour))
+	assert.False(t, l.IsRevoked("c"))
+	assert.Equal(t, 1, store.reads)
+}
+
+func TestRevocationListSync(t *testing.T) {
+	store := &memRevocationStore{revoked: map[string]time.Time{}}
+	l := NewRevocationList(configuration.SecurityConfig{}, store)
+	assert.Nil(t, l.Load(context.Background()))
+	store.revoke("a", time.Now().Add(time.Hour))
+	time.Sleep(time.Millisecond)
+	assert.True(t, l.IsRevoked("a"))
+
+	// A failed sync keeps the current list
+	store.err = errors.New("store down")
+	time.Sleep(time.Millisecond)
+	assert.True(t, l.IsRevoked("a"))
+	assert.NotNil(t, l.Load(context.Background()))
+}
+
+func TestRevocationListSyncDoesNotBlock(t *testing.T) {
+	store := &memRevocationStore{revoked: map[string]time.Time{"a": time.Now().Add(time.Hour)}}
+	l := NewRevocationList(configuration.SecurityConfig{}, store)
+	assert.Nil(t, l.Load(context.Background()))
+	store.block = make(chan struct{})
+	store.revoke("b", time.Now().Add(time.Hour))
+	time.Sleep(time.Millisecond)
+	synced := make(chan bool)
+	go func() {
+		synced <- l.IsRevoked("b")
+	}()
+
+	// The other checks use the current list while the store is fetched
+	time.Sleep(10 * time.Millisecond)
+	checked := make(chan bool)
+	go func() {
+		checked <- l.IsRevoked("a")
+	}()
+	select {
+	case revoked := <-checked:
+		assert.True(t, revoked)
+	case <-time.After(time.Second):
+		t.Fatal("IsRevoked blocked by the sync")
+	}
+	close(store.block)
+	assert.True(t, <-synced)
+	assert.Equal(t, 2, store.reads)
+}
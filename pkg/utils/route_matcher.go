@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/StevenRojas/goaccess/pkg/entities"
+)
+
+// RouteMatcher resolve concrete requests against the registered action templates
+type RouteMatcher interface {
+	// Match get the template action for a method and a raw path, e.g. PUT /vehicle/42 is put:vehicle:[]
+	Match(method string, path string) (*entities.RouteMatch, bool)
+	// MatchAction get the template action for an action string, e.g. put:vehicle:42 is put:vehicle:[]
+	MatchAction(action string) (*entities.RouteMatch, bool)
+}
+
+type route struct {
+	segments []string
+	match    entities.RouteMatch
+}
+
+type routeMatcher struct {
+	routes map[string][]route // method:segment count
+}
+
+// NewRouteMatcher compile the actions of the given module templates into a route matcher
+func NewRouteMatcher(modules []entities.Module) RouteMatcher {
+	m := &routeMatcher{
+		routes: make(map[string][]route),
+	}
+	for _, module := range modules {
+		for _, submodule := range module.SubModules {
+			for action := range submodule.Actions {
+				parts := strings.Split(action, ":")
+				if len(parts) < 2 {
+					continue
+				}
+				key := m.key(parts[0], len(parts)-1)
+				m.routes[key] = append(m.routes[key], route{
+					segments: parts[1:],
+					match: entities.RouteMatch{
+						Action:    action,
+						Module:    module.Name,
+						SubModule: submodule.Name,
+					},
+				})
+			}
+		}
+	}
+	// Most specific routes first: a literal segment wins over a placeholder in the same position
+	for _, routes := range m.routes {
+		sort.Slice(routes, func(i, j int) bool {
+			for k := range routes[i].segments {
+				pi := routes[i].segments[k] == actionPlaceholder
+				pj := routes[j].segments[k] == actionPlaceholder
+				if pi != pj {
+					return pj
+				}
+			}
+			return routes[i].match.Action < routes[j].match.Action
+		})
+	}
+	return m
+}
+
+// Match get the template action for a method and a raw path, e.g. PUT /vehicle/42 is put:vehicle:[]
+func (m *routeMatcher) Match(method string, path string) (*entities.RouteMatch, bool) {
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return m.match(strings.ToLower(method), segments)
+}
+
+// MatchAction get the template action for an action string, e.g. put:vehicle:42 is put:vehicle:[]
+func (m *routeMatcher) MatchAction(action string) (*entities.RouteMatch, bool) {
+	parts := strings.Split(action, ":")
+	if len(parts) < 2 {
+		return nil, false
+	}
+	return m.match(strings.ToLower(parts[0]), parts[1:])
+}
+
+func (m *routeMatcher) match(method string, segments []string) (*entities.RouteMatch, bool) {
+	for _, r := range m.routes[m.key(method, len(segments))] {
+		if r.matches(segments) {
+			match := r.match
+			return &match, true
+		}
+	}
+	return nil, false
+}
+
+func (m *routeMatcher) key(method string, count int) string {
+	return method + ":" + strconv.Itoa(count)
+}
+
+func (r *route) matches(segments []string) bool {
+	for i, segment := range r.segments {
+		if segment != actionPlaceholder && segment != segments[i] {
+			return false
+		}
+	}
+	return true
+}
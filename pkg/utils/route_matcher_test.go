@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/StevenRojas/goaccess/pkg/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestMatcher() RouteMatcher {
+	return NewRouteMatcher([]entities.Module{
+		{
+			Name: "vehicles",
+			SubModules: []entities.SubModule{
+				{
+					Name: "vehicle",
+					Actions: map[string]entities.Action{
+						"put:vehicle:[]":             {Title: "Update vehicle"},
+						"post:vehicle:[]:photo":      {Title: "Create vehicle photo"},
+						"post:vehicle:[]:[]":         {Title: "Create vehicle attribute"},
+						"delete:vehicle:[]:photo:[]": {Title: "Delete vehicle photo"},
+					},
+				},
+				{
+					Name: "brand",
+					Actions: map[string]entities.Action{
+						"post:brand": {Title: "Create brand"},
+					},
+				},
+			},
+		},
+	})
+}
+
+func TestRouteMatcherMatch(t *testing.T) {
+	m := newTestMatcher()
+	match, ok := m.Match("PUT", "/vehicle/42?force=true")
+	assert.True(t, ok)
+	assert.Equal(t, &entities.RouteMatch{Action: "put:vehicle:[]", Module: "vehicles", SubModule: "vehicle"}, match)
+
+	match, ok = m.Match("POST", "/brand/")
+	assert.True(t, ok)
+	assert.Equal(t, "brand", match.SubModule)
+
+	_, ok = m.Match("GET", "/vehicle/42")
+	assert.False(t, ok)
+	_, ok = m.Match("PUT", "/vehicle/42/photo")
+	assert.False(t, ok)
+}
+
+func TestRouteMatcherPrefersLiterals(t *testing.T) {
+	m := newTestMatcher()
+	match, ok := m.Match("POST", "/vehicle/42/photo")
+	assert.True(t, ok)
+	assert.Equal(t, "post:vehicle:[]:photo", match.Action)
+
+	match, ok = m.Match("POST", "/vehicle/42/color")
+	assert.True(t, ok)
+	assert.Equal(t, "post:vehicle:[]:[]", match.Action)
+}
+
+func TestRouteMatcherMatchAction(t *testing.T) {
+	m := newTestMatcher()
+	match, ok := m.MatchAction("delete:vehicle:toyota-1:photo:7")
+	assert.True(t, ok)
+	assert.Equal(t, "delete:vehicle:[]:photo:[]", match.Action)
+
+	match, ok = m.MatchAction("put:vehicle:[]")
+	assert.True(t, ok)
+	assert.Equal(t, "put:vehicle:[]", match.Action)
+}
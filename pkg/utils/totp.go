@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+)
+
+const (
+	totpDigits    = 6
+	totpPeriod    = 30
+	totpSecretLen = 20
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPHandler RFC 6238 time-based one-time passwords handler
+type TOTPHandler interface {
+	// GenerateSecret get a new random base32 encoded secret
+	GenerateSecret() (string, error)
+	// ProvisioningURI get the otpauth:// URI used by authenticator apps to enrol the secret
+	ProvisioningURI(account string, secret string) string
+	// Code get the code of a secret at a given time
+	Code(secret string, at time.Time) (string, error)
+	// Verify check the code against the secret allowing the configured clock skew, returns the matched time step
+	Verify(secret string, code string, at time.Time) (int64, bool)
+}
+
+type totpHandler struct {
+	issuer string
+	skew   int
+}
+
+// NewTOTPHandler return a new TOTP handler instance
+func NewTOTPHandler(config configuration.MFAConfig) TOTPHandler {
+	return &totpHandler{
+		issuer: config.Issuer,
+		skew:   config.TOTPSkew,
+	}
+}
+
+// GenerateSecret get a new random base32 encoded secret
+func (h *totpHandler) GenerateSecret() (string, error) {
+	secret := make([]byte, totpSecretLen)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// ProvisioningURI get the otpauth:// URI used by authenticator apps to enrol the secret
+func (h *totpHandler) ProvisioningURI(account string, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", h.issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(h.issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// Code get the code of a secret at a given time
+func (h *totpHandler) Code(secret string, at time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+	return h.code(key, at.Unix()/totpPeriod), nil
+}
+
+// Verify check the code against the secret allowing the configured clock skew, returns the matched time step
+func (h *totpHandler) Verify(secret string, code string, at time.Time) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return 0, false
+	}
+	current := at.Unix() / totpPeriod
+	for i := -h.skew; i <= h.skew; i++ {
+		step := current + int64(i)
+		if hmac.Equal([]byte(h.code(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// code HOTP value (RFC 4226) of the key for a counter
+func (h *totpHandler) code(key []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// GenerateRecoveryCodes get a list of random single use recovery codes in the format xxxxx-xxxxx
+func GenerateRecoveryCodes(count int) ([]string, error) {
+	codes := make([]string, count)
+	for i := range codes {
+		b := make([]byte, 6)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode get the hash used to store a recovery code, the code is normalized first
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
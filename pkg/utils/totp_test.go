@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StevenRojas/goaccess/pkg/configuration"
+	"github.com/stretchr/testify/assert"
+)
+
+// RFC 6238 appendix B test secret for SHA1
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCode(t *testing.T) {
+	h := NewTOTPHandler(configuration.MFAConfig{})
+	tests := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for at, expected := range tests {
+		code, err := h.Code(rfcSecret, time.Unix(at, 0))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, code, "time %d", at)
+	}
+}
+
+func TestTOTPVerify(t *testing.T) {
+	h := NewTOTPHandler(configuration.MFAConfig{TOTPSkew: 1})
+	at := time.Unix(1111111109, 0)
+	step, ok := h.Verify(rfcSecret, "081804", at)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1111111109/30), step)
+	_, ok = h.Verify(rfcSecret, "081804", at.Add(30*time.Second))
+	assert.True(t, ok)
+	_, ok = h.Verify(rfcSecret, "081804", at.Add(90*time.Second))
+	assert.False(t, ok)
+	_, ok = h.Verify(rfcSecret, "81804", at)
+	assert.False(t, ok)
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	h := NewTOTPHandler(configuration.MFAConfig{Issuer: "goaccess"})
+	secret, err := h.GenerateSecret()
+	assert.Nil(t, err)
+	uri, err := url.Parse(h.ProvisioningURI("srojas@gmail.com", secret))
+	assert.Nil(t, err)
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/goaccess:srojas@gmail.com", uri.Path)
+	assert.Equal(t, secret, uri.Query().Get("secret"))
+	assert.Equal(t, "goaccess", uri.Query().Get("issuer"))
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	assert.Nil(t, err)
+	assert.Len(t, codes, 10)
+	assert.Len(t, codes[0], 11)
+	assert.Equal(t, HashRecoveryCode(codes[0]), HashRecoveryCode(strings.ToUpper(strings.Replace(codes[0], "-", "", 1))))
+	assert.NotEqual(t, HashRecoveryCode(codes[0]), HashRecoveryCode(codes[1]))
+}